@@ -3,14 +3,21 @@ package domain
 import "time"
 
 type Process struct {
-	ID          string    `json:"id"`
-	LogID       string    `json:"log_id"`
-	Name        string    `json:"name"`
-	PID         int       `json:"pid"`
-	CPUPercent  float64   `json:"cpu_percent"`
-	MemoryUsage int64     `json:"memory_usage"`
-	Status      string    `json:"status"`
-	Timestamp   time.Time `json:"timestamp"`
+	ID          string     `json:"id"`
+	LogID       string     `json:"log_id"`
+	Name        string     `json:"name"`
+	PID         int        `json:"pid"`
+	PPID        int        `json:"ppid"`
+	CPUPercent  float64    `json:"cpu_percent"`
+	MemoryUsage int64      `json:"memory_usage"`
+	Status      string     `json:"status"`
+	Cmdline     string     `json:"cmdline,omitempty"`
+	Username    string     `json:"username,omitempty"`
+	UID         string     `json:"uid,omitempty"`
+	StartTime   *time.Time `json:"start_time,omitempty"`
+	ExePath     string     `json:"exe_path,omitempty"`
+	ExeSHA256   string     `json:"exe_sha256,omitempty"`
+	Timestamp   time.Time  `json:"timestamp"`
 }
 
 type ProcessRepository interface {
@@ -241,7 +241,7 @@ func (c *Consumer) extractProcesses(rawMsg *struct {
 	processes := make([]domain.Process, 0, len(processList))
 	for _, p := range processList {
 		proc := p.(map[string]interface{})
-		processes = append(processes, domain.Process{
+		process := domain.Process{
 			ID:          uuid.New().String(),
 			LogID:       logID,
 			Name:        proc["name"].(string),
@@ -250,11 +250,31 @@ func (c *Consumer) extractProcesses(rawMsg *struct {
 			MemoryUsage: int64(proc["memory_usage"].(float64)),
 			Status:      proc["status"].(string),
 			Timestamp:   time.Now(),
-		})
+		}
+		extractProcessMetadata(proc, &process)
+		processes = append(processes, process)
 	}
 	return processes, nil
 }
 
+// extractProcessMetadata copies the optional lineage, ownership and
+// executable fields; older agents don't send them
+func extractProcessMetadata(proc map[string]interface{}, process *domain.Process) {
+	if ppid, ok := proc["ppid"].(float64); ok {
+		process.PPID = int(ppid)
+	}
+	process.Cmdline, _ = proc["cmdline"].(string)
+	process.Username, _ = proc["username"].(string)
+	process.UID, _ = proc["uid"].(string)
+	process.ExePath, _ = proc["exe_path"].(string)
+	process.ExeSHA256, _ = proc["exe_sha256"].(string)
+	if raw, ok := proc["start_time"].(string); ok {
+		if startTime, err := time.Parse(time.RFC3339Nano, raw); err == nil && !startTime.IsZero() {
+			process.StartTime = &startTime
+		}
+	}
+}
+
 func (c *Consumer) storeData(logEntry *domain.Log, processes []domain.Process) error {
 	if err := c.logService.StoreLog(logEntry); err != nil {
 		return fmt.Errorf("error storing log entry: %w", err)
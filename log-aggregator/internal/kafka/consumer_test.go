@@ -400,3 +400,40 @@ func TestConsumer_CreateLogEntry(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractProcessMetadata(t *testing.T) {
+	t.Run("Full metadata", func(t *testing.T) {
+		var process domain.Process
+		extractProcessMetadata(map[string]interface{}{
+			"ppid":       float64(1),
+			"cmdline":    "/usr/bin/webd --port 8080",
+			"username":   "www-data",
+			"uid":        "33",
+			"start_time": "2024-12-28T08:00:11.665024-05:00",
+			"exe_path":   "/usr/bin/webd",
+			"exe_sha256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		}, &process)
+
+		assert.Equal(t, 1, process.PPID)
+		assert.Equal(t, "/usr/bin/webd --port 8080", process.Cmdline)
+		assert.Equal(t, "www-data", process.Username)
+		assert.Equal(t, "33", process.UID)
+		assert.Equal(t, "/usr/bin/webd", process.ExePath)
+		assert.Len(t, process.ExeSHA256, 64)
+		if assert.NotNil(t, process.StartTime) {
+			assert.Equal(t, int64(1735390811), process.StartTime.Unix())
+		}
+	})
+
+	t.Run("Legacy agent payload", func(t *testing.T) {
+		var process domain.Process
+		extractProcessMetadata(map[string]interface{}{
+			"name":       "launchd",
+			"start_time": "0001-01-01T00:00:00Z",
+		}, &process)
+
+		assert.Zero(t, process.PPID)
+		assert.Empty(t, process.ExeSHA256)
+		assert.Nil(t, process.StartTime)
+	})
+}
@@ -24,11 +24,18 @@ func (r *ProcessRepository) StoreBatch(processes []domain.Process) error {
             log_id,
             name,
             pid,
+            ppid,
             cpu_percent,
             memory_usage,
             status,
+            cmdline,
+            username,
+            uid,
+            start_time,
+            exe_path,
+            exe_sha256,
             created_at
-        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        ) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
     `
 
 	// Debug logging - Start
@@ -65,9 +72,16 @@ func (r *ProcessRepository) StoreBatch(processes []domain.Process) error {
 			process.LogID,
 			process.Name,
 			process.PID,
+			process.PPID,
 			process.CPUPercent,
 			process.MemoryUsage,
 			process.Status,
+			nullString(process.Cmdline),
+			nullString(process.Username),
+			nullString(process.UID),
+			process.StartTime,
+			nullString(process.ExePath),
+			nullString(process.ExeSHA256),
 			process.Timestamp,
 		)
 		if err != nil {
@@ -105,9 +119,16 @@ func (r *ProcessRepository) FindByLogID(logID string) ([]domain.Process, error)
 			log_id,
 			name,
 			pid,
+			COALESCE(ppid, 0),
 			cpu_percent,
 			memory_usage,
 			status,
+			COALESCE(cmdline, ''),
+			COALESCE(username, ''),
+			COALESCE(uid, ''),
+			start_time,
+			COALESCE(exe_path, ''),
+			COALESCE(exe_sha256, ''),
 			created_at
 		FROM process_logs
 		WHERE log_id = $1
@@ -123,19 +144,30 @@ func (r *ProcessRepository) FindByLogID(logID string) ([]domain.Process, error)
 	var processes []domain.Process
 	for rows.Next() {
 		var process domain.Process
+		var startTime sql.NullTime
 		err := rows.Scan(
 			&process.ID,
 			&process.LogID,
 			&process.Name,
 			&process.PID,
+			&process.PPID,
 			&process.CPUPercent,
 			&process.MemoryUsage,
 			&process.Status,
+			&process.Cmdline,
+			&process.Username,
+			&process.UID,
+			&startTime,
+			&process.ExePath,
+			&process.ExeSHA256,
 			&process.Timestamp,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan process row: %w", err)
 		}
+		if startTime.Valid {
+			process.StartTime = &startTime.Time
+		}
 		processes = append(processes, process)
 	}
 
@@ -145,3 +177,8 @@ func (r *ProcessRepository) FindByLogID(logID string) ([]domain.Process, error)
 
 	return processes, nil
 }
+
+// nullString stores empty optional fields as NULL rather than empty strings
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
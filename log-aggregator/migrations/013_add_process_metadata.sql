@@ -0,0 +1,29 @@
+-- Schema Version: 1.0.0
+-- Created: 2026-10-16
+-- Description: Add security triage metadata to process logs
+
+-- Add lineage, ownership and executable columns to process_logs table
+ALTER TABLE process_logs
+ADD COLUMN ppid INTEGER,
+ADD COLUMN cmdline TEXT,
+ADD COLUMN username VARCHAR(255),
+ADD COLUMN uid VARCHAR(64),
+ADD COLUMN start_time TIMESTAMP,
+ADD COLUMN exe_path TEXT,
+ADD COLUMN exe_sha256 CHAR(64);
+
+-- Add indexes for hunting by executable hash and parent process
+CREATE INDEX idx_process_logs_exe_sha256 ON process_logs(exe_sha256);
+CREATE INDEX idx_process_logs_ppid ON process_logs(log_id, ppid);
+
+-- Down migration
+-- DROP INDEX IF EXISTS idx_process_logs_ppid;
+-- DROP INDEX IF EXISTS idx_process_logs_exe_sha256;
+-- ALTER TABLE process_logs
+--     DROP COLUMN IF EXISTS exe_sha256,
+--     DROP COLUMN IF EXISTS exe_path,
+--     DROP COLUMN IF EXISTS start_time,
+--     DROP COLUMN IF EXISTS uid,
+--     DROP COLUMN IF EXISTS username,
+--     DROP COLUMN IF EXISTS cmdline,
+--     DROP COLUMN IF EXISTS ppid;
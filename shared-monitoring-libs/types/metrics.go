@@ -37,19 +37,49 @@ type MetricPayload struct {
 
 	// Collection metadata
 	Metadata struct {
-		CollectionDuration string   `json:"collection_duration"`
-		CollectorCount     int      `json:"collector_count"`
-		Errors             []string `json:"errors,omitempty"`
+		CollectionDuration string      `json:"collection_duration"`
+		CollectorCount     int         `json:"collector_count"`
+		Errors             []string    `json:"errors,omitempty"`
+		Queue              *QueueStats `json:"queue,omitempty"`
+		// Collectors reports the health of each enabled collector by name
+		Collectors map[string]CollectorHealth `json:"collectors,omitempty"`
 	} `json:"metadata"`
 }
 
+// CollectorHealth describes a collector's recent runs. A collector that keeps
+// failing is paused until DisabledUntil.
+type CollectorHealth struct {
+	Duration            string     `json:"duration"` // of the latest run
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	DisabledUntil       *time.Time `json:"disabled_until,omitempty"`
+}
+
+// QueueStats describes the agent's offline queue of undelivered payloads.
+// Dropped counts cover the lifetime of the agent process.
+type QueueStats struct {
+	Depth          int   `json:"depth"`
+	Bytes          int64 `json:"bytes"`
+	DroppedEvicted int64 `json:"dropped_evicted"`
+	DroppedExpired int64 `json:"dropped_expired"`
+	DroppedFailed  int64 `json:"dropped_failed"`
+}
+
 // ProcessInfo represents information about a single process
 type ProcessInfo struct {
-	Name        string  `json:"name"`
-	PID         int     `json:"pid"`
-	CPUPercent  float64 `json:"cpu_percent"`
-	MemoryUsage uint64  `json:"memory_usage"`
-	Status      string  `json:"status"`
+	Name        string    `json:"name"`
+	PID         int       `json:"pid"`
+	PPID        int       `json:"ppid"`
+	CPUPercent  float64   `json:"cpu_percent"`
+	MemoryUsage uint64    `json:"memory_usage"`
+	Status      string    `json:"status"`
+	Cmdline     string    `json:"cmdline,omitempty"`
+	Username    string    `json:"username,omitempty"`
+	UID         string    `json:"uid,omitempty"`
+	StartTime   time.Time `json:"start_time"`
+	ExePath     string    `json:"exe_path,omitempty"`
+	ExeSHA256   string    `json:"exe_sha256,omitempty"`
 }
 
 // ThreatIndicator represents a security threat or anomaly
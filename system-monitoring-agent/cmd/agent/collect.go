@@ -0,0 +1,79 @@
+// cmd/agent/collect.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/travism26/system-monitoring-agent/internal/config"
+	"github.com/travism26/system-monitoring-agent/internal/metrics"
+	"github.com/travism26/system-monitoring-agent/internal/monitor"
+)
+
+const collectUsage = `usage: agent collect [--once] [--pretty] [--all]
+
+Prints what the agent would export, one JSON payload per line, every
+collection interval until interrupted. Nothing is exported.
+
+FIM, auth log and baseline checks are skipped unless --all is given, since
+they update state the running agent relies on: auth log offsets move past the
+events read, and baselines learn from the sample.`
+
+// runCollect handles the "collect" subcommand
+func runCollect(cfg *config.Config, args []string) int {
+	flags := newFlagSet("collect", collectUsage)
+	once := flags.Bool("once", false, "print a single payload and exit")
+	pretty := flags.Bool("pretty", false, "indent the JSON")
+	all := flags.Bool("all", false, "include the checks that update agent state")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+
+	if !*all {
+		cfg.Baseline.Enabled = false
+		var enabled []string
+		for _, metric := range cfg.Tenant.CollectionRules.EnabledMetrics {
+			if metric != "fim" && metric != "auth" {
+				enabled = append(enabled, metric)
+			}
+		}
+		cfg.Tenant.CollectionRules.EnabledMetrics = enabled
+	}
+
+	mon, err := monitor.NewSystemMonitor()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating system monitor: %v\n", err)
+		return 1
+	}
+	mc := metrics.NewMetricsCollector(mon, cfg)
+
+	encoder := json.NewEncoder(os.Stdout)
+	if *pretty {
+		encoder.SetIndent("", "  ")
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(time.Duration(max(cfg.Interval, 1)) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := encoder.Encode(mc.Collect()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing payload: %v\n", err)
+			return 1
+		}
+		if *once {
+			return 0
+		}
+		select {
+		case <-ticker.C:
+		case <-interrupt:
+			return 0
+		}
+	}
+}
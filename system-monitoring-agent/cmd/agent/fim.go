@@ -0,0 +1,38 @@
+// cmd/agent/fim.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/travism26/system-monitoring-agent/internal/config"
+	"github.com/travism26/system-monitoring-agent/internal/fim"
+)
+
+const fimUsage = `usage: agent fim rebaseline [path...]
+
+Records the current state of the monitored files as the approved baseline.
+With paths, only files at or below them are re-baselined.`
+
+// runFIM handles the "fim" subcommand and returns the process exit code
+func runFIM(cfg *config.Config, args []string) int {
+	if len(args) == 0 || args[0] != "rebaseline" {
+		fmt.Fprintln(os.Stderr, fimUsage)
+		return 2
+	}
+
+	store, err := fim.NewStore(cfg.HTTP.StorageDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening FIM baseline: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	count, err := fim.NewMonitor(store, cfg.FIM.Paths, cfg.FIM.ExcludePaths).Rebaseline(args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error re-baselining: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Baselined %d files\n", count)
+	return 0
+}
@@ -2,23 +2,52 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/travism26/system-monitoring-agent/internal/config"
 
 	"github.com/travism26/system-monitoring-agent/internal/agent"
 	"github.com/travism26/system-monitoring-agent/internal/exporter"
+	"github.com/travism26/system-monitoring-agent/internal/external"
 	"github.com/travism26/system-monitoring-agent/internal/metrics"
 	"github.com/travism26/system-monitoring-agent/internal/monitor"
 )
 
+const usage = `usage: agent [command]
+
+With no command, runs the agent. Commands help debug an agent in the field:
+
+  collect [--once]                    print collected payloads instead of exporting them
+  validate-config [file]              check a configuration file
+  replay --file path | --queue name   re-send stored payloads to an endpoint
+  queue stats|purge                   inspect or empty the offline queues
+  fim rebaseline [path...]            approve the current state of monitored files
+
+Run "agent <command> -h" for a command's options.`
+
+// commands run instead of the agent with the loaded configuration. Each
+// returns the process exit code.
+var commands = map[string]func(cfg *config.Config, args []string) int{
+	"collect": runCollect,
+	"fim":     runFIM,
+	"queue":   runQueue,
+	"replay":  runReplay,
+}
+
 func main() {
-	fmt.Println("Starting System Monitoring Agent...")
+	// External collectors start their commands through the agent binary
+	external.RunWrapper()
+
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
 
 	// Load configuration
 	cfg, err := config.LoadConfig()
@@ -26,7 +55,9 @@ func main() {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
 
-	storage, err := exporter.NewMetricStorage(cfg.HTTP.StorageDir)
+	fmt.Println("Starting System Monitoring Agent...")
+
+	storage, err := exporter.NewMetricStorage(cfg.HTTP.StorageDir, exporter.StorageOptionsFromConfig(cfg.Storage))
 	if err != nil {
 		log.Fatalf("Error creating metric storage: %v", err)
 	}
@@ -37,11 +68,14 @@ func main() {
 		log.Fatalf("Error creating system monitor: %v", err)
 	}
 	mc := metrics.NewMetricsCollector(mon, cfg)
+	mc.SetQueueStats(storage)
 
 	// Initialize exporters
-	exporters := []exporter.MetricsExporter{
-		exporter.NewFileExporter(cfg.LogFilePath),
+	fileExporter, err := exporter.NewFileExporter(cfg.LogFilePath, exporter.FileOptionsFromConfig(cfg.LogSettings))
+	if err != nil {
+		log.Fatalf("Error creating file exporter: %v", err)
 	}
+	exporters := []exporter.MetricsExporter{fileExporter}
 
 	// Initialize HTTP exporter with tenant context
 	httpExporter, err := exporter.NewHTTPExporter(cfg, storage)
@@ -49,28 +83,173 @@ func main() {
 		log.Fatalf("Error creating HTTP exporter: %v", err)
 	}
 	exporters = append(exporters, httpExporter)
+	// Rotated API keys are checked over the exporter's TLS settings and go
+	// out with its next request
+	cfg.SetAPIKeyClient(httpExporter.Client())
+	cfg.OnAPIKeyChange(httpExporter.SetAPIKey)
+	cfg.StartAPIKeyChecks()
+
+	storages := []exporter.MetricStorage{storage}
+	if cfg.Kafka.Enabled {
+		kafkaOptions := exporter.StorageOptionsFromConfig(cfg.Storage)
+		kafkaOptions.Table = exporter.KafkaQueueTable
+		kafkaStorage, err := exporter.NewMetricStorage(cfg.HTTP.StorageDir, kafkaOptions)
+		if err != nil {
+			log.Fatalf("Error creating Kafka storage: %v", err)
+		}
+		storages = append(storages, kafkaStorage)
+		kafkaExporter, err := exporter.NewKafkaExporter(cfg, kafkaStorage)
+		if err != nil {
+			log.Fatalf("Error creating Kafka exporter: %v", err)
+		}
+		exporters = append(exporters, kafkaExporter)
+	}
+
+	if cfg.OTLP.Endpoint != "" {
+		otlpExporter, err := exporter.NewOTLPExporter(cfg)
+		if err != nil {
+			log.Fatalf("Error creating OTLP exporter: %v", err)
+		}
+		exporters = append(exporters, otlpExporter)
+	}
+
+	if cfg.Prometheus.ListenAddress != "" {
+		promExporter, err := exporter.NewPrometheusExporter(cfg)
+		if err != nil {
+			log.Fatalf("Error creating Prometheus exporter: %v", err)
+		}
+		exporters = append(exporters, promExporter)
+	}
+
+	if cfg.Syslog.Address != "" {
+		syslogExporter, err := exporter.NewSyslogExporter(cfg)
+		if err != nil {
+			log.Fatalf("Error creating syslog exporter: %v", err)
+		}
+		exporters = append(exporters, syslogExporter)
+	}
 
 	// Initialize agent
 	ag := agent.NewAgent(cfg, mc, exporters...)
 
 	// Set up signal handling
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	// Start agent in goroutine
-	done := make(chan struct{})
+	ctx, stop := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
 	go func() {
-		ag.Start(done)
+		defer close(stopped)
+		ag.Start(ctx)
 	}()
 
-	// Wait for termination signal
-	<-sigChan
+	// Reload the configuration on SIGHUP; stop on anything else
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Println("[INFO] Received SIGHUP, reloading configuration")
+		ag.Reload()
+	}
 	fmt.Println("Received termination signal, stopping agent...")
+	go func() {
+		for sig := range sigChan {
+			if sig != syscall.SIGHUP {
+				log.Println("[WARN] Received second termination signal, exiting without flushing")
+				os.Exit(1)
+			}
+		}
+	}()
+
+	os.Exit(shutdown(cfg, ag, mc, stop, stopped, storages))
+}
 
-	// Signal agent to stop
-	close(done)
+// shutdown stops collection and the API key checks, then gives the exporters
+// until the configured deadline to deliver or queue what they hold. Their
+// storage and the collectors' are closed once nothing is using them. It
+// returns the exit code: non-zero if any payloads were dropped.
+func shutdown(cfg *config.Config, ag *agent.Agent, mc *metrics.MetricsCollector, stop context.CancelFunc, stopped <-chan struct{}, storages []exporter.MetricStorage) int {
+	ctx, cancel := context.WithTimeout(context.Background(), ag.ShutdownTimeout())
+	defer cancel()
 
-	// Give the agent time to clean up
-	time.Sleep(time.Second)
+	stop()
+	cfg.StopAPIKeyChecks()
+	collecting := true
+	select {
+	case <-stopped:
+		collecting = false
+	case <-ctx.Done():
+		log.Println("[WARN] Collection didn't stop before the shutdown deadline")
+	}
+
+	code := 0
+	if err := ag.Shutdown(ctx); err != nil {
+		log.Printf("[ERROR] Shutdown: %v", err)
+		code = 1
+	}
+	// A collection still running may be using the collectors' databases;
+	// they are left as after a crash. So are those of collectors whose runs
+	// outlast the deadline.
+	if !collecting {
+		if err := mc.Wait(ctx); err != nil {
+			log.Printf("[WARN] Closing collectors: %v", err)
+		}
+		if err := mc.Close(); err != nil {
+			log.Printf("[ERROR] Closing collectors: %v", err)
+			code = 1
+		}
+	}
+	// The exporters are done with their storage once Shutdown returns
+	for _, storage := range storages {
+		if err := storage.Close(); err != nil {
+			log.Printf("[ERROR] Closing metric storage: %v", err)
+			code = 1
+		}
+	}
 	fmt.Println("Agent shutdown complete")
+	return code
+}
+
+// runCommand runs a subcommand and returns the process exit code
+func runCommand(name string, args []string) int {
+	switch name {
+	case "validate-config":
+		// Loads the configuration itself, to report why it doesn't load
+		return runValidateConfig(args)
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return 0
+	}
+
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", name, usage)
+		return 2
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		return 1
+	}
+	return command(cfg, args)
+}
+
+// newFlagSet parses a command's flags, printing its usage on -h or an error
+func newFlagSet(name, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), usage)
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// usageError is the exit code for bad arguments; asking for help isn't one
+func usageError(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
 }
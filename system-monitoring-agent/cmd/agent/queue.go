@@ -0,0 +1,103 @@
+// cmd/agent/queue.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/travism26/system-monitoring-agent/internal/config"
+	"github.com/travism26/system-monitoring-agent/internal/exporter"
+)
+
+const queueUsage = `usage: agent queue stats|purge [--queue http|kafka|all]
+
+Inspects or empties the offline queues of payloads waiting for delivery.
+Stop the agent before purging, as it may be replaying the queue.`
+
+// queueTables maps the queue names accepted on the command line to their
+// tables in metrics.db
+var queueTables = map[string]string{
+	"http":  exporter.HTTPQueueTable,
+	"kafka": exporter.KafkaQueueTable,
+}
+
+// runQueue handles the "queue" subcommand
+func runQueue(cfg *config.Config, args []string) int {
+	flags := newFlagSet("queue", queueUsage)
+	name := flags.String("queue", "all", "queue to use: http, kafka or all")
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+		flags.Usage()
+		return 0
+	}
+	if len(args) == 0 || (args[0] != "stats" && args[0] != "purge") {
+		flags.Usage()
+		return 2
+	}
+	action := args[0]
+	if err := flags.Parse(args[1:]); err != nil {
+		return usageError(err)
+	}
+
+	names := []string{"http", "kafka"}
+	if *name != "all" {
+		if _, ok := queueTables[*name]; !ok {
+			fmt.Fprintf(os.Stderr, "unknown queue %q\n", *name)
+			return 2
+		}
+		names = []string{*name}
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if action == "stats" {
+		fmt.Fprintln(out, "QUEUE\tPAYLOADS\tBYTES\tOLDEST")
+	}
+	for _, name := range names {
+		storage, err := openQueue(cfg, name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening the %s queue: %v\n", name, err)
+			return 1
+		}
+		err = queueAction(out, storage, name, action)
+		storage.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading the %s queue: %v\n", name, err)
+			return 1
+		}
+	}
+	out.Flush()
+	return 0
+}
+
+func queueAction(out *tabwriter.Writer, storage *exporter.SQLiteStorage, name, action string) error {
+	if action == "purge" {
+		purged, err := storage.Purge()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Purged %d payloads from the %s queue\n", purged, name)
+		return nil
+	}
+
+	stats, err := storage.Stats()
+	if err != nil {
+		return err
+	}
+	oldest, err := storage.Oldest()
+	if err != nil {
+		return err
+	}
+	age := "-"
+	if !oldest.IsZero() {
+		age = fmt.Sprintf("%s (%s ago)", oldest.UTC().Format(time.RFC3339), time.Since(oldest).Round(time.Second))
+	}
+	fmt.Fprintf(out, "%s\t%d\t%d\t%s\n", name, stats.Depth, stats.Bytes, age)
+	return nil
+}
+
+// openQueue opens a queue without its size and age budget, so that reading
+// it doesn't drop anything
+func openQueue(cfg *config.Config, name string) (*exporter.SQLiteStorage, error) {
+	return exporter.NewMetricStorage(cfg.HTTP.StorageDir, exporter.StorageOptions{Table: queueTables[name]})
+}
@@ -0,0 +1,178 @@
+// cmd/agent/replay.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+	"github.com/travism26/system-monitoring-agent/internal/exporter"
+)
+
+const replayUsage = `usage: agent replay (--file path | --queue http|kafka) [options]
+
+Re-sends stored payloads to a metrics endpoint, the configured one unless
+--endpoint is given. Payloads are read from a metrics file written by the file
+exporter in the json or pretty format, gzipped or not, or from an offline
+queue, and sent in batches of HTTP.BatchSize. Replay stops at the first batch
+that fails.`
+
+// errReplayLimit ends a replay once --limit payloads have been read
+var errReplayLimit = errors.New("replay limit reached")
+
+// replayer sends payloads in batches, keeping count of what was sent
+type replayer struct {
+	sender  *exporter.HTTPExporter // nil for a dry run
+	batch   int
+	limit   int
+	pending []types.MetricPayload
+	read    int
+	sent    int
+}
+
+// add queues a payload, sending once a batch is full
+func (r *replayer) add(payload types.MetricPayload) error {
+	if r.limit > 0 && r.read >= r.limit {
+		return errReplayLimit
+	}
+	r.read++
+	r.pending = append(r.pending, payload)
+	if len(r.pending) < r.batch {
+		return nil
+	}
+	return r.flush()
+}
+
+func (r *replayer) flush() error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+	if r.sender != nil {
+		if err := r.sender.Send(r.pending); err != nil {
+			return err
+		}
+	}
+	r.sent += len(r.pending)
+	r.pending = r.pending[:0]
+	return nil
+}
+
+// runReplay handles the "replay" subcommand
+func runReplay(cfg *config.Config, args []string) int {
+	flags := newFlagSet("replay", replayUsage)
+	file := flags.String("file", "", "metrics file to replay")
+	queue := flags.String("queue", "", "offline queue to replay: http or kafka")
+	endpoint := flags.String("endpoint", "", "metrics URL to send to instead of the configured endpoints")
+	batch := flags.Int("batch", 0, "payloads per request (default HTTP.BatchSize)")
+	limit := flags.Int("limit", 0, "stop after this many payloads")
+	dryRun := flags.Bool("dry-run", false, "read and count the payloads without sending them")
+	remove := flags.Bool("remove", false, "delete replayed payloads from the queue")
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if (*file == "") == (*queue == "") || (*remove && *queue == "") || flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	if _, ok := queueTables[*queue]; *queue != "" && !ok {
+		fmt.Fprintf(os.Stderr, "unknown queue %q\n", *queue)
+		return 2
+	}
+
+	target := cfg.Snapshot()
+	if *endpoint != "" {
+		target.Tenant.Endpoints.Metrics = *endpoint
+		target.Tenant.Endpoints.MetricsFailover = nil
+		target.Tenant.Endpoints.HealthCheck = ""
+	}
+	if *batch > 0 {
+		target.HTTP.BatchSize = *batch
+	}
+	r := &replayer{batch: max(target.HTTP.BatchSize, 1), limit: *limit}
+	if !*dryRun {
+		sender, err := exporter.NewHTTPExporter(target, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating HTTP exporter: %v\n", err)
+			return 1
+		}
+		defer sender.Close()
+		r.sender = sender
+	}
+
+	var err error
+	if *file != "" {
+		err = exporter.ScanFile(*file, r.add)
+		if errors.Is(err, errReplayLimit) {
+			err = nil
+		}
+		if err == nil {
+			err = r.flush()
+		}
+	} else {
+		err = replayQueue(cfg, *queue, r, *remove)
+	}
+
+	destination := "nowhere (dry run)"
+	if !*dryRun {
+		destination = redactedURL(target.Tenant.Endpoints.Metrics)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error after replaying %d payloads to %s: %v\n", r.sent, destination, err)
+		return 1
+	}
+	fmt.Printf("Replayed %d payloads to %s\n", r.sent, destination)
+	return 0
+}
+
+// replayQueue sends a queue a page at a time, removing each page once it is
+// delivered when asked to
+func replayQueue(cfg *config.Config, name string, r *replayer, remove bool) error {
+	storage, err := openQueue(cfg, name)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	var lastID int64
+	for {
+		batches, err := storage.LoadAfter(lastID, r.batch)
+		if err != nil || len(batches) == 0 {
+			return err
+		}
+		var ids []int64
+		for _, b := range batches {
+			if err := r.add(b.Data); errors.Is(err, errReplayLimit) {
+				break
+			} else if err != nil {
+				return err
+			}
+			ids = append(ids, b.ID)
+			lastID = b.ID
+		}
+		if err := r.flush(); err != nil {
+			return err
+		}
+		if remove && r.sender != nil {
+			for _, id := range ids {
+				if err := storage.Remove(id); err != nil {
+					return err
+				}
+			}
+		}
+		if len(ids) < len(batches) {
+			return nil
+		}
+	}
+}
+
+// redactedURL hides any credentials in an endpoint before it is printed
+func redactedURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "the configured endpoint"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+	"github.com/travism26/system-monitoring-agent/internal/exporter"
+)
+
+// replayServer records the tenant of every payload it receives
+type replayServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	tenants []string
+}
+
+func newReplayServer(t *testing.T) *replayServer {
+	rs := &replayServer{}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Data []types.MetricPayload `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rs.mu.Lock()
+		for _, payload := range body.Data {
+			rs.tenants = append(rs.tenants, payload.TenantID)
+		}
+		rs.mu.Unlock()
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+func (rs *replayServer) received() []string {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return append([]string(nil), rs.tenants...)
+}
+
+func replayConfig(t *testing.T) *config.Config {
+	cfg := &config.Config{}
+	cfg.HTTP.StorageDir = t.TempDir()
+	cfg.HTTP.BatchSize = 2
+	cfg.Tenant.Endpoints.Metrics = "http://127.0.0.1:1/unreachable"
+	return cfg
+}
+
+func TestReplayFile(t *testing.T) {
+	server := newReplayServer(t)
+	cfg := replayConfig(t)
+
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	file, err := exporter.NewFileExporter(path, exporter.FileOptions{})
+	require.NoError(t, err)
+	for _, tenant := range []string{"a", "b", "c"} {
+		require.NoError(t, file.Export(types.MetricPayload{TenantID: tenant}))
+	}
+	require.NoError(t, file.Close())
+
+	assert.Equal(t, 0, runReplay(cfg, []string{"--file", path, "--endpoint", server.URL, "--dry-run"}))
+	assert.Empty(t, server.received())
+
+	assert.Equal(t, 0, runReplay(cfg, []string{"--file", path, "--endpoint", server.URL, "--limit", "2"}))
+	assert.Equal(t, []string{"a", "b"}, server.received())
+
+	assert.Equal(t, 0, runReplay(cfg, []string{"--file", path, "--endpoint", server.URL, "--batch", "5"}))
+	assert.Equal(t, []string{"a", "b", "a", "b", "c"}, server.received())
+}
+
+func TestReplayQueue(t *testing.T) {
+	server := newReplayServer(t)
+	cfg := replayConfig(t)
+
+	storage, err := exporter.NewMetricStorage(cfg.HTTP.StorageDir, exporter.StorageOptions{})
+	require.NoError(t, err)
+	for _, tenant := range []string{"a", "b", "c"} {
+		_, err := storage.Store(exporter.MetricBatch{Data: types.MetricPayload{TenantID: tenant}})
+		require.NoError(t, err)
+	}
+
+	// Without --remove the queue is left as it was
+	assert.Equal(t, 0, runReplay(cfg, []string{"--queue", "http", "--endpoint", server.URL}))
+	assert.Equal(t, []string{"a", "b", "c"}, server.received())
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Depth)
+
+	assert.Equal(t, 0, runReplay(cfg, []string{"--queue", "http", "--endpoint", server.URL, "--remove", "--limit", "2"}))
+	stats, err = storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Depth)
+	require.NoError(t, storage.Close())
+
+	// A failed send keeps the payloads queued
+	assert.Equal(t, 1, runReplay(cfg, []string{"--queue", "http", "--remove"}))
+	assert.Equal(t, 0, runQueue(cfg, []string{"stats"}))
+	assert.Equal(t, 0, runQueue(cfg, []string{"purge", "--queue", "http"}))
+
+	storage, err = exporter.NewMetricStorage(cfg.HTTP.StorageDir, exporter.StorageOptions{})
+	require.NoError(t, err)
+	defer storage.Close()
+	stats, err = storage.Stats()
+	require.NoError(t, err)
+	assert.Zero(t, stats.Depth)
+}
+
+func TestReplayRejectsBadArguments(t *testing.T) {
+	cfg := replayConfig(t)
+	for _, args := range [][]string{
+		{},
+		{"--file", "metrics.json", "--queue", "http"},
+		{"--file", "metrics.json", "--remove"},
+		{"--queue", "elsewhere"},
+	} {
+		assert.Equal(t, 2, runReplay(cfg, args), "%v", args)
+	}
+	assert.Equal(t, 2, runQueue(cfg, []string{"drop"}))
+	assert.Equal(t, 2, runQueue(cfg, []string{"stats", "--queue", "elsewhere"}))
+}
+
+func TestReplayUsesRotatedKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rotate", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"api_key": "rotated-key", "grace_period": 3600}`)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get("X-API-Key"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(`
+Tenant:
+  ID: "tenant-123"
+  APIKey: "initial-key-0123456789abcdefghijkl"
+  Endpoints:
+    Metrics: "http://127.0.0.1:1/unreachable"
+    KeyRotation: "%s/rotate"
+HTTP:
+  StorageDir: %q
+  Headers:
+    TenantID: "X-Tenant-ID"
+    APIKey: "X-API-Key"
+`, server.URL, dir)), 0o600))
+	viper.Reset()
+	defer viper.Reset()
+	cfg, err := config.LoadConfigFile(path)
+	require.NoError(t, err)
+	require.NoError(t, cfg.RotateAPIKey())
+
+	file := filepath.Join(t.TempDir(), "metrics.json")
+	exp, err := exporter.NewFileExporter(file, exporter.FileOptions{})
+	require.NoError(t, err)
+	require.NoError(t, exp.Export(types.MetricPayload{TenantID: "a"}))
+	require.NoError(t, exp.Close())
+
+	assert.Equal(t, 0, runReplay(cfg, []string{"--file", file, "--endpoint", server.URL + "/metrics"}))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"rotated-key"}, keys)
+}
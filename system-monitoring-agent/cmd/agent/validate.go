@@ -0,0 +1,41 @@
+// cmd/agent/validate.go
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+const validateUsage = `usage: agent validate-config [file]
+
+Loads a configuration file, ./configs/config.yaml by default, and reports the
+first problem that would stop the agent from starting with it.`
+
+// runValidateConfig handles the "validate-config" subcommand
+func runValidateConfig(args []string) int {
+	flags := newFlagSet("validate-config", validateUsage)
+	if err := flags.Parse(args); err != nil {
+		return usageError(err)
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.LoadConfigFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration is invalid: %v\n", err)
+		return 1
+	}
+	// Loading skips the tenant checks when no tenant is set, but the agent
+	// can't run without one
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration is invalid: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Configuration %s is valid (revision %s)\n", config.ConfigFile(), cfg.Revision)
+	return 0
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	valid := write("valid.yaml", `
+Tenant:
+  ID: "tenant-123"
+  APIKey: "01234567890123456789012345678901"
+  Endpoints:
+    Metrics: "http://localhost:8080/metrics"
+Interval: 30
+`)
+	assert.Equal(t, 0, runValidateConfig([]string{valid}))
+
+	badEndpoint := write("endpoint.yaml", `
+Tenant:
+  ID: "tenant-123"
+  APIKey: "01234567890123456789012345678901"
+  Endpoints:
+    Metrics: "not a url"
+Interval: 30
+`)
+	assert.Equal(t, 1, runValidateConfig([]string{badEndpoint}))
+
+	// Loading tolerates a missing tenant; validation doesn't
+	assert.Equal(t, 1, runValidateConfig([]string{write("empty.yaml", "Interval: 30\n")}))
+	assert.Equal(t, 1, runValidateConfig([]string{filepath.Join(dir, "missing.yaml")}))
+	assert.Equal(t, 2, runValidateConfig([]string{valid, badEndpoint}))
+}
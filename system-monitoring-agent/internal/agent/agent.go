@@ -2,33 +2,66 @@
 package agent
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"reflect"
+	"sync"
 	"time"
 
+	"github.com/travism26/shared-monitoring-libs/types"
 	"github.com/travism26/system-monitoring-agent/internal/config"
 	"github.com/travism26/system-monitoring-agent/internal/exporter"
 	"github.com/travism26/system-monitoring-agent/internal/metrics"
 )
 
+const (
+	// configWatchInterval is how often the config file is checked for changes
+	configWatchInterval = 5 * time.Second
+	// defaultShutdownTimeout bounds Shutdown when ShutdownTimeout isn't set
+	defaultShutdownTimeout = 15 * time.Second
+)
+
+// ErrPayloadsDropped reports payloads lost while shutting down
+var ErrPayloadsDropped = errors.New("payloads dropped on shutdown")
+
 type Agent struct {
 	config    *config.Config
 	metrics   *metrics.MetricsCollector
 	exporters []exporter.MetricsExporter
 	interval  time.Duration
+	reload    chan struct{}
+	watcher   *config.FileWatcher
 }
 
 func NewAgent(cfg *config.Config, mc *metrics.MetricsCollector, exporters ...exporter.MetricsExporter) *Agent {
-	// Use tenant-specific sample rate if configured, otherwise use global interval
+	return &Agent{
+		config:    cfg,
+		metrics:   mc,
+		exporters: exporters,
+		interval:  collectionInterval(cfg),
+		reload:    make(chan struct{}, 1),
+		watcher:   config.NewFileWatcher(config.ConfigFile()),
+	}
+}
+
+// collectionInterval uses the tenant-specific sample rate if configured,
+// otherwise the global interval
+func collectionInterval(cfg *config.Config) time.Duration {
 	interval := cfg.Interval
 	if cfg.Tenant.CollectionRules.SampleRate > 0 {
 		interval = cfg.Tenant.CollectionRules.SampleRate
 	}
+	return time.Duration(interval) * time.Second
+}
 
-	return &Agent{
-		config:    cfg,
-		metrics:   mc,
-		exporters: exporters,
-		interval:  time.Duration(interval) * time.Second,
+// Reload asks the running agent to re-read its config file, as on SIGHUP.
+// It returns without waiting for the reload.
+func (a *Agent) Reload() {
+	select {
+	case a.reload <- struct{}{}:
+	default:
 	}
 }
 
@@ -39,7 +72,10 @@ func (a *Agent) validateTenantContext() error {
 	return nil
 }
 
-func (a *Agent) Start(done chan struct{}) {
+// Start collects and exports metrics every interval until ctx is cancelled.
+// A collection in progress is cut short and not exported; exporters are left
+// open for Shutdown.
+func (a *Agent) Start(ctx context.Context) {
 	// Validate tenant context before starting
 	if err := a.validateTenantContext(); err != nil {
 		log.Printf("Error validating tenant context: %v", err)
@@ -48,32 +84,188 @@ func (a *Agent) Start(done chan struct{}) {
 
 	ticker := time.NewTicker(a.interval)
 	defer ticker.Stop()
+	watch := time.NewTicker(configWatchInterval)
+	defer watch.Stop()
 
 	for {
 		select {
-		case <-done:
+		case <-ctx.Done():
 			return
+		case <-a.reload:
+			a.reloadConfig(ticker)
+		case <-watch.C:
+			if a.watcher.Changed() {
+				log.Printf("[INFO] Config file changed, reloading")
+				a.reloadConfig(ticker)
+			}
 		case <-ticker.C:
-			data := a.metrics.Collect()
-
-			// Export metrics with retry logic
-			for _, exp := range a.exporters {
-				retries := 0
-				maxRetries := a.config.HTTP.RetryAttempts
-
-				for retries < maxRetries {
-					if err := exp.Export(data); err != nil {
-						if retries == maxRetries-1 {
-							log.Printf("Error exporting metrics after %d retries: %v", maxRetries, err)
-							break
-						}
-						retries++
-						time.Sleep(time.Duration(a.config.HTTP.RetryDelay) * time.Second)
-						continue
-					}
-					break
-				}
+			data := a.metrics.CollectContext(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			a.export(ctx, data)
+		}
+	}
+}
+
+// export hands a payload to each exporter, retrying failures up to
+// HTTP.RetryAttempts times. Waits between attempts end on cancellation.
+func (a *Agent) export(ctx context.Context, data types.MetricPayload) {
+	maxRetries := a.config.HTTP.RetryAttempts
+	delay := time.Duration(a.config.HTTP.RetryDelay) * time.Second
+
+	for _, exp := range a.exporters {
+		for attempt := 1; ; attempt++ {
+			err := exp.Export(data)
+			if err == nil {
+				break
+			}
+			if attempt >= maxRetries {
+				log.Printf("Error exporting metrics after %d retries: %v", maxRetries, err)
+				break
+			}
+			if !sleep(ctx, delay) {
+				log.Printf("Error exporting metrics, stopped retrying on shutdown: %v", err)
+				break
+			}
+		}
+	}
+}
+
+// sleep waits for d, returning false if ctx is cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ShutdownTimeout is how long Shutdown should be given, from the
+// ShutdownTimeout setting
+func (a *Agent) ShutdownTimeout() time.Duration {
+	if a.config.ShutdownTimeout > 0 {
+		return time.Duration(a.config.ShutdownTimeout) * time.Second
+	}
+	return defaultShutdownTimeout
+}
+
+// Shutdown closes the exporters once Start has returned. Those holding
+// undelivered payloads get until ctx is done to deliver them or save them to
+// their offline queue. The error wraps ErrPayloadsDropped if any payloads
+// were lost while shutting down.
+func (a *Agent) Shutdown(ctx context.Context) error {
+	droppedBefore := a.dropped()
+
+	// Closed together, so one slow exporter doesn't use up the others' time
+	errs := make([]error, len(a.exporters))
+	var wg sync.WaitGroup
+	for i, exp := range a.exporters {
+		wg.Add(1)
+		go func(i int, exp exporter.MetricsExporter) {
+			defer wg.Done()
+			var err error
+			if s, ok := exp.(exporter.Shutdowner); ok {
+				err = s.Shutdown(ctx)
+			} else {
+				err = exp.Close()
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("closing %T: %w", exp, err)
+			}
+		}(i, exp)
+	}
+	wg.Wait()
+
+	if dropped := a.dropped() - droppedBefore; dropped > 0 {
+		errs = append(errs, fmt.Errorf("%w: %d", ErrPayloadsDropped, dropped))
+	}
+	return errors.Join(errs...)
+}
+
+// dropped totals the payloads the exporters have discarded undelivered
+func (a *Agent) dropped() int64 {
+	var total int64
+	for _, exp := range a.exporters {
+		if counter, ok := exp.(exporter.DropCounter); ok {
+			total += counter.Dropped()
+		}
+	}
+	return total
+}
+
+// reloadConfig re-reads the config file and applies it. A file that fails
+// validation is never applied; one that components refuse is rolled back.
+// Reloads run between collections, so collectors never see a half-applied
+// configuration.
+func (a *Agent) reloadConfig(ticker *time.Ticker) {
+	// A signalled reload also covers a change the watcher hasn't seen yet
+	a.watcher.Changed()
+
+	previous := a.config.Snapshot()
+	if err := a.config.ReloadConfig(); err != nil {
+		log.Printf("[ERROR] Rejected config reload, keeping version %s: %v", previous.Revision, err)
+		return
+	}
+
+	if err := a.applyConfig(previous); err != nil {
+		log.Printf("[ERROR] Failed to apply config version %s, rolling back to %s: %v", a.config.Revision, previous.Revision, err)
+		rejected := a.config.Snapshot()
+		if err := a.config.Restore(previous); err != nil {
+			log.Printf("[ERROR] Failed to restore config version %s: %v", previous.Revision, err)
+		}
+		if err := a.applyConfig(rejected); err != nil {
+			log.Printf("[ERROR] Failed to reapply config version %s: %v", previous.Revision, err)
+		}
+	} else {
+		log.Printf("[INFO] Applied config version %s", a.config.Revision)
+	}
+	ticker.Reset(a.interval)
+}
+
+// applyConfig brings the exporters, collectors and interval in line with the
+// current configuration, given the one it replaces
+func (a *Agent) applyConfig(previous *config.Config) error {
+	for _, exp := range a.exporters {
+		if reloader, ok := exp.(exporter.Reloader); ok {
+			if err := reloader.Reload(a.config); err != nil {
+				return fmt.Errorf("%T: %w", exp, err)
 			}
 		}
 	}
+	a.metrics.Reload(previous)
+	a.interval = collectionInterval(a.config)
+
+	if changed := restartRequired(previous, a.config); len(changed) > 0 {
+		log.Printf("[WARN] Changes to %v take effect after a restart", changed)
+	}
+	return nil
+}
+
+// restartRequired lists changed sections that are only read at startup
+func restartRequired(previous, current *config.Config) []string {
+	sections := []struct {
+		name      string
+		old, next interface{}
+	}{
+		{"LogFilePath", previous.LogFilePath, current.LogFilePath},
+		{"LogSettings", previous.LogSettings, current.LogSettings},
+		{"Kafka", previous.Kafka, current.Kafka},
+		{"Prometheus", previous.Prometheus, current.Prometheus},
+		{"Storage", previous.Storage, current.Storage},
+		{"Security", previous.Security, current.Security},
+		// The key manager keeps its endpoints, so a rotated key isn't lost
+		{"Tenant.Endpoints.KeyValidation", previous.Tenant.Endpoints.KeyValidation, current.Tenant.Endpoints.KeyValidation},
+		{"Tenant.Endpoints.KeyRotation", previous.Tenant.Endpoints.KeyRotation, current.Tenant.Endpoints.KeyRotation},
+	}
+	var changed []string
+	for _, section := range sections {
+		if !reflect.DeepEqual(section.old, section.next) {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
 }
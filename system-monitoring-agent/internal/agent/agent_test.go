@@ -1,11 +1,18 @@
 package agent
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/shirou/gopsutil/mem"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/travism26/shared-monitoring-libs/types"
 	"github.com/travism26/system-monitoring-agent/internal/config"
 	"github.com/travism26/system-monitoring-agent/internal/core"
@@ -76,6 +83,10 @@ func (m *MockMonitor) GetProcesses() ([]core.ProcessInfo, error) {
 	return []core.ProcessInfo{}, nil
 }
 
+func (m *MockMonitor) GetConnections() ([]core.ConnectionInfo, error) {
+	return []core.ConnectionInfo{}, nil
+}
+
 type MockHTTPExporter struct{}
 
 func (m *MockHTTPExporter) Export(data types.MetricPayload) error {
@@ -88,13 +99,16 @@ func (m *MockHTTPExporter) Close() error {
 
 func TestNewAgent(t *testing.T) {
 	cfg := &config.Config{
-		LogFilePath: "./agent.log",
+		LogFilePath: filepath.Join(t.TempDir(), "agent.log"),
 		Interval:    60,
 	}
 	mon := &MockMonitor{}
 	mc := metrics.NewMetricsCollector(mon, cfg)
+	fileExporter, err := exporter.NewFileExporter(cfg.LogFilePath, exporter.FileOptions{})
+	require.NoError(t, err)
+	t.Cleanup(func() { fileExporter.Close() })
 	exporters := []exporter.MetricsExporter{
-		exporter.NewFileExporter(cfg.LogFilePath),
+		fileExporter,
 		&MockHTTPExporter{},
 	}
 	agent := NewAgent(cfg, mc, exporters...)
@@ -106,27 +120,164 @@ func TestNewAgent(t *testing.T) {
 
 func TestAgentStart(t *testing.T) {
 	cfg := &config.Config{
-		LogFilePath: "./agent.log",
+		LogFilePath: filepath.Join(t.TempDir(), "agent.log"),
 		Interval:    1, // Set a short interval for testing
 	}
 	mon := &MockMonitor{}
 	mc := metrics.NewMetricsCollector(mon, cfg)
+	fileExporter, err := exporter.NewFileExporter(cfg.LogFilePath, exporter.FileOptions{})
+	require.NoError(t, err)
+	t.Cleanup(func() { fileExporter.Close() })
 	exporters := []exporter.MetricsExporter{
-		exporter.NewFileExporter(cfg.LogFilePath),
+		fileExporter,
 		&MockHTTPExporter{},
 	}
 	agent := NewAgent(cfg, mc, exporters...)
 
 	// Run Start in a separate goroutine
-	done := make(chan struct{})
-	go agent.Start(done)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		agent.Start(ctx)
+	}()
 
 	// Allow some time for the ticker to tick
 	time.Sleep(3 * time.Second)
-	close(done)
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after cancellation")
+	}
 
 	// Here you would typically check if the metrics were exported correctly.
 	// This might involve checking the output file or using a mock exporter.
 	// For simplicity, we will just assert that the function runs without panic.
 	assert.NotNil(t, agent)
 }
+
+// reloadingExporter records the interval of each config it is reloaded with
+// and refuses failInterval
+type reloadingExporter struct {
+	MockHTTPExporter
+	reloads      []int
+	failInterval int
+}
+
+func (r *reloadingExporter) Reload(cfg *config.Config) error {
+	r.reloads = append(r.reloads, cfg.Interval)
+	if cfg.Interval == r.failInterval {
+		return errors.New("refused")
+	}
+	return nil
+}
+
+const reloadTestConfig = `
+Tenant:
+  CollectionRules:
+    EnabledMetrics: ["cpu"]
+    SampleRate: 0
+Baseline:
+  Enabled: false
+Interval: %d
+`
+
+func TestAgentReloadAppliesAndRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	writeConfig := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	writeConfig(fmt.Sprintf(reloadTestConfig, 30))
+
+	viper.Reset()
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(dir)
+	cfg, err := config.LoadConfig()
+	require.NoError(t, err)
+
+	mc := metrics.NewMetricsCollector(&MockMonitor{}, cfg)
+	exp := &reloadingExporter{failInterval: 20}
+	agent := NewAgent(cfg, mc, exp)
+	ticker := time.NewTicker(agent.interval)
+	defer ticker.Stop()
+	assert.Equal(t, 30*time.Second, agent.interval)
+
+	// A valid change is applied
+	writeConfig(fmt.Sprintf(reloadTestConfig, 10))
+	agent.reloadConfig(ticker)
+	assert.Equal(t, 10*time.Second, agent.interval)
+	applied := cfg.Revision
+
+	// A file that doesn't parse is never applied
+	writeConfig("Interval: [")
+	agent.reloadConfig(ticker)
+	assert.Equal(t, 10*time.Second, agent.interval)
+	assert.Equal(t, applied, cfg.Revision)
+
+	// A change an exporter refuses is rolled back everywhere
+	writeConfig(fmt.Sprintf(reloadTestConfig, 20))
+	agent.reloadConfig(ticker)
+	assert.Equal(t, 10*time.Second, agent.interval)
+	assert.Equal(t, 10, cfg.Interval)
+	assert.Equal(t, applied, cfg.Revision)
+	assert.Equal(t, []int{10, 20, 10}, exp.reloads)
+
+	payload := mc.Collect()
+	assert.Equal(t, applied, payload.TenantMetadata["config_version"])
+}
+
+// shutdownExporter drops a payload when its shutdown deadline passes before
+// it has finished
+type shutdownExporter struct {
+	MockHTTPExporter
+	delay   time.Duration
+	dropped int64
+	closed  bool
+}
+
+func (s *shutdownExporter) Shutdown(ctx context.Context) error {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		s.dropped++
+	}
+	s.closed = true
+	return nil
+}
+
+func (s *shutdownExporter) Dropped() int64 {
+	return s.dropped
+}
+
+func TestAgentShutdown(t *testing.T) {
+	cfg := &config.Config{Interval: 1}
+	mc := metrics.NewMetricsCollector(&MockMonitor{}, cfg)
+	assert.Equal(t, defaultShutdownTimeout, NewAgent(cfg, mc).ShutdownTimeout())
+
+	t.Run("within deadline", func(t *testing.T) {
+		fast := &shutdownExporter{delay: 10 * time.Millisecond}
+		agent := NewAgent(cfg, mc, fast, &MockHTTPExporter{})
+		require.NoError(t, agent.Shutdown(context.Background()))
+		assert.True(t, fast.closed)
+	})
+
+	t.Run("past deadline", func(t *testing.T) {
+		fast := &shutdownExporter{delay: 10 * time.Millisecond}
+		slow := &shutdownExporter{delay: time.Minute, dropped: 2}
+		agent := NewAgent(cfg, mc, fast, slow)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := agent.Shutdown(ctx)
+		assert.Less(t, time.Since(start), 5*time.Second)
+		assert.True(t, fast.closed)
+		assert.True(t, slow.closed)
+		// Only drops during shutdown count
+		assert.ErrorIs(t, err, ErrPayloadsDropped)
+		assert.ErrorContains(t, err, "payloads dropped on shutdown: 1")
+	})
+}
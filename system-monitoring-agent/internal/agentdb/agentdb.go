@@ -0,0 +1,35 @@
+// Package agentdb opens the SQLite database the agent keeps its state in
+package agentdb
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// fileName is the database file under the storage directory
+const fileName = "metrics.db"
+
+// Open opens the agent database under storageDir, creating the directory if
+// needed. The offline queues, FIM baseline, persistence inventory, auth log
+// offsets and metric baselines all share this file, so connections wait for
+// each other's locks rather than failing with SQLITE_BUSY.
+func Open(storageDir string) (*sql.DB, error) {
+	if err := os.MkdirAll(storageDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	dbPath := filepath.Join(storageDir, fileName) + "?_busy_timeout=5000"
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return db, nil
+}
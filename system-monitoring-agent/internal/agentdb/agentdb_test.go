@@ -0,0 +1,21 @@
+package agentdb
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	db, err := Open(dir)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.FileExists(t, filepath.Join(dir, fileName))
+
+	var timeout int
+	require.NoError(t, db.QueryRow("PRAGMA busy_timeout").Scan(&timeout))
+	assert.Equal(t, 5000, timeout)
+}
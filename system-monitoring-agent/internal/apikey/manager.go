@@ -8,10 +8,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"net/http"
 	"sync"
 	"time"
 )
 
+// DefaultHeader carries the key when Config.Header is not set
+const DefaultHeader = "X-API-Key"
+
 var (
 	ErrKeyExpired      = errors.New("API key has expired")
 	ErrKeyInvalid      = errors.New("invalid API key")
@@ -35,17 +40,33 @@ type Manager struct {
 	// Current active key
 	currentKey string
 
-	// Encrypted backup key for rotation
-	backupKey []byte
+	// Encrypted backup key for rotation, accepted by the server until
+	// backupUntil
+	backupKey   []byte
+	backupUntil time.Time
+
+	// source identifies the configured key the current one was rotated
+	// from, so a saved key is only reused while that key is configured
+	source string
+
+	// rotateRequested is set when the server asks for a new key
+	rotateRequested bool
 
 	// Key metadata
 	status KeyStatus
 
-	// Encryption key for securing stored keys
+	// Encryption key for securing stored keys, and whether it has been
+	// saved next to KeyFile yet
 	encryptionKey []byte
+	secretSaved   bool
 
 	// Configuration
 	config Config
+	client *http.Client
+
+	stop      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
 }
 
 // Config holds API key manager configuration
@@ -53,6 +74,19 @@ type Config struct {
 	// Validation endpoint for checking key status
 	ValidationEndpoint string
 
+	// Endpoint that issues a new key in exchange for the current one
+	RotationEndpoint string
+
+	// Header carrying the key in requests to both endpoints
+	Header string
+
+	// File the rotated key is saved to, so it survives restarts. Empty
+	// keeps rotated keys in memory only.
+	KeyFile string
+
+	// Called with the new key after every change, outside the manager's lock
+	OnKeyChange func(key string)
+
 	// How often to check key validity
 	ValidationInterval time.Duration
 
@@ -63,23 +97,36 @@ type Config struct {
 	EncryptKeys bool
 }
 
-// NewManager creates a new API key manager
+// NewManager creates a new API key manager. A key saved to KeyFile by an
+// earlier rotation of initialKey takes its place.
 func NewManager(initialKey string, cfg Config) (*Manager, error) {
 	if initialKey == "" {
 		return nil, ErrKeyInvalid
 	}
+	if cfg.Header == "" {
+		cfg.Header = DefaultHeader
+	}
 
-	// Generate encryption key if encryption is enabled
+	// Load or generate the encryption key if encryption is enabled
 	var encKey []byte
+	secretSaved := false
 	if cfg.EncryptKeys {
-		encKey = make([]byte, 32)
-		if _, err := io.ReadFull(rand.Reader, encKey); err != nil {
-			return nil, fmt.Errorf("failed to generate encryption key: %w", err)
+		var err error
+		if encKey, err = loadSecret(cfg.KeyFile); err != nil {
+			log.Printf("[WARN] Replacing unreadable API key encryption key: %v", err)
+		}
+		secretSaved = encKey != nil
+		if encKey == nil {
+			encKey = make([]byte, 32)
+			if _, err := io.ReadFull(rand.Reader, encKey); err != nil {
+				return nil, fmt.Errorf("failed to generate encryption key: %w", err)
+			}
 		}
 	}
 
 	m := &Manager{
 		currentKey: initialKey,
+		source:     fingerprint(initialKey),
 		status: KeyStatus{
 			IsValid:   true, // Assume initially valid
 			ExpiresAt: time.Now().Add(cfg.MaxKeyAge),
@@ -87,17 +134,40 @@ func NewManager(initialKey string, cfg Config) (*Manager, error) {
 			RotatedAt: time.Now(),
 		},
 		encryptionKey: encKey,
+		secretSaved:   secretSaved,
 		config:        cfg,
+		client:        &http.Client{Timeout: requestTimeout},
+		stop:          make(chan struct{}),
 	}
-
-	// Start validation routine if interval is set
-	if cfg.ValidationInterval > 0 {
-		go m.startValidationRoutine()
+	if err := m.load(); err != nil {
+		log.Printf("[WARN] Ignoring saved API key, using the configured one: %v", err)
 	}
 
 	return m, nil
 }
 
+// SetClient replaces the client used to reach the validation and rotation
+// endpoints, so they can share the metrics exporter's TLS settings
+func (m *Manager) SetClient(client *http.Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.client = client
+}
+
+// Start begins the validation routine, which checks the key every
+// ValidationInterval and rotates it when due, until Stop is called
+func (m *Manager) Start() {
+	if m.config.ValidationInterval <= 0 {
+		return
+	}
+	m.startOnce.Do(func() { go m.startValidationRoutine() })
+}
+
+// Stop ends the validation routine
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
 // GetKey returns the current API key
 func (m *Manager) GetKey() string {
 	m.mu.RLock()
@@ -105,6 +175,21 @@ func (m *Manager) GetKey() string {
 	return m.currentKey
 }
 
+// BackupKey returns the key replaced by the last rotation while the server
+// still accepts it
+func (m *Manager) BackupKey() (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.backupKey == nil || !time.Now().Before(m.backupUntil) {
+		return "", false
+	}
+	key, err := m.decryptKey(m.backupKey)
+	if err != nil {
+		return "", false
+	}
+	return key, true
+}
+
 // GetStatus returns the current key status
 func (m *Manager) GetStatus() KeyStatus {
 	m.mu.RLock()
@@ -112,45 +197,129 @@ func (m *Manager) GetStatus() KeyStatus {
 	return m.status
 }
 
-// UpdateKey sets a new API key
+// UpdateKey sets a new API key, as configured by an operator. It replaces
+// any rotated key and has no grace window for the one it replaces.
 func (m *Manager) UpdateKey(newKey string) error {
 	if newKey == "" {
 		return ErrKeyInvalid
 	}
 
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	// Encrypt and store current key as backup before updating
 	if m.config.EncryptKeys {
 		encrypted, err := m.encryptKey(m.currentKey)
 		if err != nil {
+			m.mu.Unlock()
 			return fmt.Errorf("failed to backup current key: %w", err)
 		}
 		m.backupKey = encrypted
 	}
+	m.backupUntil = time.Time{}
 
 	m.currentKey = newKey
+	m.source = fingerprint(newKey)
+	m.rotateRequested = false
 	m.status.RotatedAt = time.Now()
 	m.status.LastUsed = time.Now()
 	m.status.ExpiresAt = time.Now().Add(m.config.MaxKeyAge)
 	m.status.IsValid = true
 	m.status.ValidationError = nil
+	m.mu.Unlock()
 
+	m.notify(newKey)
 	return nil
 }
 
-// RotateKey generates and updates to a new API key
+// RotateKey exchanges the current key for a new one at the rotation
+// endpoint. The new key is saved to KeyFile and takes over at once; the old
+// one is kept as the backup for the grace window the server gives it. If the
+// new key can't be saved it is used anyway, since the server has already
+// retired the old one, and the error says so.
 func (m *Manager) RotateKey() error {
-	// Implementation would integrate with your key management system
-	// to generate and validate a new key
-	return ErrKeyRotationFail
+	if m.config.RotationEndpoint == "" {
+		return fmt.Errorf("%w: no rotation endpoint configured", ErrKeyRotationFail)
+	}
+
+	m.mu.RLock()
+	current, client := m.currentKey, m.client
+	m.mu.RUnlock()
+
+	issued, err := m.requestRotation(client, current)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	if m.currentKey != current {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: key changed during rotation", ErrKeyRotationFail)
+	}
+	backup, err := m.encryptKey(current)
+	if err != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %v", ErrKeyRotationFail, err)
+	}
+	m.backupKey = backup
+	m.backupUntil = now.Add(issued.GracePeriod)
+	m.currentKey = issued.Key
+	m.rotateRequested = false
+	m.status = KeyStatus{
+		IsValid:   true,
+		ExpiresAt: issued.ExpiresAt,
+		LastUsed:  now,
+		RotatedAt: now,
+	}
+	if m.status.ExpiresAt.IsZero() {
+		m.status.ExpiresAt = now.Add(m.config.MaxKeyAge)
+	}
+	saveErr := m.save()
+	m.mu.Unlock()
+
+	m.notify(issued.Key)
+	if saveErr != nil {
+		return fmt.Errorf("rotated API key in use but not saved: %w", saveErr)
+	}
+	return nil
 }
 
-// ValidateKey checks if the current key is valid
+// ValidateKey checks if the current key is valid, asking the validation
+// endpoint when one is configured. The server's answer replaces the local
+// expiry; if it can't be reached the key's status is left as it was.
 func (m *Manager) ValidateKey() error {
+	m.mu.RLock()
+	key, client := m.currentKey, m.client
+	m.mu.RUnlock()
+
+	var result *validation
+	if m.config.ValidationEndpoint != "" {
+		var err error
+		if result, err = m.requestValidation(client, key); err != nil {
+			m.mu.Lock()
+			m.status.ValidationError = err
+			m.mu.Unlock()
+			return err
+		}
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.currentKey != key {
+		// Rotated meanwhile; the answer is about a retired key
+		return nil
+	}
+
+	if result != nil {
+		if !result.Valid {
+			m.status.IsValid = false
+			m.status.ValidationError = ErrKeyInvalid
+			return ErrKeyInvalid
+		}
+		if !result.ExpiresAt.IsZero() {
+			m.status.ExpiresAt = result.ExpiresAt
+		}
+		m.rotateRequested = result.Rotate
+	}
 
 	// Check expiration
 	if time.Now().After(m.status.ExpiresAt) {
@@ -159,26 +328,60 @@ func (m *Manager) ValidateKey() error {
 		return ErrKeyExpired
 	}
 
-	// Implementation would call validation endpoint
-	// For now, just update last used timestamp
+	m.status.IsValid = true
+	m.status.ValidationError = nil
 	m.status.LastUsed = time.Now()
 	return nil
 }
 
-// startValidationRoutine periodically validates the API key
+// rotationDue reports whether the key should be rotated: the server asked
+// for it, or it expires before the next check
+func (m *Manager) rotationDue(now time.Time) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.config.RotationEndpoint == "" {
+		return false
+	}
+	return m.rotateRequested || !now.Before(m.status.ExpiresAt.Add(-m.config.ValidationInterval))
+}
+
+// startValidationRoutine periodically validates the API key, rotating it
+// when due
 func (m *Manager) startValidationRoutine() {
 	ticker := time.NewTicker(m.config.ValidationInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		if err := m.ValidateKey(); err != nil {
-			// Log validation error but continue running
-			// The error is stored in status.ValidationError
-			continue
+	for {
+		select {
+		case <-ticker.C:
+			m.check()
+		case <-m.stop:
+			return
 		}
 	}
 }
 
+// check validates the key and rotates it if due. Errors are logged and
+// kept in the key's status.
+func (m *Manager) check() {
+	if err := m.ValidateKey(); err != nil {
+		log.Printf("[WARN] API key validation failed: %v", err)
+	}
+	if m.rotationDue(time.Now()) {
+		if err := m.RotateKey(); err != nil {
+			log.Printf("[ERROR] API key rotation failed: %v", err)
+			return
+		}
+		log.Printf("[INFO] Rotated API key")
+	}
+}
+
+func (m *Manager) notify(key string) {
+	if m.config.OnKeyChange != nil {
+		m.config.OnKeyChange(key)
+	}
+}
+
 // encryptKey encrypts an API key for secure storage
 func (m *Manager) encryptKey(key string) ([]byte, error) {
 	if !m.config.EncryptKeys {
@@ -1,8 +1,17 @@
 package apikey
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewManager(t *testing.T) {
@@ -161,3 +170,205 @@ func TestManager_Encryption(t *testing.T) {
 		t.Errorf("Key encryption/decryption failed, got = %v, want = %v", decrypted, "secret-key")
 	}
 }
+
+// keyServer validates and rotates keys the way the agent's backend does,
+// accepting a replaced key for grace after rotation
+type keyServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	valid    map[string]bool
+	next     int
+	grace    int
+	rotate   bool
+	requests []string
+}
+
+func newKeyServer(t *testing.T, key string) *keyServer {
+	ks := &keyServer{valid: map[string]bool{key: true}, grace: 3600}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		key := r.Header.Get("X-Agent-Key")
+		ks.requests = append(ks.requests, "validate "+key)
+		if !ks.valid[key] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"valid": true, "expires_at": "2099-01-01T00:00:00Z", "rotate": %t}`, ks.rotate)
+	})
+	mux.HandleFunc("/rotate", func(w http.ResponseWriter, r *http.Request) {
+		ks.mu.Lock()
+		defer ks.mu.Unlock()
+		key := r.Header.Get("X-Agent-Key")
+		ks.requests = append(ks.requests, "rotate "+key)
+		if r.Method != http.MethodPost || !ks.valid[key] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ks.next++
+		issued := fmt.Sprintf("rotated-key-%d", ks.next)
+		ks.valid[issued] = true
+		ks.rotate = false
+		fmt.Fprintf(w, `{"api_key": %q, "expires_at": "2099-01-01T00:00:00Z", "grace_period": %d}`, issued, ks.grace)
+	})
+	ks.Server = httptest.NewServer(mux)
+	t.Cleanup(ks.Close)
+	return ks
+}
+
+func (ks *keyServer) config(keyFile string) Config {
+	return Config{
+		ValidationEndpoint: ks.URL + "/validate",
+		RotationEndpoint:   ks.URL + "/rotate",
+		Header:             "X-Agent-Key",
+		KeyFile:            keyFile,
+		MaxKeyAge:          24 * time.Hour,
+		EncryptKeys:        true,
+	}
+}
+
+func TestManager_ValidateKeyAgainstEndpoint(t *testing.T) {
+	server := newKeyServer(t, "initial-key")
+	manager, err := NewManager("initial-key", server.config(""))
+	require.NoError(t, err)
+
+	require.NoError(t, manager.ValidateKey())
+	status := manager.GetStatus()
+	assert.True(t, status.IsValid)
+	assert.Equal(t, time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC), status.ExpiresAt)
+	assert.Equal(t, []string{"validate initial-key"}, server.requests)
+
+	// A key the server refuses is marked invalid
+	manager, err = NewManager("unknown-key", server.config(""))
+	require.NoError(t, err)
+	assert.ErrorIs(t, manager.ValidateKey(), ErrKeyInvalid)
+	assert.False(t, manager.GetStatus().IsValid)
+
+	// An unreachable server leaves the key's status alone
+	server.Close()
+	manager, err = NewManager("initial-key", server.config(""))
+	require.NoError(t, err)
+	assert.Error(t, manager.ValidateKey())
+	assert.True(t, manager.GetStatus().IsValid)
+}
+
+func TestManager_RotateKey(t *testing.T) {
+	server := newKeyServer(t, "initial-key")
+	keyFile := filepath.Join(t.TempDir(), "api_key")
+	cfg := server.config(keyFile)
+	var changes []string
+	cfg.OnKeyChange = func(key string) { changes = append(changes, key) }
+
+	manager, err := NewManager("initial-key", cfg)
+	require.NoError(t, err)
+	require.NoError(t, manager.RotateKey())
+	assert.Equal(t, "rotated-key-1", manager.GetKey())
+	assert.Equal(t, []string{"rotated-key-1"}, changes)
+	assert.Equal(t, []string{"rotate initial-key"}, server.requests)
+
+	// The replaced key is the backup during the server's grace window
+	backup, ok := manager.BackupKey()
+	assert.True(t, ok)
+	assert.Equal(t, "initial-key", backup)
+
+	// Saved encrypted, for the owner only
+	data, err := os.ReadFile(keyFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "rotated-key-1")
+	for _, path := range []string{keyFile, keyFile + ".secret"} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+
+	// A restart with the same configured key picks up the rotated one
+	restarted, err := NewManager("initial-key", server.config(keyFile))
+	require.NoError(t, err)
+	assert.Equal(t, "rotated-key-1", restarted.GetKey())
+	backup, ok = restarted.BackupKey()
+	assert.True(t, ok)
+	assert.Equal(t, "initial-key", backup)
+
+	// A newly configured key replaces the rotated one
+	replaced, err := NewManager("operator-key", server.config(keyFile))
+	require.NoError(t, err)
+	assert.Equal(t, "operator-key", replaced.GetKey())
+}
+
+func TestManager_RotateKeyFailures(t *testing.T) {
+	server := newKeyServer(t, "initial-key")
+
+	// Without a grace window the old key is not kept as a backup
+	server.grace = 0
+	manager, err := NewManager("initial-key", server.config(""))
+	require.NoError(t, err)
+	require.NoError(t, manager.RotateKey())
+	_, ok := manager.BackupKey()
+	assert.False(t, ok)
+
+	refused, err := NewManager("unknown-key", server.config(""))
+	require.NoError(t, err)
+	assert.ErrorIs(t, refused.RotateKey(), ErrKeyRotationFail)
+	assert.Equal(t, "unknown-key", refused.GetKey())
+
+	unconfigured, err := NewManager("initial-key", Config{MaxKeyAge: time.Hour})
+	require.NoError(t, err)
+	assert.ErrorIs(t, unconfigured.RotateKey(), ErrKeyRotationFail)
+}
+
+func TestManager_CheckRotatesWhenDue(t *testing.T) {
+	server := newKeyServer(t, "initial-key")
+	cfg := server.config("")
+	cfg.ValidationInterval = time.Hour
+
+	// Not due: long before expiry and not asked to
+	manager, err := NewManager("initial-key", cfg)
+	require.NoError(t, err)
+	defer manager.Stop()
+	manager.check()
+	assert.Equal(t, "initial-key", manager.GetKey())
+
+	// The server asks for a new key
+	server.rotate = true
+	manager.check()
+	assert.Equal(t, "rotated-key-1", manager.GetKey())
+
+	// The key expires before the next check
+	cfg.ValidationEndpoint = ""
+	cfg.MaxKeyAge = 30 * time.Minute
+	expiring, err := NewManager("rotated-key-1", cfg)
+	require.NoError(t, err)
+	defer expiring.Stop()
+	expiring.check()
+	assert.Equal(t, "rotated-key-2", expiring.GetKey())
+}
+
+func TestManager_StartRunsValidationRoutine(t *testing.T) {
+	server := newKeyServer(t, "initial-key")
+	server.rotate = true
+	cfg := server.config("")
+	cfg.ValidationInterval = 10 * time.Millisecond
+
+	manager, err := NewManager("initial-key", cfg)
+	require.NoError(t, err)
+	defer manager.Stop()
+	time.Sleep(50 * time.Millisecond)
+	server.mu.Lock()
+	assert.Empty(t, server.requests, "the routine shouldn't run before Start")
+	server.mu.Unlock()
+
+	manager.Start()
+	assert.Eventually(t, func() bool { return manager.GetKey() == "rotated-key-1" }, 5*time.Second, 10*time.Millisecond)
+
+	// A check already under way may still finish
+	manager.Stop()
+	time.Sleep(50 * time.Millisecond)
+	server.mu.Lock()
+	requests := len(server.requests)
+	server.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	assert.Len(t, server.requests, requests, "the routine should end on Stop")
+}
@@ -0,0 +1,114 @@
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// requestTimeout bounds requests to the key endpoints
+const requestTimeout = 10 * time.Second
+
+// validation is the validation endpoint's answer. A 200 response with an
+// empty body counts as valid; 401 and 403 as invalid.
+//
+//	{"valid": true, "expires_at": "2024-06-01T00:00:00Z", "rotate": false}
+type validation struct {
+	Valid     bool      `json:"valid"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Rotate asks the agent to rotate the key before it expires
+	Rotate bool `json:"rotate"`
+}
+
+// issuedKey is the rotation endpoint's answer. GracePeriod is how long the
+// server keeps accepting the key being replaced.
+//
+//	{"api_key": "...", "expires_at": "2024-06-01T00:00:00Z", "grace_period": 3600}
+type issuedKey struct {
+	Key         string        `json:"api_key"`
+	ExpiresAt   time.Time     `json:"expires_at"`
+	GracePeriod time.Duration `json:"-"`
+}
+
+func (k *issuedKey) UnmarshalJSON(data []byte) error {
+	type plain issuedKey
+	var raw struct {
+		plain
+		GracePeriod int64 `json:"grace_period"` // seconds
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*k = issuedKey(raw.plain)
+	k.GracePeriod = time.Duration(raw.GracePeriod) * time.Second
+	return nil
+}
+
+// requestValidation asks the validation endpoint about key
+func (m *Manager) requestValidation(client *http.Client, key string) (*validation, error) {
+	resp, body, err := m.call(client, http.MethodGet, m.config.ValidationEndpoint, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach key validation endpoint: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return &validation{Valid: false}, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("key validation endpoint returned status %d", resp.StatusCode)
+	}
+
+	result := &validation{Valid: true}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, result); err != nil {
+			return nil, fmt.Errorf("invalid key validation response: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// requestRotation exchanges key for a new one at the rotation endpoint
+func (m *Manager) requestRotation(client *http.Client, key string) (*issuedKey, error) {
+	resp, body, err := m.call(client, http.MethodPost, m.config.RotationEndpoint, key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKeyRotationFail, err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("%w: server returned status %d", ErrKeyRotationFail, resp.StatusCode)
+	}
+
+	var issued issuedKey
+	if err := json.Unmarshal(body, &issued); err != nil {
+		return nil, fmt.Errorf("%w: invalid response: %v", ErrKeyRotationFail, err)
+	}
+	if issued.Key == "" || issued.Key == key {
+		return nil, fmt.Errorf("%w: server issued no new key", ErrKeyRotationFail)
+	}
+	return &issued, nil
+}
+
+// call sends an empty request carrying key and reads a bounded response
+func (m *Manager) call(client *http.Client, method, url, key string) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set(m.config.Header, key)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
@@ -0,0 +1,157 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// storedKey is what KeyFile holds, encrypted when EncryptKeys is set
+type storedKey struct {
+	Key         string    `json:"key"`
+	Backup      string    `json:"backup,omitempty"`
+	BackupUntil time.Time `json:"backup_until,omitempty"`
+	RotatedAt   time.Time `json:"rotated_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// Source is the fingerprint of the configured key this one was
+	// rotated from
+	Source string `json:"source"`
+}
+
+// fingerprint identifies a key without revealing it
+func fingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// secretFile holds the encryption key for KeyFile
+func secretFile(keyFile string) string {
+	return keyFile + ".secret"
+}
+
+// loadSecret reads the encryption key saved next to keyFile, returning nil
+// if there is none yet
+func loadSecret(keyFile string) ([]byte, error) {
+	if keyFile == "" {
+		return nil, nil
+	}
+	secret, err := os.ReadFile(secretFile(keyFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API key encryption key: %w", err)
+	}
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("%w: %s is not a 32 byte key", ErrEncryptionFail, secretFile(keyFile))
+	}
+	return secret, nil
+}
+
+// load takes over a key saved by an earlier rotation of the configured key.
+// A key rotated from a different configured key is ignored, so an operator
+// can replace a rotated key by configuring a new one.
+func (m *Manager) load() error {
+	if m.config.KeyFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.config.KeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read saved API key: %w", err)
+	}
+	plaintext, err := m.decryptKey(data)
+	if err != nil {
+		return fmt.Errorf("failed to read saved API key: %w", err)
+	}
+	var stored storedKey
+	if err := json.Unmarshal([]byte(plaintext), &stored); err != nil {
+		return fmt.Errorf("failed to read saved API key: %w", err)
+	}
+	if stored.Source != m.source || stored.Key == "" {
+		return nil
+	}
+
+	m.currentKey = stored.Key
+	m.status.RotatedAt = stored.RotatedAt
+	m.status.ExpiresAt = stored.ExpiresAt
+	if stored.Backup != "" {
+		if m.backupKey, err = m.encryptKey(stored.Backup); err != nil {
+			return err
+		}
+		m.backupUntil = stored.BackupUntil
+	}
+	return nil
+}
+
+// save writes the current key to KeyFile, replacing it atomically. Callers
+// hold the lock.
+func (m *Manager) save() error {
+	if m.config.KeyFile == "" {
+		return nil
+	}
+	stored := storedKey{
+		Key:       m.currentKey,
+		RotatedAt: m.status.RotatedAt,
+		ExpiresAt: m.status.ExpiresAt,
+		Source:    m.source,
+	}
+	if m.backupKey != nil {
+		backup, err := m.decryptKey(m.backupKey)
+		if err != nil {
+			return err
+		}
+		stored.Backup, stored.BackupUntil = backup, m.backupUntil
+	}
+	plaintext, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	data, err := m.encryptKey(string(plaintext))
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.config.KeyFile), 0o700); err != nil {
+		return err
+	}
+	if m.config.EncryptKeys && !m.secretSaved {
+		if err := writeFileAtomic(secretFile(m.config.KeyFile), m.encryptionKey); err != nil {
+			return err
+		}
+		m.secretSaved = true
+	}
+	return writeFileAtomic(m.config.KeyFile, data)
+}
+
+// writeFileAtomic replaces path with data, readable by the owner only
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,181 @@
+package authlog
+
+import (
+	"sort"
+	"time"
+)
+
+// Finding kinds reported by the Detector
+const (
+	FindingBruteForce        = "brute_force"
+	FindingPasswordSpray     = "password_spray"
+	FindingBruteForceSuccess = "brute_force_success"
+)
+
+// Finding is a detection over the recent logins from one source IP
+type Finding struct {
+	Kind      string
+	SourceIP  string
+	Failures  int
+	Users     []string
+	User      string // account logged into, for brute_force_success
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+type attempt struct {
+	at      time.Time
+	user    string
+	failure bool
+}
+
+// Detector tracks remote login attempts per source IP over a sliding window.
+// Record timestamps are used rather than the wall clock, so a burst read late
+// from the log is still judged by when it happened.
+type Detector struct {
+	window     time.Duration
+	bruteForce int
+	spray      int
+
+	attempts map[string][]attempt
+	// alerted holds when each kind was last reported per source, so an
+	// ongoing attack is reported once per window rather than per line
+	alerted map[string]time.Time
+	// swept is when every source was last pruned. A source that stops
+	// appearing is only dropped by a sweep.
+	swept time.Time
+}
+
+// NewDetector creates a Detector reporting brute force at bruteForce failed
+// logins and password spraying at spray distinct user names from one source
+// within window
+func NewDetector(window time.Duration, bruteForce, spray int) *Detector {
+	return &Detector{
+		window:     window,
+		bruteForce: bruteForce,
+		spray:      spray,
+		attempts:   make(map[string][]attempt),
+		alerted:    make(map[string]time.Time),
+	}
+}
+
+// Observe records a login event and returns any new findings
+func (d *Detector) Observe(rec LoginRecord) []Finding {
+	if rec.SourceIP == "" {
+		return nil
+	}
+	switch rec.Event {
+	case EventLoginFailure, EventInvalidUser, EventLoginSuccess:
+	default:
+		return nil
+	}
+
+	d.sweep(rec.Timestamp)
+	attempts := d.prune(rec.SourceIP, rec.Timestamp)
+	if rec.Event == EventLoginSuccess {
+		failures := countFailures(attempts)
+		// The attempts that led to a successful login are no longer a
+		// running attack
+		delete(d.attempts, rec.SourceIP)
+		if failures >= d.bruteForce {
+			return []Finding{d.finding(FindingBruteForceSuccess, rec, attempts, rec.User)}
+		}
+		return nil
+	}
+
+	// Invalid user lines usually precede a failed password line for the same
+	// attempt, so they only count towards the user names tried
+	attempts = append(attempts, attempt{at: rec.Timestamp, user: rec.User, failure: rec.Event == EventLoginFailure})
+	d.attempts[rec.SourceIP] = attempts
+
+	var findings []Finding
+	if countFailures(attempts) >= d.bruteForce && d.shouldAlert(FindingBruteForce, rec) {
+		findings = append(findings, d.finding(FindingBruteForce, rec, attempts, ""))
+	}
+	if len(distinctUsers(attempts)) >= d.spray && d.shouldAlert(FindingPasswordSpray, rec) {
+		findings = append(findings, d.finding(FindingPasswordSpray, rec, attempts, ""))
+	}
+	return findings
+}
+
+// prune drops attempts from a source that fell out of the window
+func (d *Detector) prune(source string, now time.Time) []attempt {
+	attempts := d.attempts[source]
+	cutoff := now.Add(-d.window)
+	i := 0
+	for i < len(attempts) && !attempts[i].at.After(cutoff) {
+		i++
+	}
+	attempts = attempts[i:]
+	if len(attempts) == 0 {
+		delete(d.attempts, source)
+	} else {
+		d.attempts[source] = attempts
+	}
+	return attempts
+}
+
+// sweep prunes every source and drops expired alerts, at most once per
+// window, so scanners that try once and move on don't accumulate
+func (d *Detector) sweep(now time.Time) {
+	if now.Sub(d.swept) < d.window {
+		return
+	}
+	d.swept = now
+	for source := range d.attempts {
+		d.prune(source, now)
+	}
+	cutoff := now.Add(-d.window)
+	for key, at := range d.alerted {
+		if !at.After(cutoff) {
+			delete(d.alerted, key)
+		}
+	}
+}
+
+func (d *Detector) shouldAlert(kind string, rec LoginRecord) bool {
+	key := kind + "/" + rec.SourceIP
+	if at, ok := d.alerted[key]; ok && at.After(rec.Timestamp.Add(-d.window)) {
+		return false
+	}
+	d.alerted[key] = rec.Timestamp
+	return true
+}
+
+func (d *Detector) finding(kind string, rec LoginRecord, attempts []attempt, user string) Finding {
+	finding := Finding{
+		Kind:     kind,
+		SourceIP: rec.SourceIP,
+		Failures: countFailures(attempts),
+		Users:    distinctUsers(attempts),
+		User:     user,
+		LastSeen: rec.Timestamp,
+	}
+	if len(attempts) > 0 {
+		finding.FirstSeen = attempts[0].at
+	}
+	return finding
+}
+
+func countFailures(attempts []attempt) int {
+	count := 0
+	for _, a := range attempts {
+		if a.failure {
+			count++
+		}
+	}
+	return count
+}
+
+func distinctUsers(attempts []attempt) []string {
+	seen := make(map[string]bool)
+	var users []string
+	for _, a := range attempts {
+		if a.user != "" && !seen[a.user] {
+			seen[a.user] = true
+			users = append(users, a.user)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
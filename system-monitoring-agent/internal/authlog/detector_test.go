@@ -0,0 +1,100 @@
+package authlog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var detectStart = time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func failure(at time.Duration, ip, user string) LoginRecord {
+	return LoginRecord{Timestamp: detectStart.Add(at), Service: "sshd", Event: EventLoginFailure, SourceIP: ip, User: user}
+}
+
+func TestDetectorBruteForce(t *testing.T) {
+	d := NewDetector(5*time.Minute, 5, 10)
+
+	var findings []Finding
+	for i := 0; i < 8; i++ {
+		findings = append(findings, d.Observe(failure(time.Duration(i)*time.Second, "203.0.113.9", "root"))...)
+	}
+	// Reported once, at the fifth failure
+	require.Len(t, findings, 1)
+	assert.Equal(t, FindingBruteForce, findings[0].Kind)
+	assert.Equal(t, 5, findings[0].Failures)
+	assert.Equal(t, []string{"root"}, findings[0].Users)
+	assert.Equal(t, detectStart, findings[0].FirstSeen)
+
+	// Other sources are tracked separately
+	assert.Empty(t, d.Observe(failure(10*time.Second, "198.51.100.1", "root")))
+
+	// A success after the attempts is reported
+	success := LoginRecord{Timestamp: detectStart.Add(20 * time.Second), Event: EventLoginSuccess, SourceIP: "203.0.113.9", User: "root"}
+	findings = d.Observe(success)
+	require.Len(t, findings, 1)
+	assert.Equal(t, FindingBruteForceSuccess, findings[0].Kind)
+	assert.Equal(t, "root", findings[0].User)
+	assert.Equal(t, 8, findings[0].Failures)
+}
+
+func TestDetectorSlidingWindow(t *testing.T) {
+	d := NewDetector(time.Minute, 3, 10)
+
+	// Failures spread wider than the window never add up
+	for i := 0; i < 10; i++ {
+		assert.Empty(t, d.Observe(failure(time.Duration(i)*31*time.Second, "203.0.113.9", "root")))
+	}
+
+	// Once the window has passed, a renewed attack is reported again
+	d = NewDetector(time.Minute, 3, 10)
+	var findings []Finding
+	for i := 0; i < 3; i++ {
+		findings = append(findings, d.Observe(failure(time.Duration(i)*time.Second, "203.0.113.9", "root"))...)
+	}
+	for i := 0; i < 3; i++ {
+		findings = append(findings, d.Observe(failure(2*time.Minute+time.Duration(i)*time.Second, "203.0.113.9", "root"))...)
+	}
+	assert.Len(t, findings, 2)
+}
+
+func TestDetectorPasswordSpray(t *testing.T) {
+	d := NewDetector(5*time.Minute, 100, 4)
+
+	var findings []Finding
+	for i := 0; i < 4; i++ {
+		user := fmt.Sprintf("user%d", i)
+		invalid := LoginRecord{Timestamp: detectStart.Add(time.Duration(i) * time.Second), Event: EventInvalidUser, SourceIP: "203.0.113.9", User: user}
+		findings = append(findings, d.Observe(invalid)...)
+	}
+	require.Len(t, findings, 1)
+	assert.Equal(t, FindingPasswordSpray, findings[0].Kind)
+	assert.Equal(t, []string{"user0", "user1", "user2", "user3"}, findings[0].Users)
+	// Invalid user lines don't count as failed passwords
+	assert.Equal(t, 0, findings[0].Failures)
+
+	// Local events without a source are ignored
+	assert.Empty(t, d.Observe(LoginRecord{Timestamp: detectStart, Event: EventSuFailure, User: "bob"}))
+}
+
+func TestDetectorForgetsIdleSources(t *testing.T) {
+	d := NewDetector(time.Minute, 3, 10)
+
+	// Scanners that try once and move on
+	for i := 0; i < 500; i++ {
+		d.Observe(failure(time.Duration(i)*10*time.Millisecond, fmt.Sprintf("10.0.%d.%d", i/256, i%256), "root"))
+	}
+	for i := 0; i < 3; i++ {
+		d.Observe(failure(10*time.Second+time.Duration(i)*time.Second, "203.0.113.9", "root"))
+	}
+	assert.Len(t, d.alerted, 1)
+
+	// Any source's next line, a couple of windows later, drops them all
+	d.Observe(failure(3*time.Minute, "192.0.2.1", "admin"))
+	assert.Len(t, d.attempts, 1)
+	assert.Contains(t, d.attempts, "192.0.2.1")
+	assert.Empty(t, d.alerted)
+}
@@ -0,0 +1,74 @@
+package authlog
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// Monitor tails a set of auth logs and feeds their events to a Detector
+type Monitor struct {
+	tailers  []*Tailer
+	offsets  *OffsetStore
+	detector *Detector
+	now      func() time.Time
+}
+
+func NewMonitor(paths []string, offsets *OffsetStore, detector *Detector) *Monitor {
+	tailers := make([]*Tailer, 0, len(paths))
+	for _, path := range paths {
+		tailers = append(tailers, NewTailer(path, offsets))
+	}
+	return &Monitor{
+		tailers:  tailers,
+		offsets:  offsets,
+		detector: detector,
+		now:      time.Now,
+	}
+}
+
+// Close closes the offset store
+func (m *Monitor) Close() error {
+	if m.offsets == nil {
+		return nil
+	}
+	return m.offsets.Close()
+}
+
+// Poll returns the login records appended to the logs since the previous
+// poll and the findings they triggered. Logs that don't exist on this host
+// are skipped; an error is returned only when no log could be read.
+func (m *Monitor) Poll() ([]LoginRecord, []Finding, error) {
+	var records []LoginRecord
+	var findings []Finding
+	var lastErr error
+	read := 0
+	now := m.now()
+
+	for _, tailer := range m.tailers {
+		lines, err := tailer.ReadLines()
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				lastErr = err
+			}
+			if lines == nil {
+				continue
+			}
+		}
+		read++
+
+		for _, line := range lines {
+			rec, ok := ParseLine(line, now)
+			if !ok {
+				continue
+			}
+			records = append(records, rec)
+			findings = append(findings, m.detector.Observe(rec)...)
+		}
+	}
+
+	if read == 0 && lastErr != nil {
+		return nil, nil, lastErr
+	}
+	return records, findings, nil
+}
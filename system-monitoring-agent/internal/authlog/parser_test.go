@@ -0,0 +1,84 @@
+package authlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var parseNow = time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want LoginRecord
+	}{
+		{
+			name: "ssh publickey login",
+			line: "Mar 10 11:58:01 web1 sshd[2101]: Accepted publickey for alice from 198.51.100.4 port 50122 ssh2: ED25519 SHA256:abc",
+			want: LoginRecord{Service: "sshd", PID: 2101, Event: EventLoginSuccess, User: "alice", SourceIP: "198.51.100.4", Port: 50122, Method: "publickey"},
+		},
+		{
+			name: "ssh failed password for invalid user",
+			line: "Mar  9 23:10:44 web1 sshd[2200]: Failed password for invalid user admin from 203.0.113.9 port 40000 ssh2",
+			want: LoginRecord{Service: "sshd", PID: 2200, Event: EventLoginFailure, User: "admin", SourceIP: "203.0.113.9", Port: 40000, Method: "password"},
+		},
+		{
+			name: "ssh invalid user",
+			line: "2025-03-10T11:00:00.123456+00:00 web1 sshd[2201]: Invalid user oracle from 2001:db8::7 port 41000",
+			want: LoginRecord{Service: "sshd", PID: 2201, Event: EventInvalidUser, User: "oracle", SourceIP: "2001:db8::7", Port: 41000},
+		},
+		{
+			name: "sudo command",
+			line: "Mar 10 11:00:00 web1 sudo:    alice : TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/usr/bin/apt update",
+			want: LoginRecord{Service: "sudo", Event: EventSudoCommand, User: "alice", TargetUser: "root", TTY: "pts/0", Command: "/usr/bin/apt update"},
+		},
+		{
+			name: "sudo wrong password",
+			line: "Mar 10 11:00:00 web1 sudo:    bob : 3 incorrect password attempts ; TTY=pts/1 ; PWD=/home/bob ; USER=root ; COMMAND=/bin/bash",
+			want: LoginRecord{Service: "sudo", Event: EventSudoFailure, User: "bob", TargetUser: "root", TTY: "pts/1", Command: "/bin/bash"},
+		},
+		{
+			name: "su success",
+			line: "Mar 10 11:00:00 web1 su[300]: (to root) alice on pts/0",
+			want: LoginRecord{Service: "su", PID: 300, Event: EventSuSuccess, User: "alice", TargetUser: "root", TTY: "pts/0"},
+		},
+		{
+			name: "su failure",
+			line: "Mar 10 11:00:00 web1 su[301]: pam_unix(su:auth): authentication failure; logname=bob uid=1001 euid=0 tty=/dev/pts/1 ruser=bob rhost=  user=root",
+			want: LoginRecord{Service: "su", PID: 301, Event: EventSuFailure, User: "bob", TargetUser: "root"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseLine(tt.line, parseNow)
+			require.True(t, ok)
+			assert.Equal(t, "web1", got.Host)
+			assert.False(t, got.Timestamp.IsZero())
+			got.Timestamp, got.Host = time.Time{}, ""
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseLineIgnoresOtherMessages(t *testing.T) {
+	for _, line := range []string{
+		"Mar 10 11:00:00 web1 sshd[1]: Connection closed by 203.0.113.9 port 40000 [preauth]",
+		"Mar 10 11:00:00 web1 CRON[2]: pam_unix(cron:session): session opened for user root",
+		"not a syslog line",
+	} {
+		_, ok := ParseLine(line, parseNow)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestParseTimestampYearRollover(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 5, 0, 0, time.UTC)
+	rec, ok := ParseLine("Dec 31 23:59:00 web1 sshd[1]: Invalid user x from 203.0.113.9", now)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC), rec.Timestamp)
+}
@@ -0,0 +1,183 @@
+package authlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/travism26/system-monitoring-agent/internal/agentdb"
+)
+
+// maxReadBytes bounds how much of a log is consumed per cycle so a flood of
+// log lines can't stall collection; the rest is read on later cycles
+const maxReadBytes = 4 << 20
+
+// fingerprintBytes is how much of the start of a file identifies it. A
+// rotated log starts with different lines, so its fingerprint changes.
+const fingerprintBytes = 256
+
+// OffsetStore persists read positions in the agent's SQLite database so
+// tailing resumes where it stopped after a restart
+type OffsetStore struct {
+	db *sql.DB
+}
+
+// NewOffsetStore opens the offsets table in metrics.db under storageDir
+func NewOffsetStore(storageDir string) (*OffsetStore, error) {
+	db, err := agentdb.Open(storageDir)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS authlog_offsets (
+			path TEXT PRIMARY KEY,
+			offset INTEGER NOT NULL,
+			fingerprint TEXT NOT NULL,
+			updated_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create authlog_offsets table: %w", err)
+	}
+
+	return &OffsetStore{db: db}, nil
+}
+
+// position is a saved read offset together with the fingerprint of the file
+// it was taken in
+type position struct {
+	offset      int64
+	fingerprint string
+}
+
+func (s *OffsetStore) load(path string) (position, bool, error) {
+	var pos position
+	err := s.db.QueryRow("SELECT offset, fingerprint FROM authlog_offsets WHERE path = ?", path).Scan(&pos.offset, &pos.fingerprint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return position{}, false, nil
+	}
+	if err != nil {
+		return position{}, false, fmt.Errorf("failed to load offset for %s: %w", path, err)
+	}
+	return pos, true, nil
+}
+
+func (s *OffsetStore) save(path string, pos position) error {
+	_, err := s.db.Exec(
+		"INSERT OR REPLACE INTO authlog_offsets (path, offset, fingerprint, updated_at) VALUES (?, ?, ?, ?)",
+		path, pos.offset, pos.fingerprint, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save offset for %s: %w", path, err)
+	}
+	return nil
+}
+
+func (s *OffsetStore) Close() error {
+	return s.db.Close()
+}
+
+// Tailer reads the lines appended to a log file since the last call
+type Tailer struct {
+	path    string
+	offsets *OffsetStore
+}
+
+func NewTailer(path string, offsets *OffsetStore) *Tailer {
+	return &Tailer{path: path, offsets: offsets}
+}
+
+// ReadLines returns the complete lines appended since the saved offset. A
+// file seen for the first time is read from its end, so history from before
+// the agent was installed isn't reported. After rotation (the file shrank or
+// its start changed) reading restarts at the beginning of the new file.
+func (t *Tailer) ReadLines() ([]string, error) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	saved, ok, err := t.offsets.load(t.path)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, t.savePosition(f, info.Size())
+	}
+
+	offset := saved.offset
+	if offset > info.Size() {
+		offset = 0
+	} else if fp, err := fingerprint(f, offset); err != nil {
+		return nil, err
+	} else if fp != saved.fingerprint {
+		offset = 0
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxReadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", t.path, err)
+	}
+
+	// Leave a trailing partial line for the next cycle
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end == 0 && len(data) == maxReadBytes {
+		// A single line longer than the read limit is skipped
+		end = len(data)
+	}
+	lines := splitLines(data[:end])
+
+	if err := t.savePosition(f, offset+int64(end)); err != nil {
+		return lines, err
+	}
+	return lines, nil
+}
+
+func (t *Tailer) savePosition(f *os.File, offset int64) error {
+	fp, err := fingerprint(f, offset)
+	if err != nil {
+		return err
+	}
+	return t.offsets.save(t.path, position{offset: offset, fingerprint: fp})
+}
+
+// fingerprint hashes the start of the file, up to offset
+func fingerprint(f io.ReaderAt, offset int64) (string, error) {
+	n := offset
+	if n > fingerprintBytes {
+		n = fingerprintBytes
+	}
+	buf := make([]byte, n)
+	if _, err := f.ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func splitLines(data []byte) []string {
+	var lines []string
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if line = bytes.TrimRight(line, "\r"); len(line) > 0 {
+			lines = append(lines, string(line))
+		}
+	}
+	return lines
+}
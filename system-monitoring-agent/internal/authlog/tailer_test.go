@@ -0,0 +1,87 @@
+package authlog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendLog(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestTailerResumesAcrossRestarts(t *testing.T) {
+	storageDir := t.TempDir()
+	logPath := filepath.Join(t.TempDir(), "auth.log")
+	appendLog(t, logPath, "old line before install\n")
+
+	offsets, err := NewOffsetStore(storageDir)
+	require.NoError(t, err)
+	tailer := NewTailer(logPath, offsets)
+
+	// Existing history is skipped
+	lines, err := tailer.ReadLines()
+	require.NoError(t, err)
+	assert.Empty(t, lines)
+
+	appendLog(t, logPath, "line 1\nline 2\npartial")
+	lines, err = tailer.ReadLines()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"line 1", "line 2"}, lines)
+	require.NoError(t, offsets.Close())
+
+	// A new process picks up after the last complete line
+	appendLog(t, logPath, " line 3\n")
+	offsets, err = NewOffsetStore(storageDir)
+	require.NoError(t, err)
+	defer offsets.Close()
+	lines, err = NewTailer(logPath, offsets).ReadLines()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"partial line 3"}, lines)
+}
+
+func TestTailerFollowsRotation(t *testing.T) {
+	offsets, err := NewOffsetStore(t.TempDir())
+	require.NoError(t, err)
+	defer offsets.Close()
+
+	logPath := filepath.Join(t.TempDir(), "auth.log")
+	appendLog(t, logPath, "first file line 1\n")
+	tailer := NewTailer(logPath, offsets)
+	_, err = tailer.ReadLines()
+	require.NoError(t, err)
+	appendLog(t, logPath, "first file line 2\n")
+	_, err = tailer.ReadLines()
+	require.NoError(t, err)
+
+	// Rotated to a new file that is already longer than the old offset
+	require.NoError(t, os.Rename(logPath, logPath+".1"))
+	appendLog(t, logPath, "second file line 1 which is long enough\nsecond file line 2\n")
+
+	lines, err := tailer.ReadLines()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"second file line 1 which is long enough", "second file line 2"}, lines)
+
+	// Truncated in place
+	require.NoError(t, os.WriteFile(logPath, []byte("after truncate\n"), 0644))
+	lines, err = tailer.ReadLines()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"after truncate"}, lines)
+}
+
+func TestTailerMissingFile(t *testing.T) {
+	offsets, err := NewOffsetStore(t.TempDir())
+	require.NoError(t, err)
+	defer offsets.Close()
+
+	_, err = NewTailer(filepath.Join(t.TempDir(), "secure"), offsets).ReadLines()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,97 @@
+package baseline
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/travism26/system-monitoring-agent/internal/agentdb"
+)
+
+// Store persists baselines in the agent's SQLite database so they survive
+// restarts
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore opens the baseline table in metrics.db under storageDir
+func NewStore(storageDir string) (*Store, error) {
+	db, err := agentdb.Open(storageDir)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS metric_baselines (
+			metric TEXT NOT NULL,
+			bucket TEXT NOT NULL,
+			mean REAL NOT NULL,
+			variance REAL NOT NULL,
+			samples INTEGER NOT NULL,
+			updated_at DATETIME NOT NULL,
+			PRIMARY KEY (metric, bucket)
+		);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create baseline table: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Load returns every stored baseline keyed by metric and bucket
+func (s *Store) Load() (map[Key]Baseline, error) {
+	rows, err := s.db.Query(`
+		SELECT metric, bucket, mean, variance, samples, updated_at
+		FROM metric_baselines
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query baselines: %w", err)
+	}
+	defer rows.Close()
+
+	baselines := make(map[Key]Baseline)
+	for rows.Next() {
+		var key Key
+		var b Baseline
+		if err := rows.Scan(&key.Metric, &key.Bucket, &b.Mean, &b.Variance, &b.Samples, &b.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan baseline: %w", err)
+		}
+		baselines[key] = b
+	}
+	return baselines, rows.Err()
+}
+
+// Save upserts the given baselines in one transaction
+func (s *Store) Save(baselines map[Key]Baseline) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO metric_baselines (metric, bucket, mean, variance, samples, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(metric, bucket) DO UPDATE SET
+			mean = excluded.mean,
+			variance = excluded.variance,
+			samples = excluded.samples,
+			updated_at = excluded.updated_at
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for key, b := range baselines {
+		if _, err := stmt.Exec(key.Metric, key.Bucket, b.Mean, b.Variance, b.Samples, b.UpdatedAt.UTC()); err != nil {
+			return fmt.Errorf("failed to save baseline for %s: %w", key.Metric, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
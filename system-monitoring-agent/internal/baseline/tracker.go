@@ -0,0 +1,235 @@
+package baseline
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Key identifies one baseline: a metric, and with seasonal baselines the hour
+// of day it covers
+type Key struct {
+	Metric string
+	Bucket string
+}
+
+// Baseline is an exponentially weighted moving mean and variance
+type Baseline struct {
+	Mean      float64
+	Variance  float64
+	Samples   int
+	UpdatedAt time.Time
+}
+
+func (b Baseline) StdDev() float64 {
+	return math.Sqrt(b.Variance)
+}
+
+// update folds a sample into the moving mean and variance
+func (b *Baseline) update(value, alpha float64, now time.Time) {
+	if b.Samples == 0 {
+		b.Mean = value
+		b.Variance = 0
+	} else {
+		diff := value - b.Mean
+		incr := alpha * diff
+		b.Mean += incr
+		b.Variance = (1 - alpha) * (b.Variance + diff*incr)
+	}
+	b.Samples++
+	b.UpdatedAt = now
+}
+
+// Direction is the side of its baseline on which a metric is anomalous
+type Direction string
+
+const (
+	Above Direction = "above"
+	Below Direction = "below"
+	Both  Direction = "both"
+)
+
+// Metric is a value tracked against its baseline. MinStdDev keeps a near
+// constant metric from flagging tiny movements as anomalies. Direction
+// defaults to Above, since a host going quiet is rarely a concern.
+type Metric struct {
+	Name      string
+	MinStdDev float64
+	Direction Direction
+}
+
+// exceeds reports whether a signed deviation is past sigma in the metric's
+// direction
+func (m Metric) exceeds(deviation, sigma float64) bool {
+	switch m.Direction {
+	case Below:
+		return deviation < -sigma
+	case Both:
+		return math.Abs(deviation) > sigma
+	default:
+		return deviation > sigma
+	}
+}
+
+// DefaultMetrics are the host-level values the agent baselines
+func DefaultMetrics() []Metric {
+	return []Metric{
+		{Name: "cpu_usage", MinStdDev: 2},
+		{Name: "memory_usage_percent", MinStdDev: 2},
+		{Name: "network.bytes_received_per_sec", MinStdDev: 10 * 1024},
+		{Name: "network.bytes_sent_per_sec", MinStdDev: 10 * 1024},
+		{Name: "process_count", MinStdDev: 5},
+	}
+}
+
+// Config controls how baselines are learned and when a value is anomalous.
+// Alpha is the EWMA weight of each new sample, Sigma the number of standard
+// deviations from the mean that counts as an anomaly, and WarmupSamples the
+// samples a baseline needs before it is trusted. Seasonal keeps a separate
+// baseline for each hour of the day.
+type Config struct {
+	Alpha         float64
+	Sigma         float64
+	WarmupSamples int
+	Seasonal      bool
+}
+
+// Anomaly is a value that deviated from its baseline by more than the
+// configured number of standard deviations
+type Anomaly struct {
+	Metric    string
+	Bucket    string
+	Value     float64
+	Mean      float64
+	StdDev    float64
+	Deviation float64 // in standard deviations, signed
+	Sigma     float64
+	Samples   int
+}
+
+// Tracker learns per-host baselines and flags values that deviate from them
+type Tracker struct {
+	store     *Store
+	config    Config
+	metrics   map[string]Metric
+	baselines map[Key]Baseline
+	// anomalous holds the metrics whose last value was anomalous, so an
+	// episode is reported when it starts rather than on every sample
+	anomalous map[string]bool
+}
+
+// NewTracker loads the stored baselines. store may be nil, in which case
+// baselines are kept in memory only.
+func NewTracker(store *Store, config Config, metrics []Metric) (*Tracker, error) {
+	if config.Alpha <= 0 || config.Alpha > 1 {
+		return nil, fmt.Errorf("baseline alpha must be in (0, 1], got %g", config.Alpha)
+	}
+	if config.Sigma <= 0 {
+		return nil, fmt.Errorf("baseline sigma must be positive, got %g", config.Sigma)
+	}
+
+	t := &Tracker{
+		store:     store,
+		config:    config,
+		metrics:   make(map[string]Metric, len(metrics)),
+		baselines: make(map[Key]Baseline),
+		anomalous: make(map[string]bool),
+	}
+	for _, metric := range metrics {
+		switch metric.Direction {
+		case "":
+			metric.Direction = Above
+		case Above, Below, Both:
+		default:
+			return nil, fmt.Errorf("baseline direction for %s must be above, below or both, got %q", metric.Name, metric.Direction)
+		}
+		t.metrics[metric.Name] = metric
+	}
+	if store != nil {
+		baselines, err := store.Load()
+		if err != nil {
+			return nil, err
+		}
+		t.baselines = baselines
+	}
+	return t, nil
+}
+
+// Observe checks each tracked value against its baseline, then folds it into
+// the baseline. Values that deviate are still learned from so that a lasting
+// change in workload becomes the new normal. An anomaly is returned once when
+// a metric first deviates, and again only after it has come back within its
+// baseline. Untracked values are ignored.
+func (t *Tracker) Observe(values map[string]float64, now time.Time) ([]Anomaly, error) {
+	var anomalies []Anomaly
+	updated := make(map[Key]Baseline, len(values))
+
+	for name, value := range values {
+		metric, ok := t.metrics[name]
+		if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		key := Key{Metric: name, Bucket: t.bucket(now)}
+		b := t.baselines[key]
+
+		anomalous := false
+		if b.Samples >= t.config.WarmupSamples {
+			stddev := math.Max(b.StdDev(), metric.MinStdDev)
+			if stddev > 0 {
+				deviation := (value - b.Mean) / stddev
+				anomalous = metric.exceeds(deviation, t.config.Sigma)
+				if anomalous && !t.anomalous[name] {
+					anomalies = append(anomalies, Anomaly{
+						Metric:    name,
+						Bucket:    key.Bucket,
+						Value:     value,
+						Mean:      b.Mean,
+						StdDev:    b.StdDev(),
+						Deviation: deviation,
+						Sigma:     t.config.Sigma,
+						Samples:   b.Samples,
+					})
+				}
+			}
+		}
+		if anomalous {
+			t.anomalous[name] = true
+		} else {
+			delete(t.anomalous, name)
+		}
+
+		b.update(value, t.config.Alpha, now)
+		t.baselines[key] = b
+		updated[key] = b
+	}
+
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Metric < anomalies[j].Metric })
+
+	if t.store != nil && len(updated) > 0 {
+		if err := t.store.Save(updated); err != nil {
+			return anomalies, err
+		}
+	}
+	return anomalies, nil
+}
+
+// Baseline returns the current baseline of a metric for the given time
+func (t *Tracker) Baseline(metric string, at time.Time) (Baseline, bool) {
+	b, ok := t.baselines[Key{Metric: metric, Bucket: t.bucket(at)}]
+	return b, ok
+}
+
+func (t *Tracker) bucket(at time.Time) string {
+	if !t.config.Seasonal {
+		return "all"
+	}
+	return fmt.Sprintf("hour_%02d", at.Hour())
+}
+
+func (t *Tracker) Close() error {
+	if t.store == nil {
+		return nil
+	}
+	return t.store.Close()
+}
@@ -0,0 +1,194 @@
+package baseline
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testConfig = Config{Alpha: 0.1, Sigma: 3, WarmupSamples: 20}
+
+// learn feeds a metric alternating around mean by spread
+func learn(t *testing.T, tracker *Tracker, metric string, mean, spread float64, samples int, start time.Time) time.Time {
+	t.Helper()
+	at := start
+	for i := 0; i < samples; i++ {
+		value := mean + spread
+		if i%2 == 1 {
+			value = mean - spread
+		}
+		anomalies, err := tracker.Observe(map[string]float64{metric: value}, at)
+		require.NoError(t, err)
+		require.Empty(t, anomalies)
+		at = at.Add(time.Minute)
+	}
+	return at
+}
+
+func TestTrackerFlagsDeviationsFromHostBaseline(t *testing.T) {
+	metrics := []Metric{{Name: "cpu_usage", MinStdDev: 2}}
+	start := time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	// A build server that normally runs hot with large swings
+	busy, err := NewTracker(nil, testConfig, metrics)
+	require.NoError(t, err)
+	at := learn(t, busy, "cpu_usage", 70, 20, 100, start)
+	anomalies, err := busy.Observe(map[string]float64{"cpu_usage": 95}, at)
+	require.NoError(t, err)
+	assert.Empty(t, anomalies)
+
+	// An idle bastion host
+	idle, err := NewTracker(nil, testConfig, metrics)
+	require.NoError(t, err)
+	at = learn(t, idle, "cpu_usage", 2, 0.5, 100, start)
+	anomalies, err = idle.Observe(map[string]float64{"cpu_usage": 60}, at)
+	require.NoError(t, err)
+	require.Len(t, anomalies, 1)
+	assert.Equal(t, "cpu_usage", anomalies[0].Metric)
+	assert.Equal(t, "all", anomalies[0].Bucket)
+	assert.Equal(t, 60.0, anomalies[0].Value)
+	assert.InDelta(t, 2, anomalies[0].Mean, 0.2)
+	assert.Greater(t, anomalies[0].Deviation, 3.0)
+	assert.Equal(t, 100, anomalies[0].Samples)
+
+	// Small movements on a flat metric stay within the minimum deviation
+	anomalies, err = idle.Observe(map[string]float64{"cpu_usage": 6}, at.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, anomalies)
+}
+
+func TestTrackerDirections(t *testing.T) {
+	start := time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)
+	tracker, err := NewTracker(nil, testConfig, []Metric{
+		{Name: "cpu_usage", MinStdDev: 2},
+		{Name: "process_count", MinStdDev: 5, Direction: Both},
+		{Name: "memory_usage_percent", MinStdDev: 2, Direction: Below},
+	})
+	require.NoError(t, err)
+	at := start
+	for i := 0; i < 100; i++ {
+		_, err := tracker.Observe(map[string]float64{"cpu_usage": 70, "process_count": 300, "memory_usage_percent": 50}, at)
+		require.NoError(t, err)
+		at = at.Add(time.Minute)
+	}
+
+	// A busy server going idle is only reported for metrics watched below
+	anomalies, err := tracker.Observe(map[string]float64{"cpu_usage": 1, "process_count": 100, "memory_usage_percent": 95}, at)
+	require.NoError(t, err)
+	require.Len(t, anomalies, 1)
+	assert.Equal(t, "process_count", anomalies[0].Metric)
+	assert.Less(t, anomalies[0].Deviation, -3.0)
+
+	_, err = NewTracker(nil, testConfig, []Metric{{Name: "cpu_usage", Direction: "sideways"}})
+	assert.Error(t, err)
+}
+
+func TestTrackerReportsEachEpisodeOnce(t *testing.T) {
+	tracker, err := NewTracker(nil, testConfig, []Metric{{Name: "cpu_usage", MinStdDev: 2}})
+	require.NoError(t, err)
+	at := learn(t, tracker, "cpu_usage", 10, 1, 100, time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC))
+
+	observe := func(value float64) []Anomaly {
+		anomalies, err := tracker.Observe(map[string]float64{"cpu_usage": value}, at)
+		require.NoError(t, err)
+		at = at.Add(time.Minute)
+		return anomalies
+	}
+
+	assert.Len(t, observe(90), 1)
+	assert.Empty(t, observe(90), "a continuing anomaly is not reported again")
+	assert.Empty(t, observe(95))
+
+	// Back within the baseline ends the episode
+	assert.Empty(t, observe(10))
+	assert.Len(t, observe(200), 1)
+}
+
+func TestTrackerWarmupAndUntrackedMetrics(t *testing.T) {
+	tracker, err := NewTracker(nil, testConfig, []Metric{{Name: "process_count"}})
+	require.NoError(t, err)
+
+	at := time.Now()
+	for i := 0; i < testConfig.WarmupSamples; i++ {
+		value := 100.0
+		if i == 5 {
+			value = 1000
+		}
+		anomalies, err := tracker.Observe(map[string]float64{"process_count": value, "other": math.NaN()}, at)
+		require.NoError(t, err)
+		assert.Empty(t, anomalies, "no anomalies during warmup")
+	}
+	_, ok := tracker.Baseline("other", at)
+	assert.False(t, ok)
+
+	b, ok := tracker.Baseline("process_count", at)
+	require.True(t, ok)
+	assert.Equal(t, testConfig.WarmupSamples, b.Samples)
+}
+
+func TestTrackerSeasonalBuckets(t *testing.T) {
+	config := testConfig
+	config.Seasonal = true
+	tracker, err := NewTracker(nil, config, []Metric{{Name: "cpu_usage", MinStdDev: 1}})
+	require.NoError(t, err)
+
+	// Busy during the nightly job at 02:00, idle at 14:00
+	night := time.Date(2025, 3, 10, 2, 0, 0, 0, time.Local)
+	day := time.Date(2025, 3, 10, 14, 0, 0, 0, time.Local)
+	for i := 0; i < 30; i++ {
+		_, err := tracker.Observe(map[string]float64{"cpu_usage": 90}, night)
+		require.NoError(t, err)
+		_, err = tracker.Observe(map[string]float64{"cpu_usage": 5}, day)
+		require.NoError(t, err)
+	}
+
+	anomalies, err := tracker.Observe(map[string]float64{"cpu_usage": 90}, night.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, anomalies)
+
+	anomalies, err = tracker.Observe(map[string]float64{"cpu_usage": 90}, day.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, anomalies, 1)
+	assert.Equal(t, "hour_14", anomalies[0].Bucket)
+}
+
+func TestTrackerPersistsBaselines(t *testing.T) {
+	dir := t.TempDir()
+	metrics := []Metric{{Name: "memory_usage_percent", MinStdDev: 1}}
+	at := time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	store, err := NewStore(dir)
+	require.NoError(t, err)
+	tracker, err := NewTracker(store, testConfig, metrics)
+	require.NoError(t, err)
+	learn(t, tracker, "memory_usage_percent", 40, 1, 30, at)
+	before, _ := tracker.Baseline("memory_usage_percent", at)
+	require.NoError(t, tracker.Close())
+
+	store, err = NewStore(dir)
+	require.NoError(t, err)
+	tracker, err = NewTracker(store, testConfig, metrics)
+	require.NoError(t, err)
+	defer tracker.Close()
+
+	after, ok := tracker.Baseline("memory_usage_percent", at)
+	require.True(t, ok)
+	assert.Equal(t, before.Samples, after.Samples)
+	assert.InDelta(t, before.Mean, after.Mean, 1e-9)
+	assert.InDelta(t, before.Variance, after.Variance, 1e-9)
+
+	// The restored baseline is used straight away
+	anomalies, err := tracker.Observe(map[string]float64{"memory_usage_percent": 95}, at)
+	require.NoError(t, err)
+	assert.Len(t, anomalies, 1)
+}
+
+func TestNewTrackerRejectsInvalidConfig(t *testing.T) {
+	_, err := NewTracker(nil, Config{Alpha: 0, Sigma: 3}, nil)
+	assert.Error(t, err)
+	_, err = NewTracker(nil, Config{Alpha: 0.1, Sigma: 0}, nil)
+	assert.Error(t, err)
+}
@@ -2,10 +2,18 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,12 +24,19 @@ import (
 // ConfigVersion tracks configuration changes
 const CurrentConfigVersion = "1.0.0"
 
+// apiKeyFile is where rotated API keys are saved, under HTTP.StorageDir
+const apiKeyFile = "api_key"
+
 var (
 	ErrInvalidTenantID     = errors.New("invalid tenant ID format")
 	ErrMissingAPIKey       = errors.New("API key is required")
 	ErrInvalidEndpoint     = errors.New("invalid endpoint URL")
 	ErrInvalidRetention    = errors.New("invalid retention period")
 	ErrInvalidStorageLimit = errors.New("invalid storage limit")
+	ErrInvalidInterval     = errors.New("invalid collection interval")
+	ErrInvalidCollector    = errors.New("invalid external collector")
+	ErrInvalidOption       = errors.New("invalid option")
+	ErrInvalidKafkaTopic   = errors.New("invalid Kafka topic")
 )
 
 // TenantConfig holds tenant-specific configuration
@@ -35,6 +50,11 @@ type TenantConfig struct {
 		Metrics       string `yaml:"Metrics"`
 		HealthCheck   string `yaml:"HealthCheck"`
 		KeyValidation string `yaml:"KeyValidation"`
+		// KeyRotation issues a new API key in exchange for the current one
+		KeyRotation string `yaml:"KeyRotation"`
+		// MetricsFailover are further metrics endpoints, tried in order after
+		// Metrics
+		MetricsFailover []string `yaml:"MetricsFailover"`
 	} `yaml:"Endpoints"`
 	CollectionRules struct {
 		EnabledMetrics []string `yaml:"EnabledMetrics"` // List of enabled metric types
@@ -43,23 +63,29 @@ type TenantConfig struct {
 	} `yaml:"CollectionRules"`
 }
 
-// LogSettings holds logging configuration
+// LogSettings holds logging configuration. The size, age and compression
+// settings also govern rotation of the metrics file at LogFilePath.
 type LogSettings struct {
 	Level      string `yaml:"Level"`
-	Format     string `yaml:"Format"`
-	MaxSize    int    `yaml:"MaxSize"`
-	MaxBackups int    `yaml:"MaxBackups"`
-	MaxAge     int    `yaml:"MaxAge"`
-	Compress   bool   `yaml:"Compress"`
+	Format     string `yaml:"Format"`     // metrics file format: json (one object per line), pretty or csv
+	MaxSize    int    `yaml:"MaxSize"`    // MB before the file is rotated
+	MaxBackups int    `yaml:"MaxBackups"` // rotated files kept
+	MaxAge     int    `yaml:"MaxAge"`     // days rotated files are kept
+	Compress   bool   `yaml:"Compress"`   // gzip rotated files
+	// RotateInterval also rotates the file every this many hours, aligned to
+	// UTC. Zero rotates on size only.
+	RotateInterval int    `yaml:"RotateInterval"`
+	Fsync          string `yaml:"Fsync"` // never (default), always or rotate
 }
 
 // KafkaConfig holds Kafka-related configuration
 type KafkaConfig struct {
+	Enabled          bool     `yaml:"Enabled"`
 	Brokers          []string `yaml:"Brokers"`
 	Topic            string   `yaml:"Topic"`
 	TenantTopic      string   `yaml:"TenantTopic"`
-	SecurityProtocol string   `yaml:"SecurityProtocol"`
-	SASLMechanism    string   `yaml:"SASLMechanism"`
+	SecurityProtocol string   `yaml:"SecurityProtocol"` // plaintext, ssl, sasl_plaintext or sasl_ssl
+	SASLMechanism    string   `yaml:"SASLMechanism"`    // PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
 	Username         string   `yaml:"Username"`
 	Password         string   `yaml:"Password"`
 }
@@ -75,6 +101,108 @@ type HTTPConfig struct {
 		TenantID string `yaml:"TenantID"`
 		APIKey   string `yaml:"APIKey"`
 	} `yaml:"Headers"`
+	// Payloads are sent together once BatchSize are pending or BatchInterval
+	// seconds have passed since the first
+	BatchSize      int                  `yaml:"BatchSize"`
+	BatchInterval  int                  `yaml:"BatchInterval"`
+	Compression    string               `yaml:"Compression"`   // none, gzip or zstd
+	MaxRetryDelay  int                  `yaml:"MaxRetryDelay"` // seconds
+	CircuitBreaker CircuitBreakerConfig `yaml:"CircuitBreaker"`
+	Failover       FailoverConfig       `yaml:"Failover"`
+}
+
+// CircuitBreakerConfig pauses delivery for ResetTimeout seconds after
+// FailureThreshold consecutive failures
+type CircuitBreakerConfig struct {
+	FailureThreshold int `yaml:"FailureThreshold"`
+	ResetTimeout     int `yaml:"ResetTimeout"`
+}
+
+// FailoverConfig controls how the HTTP exporter spreads requests over the
+// metrics endpoints. An endpoint that fails FailureThreshold consecutive
+// requests, or its health check, is skipped for Cooldown seconds.
+type FailoverConfig struct {
+	Strategy            string `yaml:"Strategy"` // failover or round_robin
+	FailureThreshold    int    `yaml:"FailureThreshold"`
+	Cooldown            int    `yaml:"Cooldown"`            // seconds
+	HealthCheckInterval int    `yaml:"HealthCheckInterval"` // seconds
+}
+
+// OTLPConfig enables the OpenTelemetry exporter when Endpoint is set.
+// Metrics are posted to <Endpoint>/v1/metrics and threat indicators, as log
+// records, to <Endpoint>/v1/logs.
+type OTLPConfig struct {
+	Endpoint    string            `yaml:"Endpoint"` // e.g. http://localhost:4318
+	Headers     map[string]string `yaml:"Headers"`
+	Compression string            `yaml:"Compression"` // none or gzip
+	Timeout     int               `yaml:"Timeout"`     // seconds
+}
+
+// PrometheusConfig enables a scrape endpoint serving the latest collection
+// when ListenAddress is set
+type PrometheusConfig struct {
+	ListenAddress string `yaml:"ListenAddress"` // e.g. :9273
+	Path          string `yaml:"Path"`          // defaults to /metrics
+}
+
+// SyslogConfig enables the syslog exporter when Address is set. Each threat
+// indicator, and optionally a metric summary per collection, is sent as an
+// RFC 5424 message.
+type SyslogConfig struct {
+	Address  string `yaml:"Address"`  // host:port
+	Protocol string `yaml:"Protocol"` // udp, tcp or tls
+	Format   string `yaml:"Format"`   // structured or cef
+	Facility string `yaml:"Facility"` // e.g. local0, auth
+	AppName  string `yaml:"AppName"`
+	// EnterpriseID is the IANA private enterprise number qualifying the
+	// structured-data IDs, e.g. threat@<EnterpriseID>
+	EnterpriseID string `yaml:"EnterpriseID"`
+	// SeverityMap maps indicator severities (low, medium, high, critical) to
+	// syslog severity names, overriding the defaults
+	SeverityMap   map[string]string `yaml:"SeverityMap"`
+	MetricSummary bool              `yaml:"MetricSummary"`
+}
+
+// CollectorsConfig bounds how long each collector may run and how one that
+// keeps failing is paused. Durations are in seconds.
+type CollectorsConfig struct {
+	Timeout int `yaml:"Timeout"` // per collector, default 10
+	// Timeouts overrides Timeout for collectors by metric name, e.g. processes
+	Timeouts map[string]int `yaml:"Timeouts"`
+	// FailureThreshold consecutive failures pause a collector, for 30 seconds
+	// at first and doubling up to MaxBackoff while it keeps failing
+	FailureThreshold int `yaml:"FailureThreshold"` // default 3
+	MaxBackoff       int `yaml:"MaxBackoff"`       // default 600
+	// External runs site-specific checks as collectors
+	External []ExternalCollectorConfig `yaml:"External"`
+}
+
+// ExternalCollectorConfig runs an executable that writes its metrics, and
+// optionally threat indicators, to stdout as JSON. Its metrics appear in the
+// "external" map under Name, so rules address them as
+// "external.<Name>.<metric>". Interval and Timeout are in seconds; with no
+// Interval the command runs on every collection.
+type ExternalCollectorConfig struct {
+	Name     string   `yaml:"Name"`    // lower case letters, digits and _
+	Command  string   `yaml:"Command"` // absolute path
+	Args     []string `yaml:"Args"`
+	Env      []string `yaml:"Env"` // KEY=value, on top of a minimal PATH
+	Dir      string   `yaml:"Dir"` // default /
+	Interval int      `yaml:"Interval"`
+	Timeout  int      `yaml:"Timeout"` // default 10
+	// User to run as. An agent running as root runs commands as nobody
+	// unless a user is set.
+	User   string         `yaml:"User"`
+	Limits ExternalLimits `yaml:"Limits"`
+}
+
+// ExternalLimits bounds the resources an external collector may use. Zero
+// values take the defaults; the rlimits apply on Linux only.
+type ExternalLimits struct {
+	MaxOutput  int `yaml:"MaxOutput"`  // bytes of stdout, default 1 MiB
+	MemoryMB   int `yaml:"MemoryMB"`   // address space, default 512
+	CPUSeconds int `yaml:"CPUSeconds"` // default Timeout
+	OpenFiles  int `yaml:"OpenFiles"`  // default 64
 }
 
 // StorageConfig holds storage-related configuration
@@ -84,6 +212,78 @@ type StorageConfig struct {
 	CompressOldData     bool `yaml:"CompressOldData"`
 }
 
+// DiskFilterConfig selects which mounted filesystems the disk collector
+// reports. Include lists act as allow-lists when non-empty; exclude lists are
+// applied afterwards. Mountpoint entries may be glob patterns (e.g. "/snap/*")
+// and also match every mount nested below a matching directory.
+type DiskFilterConfig struct {
+	IncludeFSTypes     []string `yaml:"IncludeFSTypes"`
+	ExcludeFSTypes     []string `yaml:"ExcludeFSTypes"`
+	IncludeMountpoints []string `yaml:"IncludeMountpoints"`
+	ExcludeMountpoints []string `yaml:"ExcludeMountpoints"`
+}
+
+// AncestryRuleConfig flags a process matching Children whose parent, or any
+// ancestor up to Depth levels above it, matches Parents. Patterns are
+// case-insensitive globs on the process or executable name.
+type AncestryRuleConfig struct {
+	Name        string   `yaml:"Name"`
+	Description string   `yaml:"Description"`
+	Severity    string   `yaml:"Severity"`
+	Parents     []string `yaml:"Parents"`
+	Children    []string `yaml:"Children"`
+	Depth       int      `yaml:"Depth"`
+	Tags        []string `yaml:"Tags"`
+}
+
+// ThreatDetectionConfig holds threat analyzer configuration
+type ThreatDetectionConfig struct {
+	// AncestryRules replace the built-in rules when non-empty
+	AncestryRules []AncestryRuleConfig `yaml:"AncestryRules"`
+	// RulesFile is a YAML file of metric rules, reloaded when it changes. The
+	// Thresholds rules apply when it is unset.
+	RulesFile string `yaml:"RulesFile"`
+}
+
+// FIMConfig holds file integrity monitoring configuration. Paths may be
+// files, directories (walked recursively) or glob patterns; ExcludePaths are
+// glob patterns that also cover everything below a matching directory.
+type FIMConfig struct {
+	Paths        []string `yaml:"Paths"`
+	ExcludePaths []string `yaml:"ExcludePaths"`
+}
+
+// AuthLogConfig holds authentication log monitoring configuration. Window is
+// in seconds; a source IP is reported once it reaches BruteForceThreshold
+// failed logins or SprayThreshold distinct user names within the window.
+type AuthLogConfig struct {
+	Paths               []string `yaml:"Paths"`
+	Window              int      `yaml:"Window"`
+	BruteForceThreshold int      `yaml:"BruteForceThreshold"`
+	SprayThreshold      int      `yaml:"SprayThreshold"`
+}
+
+// BaselineConfig holds statistical baseline configuration. Alpha is the
+// weight of each new sample in the moving average, and values more than Sigma
+// standard deviations from the mean are reported once a baseline has
+// WarmupSamples samples. Seasonal learns a baseline per hour of day.
+// Directions overrides the side of the baseline a metric is reported on.
+type BaselineConfig struct {
+	Enabled       bool                `yaml:"Enabled"`
+	Alpha         float64             `yaml:"Alpha"`
+	Sigma         float64             `yaml:"Sigma"`
+	WarmupSamples int                 `yaml:"WarmupSamples"`
+	Seasonal      bool                `yaml:"Seasonal"`
+	Directions    []BaselineDirection `yaml:"Directions"`
+}
+
+// BaselineDirection reports a metric "above" its baseline (the default),
+// "below" it, or "both"
+type BaselineDirection struct {
+	Metric    string `yaml:"Metric"`
+	Direction string `yaml:"Direction"`
+}
+
 // TLSConfig holds TLS-related configuration
 type TLSConfig struct {
 	CertFile string `yaml:"CertFile"`
@@ -101,30 +301,53 @@ type SecurityConfig struct {
 // Config represents the complete configuration structure
 type Config struct {
 	sync.RWMutex
-	Version     string       `yaml:"Version"`
-	Tenant      TenantConfig `yaml:"Tenant"`
-	keyManager  *apikey.Manager
-	LogFilePath string      `yaml:"LogFilePath"`
-	LogSettings LogSettings `yaml:"LogSettings"`
-	Interval    int         `yaml:"Interval"`
-	Kafka       KafkaConfig `yaml:"Kafka"`
-	HTTP        HTTPConfig  `yaml:"HTTP"`
-	StorageDir  string      `yaml:"StorageDir"` // Maintaining backward compatibility
-	Monitors    struct {
+	Version string `yaml:"Version"`
+	// Revision identifies the contents of the loaded config file, so the
+	// configuration in effect can be told apart after a reload
+	Revision   string       `yaml:"-" mapstructure:"-"`
+	Tenant     TenantConfig `yaml:"Tenant"`
+	keyManager *apikey.Manager
+	// keyChecks is whether the key manager's validation routine should run,
+	// including for a manager started by a reload
+	keyChecks bool
+	// keyListeners are told of API key changes; they have their own lock
+	// since changes are made with the config locked
+	keyListenersMu sync.Mutex
+	keyListeners   []func(key string)
+	keyClient      *http.Client
+	LogFilePath    string      `yaml:"LogFilePath"`
+	LogSettings    LogSettings `yaml:"LogSettings"`
+	Interval       int         `yaml:"Interval"`
+	// ShutdownTimeout is how many seconds the exporters get to deliver or
+	// queue their payloads on shutdown; 0 means 15
+	ShutdownTimeout int              `yaml:"ShutdownTimeout"`
+	Kafka           KafkaConfig      `yaml:"Kafka"`
+	HTTP            HTTPConfig       `yaml:"HTTP"`
+	OTLP            OTLPConfig       `yaml:"OTLP"`
+	Prometheus      PrometheusConfig `yaml:"Prometheus"`
+	Syslog          SyslogConfig     `yaml:"Syslog"`
+	StorageDir      string           `yaml:"StorageDir"` // Maintaining backward compatibility
+	Monitors        struct {
 		CPU     bool `yaml:"CPU"`
 		Memory  bool `yaml:"Memory"`
 		Disk    bool `yaml:"Disk"`
 		Network bool `yaml:"Network"`
 		Process bool `yaml:"Process"`
 	} `yaml:"Monitors"`
-	Thresholds struct {
+	DiskFilters DiskFilterConfig `yaml:"DiskFilters"`
+	Collectors  CollectorsConfig `yaml:"Collectors"`
+	Thresholds  struct {
 		CPU                int `yaml:"CPU"`
 		Memory             int `yaml:"Memory"`
 		Disk               int `yaml:"Disk"`
 		NetworkUtilization int `yaml:"NetworkUtilization"`
 	} `yaml:"Thresholds"`
-	Storage  StorageConfig  `yaml:"Storage"`
-	Security SecurityConfig `yaml:"Security"`
+	ThreatDetection ThreatDetectionConfig `yaml:"ThreatDetection"`
+	FIM             FIMConfig             `yaml:"FIM"`
+	AuthLog         AuthLogConfig         `yaml:"AuthLog"`
+	Baseline        BaselineConfig        `yaml:"Baseline"`
+	Storage         StorageConfig         `yaml:"Storage"`
+	Security        SecurityConfig        `yaml:"Security"`
 }
 
 // validateTenantID checks if the tenant ID matches the required format
@@ -158,7 +381,9 @@ func validateEndpoints(cfg *Config) error {
 		cfg.Tenant.Endpoints.Metrics,
 		cfg.Tenant.Endpoints.HealthCheck,
 		cfg.Tenant.Endpoints.KeyValidation,
+		cfg.Tenant.Endpoints.KeyRotation,
 	}
+	endpoints = append(endpoints, cfg.Tenant.Endpoints.MetricsFailover...)
 
 	urlPattern := regexp.MustCompile(`^https?://[^\s/$.?#].[^\s]*$`)
 	for _, endpoint := range endpoints {
@@ -180,6 +405,79 @@ func validateStorage(cfg *Config) error {
 	return nil
 }
 
+// validateInterval checks the collection interval and sample rate
+func validateInterval(cfg *Config) error {
+	if cfg.Interval < 1 || cfg.Tenant.CollectionRules.SampleRate < 0 {
+		return ErrInvalidInterval
+	}
+	return nil
+}
+
+var collectorNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+// validateExternalCollectors checks that each external collector has a
+// unique name usable as a metric key and an absolute command path
+func validateExternalCollectors(cfg *Config) error {
+	seen := make(map[string]bool)
+	for _, c := range cfg.Collectors.External {
+		if !collectorNamePattern.MatchString(c.Name) || seen[c.Name] {
+			return fmt.Errorf("%w: name %q", ErrInvalidCollector, c.Name)
+		}
+		seen[c.Name] = true
+		if !filepath.IsAbs(c.Command) {
+			return fmt.Errorf("%w: %s: command must be an absolute path", ErrInvalidCollector, c.Name)
+		}
+		if c.Interval < 0 || c.Timeout < 0 {
+			return fmt.Errorf("%w: %s: negative interval or timeout", ErrInvalidCollector, c.Name)
+		}
+	}
+	return nil
+}
+
+// validateKafka checks that every payload has a topic to go to. Payloads carry
+// the configured tenant ID, so without one TenantTopic needs Topic to fall
+// back to.
+func validateKafka(cfg *Config) error {
+	kafka := cfg.Kafka
+	if kafka.Enabled && kafka.TenantTopic != "" && kafka.Topic == "" && cfg.Tenant.ID == "" {
+		return fmt.Errorf("%w: TenantTopic needs a Topic when no tenant ID is set", ErrInvalidKafkaTopic)
+	}
+	return nil
+}
+
+// validateOptions checks the settings that take one of a fixed set of values.
+// Empty settings take their default.
+func validateOptions(cfg *Config) error {
+	type option struct {
+		name    string
+		value   string
+		allowed []string
+	}
+	options := []option{
+		{"HTTP.Compression", cfg.HTTP.Compression, []string{"none", "gzip", "zstd"}},
+		{"HTTP.Failover.Strategy", cfg.HTTP.Failover.Strategy, []string{"failover", "round_robin"}},
+		{"OTLP.Compression", cfg.OTLP.Compression, []string{"none", "gzip"}},
+		{"Syslog.Protocol", strings.ToLower(cfg.Syslog.Protocol), []string{"udp", "tcp", "tls"}},
+		{"Syslog.Format", strings.ToLower(cfg.Syslog.Format), []string{"structured", "cef"}},
+		{"LogSettings.Format", cfg.LogSettings.Format, []string{"json", "ndjson", "pretty", "csv"}},
+		{"LogSettings.Fsync", cfg.LogSettings.Fsync, []string{"never", "always", "rotate"}},
+	}
+	for i, rule := range cfg.ThreatDetection.AncestryRules {
+		options = append(options, option{
+			fmt.Sprintf("ThreatDetection.AncestryRules[%d].Severity", i),
+			strings.ToLower(rule.Severity),
+			[]string{"low", "medium", "high", "critical"},
+		})
+	}
+	for _, option := range options {
+		if option.value != "" && !slices.Contains(option.allowed, option.value) {
+			return fmt.Errorf("%w: %s must be one of %s, got %q", ErrInvalidOption,
+				option.name, strings.Join(option.allowed, ", "), option.value)
+		}
+	}
+	return nil
+}
+
 // Validate performs comprehensive configuration validation
 func (cfg *Config) Validate() error {
 	if err := validateTenantID(cfg.Tenant.ID); err != nil {
@@ -188,29 +486,74 @@ func (cfg *Config) Validate() error {
 	if err := validateAPIKey(cfg.Tenant.APIKey); err != nil {
 		return err
 	}
+	return cfg.validateSettings()
+}
+
+// validateSettings checks everything but the tenant identity, which a config
+// without a tenant is allowed to leave out
+func (cfg *Config) validateSettings() error {
 	if err := validateEndpoints(cfg); err != nil {
 		return err
 	}
 	if err := validateStorage(cfg); err != nil {
 		return err
 	}
+	if err := validateInterval(cfg); err != nil {
+		return err
+	}
+	if err := validateExternalCollectors(cfg); err != nil {
+		return err
+	}
+	if err := validateOptions(cfg); err != nil {
+		return err
+	}
+	if err := validateKafka(cfg); err != nil {
+		return err
+	}
 	return nil
 }
 
 // LoadConfig loads and validates the configuration
 func LoadConfig() (*Config, error) {
-	viper.SetConfigName("config")
+	return LoadConfigFile("")
+}
+
+// LoadConfigFile loads and validates the configuration at path, or at
+// ./configs/config.yaml when path is empty
+func LoadConfigFile(path string) (*Config, error) {
 	viper.SetConfigType("yaml")
-	viper.AddConfigPath("./configs")
+	if path != "" {
+		viper.SetConfigFile(path)
+	} else {
+		viper.SetConfigName("config")
+		viper.AddConfigPath("./configs")
+	}
 
 	// Set default values
 	setDefaultConfig()
 
 	// Read config file
 	if err := viper.ReadInConfig(); err != nil {
+		if path != "" {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
 		log.Printf("Warning: No config file found; using defaults")
 	}
 
+	cfg, err := decodeConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Tenant.APIKey != "" {
+		if cfg.keyManager, err = newKeyManager(cfg, cfg.apiKeyChanged); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// decodeConfig unmarshals and validates the configuration viper last read
+func decodeConfig() (*Config, error) {
 	var cfg Config
 	if err := viper.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
@@ -218,27 +561,60 @@ func LoadConfig() (*Config, error) {
 
 	// Set current version
 	cfg.Version = CurrentConfigVersion
+	cfg.Revision = fileRevision(viper.ConfigFileUsed())
 
-	// Skip validation for empty/default config
+	// The tenant checks are skipped for a config without a tenant, but the
+	// other settings are used either way
+	validate := cfg.validateSettings
 	if cfg.Tenant.ID != "" || cfg.Tenant.APIKey != "" {
-		if err := cfg.Validate(); err != nil {
-			return nil, fmt.Errorf("invalid configuration: %w", err)
-		}
+		validate = cfg.Validate
+	}
+	if err := validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return &cfg, nil
+}
 
-		// Initialize API key manager
-		keyManager, err := apikey.NewManager(cfg.Tenant.APIKey, apikey.Config{
-			ValidationEndpoint: cfg.Tenant.Endpoints.KeyValidation,
-			ValidationInterval: 5 * time.Minute,
-			MaxKeyAge:          24 * time.Hour,
-			EncryptKeys:        true,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to initialize API key manager: %w", err)
-		}
-		cfg.keyManager = keyManager
+// newKeyManager starts a key manager for the key in cfg. Rotated keys are
+// saved under HTTP.StorageDir, and onChange is told of every new key.
+func newKeyManager(cfg *Config, onChange func(key string)) (*apikey.Manager, error) {
+	var keyFile string
+	if cfg.HTTP.StorageDir != "" {
+		keyFile = filepath.Join(cfg.HTTP.StorageDir, apiKeyFile)
 	}
+	keyManager, err := apikey.NewManager(cfg.Tenant.APIKey, apikey.Config{
+		ValidationEndpoint: cfg.Tenant.Endpoints.KeyValidation,
+		RotationEndpoint:   cfg.Tenant.Endpoints.KeyRotation,
+		Header:             cfg.HTTP.Headers.APIKey,
+		KeyFile:            keyFile,
+		OnKeyChange:        onChange,
+		ValidationInterval: 5 * time.Minute,
+		MaxKeyAge:          24 * time.Hour,
+		EncryptKeys:        true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize API key manager: %w", err)
+	}
+	return keyManager, nil
+}
 
-	return &cfg, nil
+// fileRevision is a short digest of the config file, or "defaults" when none
+// was read
+func fileRevision(path string) string {
+	if path == "" {
+		return "defaults"
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "unknown"
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// ConfigFile returns the path of the config file in use, if any
+func ConfigFile() string {
+	return viper.ConfigFileUsed()
 }
 
 // setDefaultConfig sets default values for configuration
@@ -263,6 +639,26 @@ func setDefaultConfig() {
 	viper.SetDefault("Monitors.Disk", true)
 	viper.SetDefault("Monitors.Network", true)
 	viper.SetDefault("Monitors.Process", false)
+	viper.SetDefault("DiskFilters.ExcludeFSTypes", []string{
+		"tmpfs", "devtmpfs", "overlay", "squashfs", "proc", "sysfs", "cgroup", "cgroup2",
+		"devpts", "mqueue", "debugfs", "tracefs", "securityfs", "pstore", "bpf",
+		"autofs", "fusectl", "configfs", "hugetlbfs", "binfmt_misc", "nsfs",
+	})
+	viper.SetDefault("DiskFilters.ExcludeMountpoints", []string{"/var/lib/docker/*", "/run/*", "/snap/*"})
+	viper.SetDefault("FIM.Paths", []string{
+		"/etc", "/usr/bin", "/usr/sbin", "/usr/local/bin",
+		"/root/.ssh/authorized_keys", "/home/*/.ssh/authorized_keys",
+	})
+	viper.SetDefault("FIM.ExcludePaths", []string{"/etc/mtab", "/etc/adjtime", "/etc/ld.so.cache"})
+	viper.SetDefault("AuthLog.Paths", []string{"/var/log/auth.log", "/var/log/secure"})
+	viper.SetDefault("AuthLog.Window", 300)
+	viper.SetDefault("AuthLog.BruteForceThreshold", 10)
+	viper.SetDefault("AuthLog.SprayThreshold", 5)
+	viper.SetDefault("Baseline.Enabled", true)
+	viper.SetDefault("Baseline.Alpha", 0.02)
+	viper.SetDefault("Baseline.Sigma", 3.0)
+	viper.SetDefault("Baseline.WarmupSamples", 60)
+	viper.SetDefault("Baseline.Seasonal", false)
 	viper.SetDefault("Storage.MaxStoragePerTenant", 1024)
 	viper.SetDefault("Storage.RetentionPeriod", 7)
 	viper.SetDefault("Storage.CompressOldData", true)
@@ -273,35 +669,89 @@ func setDefaultConfig() {
 	viper.SetDefault("Security.TLS.KeyFile", "")
 }
 
-// ReloadConfig reloads the configuration from disk
+// ReloadConfig re-reads the config file and applies it. A file that can't be
+// read or fails validation leaves the current configuration in place.
 func (cfg *Config) ReloadConfig() error {
-	cfg.Lock()
-	defer cfg.Unlock()
-
-	newCfg, err := LoadConfig()
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to reload config: %w", err)
+	}
+	newCfg, err := decodeConfig()
 	if err != nil {
 		return fmt.Errorf("failed to reload config: %w", err)
 	}
 
-	// Update configuration
-	oldKeyManager := cfg.keyManager
-	*cfg = *newCfg
+	cfg.Lock()
+	defer cfg.Unlock()
+	if err := cfg.updateKey(newCfg); err != nil {
+		return err
+	}
+	cfg.copyFrom(newCfg)
+	return nil
+}
+
+// Snapshot copies the current settings so they can be put back with Restore.
+// The snapshot shares the key manager, so it reports the current and backup
+// API keys rather than the configured one, which may have been rotated out.
+func (cfg *Config) Snapshot() *Config {
+	cfg.RLock()
+	defer cfg.RUnlock()
+	snapshot := &Config{keyManager: cfg.keyManager, keyClient: cfg.keyClient}
+	snapshot.copyFrom(cfg)
+	return snapshot
+}
 
-	// Preserve key manager if new config doesn't initialize one
-	if cfg.keyManager == nil && oldKeyManager != nil {
-		cfg.keyManager = oldKeyManager
+// Restore puts back settings taken with Snapshot, including the API key
+func (cfg *Config) Restore(snapshot *Config) error {
+	cfg.Lock()
+	defer cfg.Unlock()
+	if err := cfg.updateKey(snapshot); err != nil {
+		return err
 	}
+	cfg.copyFrom(snapshot)
+	return nil
+}
 
-	// Update key if it changed
-	if oldKeyManager != nil && cfg.Tenant.APIKey != oldKeyManager.GetKey() {
-		if err := cfg.keyManager.UpdateKey(cfg.Tenant.APIKey); err != nil {
+// updateKey hands a changed API key to the key manager, starting one if the
+// agent had no key before. The key manager, and so any rotated key, survives
+// reloads. Callers hold the write lock.
+func (cfg *Config) updateKey(next *Config) error {
+	if next.Tenant.APIKey == "" {
+		return nil
+	}
+	if cfg.keyManager == nil {
+		keyManager, err := newKeyManager(next, cfg.apiKeyChanged)
+		if err != nil {
+			return err
+		}
+		if cfg.keyClient != nil {
+			keyManager.SetClient(cfg.keyClient)
+		}
+		if cfg.keyChecks {
+			keyManager.Start()
+		}
+		cfg.keyManager = keyManager
+		return nil
+	}
+	if next.Tenant.APIKey != cfg.Tenant.APIKey && next.Tenant.APIKey != cfg.keyManager.GetKey() {
+		if err := cfg.keyManager.UpdateKey(next.Tenant.APIKey); err != nil {
 			return fmt.Errorf("failed to update API key: %w", err)
 		}
 	}
-
 	return nil
 }
 
+// copyFrom replaces the exported settings with those of src. The embedded
+// mutex must not be copied, so this can't be a plain assignment.
+func (cfg *Config) copyFrom(src *Config) {
+	dst := reflect.ValueOf(cfg).Elem()
+	from := reflect.ValueOf(src).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if field := dst.Type().Field(i); field.IsExported() && !field.Anonymous {
+			dst.Field(i).Set(from.Field(i))
+		}
+	}
+}
+
 // GetConfigVersion returns the current configuration version
 func (cfg *Config) GetConfigVersion() string {
 	cfg.RLock()
@@ -319,6 +769,79 @@ func (cfg *Config) GetAPIKey() string {
 	return cfg.Tenant.APIKey
 }
 
+// StartAPIKeyChecks has the key manager validate the API key periodically
+// and rotate it when due. Only the agent does; commands use the key as
+// loaded.
+func (cfg *Config) StartAPIKeyChecks() {
+	cfg.Lock()
+	defer cfg.Unlock()
+	cfg.keyChecks = true
+	if cfg.keyManager != nil {
+		cfg.keyManager.Start()
+	}
+}
+
+// StopAPIKeyChecks ends the periodic checks started by StartAPIKeyChecks
+func (cfg *Config) StopAPIKeyChecks() {
+	cfg.Lock()
+	defer cfg.Unlock()
+	cfg.keyChecks = false
+	if cfg.keyManager != nil {
+		cfg.keyManager.Stop()
+	}
+}
+
+// RotateAPIKey exchanges the API key for a new one at the rotation endpoint
+func (cfg *Config) RotateAPIKey() error {
+	// Not holding the lock while waiting on the server
+	cfg.RLock()
+	keyManager := cfg.keyManager
+	cfg.RUnlock()
+	if keyManager != nil {
+		return keyManager.RotateKey()
+	}
+	return apikey.ErrKeyInvalid
+}
+
+// GetBackupAPIKey returns the key replaced by the last rotation while the
+// server still accepts it
+func (cfg *Config) GetBackupAPIKey() (string, bool) {
+	cfg.RLock()
+	defer cfg.RUnlock()
+	if cfg.keyManager != nil {
+		return cfg.keyManager.BackupKey()
+	}
+	return "", false
+}
+
+// OnAPIKeyChange registers fn to be called with the new key whenever the
+// API key is rotated or replaced by a reload
+func (cfg *Config) OnAPIKeyChange(fn func(key string)) {
+	cfg.keyListenersMu.Lock()
+	defer cfg.keyListenersMu.Unlock()
+	cfg.keyListeners = append(cfg.keyListeners, fn)
+}
+
+func (cfg *Config) apiKeyChanged(key string) {
+	cfg.keyListenersMu.Lock()
+	listeners := append([]func(string){}, cfg.keyListeners...)
+	cfg.keyListenersMu.Unlock()
+	for _, fn := range listeners {
+		fn(key)
+	}
+}
+
+// SetAPIKeyClient sets the client the key manager reaches the key
+// validation and rotation endpoints with
+func (cfg *Config) SetAPIKeyClient(client *http.Client) {
+	cfg.Lock()
+	defer cfg.Unlock()
+	cfg.keyClient = client
+	if cfg.keyManager != nil {
+		cfg.keyManager.SetClient(client)
+	}
+}
+
 // GetAPIKeyStatus returns the current API key status
 func (cfg *Config) GetAPIKeyStatus() *apikey.KeyStatus {
 	cfg.RLock()
@@ -332,10 +855,12 @@ func (cfg *Config) GetAPIKeyStatus() *apikey.KeyStatus {
 
 // ValidateAPIKey checks if the current API key is valid
 func (cfg *Config) ValidateAPIKey() error {
+	// Not holding the lock while waiting on the server
 	cfg.RLock()
-	defer cfg.RUnlock()
-	if cfg.keyManager != nil {
-		return cfg.keyManager.ValidateKey()
+	keyManager := cfg.keyManager
+	cfg.RUnlock()
+	if keyManager != nil {
+		return keyManager.ValidateKey()
 	}
 	return apikey.ErrKeyInvalid
 }
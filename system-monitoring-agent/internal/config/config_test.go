@@ -1,17 +1,30 @@
 package config
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestAPIKeyManagement(t *testing.T) {
+	// The validation endpoint accepts the configured key
+	validation := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "01234567890123456789012345678901" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer validation.Close()
+
 	// Create a test config with API key
-	configData := `
+	configData := fmt.Sprintf(`
 Version: "1.0.0"
 Tenant:
   ID: "tenant-123"
@@ -19,8 +32,8 @@ Tenant:
   Endpoints:
     Metrics: "http://localhost:8080/metrics"
     HealthCheck: "http://localhost:8080/health"
-    KeyValidation: "http://localhost:8080/validate"
-`
+    KeyValidation: "%s/validate"
+`, validation.URL)
 	// Create temp config directory
 	configDir := filepath.Join(t.TempDir(), "configs")
 	err := os.MkdirAll(configDir, 0755)
@@ -149,17 +162,30 @@ Security:
 			expected: Config{
 				Version: "1.0.0",
 				Tenant: TenantConfig{
-					ID:     "tenant-123",
-					APIKey: "01234567890123456789012345678901",
+					ID:          "tenant-123",
+					APIKey:      "01234567890123456789012345678901",
+					Environment: "development",
+					Type:        "standard",
 					Endpoints: struct {
-						Metrics       string `yaml:"Metrics"`
-						HealthCheck   string `yaml:"HealthCheck"`
-						KeyValidation string `yaml:"KeyValidation"`
+						Metrics         string   `yaml:"Metrics"`
+						HealthCheck     string   `yaml:"HealthCheck"`
+						KeyValidation   string   `yaml:"KeyValidation"`
+						KeyRotation     string   `yaml:"KeyRotation"`
+						MetricsFailover []string `yaml:"MetricsFailover"`
 					}{
 						Metrics:       "http://localhost:8080/metrics",
 						HealthCheck:   "http://localhost:8080/health",
 						KeyValidation: "http://localhost:8080/validate",
 					},
+					CollectionRules: struct {
+						EnabledMetrics []string `yaml:"EnabledMetrics"`
+						SampleRate     int      `yaml:"SampleRate"`
+						RetentionDays  int      `yaml:"RetentionDays"`
+					}{
+						EnabledMetrics: []string{"cpu", "memory", "disk", "network"},
+						SampleRate:     60,
+						RetentionDays:  7,
+					},
 				},
 				LogFilePath: "./agent.log",
 				LogSettings: LogSettings{
@@ -342,7 +368,8 @@ StorageDir: "./storage"
 		},
 	}
 
-	for _, tt := range tests {
+	for i := range tests {
+		tt := &tests[i] // Config holds a mutex, so don't copy the cases
 		t.Run(tt.name, func(t *testing.T) {
 			// Create temp config directory
 			configDir := filepath.Join(t.TempDir(), "configs")
@@ -390,3 +417,210 @@ StorageDir: "./storage"
 		})
 	}
 }
+
+const reloadTestConfig = `
+Tenant:
+  ID: "tenant-123"
+  APIKey: "01234567890123456789012345678901"
+  Endpoints:
+    Metrics: "%s"
+Interval: %d
+`
+
+// loadTestConfig writes a config file to a temp directory and loads it
+func loadTestConfig(t *testing.T, metrics string, interval int) (*Config, string) {
+	configDir := t.TempDir()
+	configPath := filepath.Join(configDir, "config.yaml")
+	assert.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(reloadTestConfig, metrics, interval)), 0644))
+
+	viper.Reset()
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(configDir)
+
+	cfg, err := LoadConfig()
+	assert.NoError(t, err)
+	return cfg, configPath
+}
+
+func TestReloadConfig(t *testing.T) {
+	cfg, configPath := loadTestConfig(t, "http://localhost:8080/metrics", 30)
+	revision := cfg.Revision
+	assert.Len(t, revision, 12)
+
+	t.Run("applies a valid file", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(reloadTestConfig, "http://localhost:9090/metrics", 15)), 0644))
+		assert.NoError(t, cfg.ReloadConfig())
+		assert.Equal(t, "http://localhost:9090/metrics", cfg.Tenant.Endpoints.Metrics)
+		assert.Equal(t, 15, cfg.Interval)
+		assert.NotEqual(t, revision, cfg.Revision)
+	})
+
+	t.Run("keeps the current config when validation fails", func(t *testing.T) {
+		applied := cfg.Revision
+		assert.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(reloadTestConfig, "not a url", 15)), 0644))
+		assert.ErrorIs(t, cfg.ReloadConfig(), ErrInvalidEndpoint)
+		assert.Equal(t, "http://localhost:9090/metrics", cfg.Tenant.Endpoints.Metrics)
+		assert.Equal(t, applied, cfg.Revision)
+
+		assert.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf(reloadTestConfig, "http://localhost:9090/metrics", 0)), 0644))
+		assert.ErrorIs(t, cfg.ReloadConfig(), ErrInvalidInterval)
+		assert.Equal(t, 15, cfg.Interval)
+	})
+}
+
+func TestLoadConfigValidatesSettingsWithoutTenant(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	assert.NoError(t, os.WriteFile(path, []byte("Interval: 30\n"), 0644))
+	cfg, err := LoadConfigFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 30, cfg.Interval)
+
+	assert.NoError(t, os.WriteFile(path, []byte("Interval: 0\nTenant:\n  CollectionRules:\n    SampleRate: 0\n"), 0644))
+	_, err = LoadConfigFile(path)
+	assert.ErrorIs(t, err, ErrInvalidInterval)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	cfg, configPath := loadTestConfig(t, "http://localhost:8080/metrics", 30)
+	snapshot := cfg.Snapshot()
+
+	reloaded := strings.Replace(fmt.Sprintf(reloadTestConfig, "http://localhost:9090/metrics", 15),
+		"01234567890123456789012345678901", "98765432109876543210987654321098", 1)
+	assert.NoError(t, os.WriteFile(configPath, []byte(reloaded), 0644))
+	assert.NoError(t, cfg.ReloadConfig())
+	assert.Equal(t, "98765432109876543210987654321098", cfg.GetAPIKey())
+
+	assert.NoError(t, cfg.Restore(snapshot))
+	assert.Equal(t, 30, cfg.Interval)
+	assert.Equal(t, "http://localhost:8080/metrics", cfg.Tenant.Endpoints.Metrics)
+	assert.Equal(t, snapshot.Revision, cfg.Revision)
+	assert.Equal(t, "01234567890123456789012345678901", cfg.GetAPIKey())
+}
+
+func TestFileWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("Interval: 30\n"), 0644))
+
+	watcher := NewFileWatcher(path)
+	assert.False(t, watcher.Changed())
+
+	assert.NoError(t, os.WriteFile(path, []byte("Interval: 300\n"), 0644))
+	assert.True(t, watcher.Changed())
+	assert.False(t, watcher.Changed())
+
+	// A file replaced by rename, as editors do, is a change too
+	replacement := path + ".tmp"
+	assert.NoError(t, os.WriteFile(replacement, []byte("Interval: 15\n"), 0644))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(replacement, later, later))
+	assert.NoError(t, os.Rename(replacement, path))
+	assert.True(t, watcher.Changed())
+
+	assert.NoError(t, os.Remove(path))
+	assert.False(t, watcher.Changed())
+	assert.False(t, NewFileWatcher("").Changed())
+}
+
+func TestValidateExternalCollectors(t *testing.T) {
+	tests := []struct {
+		name      string
+		external  []ExternalCollectorConfig
+		expectErr bool
+	}{
+		{"none", nil, false},
+		{"valid", []ExternalCollectorConfig{{Name: "vendor_cli", Command: "/opt/checks/vendor.sh", Interval: 300}}, false},
+		{"name not usable as a metric key", []ExternalCollectorConfig{{Name: "Vendor CLI", Command: "/opt/checks/vendor.sh"}}, true},
+		{"missing name", []ExternalCollectorConfig{{Command: "/opt/checks/vendor.sh"}}, true},
+		{"duplicate name", []ExternalCollectorConfig{
+			{Name: "vendor", Command: "/opt/checks/a.sh"},
+			{Name: "vendor", Command: "/opt/checks/b.sh"},
+		}, true},
+		{"relative command", []ExternalCollectorConfig{{Name: "vendor", Command: "vendor.sh"}}, true},
+		{"negative timeout", []ExternalCollectorConfig{{Name: "vendor", Command: "/opt/checks/vendor.sh", Timeout: -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Collectors.External = tt.external
+			err := validateExternalCollectors(cfg)
+			if tt.expectErr {
+				assert.ErrorIs(t, err, ErrInvalidCollector)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateKafka(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		topic     string
+		tenantID  string
+		expectErr bool
+	}{
+		{"disabled", false, "", "", false},
+		{"tenant topic with tenant", true, "", "tenant-1", false},
+		{"tenant topic with fallback", true, "system-metrics", "", false},
+		{"tenant topic without tenant or fallback", true, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Kafka.Enabled = tt.enabled
+			cfg.Kafka.Topic = tt.topic
+			cfg.Kafka.TenantTopic = "tenant-{id}-metrics"
+			cfg.Tenant.ID = tt.tenantID
+			err := validateKafka(cfg)
+			if tt.expectErr {
+				assert.ErrorIs(t, err, ErrInvalidKafkaTopic)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(cfg *Config)
+		expectErr bool
+	}{
+		{"defaults", func(cfg *Config) {}, false},
+		{"all set", func(cfg *Config) {
+			cfg.HTTP.Compression = "zstd"
+			cfg.HTTP.Failover.Strategy = "round_robin"
+			cfg.Syslog.Protocol = "TLS"
+			cfg.Syslog.Format = "cef"
+			cfg.LogSettings.Format = "csv"
+			cfg.LogSettings.Fsync = "rotate"
+			cfg.ThreatDetection.AncestryRules = []AncestryRuleConfig{{Severity: "Critical"}, {}}
+		}, false},
+		{"http compression", func(cfg *Config) { cfg.HTTP.Compression = "brotli" }, true},
+		{"failover strategy", func(cfg *Config) { cfg.HTTP.Failover.Strategy = "random" }, true},
+		{"otlp compression", func(cfg *Config) { cfg.OTLP.Compression = "zstd" }, true},
+		{"syslog protocol", func(cfg *Config) { cfg.Syslog.Protocol = "relp" }, true},
+		{"syslog format", func(cfg *Config) { cfg.Syslog.Format = "leef" }, true},
+		{"file format", func(cfg *Config) { cfg.LogSettings.Format = "xml" }, true},
+		{"fsync", func(cfg *Config) { cfg.LogSettings.Fsync = "sometimes" }, true},
+		{"ancestry severity", func(cfg *Config) {
+			cfg.ThreatDetection.AncestryRules = []AncestryRuleConfig{{Severity: "high"}, {Severity: "severe"}}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			tt.modify(cfg)
+			err := validateOptions(cfg)
+			if tt.expectErr {
+				assert.ErrorIs(t, err, ErrInvalidOption)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+// internal/config/watch.go
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// FileWatcher detects changes to the config file by its modification time and
+// size, the same way threat rule files are watched. Editors that replace the
+// file rather than rewrite it are caught too, since the path is stat'ed afresh
+// each time.
+type FileWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// NewFileWatcher records the file's current state, so only later changes are
+// reported
+func NewFileWatcher(path string) *FileWatcher {
+	w := &FileWatcher{path: path}
+	w.Changed()
+	return w
+}
+
+// Changed reports whether the file changed since the last call. A missing
+// file is not a change; the agent keeps its configuration until it returns.
+func (w *FileWatcher) Changed() bool {
+	if w.path == "" {
+		return false
+	}
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false
+	}
+	w.modTime, w.size = info.ModTime(), info.Size()
+	return true
+}
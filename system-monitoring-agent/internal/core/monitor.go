@@ -1,6 +1,13 @@
 // internal/core/monitor.go
 package core
 
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
 // SystemMonitor composes all monitoring capabilities
 type SystemMonitor interface {
 	CPUMonitor
@@ -8,6 +15,7 @@ type SystemMonitor interface {
 	DiskMonitor
 	NetworkMonitor
 	ProcessMonitor
+	ConnectionMonitor
 }
 
 // CPUMonitor handles CPU-specific metrics
@@ -26,6 +34,17 @@ type DiskMonitor interface {
 	GetDiskUsage() (DiskStats, error)
 }
 
+// MountFilter decides whether a mount's usage is read. It is called before
+// the filesystem is queried, so only Device, Mountpoint and FSType are set.
+type MountFilter func(mount MountStats) bool
+
+// FilteredDiskMonitor is implemented by monitors that can skip mounts before
+// querying them, so an excluded mount whose server has gone away can't block
+// the collector
+type FilteredDiskMonitor interface {
+	GetDiskUsageFiltered(include MountFilter) (DiskStats, error)
+}
+
 // NetworkMonitor handles network-specific metrics
 type NetworkMonitor interface {
 	GetNetworkStats() (NetworkStats, error)
@@ -36,21 +55,131 @@ type ProcessMonitor interface {
 	GetProcesses() ([]ProcessInfo, error)
 }
 
+// ProcessContextMonitor is implemented by monitors that can abandon process
+// enumeration once its context is done, which matters on hosts with many
+// processes
+type ProcessContextMonitor interface {
+	GetProcessesContext(ctx context.Context) ([]ProcessInfo, error)
+}
+
+// ConnectionMonitor handles socket-level network metrics
+type ConnectionMonitor interface {
+	GetConnections() ([]ConnectionInfo, error)
+}
+
+// EphemeralPortMonitor is implemented by monitors that can read the range of
+// local ports the kernel assigns to client sockets
+type EphemeralPortMonitor interface {
+	GetEphemeralPortRange() (low, high uint16, err error)
+}
+
+// DiskStats holds root filesystem usage (Total/Used/Free) together with every
+// mounted filesystem and the cumulative I/O counters of each block device
 type DiskStats struct {
-	Total uint64
-	Used  uint64
-	Free  uint64
+	Total   uint64
+	Used    uint64
+	Free    uint64
+	Mounts  []MountStats
+	Devices []DiskIOStats
+}
+
+// MountStats holds space and inode usage for a single mounted filesystem
+type MountStats struct {
+	Device      string
+	Mountpoint  string
+	FSType      string
+	Total       uint64
+	Used        uint64
+	Free        uint64
+	InodesTotal uint64
+	InodesUsed  uint64
+	InodesFree  uint64
+}
+
+// DiskIOStats holds the cumulative I/O counters for a single block device
+type DiskIOStats struct {
+	Name       string
+	ReadBytes  uint64
+	WriteBytes uint64
+	ReadCount  uint64
+	WriteCount uint64
+	IOTimeMs   uint64
 }
 
 type NetworkStats struct {
 	BytesSent     uint64
 	BytesReceived uint64
+	Interfaces    []InterfaceStats
+}
+
+// InterfaceStats holds the cumulative counters for a single network interface
+type InterfaceStats struct {
+	Name            string
+	BytesSent       uint64
+	BytesReceived   uint64
+	PacketsSent     uint64
+	PacketsReceived uint64
+	ErrorsIn        uint64
+	ErrorsOut       uint64
+	DropsIn         uint64
+	DropsOut        uint64
+	// Loopback is set from the interface's flags. Its traffic never leaves
+	// the host, so it is left out of host totals and rates.
+	Loopback bool
+}
+
+// LoopbackInterfaces returns the names of the host's loopback interfaces,
+// such as lo0 on darwin or "Loopback Pseudo-Interface 1" on Windows
+func LoopbackInterfaces() map[string]bool {
+	loopback := make(map[string]bool)
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return loopback
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			loopback[iface.Name] = true
+		}
+	}
+	return loopback
 }
 
 type ProcessInfo struct {
 	PID         int
+	PPID        int
 	Name        string
 	CPUPercent  float64
 	MemoryUsage uint64
 	Status      string
+	Cmdline     string
+	Username    string
+	UID         string
+	StartTime   time.Time
+	ExePath     string
+	ExeSHA256   string
+}
+
+// ConnectionInfo describes a single TCP or UDP socket. State uses the kernel
+// TCP state names (LISTEN, ESTABLISHED, ...); PID is 0 when the owning process
+// could not be determined.
+type ConnectionInfo struct {
+	Protocol   string // tcp, tcp6, udp or udp6
+	LocalAddr  string
+	LocalPort  uint16
+	RemoteAddr string
+	RemotePort uint16
+	State      string
+	PID        int
+}
+
+// IsListener reports whether the socket is a listening TCP socket
+func (c ConnectionInfo) IsListener() bool {
+	return strings.HasPrefix(c.Protocol, "tcp") && c.State == "LISTEN"
+}
+
+// IsUDPBind reports whether the socket is a UDP socket without a peer. Both
+// UDP servers and unconnected client sockets, such as a resolver's, look
+// like this.
+func (c ConnectionInfo) IsUDPBind() bool {
+	return strings.HasPrefix(c.Protocol, "udp") && c.RemotePort == 0
 }
@@ -0,0 +1,112 @@
+// Package exehash computes and caches SHA-256 hashes of process executables
+package exehash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// MaxFileSize is the largest executable that will be hashed. Anything bigger
+// is skipped so a single huge binary can't stall a collection cycle.
+const MaxFileSize = 512 << 20
+
+var ErrFileTooLarge = errors.New("file too large to hash")
+
+// FileKey identifies one version of a file on disk. A binary replaced in
+// place gets a new inode or mtime, so its hash is recomputed.
+type FileKey struct {
+	Path    string
+	Inode   uint64
+	Size    int64
+	ModTime time.Time
+}
+
+// KeyFor builds the cache key for a file from its stat information
+func KeyFor(path string, info fs.FileInfo) FileKey {
+	return FileKey{
+		Path:    path,
+		Inode:   inode(info),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+}
+
+// Cache memoizes executable hashes by FileKey
+type Cache struct {
+	mu         sync.Mutex
+	entries    map[FileKey]string
+	maxEntries int
+}
+
+// NewCache creates a cache holding at most maxEntries hashes
+func NewCache(maxEntries int) *Cache {
+	return &Cache{
+		entries:    make(map[FileKey]string),
+		maxEntries: maxEntries,
+	}
+}
+
+// Hash returns the hex SHA-256 for key, calling open to read the content only
+// when the key hasn't been seen before
+func (c *Cache) Hash(key FileKey, open func() (io.ReadCloser, error)) (string, error) {
+	c.mu.Lock()
+	if sum, ok := c.entries[key]; ok {
+		c.mu.Unlock()
+		return sum, nil
+	}
+	c.mu.Unlock()
+
+	if key.Size > MaxFileSize {
+		return "", fmt.Errorf("%w: %s", ErrFileTooLarge, key.Path)
+	}
+
+	f, err := open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.LimitReader(f, MaxFileSize)); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", key.Path, err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		// Evict an arbitrary entry; stale versions of replaced binaries are
+		// never looked up again so they drain out over time
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = sum
+	return sum, nil
+}
+
+// HashFile stats and hashes the file at path
+func (c *Cache) HashFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return c.Hash(KeyFor(path, info), func() (io.ReadCloser, error) {
+		return os.Open(path)
+	})
+}
+
+// Len returns the number of cached hashes
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
@@ -0,0 +1,70 @@
+package exehash
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sha(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHashFileCachesByVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bin")
+	require.NoError(t, os.WriteFile(path, []byte("version one"), 0755))
+
+	cache := NewCache(10)
+	sum, err := cache.HashFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, sha("version one"), sum)
+
+	// Same version is served from the cache without reopening the file
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	opened := false
+	sum, err = cache.Hash(KeyFor(path, info), func() (io.ReadCloser, error) {
+		opened = true
+		return os.Open(path)
+	})
+	require.NoError(t, err)
+	assert.False(t, opened)
+	assert.Equal(t, sha("version one"), sum)
+
+	// Replacing the binary changes its mtime and size, so it is rehashed
+	require.NoError(t, os.WriteFile(path, []byte("version two!"), 0755))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+	sum, err = cache.HashFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, sha("version two!"), sum)
+}
+
+func TestCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewCache(2)
+	for _, name := range []string{"a", "b", "c"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(name), 0755))
+		_, err := cache.HashFile(path)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, cache.Len())
+}
+
+func TestHashSkipsLargeFiles(t *testing.T) {
+	cache := NewCache(1)
+	_, err := cache.Hash(FileKey{Path: "/huge", Size: MaxFileSize + 1}, func() (io.ReadCloser, error) {
+		t.Fatal("large file should not be opened")
+		return nil, nil
+	})
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+}
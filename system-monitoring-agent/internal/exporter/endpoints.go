@@ -0,0 +1,198 @@
+package exporter
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	strategyFailover   = "failover"
+	strategyRoundRobin = "round_robin"
+)
+
+// endpoint is one metrics URL and its health as seen by the exporter
+type endpoint struct {
+	url       string
+	healthURL string
+	failures  int
+	downUntil time.Time
+}
+
+// endpointPool picks the metrics endpoint for each request. With the failover
+// strategy the first healthy endpoint in configured order is used, so traffic
+// returns to the primary once its cooldown ends; with round_robin requests
+// rotate over the healthy endpoints. If every endpoint is down, the one due
+// back soonest is used rather than sending nowhere.
+type endpointPool struct {
+	mu         sync.Mutex
+	endpoints  []*endpoint
+	roundRobin bool
+	threshold  int
+	cooldown   time.Duration
+	next       int
+	now        func() time.Time
+}
+
+func newEndpointPool(urls []string, healthCheck, strategy string, threshold int, cooldown time.Duration) *endpointPool {
+	pool := &endpointPool{now: time.Now}
+	pool.Replace(urls, healthCheck, strategy, threshold, cooldown)
+	return pool
+}
+
+// Replace swaps in a new set of endpoints and settings. Endpoints already in
+// the pool keep their failure count and cooldown; they are copied rather than
+// updated, as requests and health checks in flight hold the old ones.
+func (p *endpointPool) Replace(urls []string, healthCheck, strategy string, threshold int, cooldown time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	existing := make(map[string]*endpoint, len(p.endpoints))
+	for _, e := range p.endpoints {
+		existing[e.url] = e
+	}
+	endpoints := make([]*endpoint, 0, len(urls))
+	for i, u := range urls {
+		healthURL := healthCheck
+		if i > 0 {
+			healthURL = healthURLFor(u, healthCheck)
+		}
+		e := &endpoint{url: u, healthURL: healthURL}
+		if previous, ok := existing[u]; ok {
+			e.failures, e.downUntil = previous.failures, previous.downUntil
+		}
+		endpoints = append(endpoints, e)
+	}
+
+	p.endpoints = endpoints
+	p.roundRobin = strategy == strategyRoundRobin
+	p.threshold = threshold
+	p.cooldown = cooldown
+	if len(endpoints) > 0 {
+		p.next %= len(endpoints)
+	}
+}
+
+// healthURLFor moves the health check path onto the host of a failover
+// endpoint, since each gateway answers its own health checks
+func healthURLFor(endpointURL, healthCheck string) string {
+	if healthCheck == "" {
+		return ""
+	}
+	check, err := url.Parse(healthCheck)
+	if err != nil {
+		return ""
+	}
+	target, err := url.Parse(endpointURL)
+	if err != nil {
+		return ""
+	}
+	check.Scheme = target.Scheme
+	check.Host = target.Host
+	return check.String()
+}
+
+// Pick returns the endpoint to send the next request to
+func (p *endpointPool) Pick() *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	n := len(p.endpoints)
+	start := 0
+	if p.roundRobin {
+		start = p.next
+		p.next = (p.next + 1) % n
+	}
+	for i := 0; i < n; i++ {
+		e := p.endpoints[(start+i)%n]
+		if !now.Before(e.downUntil) {
+			return e
+		}
+	}
+
+	soonest := p.endpoints[0]
+	for _, e := range p.endpoints[1:] {
+		if e.downUntil.Before(soonest.downUntil) {
+			soonest = e
+		}
+	}
+	return soonest
+}
+
+// Available returns the first endpoint in rotation without advancing the
+// round robin, or false if every endpoint is down
+func (p *endpointPool) Available() (*endpoint, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	for _, e := range p.endpoints {
+		if !now.Before(e.downUntil) {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// Success records a delivered request, clearing the endpoint's failures
+func (p *endpointPool) Success(e *endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.failures = 0
+	e.downUntil = time.Time{}
+}
+
+// Failure records a failed request and reports whether it took the endpoint
+// out of rotation
+func (p *endpointPool) Failure(e *endpoint) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e.failures++
+	if e.failures < p.threshold {
+		return false
+	}
+	e.failures = 0
+	e.downUntil = p.now().Add(p.cooldown)
+	return true
+}
+
+// SetHealthy applies a health check result. A passing check brings a down
+// endpoint back early; a failing one takes it out for a cooldown.
+func (p *endpointPool) SetHealthy(e *endpoint, healthy bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if healthy {
+		e.failures = 0
+		e.downUntil = time.Time{}
+	} else {
+		e.downUntil = p.now().Add(p.cooldown)
+	}
+}
+
+// Down reports whether an endpoint is currently out of rotation
+func (p *endpointPool) Down(e *endpoint) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.now().Before(e.downUntil)
+}
+
+// Len returns the number of endpoints in the pool
+func (p *endpointPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.endpoints)
+}
+
+// Probed returns the endpoints that have a health check URL
+func (p *endpointPool) Probed() []*endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var probed []*endpoint
+	for _, e := range p.endpoints {
+		if e.healthURL != "" {
+			probed = append(probed, e)
+		}
+	}
+	return probed
+}
@@ -0,0 +1,102 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPool(strategy string) (*endpointPool, *time.Time) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	pool := newEndpointPool(
+		[]string{"https://us.example.com/ingest", "https://eu.example.com/ingest"},
+		"https://us.example.com/health",
+		strategy, 2, time.Minute,
+	)
+	pool.now = func() time.Time { return now }
+	return pool, &now
+}
+
+func TestEndpointPoolFailover(t *testing.T) {
+	pool, now := newTestPool(strategyFailover)
+	primary, secondary := pool.endpoints[0], pool.endpoints[1]
+
+	assert.Same(t, primary, pool.Pick())
+	assert.False(t, pool.Failure(primary))
+	assert.Same(t, primary, pool.Pick())
+	assert.True(t, pool.Failure(primary))
+	assert.Same(t, secondary, pool.Pick())
+	assert.Same(t, secondary, pool.Pick())
+
+	// Traffic returns to the primary once its cooldown is over
+	*now = now.Add(time.Minute)
+	assert.Same(t, primary, pool.Pick())
+}
+
+func TestEndpointPoolRoundRobin(t *testing.T) {
+	pool, _ := newTestPool(strategyRoundRobin)
+	primary, secondary := pool.endpoints[0], pool.endpoints[1]
+
+	assert.Same(t, primary, pool.Pick())
+	assert.Same(t, secondary, pool.Pick())
+	assert.Same(t, primary, pool.Pick())
+
+	pool.SetHealthy(secondary, false)
+	for i := 0; i < 3; i++ {
+		assert.Same(t, primary, pool.Pick())
+	}
+	pool.SetHealthy(secondary, true)
+	assert.ElementsMatch(t, []*endpoint{primary, secondary}, []*endpoint{pool.Pick(), pool.Pick()})
+}
+
+func TestEndpointPoolAllDown(t *testing.T) {
+	pool, now := newTestPool(strategyFailover)
+	primary, secondary := pool.endpoints[0], pool.endpoints[1]
+
+	pool.SetHealthy(secondary, false)
+	*now = now.Add(time.Second)
+	pool.SetHealthy(primary, false)
+
+	_, ok := pool.Available()
+	assert.False(t, ok)
+	// The endpoint due back soonest is still tried
+	assert.Same(t, secondary, pool.Pick())
+}
+
+func TestEndpointPoolHealthURLs(t *testing.T) {
+	pool, _ := newTestPool(strategyFailover)
+	assert.Equal(t, "https://us.example.com/health", pool.endpoints[0].healthURL)
+	assert.Equal(t, "https://eu.example.com/health", pool.endpoints[1].healthURL)
+	require.Len(t, pool.Probed(), 2)
+
+	noChecks := newEndpointPool([]string{"https://us.example.com/ingest"}, "", strategyFailover, 2, time.Minute)
+	assert.Empty(t, noChecks.Probed())
+}
+
+func TestEndpointPoolReplaceKeepsHealth(t *testing.T) {
+	pool, _ := newTestPool(strategyFailover)
+	us := pool.Pick()
+	pool.Failure(us)
+	require.True(t, pool.Failure(us))
+
+	pool.Replace(
+		[]string{"https://ap.example.com/ingest", "https://us.example.com/ingest"},
+		"https://ap.example.com/health",
+		strategyFailover, 2, time.Minute,
+	)
+	require.Equal(t, 2, pool.Len())
+	assert.Equal(t, "https://ap.example.com/ingest", pool.Pick().url)
+
+	// The US endpoint is still cooling down after its failures
+	var replaced *endpoint
+	for _, e := range pool.Probed() {
+		if e.url == "https://us.example.com/ingest" {
+			replaced = e
+		}
+	}
+	require.NotNil(t, replaced)
+	assert.True(t, pool.Down(replaced))
+	assert.Equal(t, "https://us.example.com/health", replaced.healthURL)
+}
@@ -2,42 +2,463 @@
 package exporter
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
 )
 
+// Output formats for the metrics file
+const (
+	FileFormatJSON   = "json"   // one JSON object per line
+	FileFormatPretty = "pretty" // indented JSON
+	FileFormatCSV    = "csv"    // one summary row per collection
+)
+
+// When the metrics file is flushed to disk
+const (
+	FileSyncNever  = "never"  // leave it to the operating system
+	FileSyncAlways = "always" // after every export
+	FileSyncRotate = "rotate" // when a file is rotated or closed
+)
+
+const (
+	fileMode       = 0600
+	rotatedTimeFmt = "2006-01-02T15-04-05.000"
+)
+
+var csvHeader = []string{
+	"timestamp", "tenant_id", "hostname", "cpu_usage", "memory_usage_percent",
+	"disk_usage_percent", "network_bytes_sent", "network_bytes_received",
+	"process_count", "threat_count",
+}
+
+// FileOptions control the metrics file's format and rotation. Zero values
+// disable the matching limit.
+type FileOptions struct {
+	Format         string
+	MaxBytes       int64         // rotate before the file grows past this
+	RotateInterval time.Duration // rotate when this period, aligned to UTC, ends
+	MaxBackups     int           // rotated files kept
+	MaxAge         time.Duration // age after which rotated files are removed
+	Compress       bool          // gzip rotated files
+	Sync           string
+}
+
+// FileOptionsFromConfig maps the LogSettings section of the configuration
+func FileOptionsFromConfig(cfg config.LogSettings) FileOptions {
+	return FileOptions{
+		Format:         cfg.Format,
+		MaxBytes:       int64(cfg.MaxSize) * 1024 * 1024,
+		RotateInterval: time.Duration(cfg.RotateInterval) * time.Hour,
+		MaxBackups:     cfg.MaxBackups,
+		MaxAge:         time.Duration(cfg.MaxAge) * 24 * time.Hour,
+		Compress:       cfg.Compress,
+		Sync:           cfg.Fsync,
+	}
+}
+
+// FileExporter appends each collection to a local file readable only by the
+// agent's user. Full files are renamed with a timestamp, optionally gzipped,
+// and pruned in the background.
 type FileExporter struct {
 	outputFilePath string
+	options        FileOptions
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	period time.Time // rotation period of the open file
+	closed bool
+
+	millCh chan struct{}
+	wg     sync.WaitGroup
 }
 
-func NewFileExporter(outputFilePath string) *FileExporter {
-	return &FileExporter{outputFilePath: outputFilePath}
+func NewFileExporter(outputFilePath string, options FileOptions) (*FileExporter, error) {
+	switch options.Format {
+	case "", "ndjson":
+		options.Format = FileFormatJSON
+	case FileFormatJSON, FileFormatPretty, FileFormatCSV:
+	default:
+		return nil, fmt.Errorf("unsupported metrics file format %q", options.Format)
+	}
+	switch options.Sync {
+	case "":
+		options.Sync = FileSyncNever
+	case FileSyncNever, FileSyncAlways, FileSyncRotate:
+	default:
+		return nil, fmt.Errorf("unsupported metrics file fsync option %q", options.Sync)
+	}
+
+	e := &FileExporter{
+		outputFilePath: outputFilePath,
+		options:        options,
+		millCh:         make(chan struct{}, 1),
+	}
+	e.wg.Add(1)
+	go e.mill()
+	return e, nil
 }
 
 func (e *FileExporter) Export(data types.MetricPayload) error {
-	file, err := os.OpenFile(e.outputFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	record, err := e.encode(data)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return err
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return ErrExporterClosed
 	}
 
-	_, err = file.WriteString(string(jsonData) + "\n")
+	if e.file == nil {
+		if err := e.openFile(); err != nil {
+			return err
+		}
+	}
+	if e.needsRotation(int64(len(record))) {
+		if err := e.rotate(); err != nil {
+			return err
+		}
+	}
+	if e.size == 0 && e.options.Format == FileFormatCSV {
+		header, _ := csvRecord(csvHeader)
+		record = append(header, record...)
+	}
+
+	n, err := e.file.Write(record)
+	e.size += int64(n)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if e.options.Sync == FileSyncAlways {
+		if err := e.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync metrics file: %w", err)
+		}
 	}
 
 	log.Println("Metrics exported successfully.")
 	return nil
 }
 
+// Close flushes and closes the file and waits for pending compression
 func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	err := e.closeFile()
+	e.mu.Unlock()
+
+	close(e.millCh)
+	e.wg.Wait()
+	return err
+}
+
+func (e *FileExporter) encode(data types.MetricPayload) ([]byte, error) {
+	var record []byte
+	var err error
+	switch e.options.Format {
+	case FileFormatPretty:
+		record, err = json.MarshalIndent(data, "", "  ")
+	case FileFormatCSV:
+		return csvRecord(csvSummary(data))
+	default:
+		record, err = json.Marshal(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal data: %w", err)
+	}
+	return append(record, '\n'), nil
+}
+
+func csvRecord(fields []string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(fields); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// csvSummary flattens the headline metrics of a payload into a row matching
+// csvHeader
+func csvSummary(data types.MetricPayload) []string {
+	number := func(v interface{}) string {
+		if f, ok := asFloat(v); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return ""
+	}
+	disk, _ := data.Metrics["disk"].(map[string]interface{})
+	network, _ := data.Metrics["network"].(map[string]interface{})
+
+	return []string{
+		data.Timestamp,
+		data.TenantID,
+		data.Host.Hostname,
+		number(data.Metrics["cpu_usage"]),
+		number(data.Metrics["memory_usage_percent"]),
+		number(disk["usage_percent"]),
+		number(network["bytes_sent"]),
+		number(network["bytes_received"]),
+		strconv.Itoa(data.Processes.TotalCount),
+		strconv.Itoa(len(data.ThreatIndicators)),
+	}
+}
+
+// openFile opens the file for appending, tightening the permissions of one
+// left by an earlier run. Callers hold the lock.
+func (e *FileExporter) openFile() error {
+	if dir := filepath.Dir(e.outputFilePath); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create metrics file directory: %w", err)
+		}
+	}
+	file, err := os.OpenFile(e.outputFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open metrics file: %w", err)
+	}
+	info, err := file.Stat()
+	if err == nil && info.Mode().Perm() != fileMode {
+		err = file.Chmod(fileMode)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to secure metrics file: %w", err)
+	}
+
+	e.file = file
+	e.size = info.Size()
+	e.period = e.periodOf(info.ModTime())
+	if e.size == 0 {
+		e.period = e.periodOf(time.Now())
+	}
+	return nil
+}
+
+func (e *FileExporter) closeFile() error {
+	if e.file == nil {
+		return nil
+	}
+	var syncErr error
+	if e.options.Sync != FileSyncNever {
+		syncErr = e.file.Sync()
+	}
+	err := e.file.Close()
+	e.file = nil
+	return errors.Join(syncErr, err)
+}
+
+func (e *FileExporter) periodOf(t time.Time) time.Time {
+	if e.options.RotateInterval <= 0 {
+		return time.Time{}
+	}
+	return t.Truncate(e.options.RotateInterval)
+}
+
+// needsRotation reports whether the next write belongs in a new file. A
+// record larger than MaxBytes still goes into an empty file rather than
+// rotating forever.
+func (e *FileExporter) needsRotation(n int64) bool {
+	if e.size == 0 {
+		return false
+	}
+	if e.options.MaxBytes > 0 && e.size+n > e.options.MaxBytes {
+		return true
+	}
+	return !e.periodOf(time.Now()).Equal(e.period)
+}
+
+// rotate moves the current file aside under a timestamped name and starts a
+// new one. Callers hold the lock.
+func (e *FileExporter) rotate() error {
+	if err := e.closeFile(); err != nil {
+		log.Printf("[WARN] Failed to close metrics file before rotation: %v", err)
+	}
+
+	if err := os.Rename(e.outputFilePath, e.rotatedName(time.Now())); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate metrics file: %w", err)
+	}
+	if err := e.openFile(); err != nil {
+		return err
+	}
+
+	select {
+	case e.millCh <- struct{}{}:
+	default:
+	}
 	return nil
 }
+
+// rotatedName timestamps the metrics file's name, stepping past names already
+// taken by rotations within the same millisecond
+func (e *FileExporter) rotatedName(now time.Time) string {
+	ext := filepath.Ext(e.outputFilePath)
+	base := strings.TrimSuffix(e.outputFilePath, ext)
+	for ts := now.UTC(); ; ts = ts.Add(time.Millisecond) {
+		name := fmt.Sprintf("%s-%s%s", base, ts.Format(rotatedTimeFmt), ext)
+		if !fileExists(name) && !fileExists(name+".gz") {
+			return name
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// mill compresses and prunes rotated files after each rotation
+func (e *FileExporter) mill() {
+	defer e.wg.Done()
+	for range e.millCh {
+		if err := e.millOnce(); err != nil {
+			log.Printf("[ERROR] Failed to process rotated metrics files: %v", err)
+		}
+	}
+}
+
+type rotatedFile struct {
+	path      string
+	timestamp time.Time
+}
+
+func (e *FileExporter) millOnce() error {
+	files, err := e.rotatedFiles()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	cutoff := time.Now().Add(-e.options.MaxAge)
+	for i, f := range files {
+		expired := e.options.MaxAge > 0 && f.timestamp.Before(cutoff)
+		if expired || (e.options.MaxBackups > 0 && i >= e.options.MaxBackups) {
+			if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if e.options.Compress && !strings.HasSuffix(f.path, ".gz") {
+			if err := compressFile(f.path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rotatedFiles lists rotated copies of the metrics file, newest first
+func (e *FileExporter) rotatedFiles() ([]rotatedFile, error) {
+	dir := filepath.Dir(e.outputFilePath)
+	ext := filepath.Ext(e.outputFilePath)
+	prefix := strings.TrimSuffix(filepath.Base(e.outputFilePath), ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []rotatedFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name[len(prefix):], ".gz"), ext)
+		ts, err := time.Parse(rotatedTimeFmt, stamp)
+		if err != nil {
+			continue
+		}
+		files = append(files, rotatedFile{path: filepath.Join(dir, name), timestamp: ts})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].timestamp.After(files[j].timestamp) })
+	return files, nil
+}
+
+// compressFile gzips path to path.gz and removes the original
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fileMode)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compress %s: %w", path, err)
+	}
+	return os.Remove(path)
+}
+
+// ScanFile reads the payloads in a metrics file written in the json or
+// pretty format, rotated and gzipped or not, and calls fn for each in order.
+// CSV files hold only a summary and can't be read back.
+func ScanFile(path string, fn func(types.MetricPayload) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	decoder := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var payload types.MetricPayload
+		if err := decoder.Decode(&payload); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read payload %d from %s: %w", n, path, err)
+		}
+		if err := fn(payload); err != nil {
+			return err
+		}
+	}
+}
@@ -1,10 +1,17 @@
 package exporter
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/travism26/shared-monitoring-libs/types"
 )
@@ -12,11 +19,25 @@ import (
 func TestNewFileExporter(t *testing.T) {
 	// Test the constructor
 	path := "test.json"
-	exporter := NewFileExporter(path)
+	exporter, err := NewFileExporter(path, FileOptions{})
+	if err != nil {
+		t.Fatalf("NewFileExporter failed: %v", err)
+	}
+	defer exporter.Close()
 
 	if exporter.outputFilePath != path {
 		t.Errorf("Expected outputFilePath to be %s, got %s", path, exporter.outputFilePath)
 	}
+	if exporter.options.Format != FileFormatJSON || exporter.options.Sync != FileSyncNever {
+		t.Errorf("Expected json format and no fsync by default, got %+v", exporter.options)
+	}
+
+	if _, err := NewFileExporter(path, FileOptions{Format: "xml"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+	if _, err := NewFileExporter(path, FileOptions{Sync: "sometimes"}); err == nil {
+		t.Error("Expected an error for an unknown fsync option")
+	}
 }
 
 func TestFileExporter_Export(t *testing.T) {
@@ -31,7 +52,11 @@ func TestFileExporter_Export(t *testing.T) {
 	testFile := filepath.Join(tmpDir, "test.json")
 
 	// Initialize exporter
-	exporter := NewFileExporter(testFile)
+	exporter, err := NewFileExporter(testFile, FileOptions{Sync: FileSyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
 
 	// Test data
 	testData := types.MetricPayload{
@@ -39,7 +64,7 @@ func TestFileExporter_Export(t *testing.T) {
 		TenantID:  "test-tenant",
 		Metrics: map[string]interface{}{
 			"cpu_usage":    75.5,
-			"memory_usage": 2048,
+			"memory_usage": 2048.0, // JSON numbers decode as float64
 		},
 		Host: struct {
 			OS        string `json:"os"`
@@ -91,3 +116,257 @@ func TestFileExporter_Export(t *testing.T) {
 		t.Errorf("Expected hostname %v, got %v", testData.Host.Hostname, exportedData.Host.Hostname)
 	}
 }
+
+func filePayload(hostname string) types.MetricPayload {
+	payload := types.MetricPayload{
+		Timestamp: "2025-02-07T06:09:25Z",
+		TenantID:  "test-tenant",
+		Metrics: map[string]interface{}{
+			"cpu_usage":            75.5,
+			"memory_usage_percent": 40.25,
+			"disk":                 map[string]interface{}{"usage_percent": 60.0},
+			"network":              map[string]interface{}{"bytes_sent": uint64(1024), "bytes_received": uint64(4096)},
+		},
+		ThreatIndicators: []types.ThreatIndicator{{Type: "test", Severity: "low"}},
+	}
+	payload.Host.Hostname = hostname
+	payload.Processes.TotalCount = 120
+	return payload
+}
+
+func TestFileExporter_Permissions(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "metrics.json")
+	if err := os.WriteFile(testFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	exporter, err := NewFileExporter(testFile, FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	if err := exporter.Export(filePayload("host")); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	info, err := os.Stat(testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected mode 0600, got %o", perm)
+	}
+}
+
+func TestFileExporter_SizeRotation(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "metrics.json")
+	record, _ := json.Marshal(filePayload("host-0"))
+
+	exporter, err := NewFileExporter(testFile, FileOptions{
+		MaxBytes:   int64(len(record)) + 10, // one record per file
+		MaxBackups: 2,
+		Compress:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := exporter.Export(filePayload(fmt.Sprintf("host-%d", i))); err != nil {
+			t.Fatalf("Export %d failed: %v", i, err)
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	rotated, err := filepath.Glob(filepath.Join(dir, "metrics-*.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("Expected 2 compressed backups, got %v", rotated)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "metrics-*.json")); len(leftovers) != 0 {
+		t.Errorf("Expected rotated files to be compressed, found %v", leftovers)
+	}
+
+	// Backups are the newest rotations, in timestamp order
+	for i, path := range rotated {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var payload types.MetricPayload
+		if err := json.NewDecoder(zr).Decode(&payload); err != nil {
+			t.Fatalf("Failed to decode %s: %v", path, err)
+		}
+		f.Close()
+		if want := fmt.Sprintf("host-%d", i+2); payload.Host.Hostname != want {
+			t.Errorf("Expected %s to hold %s, got %s", path, want, payload.Host.Hostname)
+		}
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+			t.Errorf("Expected %s to have mode 0600, got %o", path, info.Mode().Perm())
+		}
+	}
+
+	var current types.MetricPayload
+	content, _ := os.ReadFile(testFile)
+	if err := json.Unmarshal(content, &current); err != nil || current.Host.Hostname != "host-4" {
+		t.Errorf("Expected the active file to hold host-4, got %q (%v)", content, err)
+	}
+}
+
+func TestFileExporter_IntervalRotationAndMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "metrics.json")
+	if err := os.WriteFile(testFile, []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().Add(-25 * time.Hour)
+	if err := os.Chtimes(testFile, yesterday, yesterday); err != nil {
+		t.Fatal(err)
+	}
+	expired := filepath.Join(dir, "metrics-"+time.Now().Add(-10*24*time.Hour).UTC().Format(rotatedTimeFmt)+".json.gz")
+	if err := os.WriteFile(expired, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	exporter, err := NewFileExporter(testFile, FileOptions{
+		RotateInterval: 24 * time.Hour,
+		MaxAge:         7 * 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Export(filePayload("host")); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(expired); !os.IsNotExist(err) {
+		t.Errorf("Expected backup older than MaxAge to be removed")
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, "metrics-*.json"))
+	if len(rotated) != 1 {
+		t.Fatalf("Expected yesterday's file to be rotated, got %v", rotated)
+	}
+	if content, _ := os.ReadFile(rotated[0]); string(content) != "{}\n" {
+		t.Errorf("Unexpected rotated content %q", content)
+	}
+}
+
+func TestFileExporter_PrettyFormat(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "metrics.json")
+	exporter, err := NewFileExporter(testFile, FileOptions{Format: FileFormatPretty})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"a", "b"} {
+		if err := exporter.Export(filePayload(host)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exporter.Close()
+
+	content, _ := os.ReadFile(testFile)
+	if !bytes.Contains(content, []byte("\n  \"tenant_id\": \"test-tenant\"")) {
+		t.Errorf("Expected indented JSON, got %s", content)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for _, want := range []string{"a", "b"} {
+		var payload types.MetricPayload
+		if err := decoder.Decode(&payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Host.Hostname != want {
+			t.Errorf("Expected host %s, got %s", want, payload.Host.Hostname)
+		}
+	}
+}
+
+func TestFileExporter_CSVFormat(t *testing.T) {
+	dir := t.TempDir()
+	testFile := filepath.Join(dir, "metrics.csv")
+	exporter, err := NewFileExporter(testFile, FileOptions{Format: FileFormatCSV, MaxBytes: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"a", "b", "c"} {
+		if err := exporter.Export(filePayload(host)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exporter.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "metrics*.csv"))
+	if len(files) < 2 {
+		t.Fatalf("Expected the CSV file to rotate, got %v", files)
+	}
+	content, _ := os.ReadFile(testFile)
+	rows, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every file, including one started by rotation, begins with the header
+	want := [][]string{
+		csvHeader,
+		{"2025-02-07T06:09:25Z", "test-tenant", "c", "75.5", "40.25", "60", "1024", "4096", "120", "1"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Expected rows %v, got %v", want, rows)
+	}
+}
+
+func TestScanFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, format := range []string{FileFormatJSON, FileFormatPretty} {
+		path := filepath.Join(dir, "metrics-"+format+".json")
+		exporter, err := NewFileExporter(path, FileOptions{Format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, host := range []string{"a", "b", "c"} {
+			if err := exporter.Export(filePayload(host)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		exporter.Close()
+		if format == FileFormatPretty {
+			// Rotated files are read gzipped
+			if err := compressFile(path); err != nil {
+				t.Fatal(err)
+			}
+			path += ".gz"
+		}
+
+		var hosts []string
+		err = ScanFile(path, func(payload types.MetricPayload) error {
+			hosts = append(hosts, payload.Host.Hostname)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if strings.Join(hosts, ",") != "a,b,c" {
+			t.Errorf("%s: expected hosts a,b,c, got %v", format, hosts)
+		}
+	}
+
+	csvPath := filepath.Join(dir, "metrics.csv")
+	exporter, err := NewFileExporter(csvPath, FileOptions{Format: FileFormatCSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter.Export(filePayload("a"))
+	exporter.Close()
+	if err := ScanFile(csvPath, func(types.MetricPayload) error { return nil }); err == nil {
+		t.Error("Expected CSV files to be rejected")
+	}
+}
@@ -2,184 +2,734 @@ package exporter
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/travism26/shared-monitoring-libs/types"
 	"github.com/travism26/system-monitoring-agent/internal/config"
 )
 
+const (
+	// idleRetryInterval is how often the offline queue is checked when
+	// nothing has failed recently
+	idleRetryInterval = 30 * time.Second
+	// closeTimeout bounds the final flush on Close
+	closeTimeout = 10 * time.Second
+	// memoryQueueSize bounds the offline queue when no storage is configured
+	memoryQueueSize = 1000
+)
+
+// ErrExporterClosed is returned by Export after Close
+var ErrExporterClosed = errors.New("exporter closed")
+
 type HTTPExporter struct {
-	apiEndpoint string
-	client      *http.Client
-	enabled     bool
-	retryQueue  chan MetricBatch
-	storage     MetricStorage
-	config      *config.Config
-	headers     map[string]string
+	endpoints *endpointPool
+	client    *http.Client
+	enabled   bool
+	storage   MetricStorage
+	volatile  bool // storage is the in-memory fallback, lost on exit
+	config    *config.Config
+	// headers and the name of the API key header among them are replaced
+	// on reload and key rotation, under mu
+	headers   map[string]string
+	keyHeader string
+
+	batchSize     int
+	batchInterval time.Duration
+	compression   string
+	zstd          *zstd.Encoder
+	retryBase     time.Duration
+	retryMax      time.Duration
+	breaker       *circuitBreaker
+
+	// Worker state, only touched by run
+	retryAttempt int
+	retryTimer   *time.Timer
+
+	mu        sync.RWMutex
+	closed    bool
+	pending   chan types.MetricPayload
+	done      chan struct{}
+	stopped   chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	dropped   int64
+	// probeStopped is closed when the health check goroutine exits
+	probeStopped chan struct{}
 }
 
 type MetricBatch struct {
+	ID        int64               // Offline queue row, zero until stored
 	Data      types.MetricPayload // Changed to use types.MetricPayload instead of map[string]interface{}
 	Timestamp time.Time
 	Attempts  int
 }
 
+// sendError describes a failed delivery. Permanent failures are requests the
+// server rejected as invalid, which retrying won't fix.
+type sendError struct {
+	status     int
+	retryAfter time.Duration
+	permanent  bool
+	// failedOver is set when the failure took the endpoint out of rotation
+	// and another one is available to retry against straight away
+	failedOver bool
+	err        error
+}
+
+func (e *sendError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sendError) Unwrap() error {
+	return e.err
+}
+
 func NewHTTPExporter(cfg *config.Config, storage MetricStorage) (*HTTPExporter, error) {
-	enabled := cfg.Tenant.Endpoints.Metrics != ""
+	urls := metricsURLs(cfg)
+	enabled := len(urls) > 0
 	if !enabled {
 		log.Println("HTTP exporter disabled: no endpoint configured")
 	}
 
-	// Initialize headers
+	compression := cfg.HTTP.Compression
+	if compression == "" {
+		compression = "none"
+	}
+	var encoder *zstd.Encoder
+	switch compression {
+	case "none", "gzip":
+	case "zstd":
+		var err error
+		if encoder, err = zstd.NewWriter(nil); err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported HTTP compression %q", compression)
+	}
+
+	strategy, err := failoverStrategy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	volatile := storage == nil
+	if volatile {
+		storage = newMemoryStorage(memoryQueueSize)
+	}
+
+	batchSize := cfg.HTTP.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	exporter := &HTTPExporter{
+		endpoints: newEndpointPool(
+			urls,
+			cfg.Tenant.Endpoints.HealthCheck,
+			strategy,
+			intOr(cfg.HTTP.Failover.FailureThreshold, 3),
+			secondsOr(cfg.HTTP.Failover.Cooldown, 60),
+		),
+		enabled:       enabled,
+		storage:       storage,
+		volatile:      volatile,
+		config:        cfg,
+		headers:       httpHeaders(cfg, compression),
+		keyHeader:     cfg.HTTP.Headers.APIKey,
+		client:        createHTTPClient(cfg),
+		batchSize:     batchSize,
+		batchInterval: secondsOr(cfg.HTTP.BatchInterval, 10),
+		compression:   compression,
+		zstd:          encoder,
+		retryBase:     secondsOr(cfg.HTTP.RetryDelay, 1),
+		retryMax:      secondsOr(cfg.HTTP.MaxRetryDelay, 300),
+		breaker: newCircuitBreaker(
+			intOr(cfg.HTTP.CircuitBreaker.FailureThreshold, 5),
+			secondsOr(cfg.HTTP.CircuitBreaker.ResetTimeout, 60),
+		),
+		pending:      make(chan types.MetricPayload, batchSize*2+100),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		probeStopped: make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+
+	if enabled {
+		go exporter.run()
+	} else {
+		close(exporter.stopped)
+	}
+	if enabled {
+		go exporter.probe(secondsOr(cfg.HTTP.Failover.HealthCheckInterval, 30))
+	} else {
+		close(exporter.probeStopped)
+	}
+	return exporter, nil
+}
+
+// metricsURLs lists the primary metrics endpoint followed by the failover
+// endpoints
+func metricsURLs(cfg *config.Config) []string {
+	var urls []string
+	primary := cfg.Tenant.Endpoints.Metrics
+	if primary == "" {
+		primary = cfg.HTTP.Endpoint
+	}
+	for _, u := range append([]string{primary}, cfg.Tenant.Endpoints.MetricsFailover...) {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+func failoverStrategy(cfg *config.Config) (string, error) {
+	strategy := cfg.HTTP.Failover.Strategy
+	if strategy == "" {
+		strategy = strategyFailover
+	}
+	if strategy != strategyFailover && strategy != strategyRoundRobin {
+		return "", fmt.Errorf("unsupported HTTP failover strategy %q", strategy)
+	}
+	return strategy, nil
+}
+
+func httpHeaders(cfg *config.Config, compression string) map[string]string {
 	headers := map[string]string{
 		"Content-Type":         "application/json",
 		"X-Tenant-Environment": cfg.Tenant.Environment,
 		"X-Tenant-Type":        cfg.Tenant.Type,
 	}
+	if compression != "none" {
+		headers["Content-Encoding"] = compression
+	}
 
 	// Add optional tenant headers if provided
 	if cfg.Tenant.ID != "" {
 		headers[cfg.HTTP.Headers.TenantID] = cfg.Tenant.ID
 	}
-	if cfg.Tenant.APIKey != "" {
-		headers[cfg.HTTP.Headers.APIKey] = cfg.Tenant.APIKey
+	// The key manager's key, which may have been rotated since it was configured
+	if key := cfg.GetAPIKey(); key != "" {
+		headers[cfg.HTTP.Headers.APIKey] = key
 	}
+	return headers
+}
 
-	exporter := &HTTPExporter{
-		apiEndpoint: cfg.Tenant.Endpoints.Metrics,
-		enabled:     enabled,
-		retryQueue:  make(chan MetricBatch, 1000),
-		storage:     storage,
-		config:      cfg,
-		headers:     headers,
-		client:      createHTTPClient(cfg),
+// SetAPIKey switches the key sent with later requests, as when the key
+// manager rotates it. Requests in flight keep the key they started with.
+func (h *HTTPExporter) SetAPIKey(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.headers = withHeader(h.headers, h.keyHeader, key)
+}
+
+// Client returns the client requests are sent with, configured for the
+// endpoints' TLS settings
+func (h *HTTPExporter) Client() *http.Client {
+	return h.client
+}
+
+// Reload switches to the metrics endpoints, failover settings and tenant
+// headers of a reloaded configuration. Endpoints that stay keep their health.
+// Batching, compression and retry settings keep their startup values.
+func (h *HTTPExporter) Reload(cfg *config.Config) error {
+	urls := metricsURLs(cfg)
+	if (len(urls) > 0) != h.enabled {
+		return errors.New("adding or removing the only metrics endpoint requires a restart")
+	}
+	if !h.enabled {
+		return nil
+	}
+	strategy, err := failoverStrategy(cfg)
+	if err != nil {
+		return err
 	}
 
-	go exporter.retryWorker()
-	return exporter, nil
+	h.endpoints.Replace(
+		urls,
+		cfg.Tenant.Endpoints.HealthCheck,
+		strategy,
+		intOr(cfg.HTTP.Failover.FailureThreshold, 3),
+		secondsOr(cfg.HTTP.Failover.Cooldown, 60),
+	)
+	headers := httpHeaders(cfg, h.compression)
+	keyHeader := cfg.HTTP.Headers.APIKey
+	h.mu.Lock()
+	h.headers = headers
+	h.keyHeader = keyHeader
+	h.mu.Unlock()
+	return nil
 }
 
-// Changed interface to use types.MetricPayload
-// before: func (h *HTTPExporter) Export(data map[string]interface{}) error {
+// Export hands a payload to the export worker without waiting for delivery.
+// If the worker has fallen behind, the payload goes straight to the offline
+// queue rather than blocking collection.
 func (h *HTTPExporter) Export(data types.MetricPayload) error {
 	if !h.enabled {
 		return nil
 	}
 
-	batch := MetricBatch{
-		Data:      data,
-		Timestamp: time.Now(),
-		Attempts:  0,
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closed {
+		return ErrExporterClosed
 	}
 
-	if err := h.sendBatch(batch); err != nil {
-		if h.storage != nil {
-			if err := h.storage.Store(batch); err != nil {
-				log.Printf("Failed to store metrics: %v", err)
-			}
+	select {
+	case h.pending <- data:
+		return nil
+	default:
+	}
+
+	if _, err := h.storage.Store(MetricBatch{Data: data, Timestamp: time.Now()}); err != nil {
+		atomic.AddInt64(&h.dropped, 1)
+		return fmt.Errorf("export queue full and offline storage failed: %w", err)
+	}
+	return nil
+}
+
+// Dropped returns how many payloads were discarded without being delivered
+// or queued: rejected by the server or lost to a storage failure
+func (h *HTTPExporter) Dropped() int64 {
+	return atomic.LoadInt64(&h.dropped)
+}
+
+// Send delivers payloads straight away, batchSize at a time, rather than
+// through the queue. It is used to replay stored payloads, and stops at the
+// first batch that fails.
+func (h *HTTPExporter) Send(payloads []types.MetricPayload) error {
+	if !h.enabled {
+		return errors.New("no metrics endpoint configured")
+	}
+	for start := 0; start < len(payloads); start += h.batchSize {
+		if err := h.send(payloads[start:min(start+h.batchSize, len(payloads))]); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// run batches payloads until batchSize are pending or batchInterval has
+// passed since the first, and resends queued batches on the retry timer
+func (h *HTTPExporter) run() {
+	defer close(h.stopped)
+
+	var batch []types.MetricPayload
+	flushTimer := time.NewTimer(h.batchInterval)
+	stopTimer(flushTimer)
+	defer flushTimer.Stop()
+	var flushC <-chan time.Time
+
+	// Batches left over from a previous run are resent straight away
+	h.retryTimer = time.NewTimer(0)
+	defer h.retryTimer.Stop()
+
+	for {
 		select {
-		case h.retryQueue <- batch:
-		default:
-			log.Println("Retry queue full, metric will be dropped")
+		case data := <-h.pending:
+			batch = append(batch, data)
+			if len(batch) >= h.batchSize {
+				stopTimer(flushTimer)
+				flushC = nil
+				h.flush(batch)
+				batch = nil
+			} else if len(batch) == 1 {
+				flushTimer.Reset(h.batchInterval)
+				flushC = flushTimer.C
+			}
+
+		case <-flushC:
+			flushC = nil
+			h.flush(batch)
+			batch = nil
+
+		case <-h.retryTimer.C:
+			h.drain()
+
+		case <-h.done:
+			for {
+				select {
+				case data := <-h.pending:
+					batch = append(batch, data)
+					continue
+				default:
+				}
+				break
+			}
+			h.flush(batch)
+			return
 		}
-		return err
 	}
+}
 
-	return nil
+// flush sends freshly collected payloads, queueing them offline if that
+// fails or the circuit breaker is open
+func (h *HTTPExporter) flush(payloads []types.MetricPayload) {
+	if len(payloads) == 0 {
+		return
+	}
+
+	attempts := 0
+	if h.breaker.Allow() {
+		settled, err := h.deliver(payloads)
+		if err == nil {
+			h.succeeded()
+			return
+		}
+		payloads = payloads[settled:]
+		h.failed(err)
+		if refused(err) {
+			attempts = 1
+		}
+	}
+
+	now := time.Now()
+	for _, data := range payloads {
+		if _, storeErr := h.storage.Store(MetricBatch{Data: data, Timestamp: now, Attempts: attempts}); storeErr != nil {
+			atomic.AddInt64(&h.dropped, 1)
+			log.Printf("[ERROR] Failed to queue metrics offline: %v", storeErr)
+		}
+	}
 }
 
-func (h *HTTPExporter) sendBatch(batch MetricBatch) error {
-	// Wrap the payload in a data field
-	wrapper := struct {
-		Data types.MetricPayload `json:"data"`
-	}{
-		Data: batch.Data,
+// drain resends one batch from the offline queue and schedules the next
+// attempt
+func (h *HTTPExporter) drain() {
+	if !h.breaker.Allow() {
+		h.scheduleRetry(h.breaker.openUntil.Sub(time.Now()))
+		return
 	}
-	jsonData, err := json.Marshal(wrapper)
+
+	queued, err := h.storage.LoadUnsent(h.batchSize)
 	if err != nil {
-		log.Printf("[ERROR] Failed to marshal metrics data: %v", err)
-		return fmt.Errorf("failed to marshal data: %w", err)
+		log.Printf("[ERROR] Failed to load queued metrics: %v", err)
+		h.scheduleRetry(idleRetryInterval)
+		return
+	}
+	if len(queued) == 0 {
+		h.scheduleRetry(idleRetryInterval)
+		return
+	}
+
+	payloads := make([]types.MetricPayload, len(queued))
+	for i, batch := range queued {
+		payloads[i] = batch.Data
+	}
+
+	settled, err := h.deliver(payloads)
+	for _, batch := range queued[:settled] {
+		if err := h.storage.Remove(batch.ID); err != nil {
+			log.Printf("[ERROR] Failed to remove sent metrics from queue: %v", err)
+		}
+	}
+	switch {
+	case err == nil:
+		h.succeeded()
+		h.scheduleRetry(0)
+	case h.ctx.Err() != nil:
+		// Abandoned on shutdown; the rest of the batch stays queued as it was
+	default:
+		if refused(err) {
+			for _, batch := range queued[settled:] {
+				if err := h.storage.MarkFailed(batch.ID); err != nil {
+					log.Printf("[ERROR] Failed to record delivery attempt: %v", err)
+				}
+			}
+		}
+		h.failed(err)
+	}
+}
+
+func (h *HTTPExporter) succeeded() {
+	h.breaker.Success()
+	h.retryAttempt = 0
+	if stats, err := h.storage.Stats(); err == nil && stats.Depth > 0 {
+		h.scheduleRetry(0)
+	}
+}
+
+// failed backs off after a retryable failure, honouring Retry-After and the
+// circuit breaker
+func (h *HTTPExporter) failed(err error) {
+	var sendErr *sendError
+	if errors.As(err, &sendErr) && sendErr.failedOver {
+		// Another endpoint is up, so retry there without backing off
+		h.scheduleRetry(0)
+		return
 	}
 
-	log.Printf("[DEBUG] Preparing to send metrics to endpoint: %s (payload size: %d bytes)", h.apiEndpoint, len(jsonData))
+	h.breaker.Failure()
+	h.retryAttempt++
+
+	delay := backoff(h.retryAttempt, h.retryBase, h.retryMax)
+	if sendErr != nil && sendErr.retryAfter > 0 {
+		delay = sendErr.retryAfter
+	}
+	if h.breaker.Open() {
+		if cooldown := h.breaker.openUntil.Sub(time.Now()); cooldown > delay {
+			delay = cooldown
+		}
+		log.Printf("[WARN] Metrics endpoint unavailable, pausing delivery for %s: %v", delay.Round(time.Second), err)
+	} else {
+		log.Printf("[WARN] Failed to send metrics, retrying in %s: %v", delay.Round(time.Second), err)
+	}
+	h.scheduleRetry(delay)
+}
 
-	req, err := http.NewRequest("POST", h.apiEndpoint, bytes.NewBuffer(jsonData))
+// deliver sends payloads, splitting a batch the server rejects as invalid or
+// too large and resending the halves, so that only payloads rejected on their
+// own are dropped. It returns how many leading payloads were sent or dropped,
+// and the retryable error that stopped it at the rest.
+func (h *HTTPExporter) deliver(payloads []types.MetricPayload) (int, error) {
+	err := h.send(payloads)
+	if err == nil {
+		return len(payloads), nil
+	}
+	if !permanent(err) {
+		return 0, err
+	}
+	if len(payloads) == 1 {
+		atomic.AddInt64(&h.dropped, 1)
+		log.Printf("[ERROR] Metrics endpoint rejected a payload, dropping it: %v", err)
+		return 1, nil
+	}
+
+	half := len(payloads) / 2
+	log.Printf("[WARN] Metrics endpoint rejected %d payloads, resending them in halves: %v", len(payloads), err)
+	settled, err := h.deliver(payloads[:half])
 	if err != nil {
-		log.Printf("[ERROR] Failed to create HTTP request: %v", err)
-		return fmt.Errorf("failed to create request: %w", err)
+		return settled, err
 	}
+	settled, err = h.deliver(payloads[half:])
+	return half + settled, err
+}
 
-	// Add headers
-	for key, value := range h.headers {
-		req.Header.Set(key, value)
+// permanent reports whether the server refused the request as invalid, which
+// retrying it unchanged won't fix
+func permanent(err error) bool {
+	var sendErr *sendError
+	return errors.As(err, &sendErr) && sendErr.permanent
+}
+
+// refused reports whether a retryable failure was the server answering and
+// refusing the request, which uses up one of a queued batch's attempts. An
+// unreachable, overloaded or failing server says nothing about the payload,
+// so outages are bounded by the queue's size and age limits instead.
+func refused(err error) bool {
+	var sendErr *sendError
+	if !errors.As(err, &sendErr) || sendErr.status == 0 {
+		return false
+	}
+	switch sendErr.status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return sendErr.status < 500
+}
+
+func (h *HTTPExporter) scheduleRetry(delay time.Duration) {
+	if delay < 0 {
+		delay = 0
+	}
+	stopTimer(h.retryTimer)
+	h.retryTimer.Reset(delay)
+}
+
+// send posts payloads as one request. A batch size of one keeps the original
+// {"data": payload} body; larger batch sizes send {"data": [payload, ...]}.
+func (h *HTTPExporter) send(payloads []types.MetricPayload) error {
+	var wrapper interface{} = struct {
+		Data []types.MetricPayload `json:"data"`
+	}{Data: payloads}
+	if h.batchSize == 1 {
+		wrapper = struct {
+			Data types.MetricPayload `json:"data"`
+		}{Data: payloads[0]}
+	}
+
+	jsonData, err := json.Marshal(wrapper)
+	if err != nil {
+		return &sendError{permanent: true, err: fmt.Errorf("failed to marshal data: %w", err)}
+	}
+	body, err := h.compress(jsonData)
+	if err != nil {
+		return &sendError{permanent: true, err: fmt.Errorf("failed to compress data: %w", err)}
+	}
+
+	target := h.endpoints.Pick()
+	h.mu.RLock()
+	headers, keyHeader := h.headers, h.keyHeader
+	h.mu.RUnlock()
+
+	req, err := h.newRequest(target.url, body, headers)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
 	}
-	log.Printf("[DEBUG] Request headers: %v", req.Header)
 
-	// Do sends an HTTP request and returns an HTTP response
-	log.Printf("[DEBUG] Sending HTTP request to %s", h.apiEndpoint)
 	resp, err := h.client.Do(req)
+	if err == nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		// Just after a rotation the server may not accept the new key yet,
+		// but still accepts the old one for its grace window
+		if backup, ok := h.config.GetBackupAPIKey(); ok && backup != headers[keyHeader] {
+			if retry, reqErr := h.newRequest(target.url, body, withHeader(headers, keyHeader, backup)); reqErr == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				log.Printf("[WARN] API key refused with status %d, retrying with the previous key", resp.StatusCode)
+				resp, err = h.client.Do(retry)
+			}
+		}
+	}
 	if err != nil {
-		log.Printf("[ERROR] Failed to send metrics: %v", err)
-		return fmt.Errorf("failed to send metrics: %w", err)
+		if h.ctx.Err() != nil {
+			// Abandoned on Close; not the endpoint's fault
+			return fmt.Errorf("failed to send metrics: %w", err)
+		}
+		return h.endpointFailed(target, &sendError{err: fmt.Errorf("failed to send metrics: %w", err)})
 	}
 	defer resp.Body.Close()
 
-	// Read response body for error cases
-	body, _ := ioutil.ReadAll(resp.Body)
+	// Only a bounded prefix of the response is kept for error messages
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	io.Copy(io.Discard, resp.Body)
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		log.Printf("[ERROR] Server returned non-success status %d. Response body: %s", resp.StatusCode, string(body))
-		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		h.endpoints.Success(target)
+		log.Printf("[DEBUG] Sent %d metric payloads (%d bytes, %s) to %s. Status: %d", len(payloads), len(body), h.compression, redactURL(target.url), resp.StatusCode)
+		return nil
 	}
 
-	log.Printf("[DEBUG] Successfully sent metrics batch. Status: %d", resp.StatusCode)
+	sendErr := &sendError{
+		status: resp.StatusCode,
+		err:    fmt.Errorf("server returned status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody)),
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		sendErr.retryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		// The endpoint is up; it's the payload that is wrong
+		sendErr.permanent = true
+		h.endpoints.Success(target)
+		return sendErr
+	}
+	return h.endpointFailed(target, sendErr)
+}
 
-	if h.storage != nil {
-		h.storage.Remove(batch)
+// newRequest builds a request posting body with the given headers
+func (h *HTTPExporter) newRequest(url string, body []byte, headers map[string]string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return req, nil
 }
 
-func (h *HTTPExporter) retryWorker() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+// withHeader returns a copy of headers with name set to value
+func withHeader(headers map[string]string, name, value string) map[string]string {
+	copied := make(map[string]string, len(headers))
+	for k, v := range headers {
+		copied[k] = v
+	}
+	copied[name] = value
+	return copied
+}
 
-	for {
-		select {
-		case batch := <-h.retryQueue:
-			if batch.Attempts >= 5 {
-				log.Printf("Dropping metric batch after %d attempts", batch.Attempts)
-				continue
-			}
+// endpointFailed records a failed request against its endpoint, noting on
+// the error whether the request can be retried elsewhere straight away
+func (h *HTTPExporter) endpointFailed(target *endpoint, sendErr *sendError) error {
+	if h.endpoints.Failure(target) {
+		if next, ok := h.endpoints.Available(); ok {
+			sendErr.failedOver = true
+			log.Printf("[WARN] Metrics endpoint %s is failing, switching to %s: %v", redactURL(target.url), redactURL(next.url), sendErr)
+		}
+	}
+	return sendErr
+}
 
-			batch.Attempts++
-			if err := h.sendBatch(batch); err != nil {
-				time.Sleep(time.Second * time.Duration(1<<batch.Attempts))
-				h.retryQueue <- batch
-			}
+// probe checks the health of each endpoint every interval until Close
+func (h *HTTPExporter) probe(interval time.Duration) {
+	defer close(h.probeStopped)
 
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		h.checkHealth()
+		select {
 		case <-ticker.C:
-			if h.storage != nil {
-				batches, err := h.storage.LoadUnsent()
-				if err != nil {
-					log.Printf("Failed to load stored metrics: %v", err)
-					continue
-				}
+		case <-h.done:
+			return
+		}
+	}
+}
 
-				for _, batch := range batches {
-					h.retryQueue <- batch
-				}
-			}
+// checkHealth probes each endpoint with a health check URL. Health checks
+// only matter when there is another endpoint to route to.
+func (h *HTTPExporter) checkHealth() {
+	if h.endpoints.Len() < 2 {
+		return
+	}
+	for _, e := range h.endpoints.Probed() {
+		err := h.healthCheck(e.healthURL)
+		if h.ctx.Err() != nil {
+			return
 		}
+		wasDown := h.endpoints.Down(e)
+		h.endpoints.SetHealthy(e, err == nil)
+		switch {
+		case err != nil && !wasDown:
+			log.Printf("[WARN] Metrics endpoint %s failed its health check, taking it out of rotation: %v", redactURL(e.url), err)
+		case err == nil && wasDown:
+			log.Printf("[INFO] Metrics endpoint %s is healthy again", redactURL(e.url))
+		}
+	}
+}
+
+func (h *HTTPExporter) healthCheck(healthURL string) error {
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (h *HTTPExporter) compress(data []byte) ([]byte, error) {
+	switch h.compression {
+	case "gzip":
+		return gzipBytes(data)
+	case "zstd":
+		return h.zstd.EncodeAll(data, nil), nil
+	default:
+		return data, nil
 	}
 }
 
@@ -187,6 +737,20 @@ func (h *HTTPExporter) retryWorker() {
 func createHTTPClient(cfg *config.Config) *http.Client {
 	log.Printf("[DEBUG] Creating HTTP client with timeout: %d seconds", cfg.HTTP.Timeout)
 
+	return &http.Client{
+		Timeout: time.Duration(cfg.HTTP.Timeout) * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   newTLSConfig(cfg),
+			MaxIdleConns:      100,
+			IdleConnTimeout:   90 * time.Second,
+			DisableKeepAlives: false,
+		},
+	}
+}
+
+// newTLSConfig builds the client TLS configuration shared by the exporters
+// from the Security section
+func newTLSConfig(cfg *config.Config) *tls.Config {
 	// Create TLS config
 	tlsConfig := &tls.Config{
 		MinVersion:         tls.VersionTLS12,
@@ -226,19 +790,76 @@ func createHTTPClient(cfg *config.Config) *http.Client {
 		}
 	}
 
-	return &http.Client{
-		Timeout: time.Duration(cfg.HTTP.Timeout) * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig:    tlsConfig,
-			MaxIdleConns:       100,
-			IdleConnTimeout:    90 * time.Second,
-			DisableCompression: true,
-			DisableKeepAlives:  false,
-		},
-	}
+	return tlsConfig
 }
 
+// Close stops accepting payloads and flushes those pending. Anything that
+// can't be delivered within closeTimeout is left in the offline queue.
 func (h *HTTPExporter) Close() error {
-	h.client.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return h.Shutdown(ctx)
+}
+
+// Shutdown is Close with the caller's deadline: pending payloads get until
+// ctx is done to be delivered, then the request in flight is abandoned and
+// they are left in the offline queue. Without storage, the payloads queued in
+// memory are lost and counted as dropped.
+func (h *HTTPExporter) Shutdown(ctx context.Context) error {
+	h.closeOnce.Do(func() {
+		h.mu.Lock()
+		h.closed = true
+		h.mu.Unlock()
+
+		close(h.done)
+		select {
+		case <-h.stopped:
+		case <-ctx.Done():
+			// Abandon the request in flight; the worker queues its payloads
+			h.cancel()
+			<-h.stopped
+		}
+		h.cancel()
+		<-h.probeStopped
+		h.client.CloseIdleConnections()
+		if h.zstd != nil {
+			h.zstd.Close()
+		}
+		if h.volatile {
+			dropQueued(h.storage, &h.dropped)
+		}
+	})
 	return nil
 }
+
+// redactURL hides any credentials in an endpoint URL before it is logged
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	return u.Redacted()
+}
+
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+func secondsOr(seconds, fallback int) time.Duration {
+	if seconds <= 0 {
+		seconds = fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func intOr(value, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
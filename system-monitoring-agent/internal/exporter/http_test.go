@@ -1,11 +1,27 @@
 package exporter
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/travism26/shared-monitoring-libs/types"
 	"github.com/travism26/system-monitoring-agent/internal/config"
 )
@@ -30,8 +46,8 @@ func TestNewHTTPExporter(t *testing.T) {
 	if !exporter.enabled {
 		t.Error("Expected exporter to be enabled with endpoint")
 	}
-	if exporter.apiEndpoint != cfg.HTTP.Endpoint {
-		t.Errorf("Expected endpoint %s, got %s", cfg.HTTP.Endpoint, exporter.apiEndpoint)
+	if got := exporter.endpoints.Pick().url; got != cfg.HTTP.Endpoint {
+		t.Errorf("Expected endpoint %s, got %s", cfg.HTTP.Endpoint, got)
 	}
 
 	// Test case 2: without endpoint (disabled)
@@ -49,6 +65,9 @@ func TestNewHTTPExporter(t *testing.T) {
 func TestHTTPExporter_Export(t *testing.T) {
 	// Create a test server
 	var receivedData map[string]interface{}
+	var wrapper struct {
+		Data map[string]interface{} `json:"data"`
+	}
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Log("🔵 Test server received a request")
 		t.Log("📝 Request Method:", r.Method)
@@ -66,9 +85,10 @@ func TestHTTPExporter_Export(t *testing.T) {
 
 		// Decode and log the received data
 		decoder := json.NewDecoder(r.Body)
-		if err := decoder.Decode(&receivedData); err != nil {
+		if err := decoder.Decode(&wrapper); err != nil {
 			t.Errorf("Failed to decode request body: %v", err)
 		}
+		receivedData = wrapper.Data
 		t.Log("📥 Received data:", receivedData)
 
 		// Respond with success
@@ -124,9 +144,11 @@ func TestHTTPExporter_Export(t *testing.T) {
 			List:             []types.ProcessInfo{},
 		},
 		Metadata: struct {
-			CollectionDuration string   `json:"collection_duration"`
-			CollectorCount     int      `json:"collector_count"`
-			Errors             []string `json:"errors,omitempty"`
+			CollectionDuration string                           `json:"collection_duration"`
+			CollectorCount     int                              `json:"collector_count"`
+			Errors             []string                         `json:"errors,omitempty"`
+			Queue              *types.QueueStats                `json:"queue,omitempty"`
+			Collectors         map[string]types.CollectorHealth `json:"collectors,omitempty"`
 		}{
 			CollectionDuration: "100ms",
 			CollectorCount:     5,
@@ -146,6 +168,11 @@ func TestHTTPExporter_Export(t *testing.T) {
 	}
 	t.Log("✅ Export completed without errors")
 
+	// Delivery is asynchronous; Close flushes pending payloads
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
 	// Verify the exported data
 	t.Log("🔍 Verifying received data matches sent data...")
 	for key, expected := range testDataAsMap {
@@ -158,7 +185,7 @@ func TestHTTPExporter_Export(t *testing.T) {
 		t.Logf("Checking %s: expected=%v (%T), received=%v (%T)",
 			key, expected, expected, received, received)
 
-		if received != expected {
+		if !reflect.DeepEqual(received, expected) {
 			t.Errorf("❌ For key %s: expected %v, got %v", key, expected, received)
 		} else {
 			t.Logf("✅ Key %s matches expected value", key)
@@ -228,3 +255,611 @@ func TestHTTPExporter_Close(t *testing.T) {
 		t.Errorf("Close() returned error: %v", err)
 	}
 }
+
+// recordingServer collects the payloads posted to it, decoding any
+// Content-Encoding. respond picks the status for each request. GET requests
+// are health checks and always succeed.
+type recordingServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  int
+	encodings []string
+	payloads  []types.MetricPayload
+}
+
+func newRecordingServer(t *testing.T, batched bool, respond func(n int, w http.ResponseWriter) bool) *recordingServer {
+	rs := &recordingServer{}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			return
+		}
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		rs.requests++
+		if respond != nil && !respond(rs.requests, w) {
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			body, err = gunzipBytes(body)
+			require.NoError(t, err)
+		case "zstd":
+			decoder, err := zstd.NewReader(nil)
+			require.NoError(t, err)
+			body, err = decoder.DecodeAll(body, nil)
+			decoder.Close()
+			require.NoError(t, err)
+		}
+		rs.encodings = append(rs.encodings, r.Header.Get("Content-Encoding"))
+
+		if batched {
+			var wrapper struct {
+				Data []types.MetricPayload `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(body, &wrapper))
+			rs.payloads = append(rs.payloads, wrapper.Data...)
+		} else {
+			var wrapper struct {
+				Data types.MetricPayload `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(body, &wrapper))
+			rs.payloads = append(rs.payloads, wrapper.Data)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+func (rs *recordingServer) received() (int, []types.MetricPayload) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.requests, append([]types.MetricPayload(nil), rs.payloads...)
+}
+
+func TestHTTPExporterBatchesAndCompresses(t *testing.T) {
+	for _, compression := range []string{"gzip", "zstd"} {
+		t.Run(compression, func(t *testing.T) {
+			server := newRecordingServer(t, true, nil)
+			cfg := &config.Config{}
+			cfg.HTTP.Endpoint = server.URL
+			cfg.HTTP.BatchSize = 3
+			cfg.HTTP.BatchInterval = 60
+			cfg.HTTP.Compression = compression
+
+			exporter, err := NewHTTPExporter(cfg, nil)
+			require.NoError(t, err)
+			for _, tenant := range []string{"a", "b", "c", "d"} {
+				require.NoError(t, exporter.Export(types.MetricPayload{TenantID: tenant}))
+			}
+
+			// The first three go out together as soon as the batch is full
+			assert.Eventually(t, func() bool {
+				_, payloads := server.received()
+				return len(payloads) == 3
+			}, 5*time.Second, 10*time.Millisecond)
+
+			// The remainder is flushed on Close rather than waiting for the interval
+			require.NoError(t, exporter.Close())
+			requests, payloads := server.received()
+			assert.Equal(t, 2, requests)
+			require.Len(t, payloads, 4)
+			assert.Equal(t, "d", payloads[3].TenantID)
+			assert.Equal(t, []string{compression, compression}, server.encodings)
+		})
+	}
+}
+
+func TestHTTPExporterFlushesOnInterval(t *testing.T) {
+	server := newRecordingServer(t, true, nil)
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = server.URL
+	cfg.HTTP.BatchSize = 100
+	cfg.HTTP.BatchInterval = 1
+
+	exporter, err := NewHTTPExporter(cfg, nil)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "a"}))
+	assert.Eventually(t, func() bool {
+		_, payloads := server.received()
+		return len(payloads) == 1
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestHTTPExporterHonoursRetryAfter(t *testing.T) {
+	var firstAt, retryAt time.Time
+	server := newRecordingServer(t, false, func(n int, w http.ResponseWriter) bool {
+		if n == 1 {
+			firstAt = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return false
+		}
+		if retryAt.IsZero() {
+			retryAt = time.Now()
+		}
+		return true
+	})
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = server.URL
+
+	storage := newMemoryStorage(10)
+	exporter, err := NewHTTPExporter(cfg, storage)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "a"}))
+	assert.Eventually(t, func() bool {
+		_, payloads := server.received()
+		return len(payloads) == 1
+	}, 5*time.Second, 20*time.Millisecond)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	assert.GreaterOrEqual(t, retryAt.Sub(firstAt), time.Second)
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Depth)
+}
+
+func TestHTTPExporterCircuitBreaker(t *testing.T) {
+	server := newRecordingServer(t, false, func(n int, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return false
+	})
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = server.URL
+	cfg.HTTP.RetryDelay = 60
+	cfg.HTTP.CircuitBreaker.FailureThreshold = 2
+	cfg.HTTP.CircuitBreaker.ResetTimeout = 60
+
+	storage := newMemoryStorage(10)
+	exporter, err := NewHTTPExporter(cfg, storage)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "a"}))
+		time.Sleep(20 * time.Millisecond)
+	}
+	require.NoError(t, exporter.Close())
+
+	// Two failures open the breaker; later payloads are queued without a request
+	requests, _ := server.received()
+	assert.Equal(t, 2, requests)
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 5, stats.Depth)
+
+	// An unavailable server doesn't use up the batches' attempts
+	queued, err := storage.LoadUnsent(10)
+	require.NoError(t, err)
+	for _, batch := range queued {
+		assert.Equal(t, 0, batch.Attempts)
+	}
+}
+
+func TestHTTPExporterCountsRefusedDeliveries(t *testing.T) {
+	server := newRecordingServer(t, false, func(n int, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	})
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = server.URL
+	cfg.HTTP.RetryDelay = 60
+
+	storage := newMemoryStorage(10)
+	exporter, err := NewHTTPExporter(cfg, storage)
+	require.NoError(t, err)
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "a"}))
+	require.NoError(t, exporter.Close())
+
+	queued, err := storage.LoadUnsent(10)
+	require.NoError(t, err)
+	require.Len(t, queued, 1)
+	assert.Equal(t, 1, queued[0].Attempts)
+}
+
+func TestRefused(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection error", &sendError{err: errors.New("connection refused")}, false},
+		{"server error", &sendError{status: http.StatusBadGateway}, false},
+		{"rate limited", &sendError{status: http.StatusTooManyRequests}, false},
+		{"request timeout", &sendError{status: http.StatusRequestTimeout}, false},
+		{"forbidden", &sendError{status: http.StatusForbidden}, true},
+		{"not found", &sendError{status: http.StatusNotFound}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, refused(tt.err))
+		})
+	}
+}
+
+func TestHTTPExporterDropsRejectedPayloads(t *testing.T) {
+	server := newRecordingServer(t, false, func(n int, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	})
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = server.URL
+
+	storage := newMemoryStorage(10)
+	exporter, err := NewHTTPExporter(cfg, storage)
+	require.NoError(t, err)
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "a"}))
+	require.NoError(t, exporter.Close())
+
+	assert.Equal(t, int64(1), exporter.Dropped())
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Depth)
+}
+
+func TestHTTPExporterSplitsRejectedBatches(t *testing.T) {
+	// Batches over two payloads are too large, and a batch holding the
+	// "bad" payload is invalid
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			return
+		}
+		var wrapper struct {
+			Data []types.MetricPayload `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&wrapper))
+		if len(wrapper.Data) > 2 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		for _, payload := range wrapper.Data {
+			if payload.TenantID == "bad" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, payload := range wrapper.Data {
+			received = append(received, payload.TenantID)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = server.URL
+	cfg.HTTP.BatchSize = 4
+	cfg.HTTP.BatchInterval = 60
+
+	storage := newMemoryStorage(10)
+	exporter, err := NewHTTPExporter(cfg, storage)
+	require.NoError(t, err)
+	for _, tenant := range []string{"a", "bad", "c", "d"} {
+		require.NoError(t, exporter.Export(types.MetricPayload{TenantID: tenant}))
+	}
+	require.NoError(t, exporter.Close())
+
+	// Only the payload rejected on its own is dropped
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"a", "c", "d"}, received)
+	assert.Equal(t, int64(1), exporter.Dropped())
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Depth)
+}
+
+func TestHTTPExporterNeverLogsAPIKey(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	server := newRecordingServer(t, false, func(n int, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	})
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = server.URL
+	cfg.HTTP.Headers.APIKey = "X-API-Key"
+	cfg.Tenant.APIKey = "sk-very-secret-key"
+
+	exporter, err := NewHTTPExporter(cfg, nil)
+	require.NoError(t, err)
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "a"}))
+	require.NoError(t, exporter.Close())
+
+	assert.Contains(t, logs.String(), "status 401")
+	assert.NotContains(t, logs.String(), "sk-very-secret-key")
+}
+
+func TestHTTPExporterCloseStopsExports(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = newRecordingServer(t, false, nil).URL
+
+	exporter, err := NewHTTPExporter(cfg, nil)
+	require.NoError(t, err)
+	require.NoError(t, exporter.Close())
+	require.NoError(t, exporter.Close())
+	assert.ErrorIs(t, exporter.Export(types.MetricPayload{}), ErrExporterClosed)
+}
+
+func TestHTTPExporterShutdownQueuesPendingPayloads(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			// Hang until the exporter gives up on the request, which is
+			// only noticed once the body has been read
+			io.Copy(io.Discard, r.Body)
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = server.URL
+	cfg.HTTP.BatchSize = 10
+	cfg.HTTP.BatchInterval = 60
+
+	storage, err := NewMetricStorage(t.TempDir(), StorageOptions{})
+	require.NoError(t, err)
+	defer storage.Close()
+	exporter, err := NewHTTPExporter(cfg, storage)
+	require.NoError(t, err)
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "a"}))
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "b"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, exporter.Shutdown(ctx))
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	queued, err := storage.LoadUnsent(10)
+	require.NoError(t, err)
+	require.Len(t, queued, 2)
+	assert.Equal(t, "a", queued[0].Data.TenantID)
+	assert.Zero(t, exporter.Dropped())
+}
+
+func TestHTTPExporterShutdownCountsPayloadsOnlyInMemory(t *testing.T) {
+	server := newRecordingServer(t, false, func(n int, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return false
+	})
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = server.URL
+	cfg.HTTP.RetryDelay = 60
+
+	exporter, err := NewHTTPExporter(cfg, nil)
+	require.NoError(t, err)
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "a"}))
+	require.NoError(t, exporter.Shutdown(context.Background()))
+	assert.Equal(t, int64(1), exporter.Dropped())
+}
+
+func TestNewHTTPExporterRejectsUnknownCompression(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = "http://example.com"
+	cfg.HTTP.Compression = "brotli"
+
+	_, err := NewHTTPExporter(cfg, nil)
+	assert.Error(t, err)
+}
+
+func TestHTTPExporterFailsOverToNextEndpoint(t *testing.T) {
+	primary := newRecordingServer(t, false, func(n int, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return false
+	})
+	secondary := newRecordingServer(t, false, nil)
+
+	cfg := &config.Config{}
+	cfg.Tenant.Endpoints.Metrics = primary.URL
+	cfg.Tenant.Endpoints.MetricsFailover = []string{secondary.URL}
+	cfg.HTTP.RetryDelay = 60
+	cfg.HTTP.Failover.FailureThreshold = 1
+
+	exporter, err := NewHTTPExporter(cfg, nil)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	// The payload is retried on the secondary straight away, not after RetryDelay
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "a"}))
+	assert.Eventually(t, func() bool {
+		_, payloads := secondary.received()
+		return len(payloads) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "b"}))
+	assert.Eventually(t, func() bool {
+		_, payloads := secondary.received()
+		return len(payloads) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	requests, _ := primary.received()
+	assert.Equal(t, 1, requests)
+}
+
+func TestHTTPExporterSkipsEndpointFailingHealthCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	var primaryHits int32
+	mux.HandleFunc("/ingest", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryHits, 1)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	primary := httptest.NewServer(mux)
+	defer primary.Close()
+	secondary := newRecordingServer(t, false, nil)
+
+	cfg := &config.Config{}
+	cfg.Tenant.Endpoints.Metrics = primary.URL + "/ingest"
+	cfg.Tenant.Endpoints.HealthCheck = primary.URL + "/health"
+	cfg.Tenant.Endpoints.MetricsFailover = []string{secondary.URL + "/ingest"}
+
+	exporter, err := NewHTTPExporter(cfg, nil)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	assert.Eventually(t, func() bool {
+		return exporter.endpoints.Down(exporter.endpoints.endpoints[0])
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "a"}))
+	assert.Eventually(t, func() bool {
+		_, payloads := secondary.received()
+		return len(payloads) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&primaryHits))
+}
+
+func TestNewHTTPExporterRejectsUnknownStrategy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = "http://example.com"
+	cfg.HTTP.Failover.Strategy = "random"
+
+	_, err := NewHTTPExporter(cfg, nil)
+	assert.Error(t, err)
+}
+
+func TestHTTPExporterReloadSwitchesEndpointAndHeaders(t *testing.T) {
+	type request struct{ server, apiKey string }
+	requests := make(chan request, 10)
+	newServer := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- request{server: name, apiKey: r.Header.Get("X-API-Key")}
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	old, next := newServer("old"), newServer("next")
+
+	cfg := &config.Config{}
+	cfg.Tenant.Endpoints.Metrics = old.URL
+	cfg.Tenant.APIKey = "old-key"
+	cfg.HTTP.Headers.APIKey = "X-API-Key"
+	exporter, err := NewHTTPExporter(cfg, nil)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "a"}))
+	assert.Equal(t, request{"old", "old-key"}, <-requests)
+
+	reloaded := &config.Config{}
+	reloaded.Tenant.Endpoints.Metrics = next.URL
+	reloaded.Tenant.APIKey = "new-key"
+	reloaded.HTTP.Headers.APIKey = "X-API-Key"
+	require.NoError(t, exporter.Reload(reloaded))
+
+	require.NoError(t, exporter.Export(types.MetricPayload{TenantID: "b"}))
+	assert.Equal(t, request{"next", "new-key"}, <-requests)
+}
+
+func TestHTTPExporterReloadRejectsInvalidSettings(t *testing.T) {
+	server := newRecordingServer(t, false, nil)
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = server.URL
+	exporter, err := NewHTTPExporter(cfg, nil)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	invalid := &config.Config{}
+	invalid.HTTP.Endpoint = server.URL
+	invalid.HTTP.Failover.Strategy = "random"
+	assert.Error(t, exporter.Reload(invalid))
+	assert.Error(t, exporter.Reload(&config.Config{}), "removing the only endpoint needs a restart")
+}
+
+func TestHTTPExporterSendDeliversSynchronously(t *testing.T) {
+	server := newRecordingServer(t, true, nil)
+	cfg := &config.Config{}
+	cfg.HTTP.Endpoint = server.URL
+	cfg.HTTP.BatchSize = 2
+	cfg.HTTP.BatchInterval = 60
+
+	exporter, err := NewHTTPExporter(cfg, nil)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	payloads := []types.MetricPayload{{TenantID: "a"}, {TenantID: "b"}, {TenantID: "c"}}
+	require.NoError(t, exporter.Send(payloads))
+	requests, received := server.received()
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, payloads, received)
+
+	disabled, err := NewHTTPExporter(&config.Config{}, nil)
+	require.NoError(t, err)
+	defer disabled.Close()
+	assert.Error(t, disabled.Send(payloads))
+}
+
+func TestHTTPExporterUsesRotatedAPIKey(t *testing.T) {
+	const initialKey = "initial-key-0123456789abcdefghijkl"
+	var mu sync.Mutex
+	accepted := map[string]bool{initialKey: true}
+	var keys []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rotate", func(w http.ResponseWriter, r *http.Request) {
+		// The new key takes a while to reach the metrics endpoint; the old
+		// one is accepted for an hour
+		fmt.Fprint(w, `{"api_key": "rotated-key", "grace_period": 3600}`)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := r.Header.Get("X-API-Key")
+		keys = append(keys, key)
+		if !accepted[key] {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(`
+Tenant:
+  ID: "tenant-123"
+  APIKey: %q
+  Endpoints:
+    Metrics: "%s/metrics"
+    KeyRotation: "%s/rotate"
+HTTP:
+  StorageDir: %q
+  Headers:
+    TenantID: "X-Tenant-ID"
+    APIKey: "X-API-Key"
+`, initialKey, server.URL, server.URL, dir)), 0o600))
+	viper.Reset()
+	defer viper.Reset()
+	cfg, err := config.LoadConfigFile(path)
+	require.NoError(t, err)
+
+	exporter, err := NewHTTPExporter(cfg, nil)
+	require.NoError(t, err)
+	defer exporter.Close()
+	cfg.OnAPIKeyChange(exporter.SetAPIKey)
+
+	require.NoError(t, cfg.RotateAPIKey())
+	require.NoError(t, exporter.Send([]types.MetricPayload{{TenantID: "a"}}))
+
+	// Once the new key is accepted the old one isn't needed
+	mu.Lock()
+	accepted["rotated-key"] = true
+	mu.Unlock()
+	require.NoError(t, exporter.Send([]types.MetricPayload{{TenantID: "b"}}))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"rotated-key", initialKey, "rotated-key"}, keys)
+}
@@ -1,9 +1,34 @@
 package exporter
 
-import "github.com/travism26/shared-monitoring-libs/types"
+import (
+	"context"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
 
 // MetricsExporter defines the interface for all metric exporters
 type MetricsExporter interface {
 	Export(data types.MetricPayload) error
 	Close() error
 }
+
+// Reloader is implemented by exporters that can take new settings from a
+// reloaded configuration without being recreated. An error leaves the
+// exporter as it was.
+type Reloader interface {
+	Reload(cfg *config.Config) error
+}
+
+// Shutdowner is implemented by exporters that hold undelivered payloads. On
+// Shutdown they stop accepting payloads and get until ctx is done to deliver
+// or persist them; Close waits for a default deadline instead.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// DropCounter is implemented by exporters that can lose payloads, reporting
+// how many they have discarded undelivered since they were created
+type DropCounter interface {
+	Dropped() int64
+}
@@ -1,63 +1,439 @@
 package exporter
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+	"github.com/xdg-go/scram"
 )
 
-// mtravis notes: I dont think we need this I dont plan on directly connecting to kafka
-// I plan on sending data to a endpoint that is connected to kafka and then passing it to kafka
-// I will keep this for now but we may want to remove it...
+const (
+	// kafkaReplayInterval is how often batches queued after a failed delivery
+	// are handed back to the producer
+	kafkaReplayInterval = 30 * time.Second
+	// kafkaReplayBatch bounds how many queued batches are in flight at once
+	kafkaReplayBatch = 100
+)
+
+// KafkaQueueTable keeps undelivered Kafka messages apart from the HTTP
+// exporter's queue
+const KafkaQueueTable = "kafka_queue"
 
-// KafkaExporter handles the export of monitoring data to Kafka topics
+// KafkaExporter handles the export of monitoring data to Kafka topics. Messages
+// are produced asynchronously; those the brokers fail to accept are queued in
+// storage and replayed.
 type KafkaExporter struct {
-	producer sarama.SyncProducer
-	topic    string
+	producer    sarama.AsyncProducer
+	topic       string
+	tenantTopic string
+	storage     MetricStorage
+	volatile    bool // storage is the in-memory fallback, lost on exit
+
+	mu       sync.Mutex
+	closed   bool
+	inFlight map[int64]bool // queued batches currently being replayed
+
+	// storageMu is held while the delivery handlers and replay use storage.
+	// abandoned is set by a Shutdown that stopped waiting for them, whose
+	// caller may go on to close the storage.
+	storageMu sync.Mutex
+	abandoned bool
+
+	done    chan struct{}
+	wg      sync.WaitGroup
+	dropped int64
+	pending int64 // collected payloads handed to the producer, not yet acknowledged
 }
 
-// NewKafkaExporter creates a new Kafka exporter instance
-// brokers is a list of Kafka broker addresses
-// topic is the Kafka topic to publish messages to
-// Returns the new exporter and any error encountered during setup
-func NewKafkaExporter(brokers []string, topic string) (*KafkaExporter, error) {
-	config := sarama.NewConfig()
-	config.Producer.RequiredAcks = sarama.WaitForAll
-	config.Producer.Retry.Max = 5
-	config.Producer.Return.Successes = true
+// kafkaMessage travels with each produced message so the delivery result can
+// be matched to its payload and, for replays, its queue row
+type kafkaMessage struct {
+	id      int64
+	payload types.MetricPayload
+}
 
-	producer, err := sarama.NewSyncProducer(brokers, config)
+// NewKafkaExporter creates a new Kafka exporter instance from the Kafka and
+// Security sections of the configuration. Undelivered messages are queued in
+// storage, which should use its own table (see KafkaQueueTable); nil keeps
+// them in memory.
+func NewKafkaExporter(cfg *config.Config, storage MetricStorage) (*KafkaExporter, error) {
+	saramaConfig, err := newSaramaConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &KafkaExporter{
-		producer: producer,
-		topic:    topic,
-	}, nil
+	producer, err := sarama.NewAsyncProducer(cfg.Kafka.Brokers, saramaConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+
+	return newKafkaExporter(producer, cfg.Kafka, storage, kafkaReplayInterval), nil
+}
+
+func newKafkaExporter(producer sarama.AsyncProducer, cfg config.KafkaConfig, storage MetricStorage, replayInterval time.Duration) *KafkaExporter {
+	volatile := storage == nil
+	if volatile {
+		storage = newMemoryStorage(memoryQueueSize)
+	}
+
+	k := &KafkaExporter{
+		producer:    producer,
+		topic:       cfg.Topic,
+		tenantTopic: cfg.TenantTopic,
+		storage:     storage,
+		volatile:    volatile,
+		inFlight:    make(map[int64]bool),
+		done:        make(chan struct{}),
+	}
+
+	k.wg.Add(3)
+	go k.handleSuccesses()
+	go k.handleErrors()
+	go k.replay(replayInterval)
+	return k
+}
+
+// newSaramaConfig maps the security protocol and SASL settings onto the
+// producer configuration
+func newSaramaConfig(cfg *config.Config) (*sarama.Config, error) {
+	kafka := cfg.Kafka
+	if len(kafka.Brokers) == 0 {
+		return nil, errors.New("at least one Kafka broker is required")
+	}
+	if kafka.Topic == "" && kafka.TenantTopic == "" {
+		return nil, errors.New("a Kafka topic is required")
+	}
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.ClientID = "system-monitoring-agent"
+	saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+	saramaConfig.Producer.Retry.Max = 5
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.Producer.Return.Errors = true
+
+	var useTLS, useSASL bool
+	switch strings.ToLower(kafka.SecurityProtocol) {
+	case "", "plaintext":
+	case "ssl":
+		useTLS = true
+	case "sasl_plaintext":
+		useSASL = true
+	case "sasl_ssl":
+		useTLS, useSASL = true, true
+	default:
+		return nil, fmt.Errorf("unsupported Kafka security protocol %q", kafka.SecurityProtocol)
+	}
+
+	if useTLS {
+		saramaConfig.Net.TLS.Enable = true
+		saramaConfig.Net.TLS.Config = newTLSConfig(cfg)
+	}
+
+	if useSASL {
+		if kafka.Username == "" || kafka.Password == "" {
+			return nil, errors.New("Kafka SASL requires a username and password")
+		}
+		saramaConfig.Net.SASL.Enable = true
+		saramaConfig.Net.SASL.User = kafka.Username
+		saramaConfig.Net.SASL.Password = kafka.Password
+
+		switch strings.ToUpper(kafka.SASLMechanism) {
+		case "", sarama.SASLTypePlaintext:
+			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case sarama.SASLTypeSCRAMSHA256:
+			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hash: scram.SHA256}
+			}
+		case sarama.SASLTypeSCRAMSHA512:
+			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{hash: scram.SHA512}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported Kafka SASL mechanism %q", kafka.SASLMechanism)
+		}
+	}
+
+	if err := saramaConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Kafka configuration: %w", err)
+	}
+	return saramaConfig, nil
 }
 
-// Export sends the provided data to Kafka as a JSON message
-// data is a map of values to be exported
-// Returns an error if marshaling or sending fails
+// Export hands the payload to the producer. Delivery is reported
+// asynchronously; if the producer's buffer is full the payload is queued
+// for replay instead of blocking collection.
 func (k *KafkaExporter) Export(data types.MetricPayload) error {
-	jsonData, err := json.Marshal(data)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.closed {
+		return ErrExporterClosed
+	}
+
+	msg, err := k.message(kafkaMessage{payload: data})
 	if err != nil {
 		return err
 	}
 
-	msg := &sarama.ProducerMessage{
-		Topic: k.topic,
-		Value: sarama.StringEncoder(jsonData),
+	select {
+	case k.producer.Input() <- msg:
+		atomic.AddInt64(&k.pending, 1)
+		return nil
+	default:
 	}
 
-	_, _, err = k.producer.SendMessage(msg)
-	return err
+	if _, err := k.storage.Store(MetricBatch{Data: data, Timestamp: time.Now()}); err != nil {
+		atomic.AddInt64(&k.dropped, 1)
+		return fmt.Errorf("Kafka producer busy and offline storage failed: %w", err)
+	}
+	return nil
+}
+
+// Dropped returns how many payloads were lost because they could neither be
+// produced nor queued
+func (k *KafkaExporter) Dropped() int64 {
+	return atomic.LoadInt64(&k.dropped)
+}
+
+func (k *KafkaExporter) message(m kafkaMessage) (*sarama.ProducerMessage, error) {
+	jsonData, err := json.Marshal(m.payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal data: %w", err)
+	}
+
+	return &sarama.ProducerMessage{
+		Topic:    k.topicFor(m.payload.TenantID),
+		Key:      sarama.StringEncoder(messageKey(m.payload)),
+		Value:    sarama.ByteEncoder(jsonData),
+		Metadata: m,
+	}, nil
+}
+
+// topicFor routes a tenant's payloads to TenantTopic, with {id} replaced by
+// the tenant ID, falling back to Topic for payloads without a tenant
+func (k *KafkaExporter) topicFor(tenantID string) string {
+	if k.tenantTopic == "" || (tenantID == "" && k.topic != "") {
+		return k.topic
+	}
+	return strings.ReplaceAll(k.tenantTopic, "{id}", tenantID)
+}
+
+// messageKey keeps each host's payloads on one partition, and so in order
+func messageKey(data types.MetricPayload) string {
+	return data.TenantID + ":" + data.Host.Hostname
+}
+
+func (k *KafkaExporter) handleSuccesses() {
+	defer k.wg.Done()
+	for msg := range k.producer.Successes() {
+		m, ok := msg.Metadata.(kafkaMessage)
+		if !ok {
+			continue
+		}
+		if m.id == 0 {
+			atomic.AddInt64(&k.pending, -1)
+			continue
+		}
+		k.withStorage(func() {
+			if err := k.storage.Remove(m.id); err != nil {
+				log.Printf("[ERROR] Failed to remove replayed Kafka message from queue: %v", err)
+			}
+		})
+		k.finishReplay(m.id)
+	}
+}
+
+// handleErrors queues payloads the brokers didn't accept. Replayed batches
+// already have a row, which records the failed attempt instead when the
+// brokers refused the message rather than being unavailable.
+func (k *KafkaExporter) handleErrors() {
+	defer k.wg.Done()
+	for perr := range k.producer.Errors() {
+		m, ok := perr.Msg.Metadata.(kafkaMessage)
+		if !ok {
+			continue
+		}
+		log.Printf("[WARN] Failed to deliver metrics to Kafka topic %s, queueing for retry: %v", perr.Msg.Topic, perr.Err)
+
+		attempts := 0
+		if kafkaRefused(perr.Err) {
+			attempts = 1
+		}
+		if m.id != 0 {
+			if attempts > 0 {
+				k.withStorage(func() {
+					if err := k.storage.MarkFailed(m.id); err != nil {
+						log.Printf("[ERROR] Failed to record Kafka delivery attempt: %v", err)
+					}
+				})
+			}
+			k.finishReplay(m.id)
+			continue
+		}
+		// A Shutdown that gave up has already counted the message as dropped
+		k.withStorage(func() {
+			if _, err := k.storage.Store(MetricBatch{Data: m.payload, Timestamp: time.Now(), Attempts: attempts}); err != nil {
+				atomic.AddInt64(&k.dropped, 1)
+				log.Printf("[ERROR] Failed to queue Kafka message offline: %v", err)
+			}
+		})
+		atomic.AddInt64(&k.pending, -1)
+	}
+}
+
+// withStorage runs fn unless Shutdown has stopped waiting for the delivery
+// handlers, after which the storage may be closed
+func (k *KafkaExporter) withStorage(fn func()) {
+	k.storageMu.Lock()
+	defer k.storageMu.Unlock()
+	if !k.abandoned {
+		fn()
+	}
+}
+
+// kafkaRefused reports whether the brokers refused the message itself, as
+// opposed to being unreachable or without a leader
+func kafkaRefused(err error) bool {
+	var kerr sarama.KError
+	if !errors.As(err, &kerr) {
+		return false
+	}
+	switch kerr {
+	case sarama.ErrInvalidMessage, sarama.ErrMessageSizeTooLarge, sarama.ErrMessageSetSizeTooLarge, sarama.ErrInvalidRecord:
+		return true
+	}
+	return false
+}
+
+func (k *KafkaExporter) finishReplay(id int64) {
+	k.mu.Lock()
+	delete(k.inFlight, id)
+	k.mu.Unlock()
+}
+
+// replay resends queued batches every interval until Close
+func (k *KafkaExporter) replay(interval time.Duration) {
+	defer k.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			k.replayQueued()
+		case <-k.done:
+			return
+		}
+	}
+}
+
+func (k *KafkaExporter) replayQueued() {
+	var queued []MetricBatch
+	var err error
+	k.withStorage(func() { queued, err = k.storage.LoadUnsent(kafkaReplayBatch) })
+	if err != nil {
+		log.Printf("[ERROR] Failed to load queued Kafka messages: %v", err)
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, batch := range queued {
+		if k.closed {
+			return
+		}
+		if k.inFlight[batch.ID] {
+			continue
+		}
+		msg, err := k.message(kafkaMessage{id: batch.ID, payload: batch.Data})
+		if err != nil {
+			log.Printf("[ERROR] Failed to rebuild queued Kafka message %d: %v", batch.ID, err)
+			continue
+		}
+		select {
+		case k.producer.Input() <- msg:
+			k.inFlight[batch.ID] = true
+		default:
+			// The producer is backed up; try the rest next time
+			return
+		}
+	}
 }
 
-// Close cleanly shuts down the Kafka producer
-// Returns any error encountered during shutdown
+// Close stops producing and waits for outstanding deliveries. Anything the
+// brokers reject meanwhile is queued for the next run.
 func (k *KafkaExporter) Close() error {
-	return k.producer.Close()
+	return k.Shutdown(context.Background())
+}
+
+// Shutdown is Close with the caller's deadline. Payloads still with the
+// producer when ctx is done can't be queued and are counted as dropped, as
+// are those queued in memory for want of storage. Once Shutdown returns the
+// exporter no longer uses its storage, which the caller can close.
+func (k *KafkaExporter) Shutdown(ctx context.Context) error {
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return nil
+	}
+	k.closed = true
+	k.mu.Unlock()
+
+	close(k.done)
+	k.producer.AsyncClose()
+	stopped := make(chan struct{})
+	go func() {
+		k.wg.Wait()
+		close(stopped)
+	}()
+
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		k.storageMu.Lock()
+		k.abandoned = true
+		k.storageMu.Unlock()
+		lost := atomic.LoadInt64(&k.pending)
+		atomic.AddInt64(&k.dropped, lost)
+		err = fmt.Errorf("gave up waiting for %d Kafka deliveries: %w", lost, ctx.Err())
+	}
+	if k.volatile {
+		dropQueued(k.storage, &k.dropped)
+	}
+	return err
+}
+
+// scramClient implements sarama.SCRAMClient
+type scramClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conv.Done()
 }
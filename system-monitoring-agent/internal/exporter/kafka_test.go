@@ -0,0 +1,216 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+func kafkaTestConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Kafka.Brokers = []string{"localhost:9092"}
+	cfg.Kafka.Topic = "system-metrics"
+	cfg.Kafka.TenantTopic = "tenant-{id}-metrics"
+	return cfg
+}
+
+func mockProducerConfig() *sarama.Config {
+	cfg := mocks.NewTestConfig()
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+	return cfg
+}
+
+func kafkaPayload(tenant, host string) types.MetricPayload {
+	payload := types.MetricPayload{TenantID: tenant}
+	payload.Host.Hostname = host
+	return payload
+}
+
+func TestNewSaramaConfig(t *testing.T) {
+	t.Run("plaintext", func(t *testing.T) {
+		saramaConfig, err := newSaramaConfig(kafkaTestConfig())
+		require.NoError(t, err)
+		assert.False(t, saramaConfig.Net.TLS.Enable)
+		assert.False(t, saramaConfig.Net.SASL.Enable)
+		assert.Equal(t, sarama.WaitForAll, saramaConfig.Producer.RequiredAcks)
+	})
+
+	t.Run("sasl_ssl with SCRAM", func(t *testing.T) {
+		cfg := kafkaTestConfig()
+		cfg.Kafka.SecurityProtocol = "SASL_SSL"
+		cfg.Kafka.SASLMechanism = "SCRAM-SHA-512"
+		cfg.Kafka.Username = "agent"
+		cfg.Kafka.Password = "secret"
+		cfg.Security.ValidateSSL = true
+
+		saramaConfig, err := newSaramaConfig(cfg)
+		require.NoError(t, err)
+		assert.True(t, saramaConfig.Net.TLS.Enable)
+		assert.False(t, saramaConfig.Net.TLS.Config.InsecureSkipVerify)
+		assert.True(t, saramaConfig.Net.SASL.Enable)
+		assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), saramaConfig.Net.SASL.Mechanism)
+		require.NotNil(t, saramaConfig.Net.SASL.SCRAMClientGeneratorFunc)
+
+		client := saramaConfig.Net.SASL.SCRAMClientGeneratorFunc()
+		require.NoError(t, client.Begin("agent", "secret", ""))
+		first, err := client.Step("")
+		require.NoError(t, err)
+		assert.Contains(t, first, "n=agent")
+	})
+
+	t.Run("sasl_plaintext defaults to PLAIN", func(t *testing.T) {
+		cfg := kafkaTestConfig()
+		cfg.Kafka.SecurityProtocol = "sasl_plaintext"
+		cfg.Kafka.Username = "agent"
+		cfg.Kafka.Password = "secret"
+
+		saramaConfig, err := newSaramaConfig(cfg)
+		require.NoError(t, err)
+		assert.False(t, saramaConfig.Net.TLS.Enable)
+		assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypePlaintext), saramaConfig.Net.SASL.Mechanism)
+	})
+
+	for name, mutate := range map[string]func(*config.Config){
+		"no brokers":       func(cfg *config.Config) { cfg.Kafka.Brokers = nil },
+		"unknown protocol": func(cfg *config.Config) { cfg.Kafka.SecurityProtocol = "tls" },
+		"missing password": func(cfg *config.Config) { cfg.Kafka.SecurityProtocol = "sasl_ssl"; cfg.Kafka.Username = "agent" },
+		"unknown mechanism": func(cfg *config.Config) {
+			cfg.Kafka.SecurityProtocol = "sasl_ssl"
+			cfg.Kafka.Username = "a"
+			cfg.Kafka.Password = "b"
+			cfg.Kafka.SASLMechanism = "GSSAPI"
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := kafkaTestConfig()
+			mutate(cfg)
+			_, err := newSaramaConfig(cfg)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestKafkaExporterRoutesAndKeysMessages(t *testing.T) {
+	producer := mocks.NewAsyncProducer(t, mockProducerConfig())
+	producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "tenant-acme-metrics" {
+			return errors.New("unexpected topic " + msg.Topic)
+		}
+		key, _ := msg.Key.Encode()
+		if string(key) != "acme:web-1" {
+			return errors.New("unexpected key " + string(key))
+		}
+		return nil
+	})
+	producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "system-metrics" {
+			return errors.New("unexpected topic " + msg.Topic)
+		}
+		return nil
+	})
+
+	exporter := newKafkaExporter(producer, kafkaTestConfig().Kafka, nil, time.Hour)
+	require.NoError(t, exporter.Export(kafkaPayload("acme", "web-1")))
+	require.NoError(t, exporter.Export(kafkaPayload("", "web-2")))
+	require.NoError(t, exporter.Close())
+	assert.ErrorIs(t, exporter.Export(kafkaPayload("acme", "web-1")), ErrExporterClosed)
+}
+
+func TestKafkaExporterQueuesFailedDeliveries(t *testing.T) {
+	producer := mocks.NewAsyncProducer(t, mockProducerConfig())
+	producer.ExpectInputAndFail(sarama.ErrNotLeaderForPartition)
+
+	storage := newMemoryStorage(10)
+	exporter := newKafkaExporter(producer, kafkaTestConfig().Kafka, storage, time.Hour)
+	require.NoError(t, exporter.Export(kafkaPayload("acme", "web-1")))
+	require.NoError(t, exporter.Close())
+
+	queued, err := storage.LoadUnsent(10)
+	require.NoError(t, err)
+	require.Len(t, queued, 1)
+	assert.Equal(t, "web-1", queued[0].Data.Host.Hostname)
+	// A partition without a leader says nothing about the message
+	assert.Equal(t, 0, queued[0].Attempts)
+	assert.Zero(t, exporter.Dropped())
+}
+
+func TestKafkaExporterReplaysQueuedMessages(t *testing.T) {
+	producer := mocks.NewAsyncProducer(t, mockProducerConfig())
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(sarama.ErrMessageSizeTooLarge)
+
+	storage := newMemoryStorage(10)
+	_, err := storage.Store(MetricBatch{Data: kafkaPayload("acme", "web-1")})
+	require.NoError(t, err)
+	_, err = storage.Store(MetricBatch{Data: kafkaPayload("acme", "web-2")})
+	require.NoError(t, err)
+
+	exporter := newKafkaExporter(producer, kafkaTestConfig().Kafka, storage, time.Hour)
+	exporter.replayQueued()
+	assert.Eventually(t, func() bool {
+		queued, _ := storage.LoadUnsent(10)
+		return len(queued) == 1 && queued[0].Attempts == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, exporter.Close())
+
+	// The delivered batch is removed; the failed one stays queued with its
+	// attempt recorded
+	queued, err := storage.LoadUnsent(10)
+	require.NoError(t, err)
+	require.Len(t, queued, 1)
+	assert.Equal(t, "web-2", queued[0].Data.Host.Hostname)
+}
+
+// stuckProducer accepts messages but never reports on them, like a producer
+// that can't reach its brokers
+type stuckProducer struct {
+	sarama.AsyncProducer
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func newStuckProducer() *stuckProducer {
+	return &stuckProducer{
+		input:     make(chan *sarama.ProducerMessage, 10),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+}
+
+func (p *stuckProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *stuckProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+func (p *stuckProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+func (p *stuckProducer) AsyncClose()                               {}
+
+func TestKafkaExporterShutdownCountsUndeliveredMessages(t *testing.T) {
+	producer := newStuckProducer()
+	exporter := newKafkaExporter(producer, kafkaTestConfig().Kafka, newMemoryStorage(10), time.Hour)
+	require.NoError(t, exporter.Export(kafkaPayload("acme", "web-1")))
+	require.NoError(t, exporter.Export(kafkaPayload("acme", "web-2")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := exporter.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int64(2), exporter.Dropped())
+
+	// A failure reported after Shutdown gave up mustn't touch the storage,
+	// which may be closed by now
+	producer.errors <- &sarama.ProducerError{Msg: <-producer.input, Err: sarama.ErrOutOfBrokers}
+	close(producer.successes)
+	close(producer.errors)
+	exporter.wg.Wait()
+	queued, err := exporter.storage.LoadUnsent(10)
+	require.NoError(t, err)
+	assert.Empty(t, queued)
+}
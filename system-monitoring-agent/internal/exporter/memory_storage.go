@@ -0,0 +1,107 @@
+package exporter
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+)
+
+// memoryStorage is the offline queue used when no SQLite storage is
+// configured. It holds at most maxBatches batches, evicting the oldest, and
+// is lost on restart.
+type memoryStorage struct {
+	mu         sync.Mutex
+	maxBatches int
+	nextID     int64
+	batches    []MetricBatch
+	dropped    types.QueueStats
+}
+
+func newMemoryStorage(maxBatches int) *memoryStorage {
+	return &memoryStorage{maxBatches: maxBatches}
+}
+
+func (s *memoryStorage) Store(batch MetricBatch) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	batch.ID = s.nextID
+	if batch.Timestamp.IsZero() {
+		batch.Timestamp = time.Now()
+	}
+	s.batches = append(s.batches, batch)
+	if over := len(s.batches) - s.maxBatches; over > 0 {
+		s.batches = s.batches[over:]
+		s.dropped.DroppedEvicted += int64(over)
+	}
+	return batch.ID, nil
+}
+
+func (s *memoryStorage) Remove(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, batch := range s.batches {
+		if batch.ID == id {
+			s.batches = append(s.batches[:i], s.batches[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no queued metric with id %d", id)
+}
+
+func (s *memoryStorage) MarkFailed(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.batches {
+		if s.batches[i].ID != id {
+			continue
+		}
+		s.batches[i].Attempts++
+		if s.batches[i].Attempts >= maxSendAttempts {
+			s.batches = append(s.batches[:i], s.batches[i+1:]...)
+			s.dropped.DroppedFailed++
+		}
+		return nil
+	}
+	return nil
+}
+
+func (s *memoryStorage) LoadUnsent(limit int) ([]MetricBatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if limit > len(s.batches) {
+		limit = len(s.batches)
+	}
+	return append([]MetricBatch(nil), s.batches[:limit]...), nil
+}
+
+func (s *memoryStorage) Stats() (types.QueueStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.dropped
+	stats.Depth = len(s.batches)
+	return stats, nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
+
+// dropQueued adds the batches left in an in-memory queue to an exporter's
+// dropped count when it shuts down, since they go with the process
+func dropQueued(storage MetricStorage, dropped *int64) {
+	stats, err := storage.Stats()
+	if err == nil && stats.Depth > 0 {
+		atomic.AddInt64(dropped, int64(stats.Depth))
+		log.Printf("[WARN] Dropping %d undelivered payloads queued in memory", stats.Depth)
+	}
+}
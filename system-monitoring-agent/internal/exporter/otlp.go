@@ -0,0 +1,600 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/host"
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	otlpScopeName   = "github.com/travism26/system-monitoring-agent"
+	otlpServiceName = "system-monitoring-agent"
+)
+
+// OTLPExporter sends payloads to an OpenTelemetry collector over OTLP/HTTP
+// with protobuf encoding. Host metrics become gauges and cumulative sums;
+// threat indicators become log records. Requests are sent from a worker, so
+// a collector that is slow or down doesn't hold up collection.
+type OTLPExporter struct {
+	queue *sendQueue
+	// mu guards the collector settings, which Reload replaces
+	mu          sync.RWMutex
+	endpoint    string
+	headers     map[string]string
+	compression string
+	client      *http.Client
+	// startTime is the start of the cumulative counters, which the kernel
+	// keeps from boot
+	startTime time.Time
+}
+
+func NewOTLPExporter(cfg *config.Config) (*OTLPExporter, error) {
+	o, err := otlpFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	o.queue = newSendQueue("OTLP collector", cfg)
+	return o, nil
+}
+
+// otlpFromConfig builds an exporter without starting its worker
+func otlpFromConfig(cfg *config.Config) (*OTLPExporter, error) {
+	endpoint := strings.TrimRight(cfg.OTLP.Endpoint, "/")
+	if endpoint == "" {
+		return nil, errors.New("OTLP endpoint is required")
+	}
+
+	compression := cfg.OTLP.Compression
+	if compression == "" {
+		compression = "none"
+	}
+	if compression != "none" && compression != "gzip" {
+		return nil, fmt.Errorf("unsupported OTLP compression %q", compression)
+	}
+
+	client := createHTTPClient(cfg)
+	client.Timeout = secondsOr(cfg.OTLP.Timeout, 10)
+
+	startTime := time.Now()
+	if boot, err := host.BootTime(); err == nil {
+		startTime = time.Unix(int64(boot), 0)
+	}
+
+	return &OTLPExporter{
+		endpoint:    endpoint,
+		headers:     cfg.OTLP.Headers,
+		compression: compression,
+		client:      client,
+		startTime:   startTime,
+	}, nil
+}
+
+// Export queues the payload's metrics and, if there are any, its threat
+// indicators. They are sent as separate requests, so a failed one is retried
+// without resending the other.
+func (o *OTLPExporter) Export(data types.MetricPayload) error {
+	resource := otlpResource(data)
+
+	metrics := &metricspb.MetricsData{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   otlpScope(data),
+				Metrics: o.convertMetrics(data),
+			}},
+		}},
+	}
+	err := o.queue.add(func(ctx context.Context) error {
+		if err := o.post(ctx, "/v1/metrics", metrics); err != nil {
+			return fmt.Errorf("failed to export OTLP metrics: %w", err)
+		}
+		return nil
+	})
+	if err != nil || len(data.ThreatIndicators) == 0 {
+		return err
+	}
+
+	logs := &logspb.LogsData{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: resource,
+			ScopeLogs: []*logspb.ScopeLogs{{
+				Scope:      otlpScope(data),
+				LogRecords: convertIndicators(data.ThreatIndicators),
+			}},
+		}},
+	}
+	return o.queue.add(func(ctx context.Context) error {
+		if err := o.post(ctx, "/v1/logs", logs); err != nil {
+			return fmt.Errorf("failed to export OTLP logs: %w", err)
+		}
+		return nil
+	})
+}
+
+// Reload switches to the collector endpoint, headers and compression of a
+// reloaded configuration
+func (o *OTLPExporter) Reload(cfg *config.Config) error {
+	if cfg.OTLP.Endpoint == "" {
+		return errors.New("removing the OTLP endpoint requires a restart")
+	}
+	next, err := otlpFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.client.CloseIdleConnections()
+	o.endpoint = next.endpoint
+	o.headers = next.headers
+	o.compression = next.compression
+	o.client = next.client
+	return nil
+}
+
+// post sends one request to path under the collector endpoint. MetricsData
+// and LogsData share their wire format with the collector's
+// Export*ServiceRequest messages, which saves pulling in the gRPC service
+// packages.
+func (o *OTLPExporter) post(ctx context.Context, path string, message proto.Message) error {
+	client, req, err := o.newRequest(ctx, path, message)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	sendErr := &sendError{
+		status: resp.StatusCode,
+		err:    fmt.Errorf("collector returned status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody)),
+	}
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound,
+		http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		// Retrying the same request won't change the collector's answer
+		sendErr.permanent = true
+	}
+	return sendErr
+}
+
+// newRequest builds a request with the current collector settings, returning
+// the client to send it with, so a reload doesn't wait for the request
+func (o *OTLPExporter) newRequest(ctx context.Context, path string, message proto.Message) (*http.Client, *http.Request, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	body, err := proto.Marshal(message)
+	if err != nil {
+		return nil, nil, &sendError{permanent: true, err: fmt.Errorf("failed to marshal data: %w", err)}
+	}
+	if o.compression == "gzip" {
+		if body, err = gzipBytes(body); err != nil {
+			return nil, nil, &sendError{permanent: true, err: fmt.Errorf("failed to compress data: %w", err)}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range o.headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	if o.compression == "gzip" {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return o.client, req, nil
+}
+
+// Close is Shutdown with the default deadline
+func (o *OTLPExporter) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return o.Shutdown(ctx)
+}
+
+// Shutdown gives the queued requests until ctx is done to be sent. Those
+// still unsent are dropped.
+func (o *OTLPExporter) Shutdown(ctx context.Context) error {
+	o.queue.shutdown(ctx)
+	o.mu.RLock()
+	o.client.CloseIdleConnections()
+	o.mu.RUnlock()
+	return nil
+}
+
+// Dropped returns how many requests were discarded unsent
+func (o *OTLPExporter) Dropped() int64 {
+	return o.queue.Dropped()
+}
+
+// otlpResource describes the host and tenant the payload came from
+func otlpResource(data types.MetricPayload) *resourcepb.Resource {
+	attrs := []*commonpb.KeyValue{
+		stringAttr("service.name", otlpServiceName),
+		stringAttr("host.name", data.Host.Hostname),
+		stringAttr("host.arch", data.Host.Arch),
+		stringAttr("os.type", data.Host.OS),
+		intAttr("host.cpu.cores", int64(data.Host.CPUCores)),
+		stringAttr("process.runtime.version", data.Host.GoVersion),
+	}
+	if data.TenantID != "" {
+		attrs = append(attrs, stringAttr("tenant.id", data.TenantID))
+	}
+	if version := data.TenantMetadata["agent_version"]; version != "" {
+		attrs = append(attrs, stringAttr("service.version", version))
+	}
+	for _, field := range otlpTenantAttributes {
+		if value := data.TenantMetadata[field.key]; value != "" {
+			attrs = append(attrs, stringAttr(field.attr, value))
+		}
+	}
+	return &resourcepb.Resource{Attributes: attrs}
+}
+
+// otlpTenantAttributes picks the tenant metadata that identifies the
+// resource. Settings such as the config version or sample rate change over
+// its lifetime, and a changed resource would start new series.
+var otlpTenantAttributes = []struct{ key, attr string }{
+	{"environment", "tenant.environment"},
+	{"tenant_type", "tenant.type"},
+	{"tenant_name", "tenant.name"},
+}
+
+func otlpScope(data types.MetricPayload) *commonpb.InstrumentationScope {
+	return &commonpb.InstrumentationScope{
+		Name:    otlpScopeName,
+		Version: data.TenantMetadata["agent_version"],
+	}
+}
+
+// metricBuilder accumulates OTLP metrics stamped with one collection time
+type metricBuilder struct {
+	now     uint64
+	start   uint64
+	metrics []*metricspb.Metric
+}
+
+func (b *metricBuilder) gauge(name, unit, description string, points ...*metricspb.NumberDataPoint) {
+	if len(points) == 0 {
+		return
+	}
+	for _, p := range points {
+		p.TimeUnixNano = b.now
+	}
+	b.metrics = append(b.metrics, &metricspb.Metric{
+		Name:        name,
+		Unit:        unit,
+		Description: description,
+		Data:        &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: points}},
+	})
+}
+
+func (b *metricBuilder) counter(name, unit, description string, points ...*metricspb.NumberDataPoint) {
+	if len(points) == 0 {
+		return
+	}
+	for _, p := range points {
+		p.TimeUnixNano = b.now
+		p.StartTimeUnixNano = b.start
+	}
+	b.metrics = append(b.metrics, &metricspb.Metric{
+		Name:        name,
+		Unit:        unit,
+		Description: description,
+		Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			DataPoints:             points,
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+		}},
+	})
+}
+
+// convertMetrics maps the CPU, memory, disk, network and process metrics.
+// Event-style data (connections, FIM, auth, persistence) is left to the
+// threat indicators.
+func (o *OTLPExporter) convertMetrics(data types.MetricPayload) []*metricspb.Metric {
+	now := time.Now()
+	if ts, err := time.Parse(time.RFC3339, data.Timestamp); err == nil {
+		now = ts
+	}
+	b := &metricBuilder{now: uint64(now.UnixNano()), start: uint64(o.startTime.UnixNano())}
+	m := data.Metrics
+
+	if v, ok := asFloat(m["cpu_usage"]); ok {
+		b.gauge("system.cpu.utilization", "1", "CPU time in use across all cores", doublePoint(v/100))
+	}
+
+	if v, ok := asFloat(m["memory_usage"]); ok {
+		b.gauge("system.memory.usage", "By", "Memory in use", intPoint(v, stringAttr("system.memory.state", "used")))
+	}
+	if v, ok := asFloat(m["total_memory"]); ok {
+		b.gauge("system.memory.limit", "By", "Total memory", intPoint(v))
+	}
+	if v, ok := asFloat(m["memory_usage_percent"]); ok {
+		b.gauge("system.memory.utilization", "1", "Fraction of memory in use", doublePoint(v/100, stringAttr("system.memory.state", "used")))
+	}
+
+	if disk, ok := m["disk"].(map[string]interface{}); ok {
+		convertDisk(b, disk)
+	}
+	if network, ok := m["network"].(map[string]interface{}); ok {
+		convertNetwork(b, network)
+	}
+
+	if data.Processes.TotalCount > 0 {
+		b.gauge("system.process.count", "{process}", "Number of processes", intPoint(float64(data.Processes.TotalCount)))
+	}
+	return b.metrics
+}
+
+func convertDisk(b *metricBuilder, disk map[string]interface{}) {
+	var usage, utilization, inodes []*metricspb.NumberDataPoint
+	mounts, _ := disk["mounts"].(map[string]interface{})
+	for _, mountpoint := range sortedKeys(mounts) {
+		mount, ok := mounts[mountpoint].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		attrs := []*commonpb.KeyValue{
+			stringAttr("system.device", fmt.Sprint(mount["device"])),
+			stringAttr("system.filesystem.mountpoint", mountpoint),
+			stringAttr("system.filesystem.type", fmt.Sprint(mount["fstype"])),
+		}
+		usage = append(usage, statePoints(mount, attrs, "used", "free")...)
+		if v, ok := asFloat(mount["usage_percent"]); ok {
+			utilization = append(utilization, doublePoint(v/100, attrs...))
+		}
+		inodes = append(inodes, statePoints(mount, attrs, "inodes_used", "inodes_free")...)
+	}
+	if len(mounts) == 0 {
+		// Hosts whose mounts were all filtered out still report the totals
+		usage = statePoints(disk, nil, "used", "free")
+		if v, ok := asFloat(disk["usage_percent"]); ok {
+			utilization = append(utilization, doublePoint(v/100))
+		}
+	}
+	b.gauge("system.filesystem.usage", "By", "Filesystem space by state", usage...)
+	b.gauge("system.filesystem.utilization", "1", "Fraction of filesystem space in use", utilization...)
+	b.gauge("system.filesystem.inodes.usage", "{inode}", "Filesystem inodes by state", inodes...)
+
+	var io, operations, ioTime []*metricspb.NumberDataPoint
+	devices, _ := disk["devices"].(map[string]interface{})
+	for _, name := range sortedKeys(devices) {
+		device, ok := devices[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		deviceAttr := stringAttr("system.device", name)
+		io = append(io, directionPoints(device, "disk.io.direction", deviceAttr, [2]string{"read_bytes", "read"}, [2]string{"write_bytes", "write"})...)
+		operations = append(operations, directionPoints(device, "disk.io.direction", deviceAttr, [2]string{"read_count", "read"}, [2]string{"write_count", "write"})...)
+		if v, ok := asFloat(device["io_time_ms"]); ok {
+			ioTime = append(ioTime, doublePoint(v/1000, deviceAttr))
+		}
+	}
+	b.counter("system.disk.io", "By", "Bytes read from and written to disk", io...)
+	b.counter("system.disk.operations", "{operation}", "Disk read and write operations", operations...)
+	b.counter("system.disk.io_time", "s", "Time the disk spent servicing requests", ioTime...)
+}
+
+func convertNetwork(b *metricBuilder, network map[string]interface{}) {
+	counters := []struct {
+		name, unit, description string
+		transmit, receive       string
+	}{
+		{"system.network.io", "By", "Bytes sent and received", "bytes_sent", "bytes_received"},
+		{"system.network.packets", "{packet}", "Packets sent and received", "packets_sent", "packets_received"},
+		{"system.network.errors", "{error}", "Send and receive errors", "errors_out", "errors_in"},
+		{"system.network.dropped", "{packet}", "Packets dropped on send and receive", "drops_out", "drops_in"},
+	}
+
+	interfaces, _ := network["interfaces"].(map[string]interface{})
+	for _, counter := range counters {
+		var points []*metricspb.NumberDataPoint
+		for _, name := range sortedKeys(interfaces) {
+			iface, ok := interfaces[name].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			points = append(points, directionPoints(iface, "network.io.direction", stringAttr("network.interface.name", name),
+				[2]string{counter.transmit, "transmit"}, [2]string{counter.receive, "receive"})...)
+		}
+		if len(interfaces) == 0 {
+			points = directionPoints(network, "network.io.direction", nil,
+				[2]string{counter.transmit, "transmit"}, [2]string{counter.receive, "receive"})
+		}
+		b.counter(counter.name, counter.unit, counter.description, points...)
+	}
+}
+
+// statePoints reports two fields of a map as one metric split by state
+func statePoints(values map[string]interface{}, attrs []*commonpb.KeyValue, usedKey, freeKey string) []*metricspb.NumberDataPoint {
+	var points []*metricspb.NumberDataPoint
+	for _, state := range []struct{ key, name string }{{usedKey, "used"}, {freeKey, "free"}} {
+		if v, ok := asFloat(values[state.key]); ok {
+			pointAttrs := append(append([]*commonpb.KeyValue{}, attrs...), stringAttr("system.filesystem.state", state.name))
+			points = append(points, intPoint(v, pointAttrs...))
+		}
+	}
+	return points
+}
+
+// directionPoints reports counters of a map as one metric split by
+// direction. Each field pairs a map key with its direction; device, when
+// set, labels the device the counters belong to.
+func directionPoints(values map[string]interface{}, directionKey string, device *commonpb.KeyValue, fields ...[2]string) []*metricspb.NumberDataPoint {
+	var points []*metricspb.NumberDataPoint
+	for _, field := range fields {
+		v, ok := asFloat(values[field[0]])
+		if !ok {
+			continue
+		}
+		var attrs []*commonpb.KeyValue
+		if device != nil {
+			attrs = append(attrs, device)
+		}
+		attrs = append(attrs, stringAttr(directionKey, field[1]))
+		points = append(points, intPoint(v, attrs...))
+	}
+	return points
+}
+
+// convertIndicators turns threat indicators into log records
+func convertIndicators(indicators []types.ThreatIndicator) []*logspb.LogRecord {
+	observed := uint64(time.Now().UnixNano())
+	records := make([]*logspb.LogRecord, 0, len(indicators))
+	for _, indicator := range indicators {
+		severityNumber, severityText := otlpSeverity(indicator.Severity)
+
+		attrs := []*commonpb.KeyValue{
+			stringAttr("threat.type", indicator.Type),
+			doubleAttr("threat.score", indicator.Score),
+		}
+		if len(indicator.Tags) > 0 {
+			tags := make([]*commonpb.AnyValue, len(indicator.Tags))
+			for i, tag := range indicator.Tags {
+				tags[i] = &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: tag}}
+			}
+			attrs = append(attrs, &commonpb.KeyValue{
+				Key:   "threat.tags",
+				Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: tags}}},
+			})
+		}
+		for _, key := range sortedKeys(indicator.Details) {
+			attrs = append(attrs, &commonpb.KeyValue{Key: "threat.details." + key, Value: anyValue(indicator.Details[key])})
+		}
+
+		record := &logspb.LogRecord{
+			ObservedTimeUnixNano: observed,
+			SeverityNumber:       severityNumber,
+			SeverityText:         severityText,
+			Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: indicator.Description}},
+			Attributes:           attrs,
+		}
+		if !indicator.Timestamp.IsZero() {
+			record.TimeUnixNano = uint64(indicator.Timestamp.UnixNano())
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// otlpSeverity maps indicator severities onto the OTLP severity scale
+func otlpSeverity(severity string) (logspb.SeverityNumber, string) {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_FATAL, "FATAL"
+	case "high":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_ERROR, "ERROR"
+	case "medium":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN, "WARN"
+	default:
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO, "INFO"
+	}
+}
+
+// anyValue converts an indicator detail, falling back to its printed form
+// for anything that isn't a scalar
+func anyValue(v interface{}) *commonpb.AnyValue {
+	switch value := v.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: value}}
+	case int, int32, int64, uint, uint32, uint64:
+		n, _ := asFloat(value)
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(n)}}
+	case float32, float64:
+		n, _ := asFloat(value)
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: n}}
+	default:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: fmt.Sprint(value)}}
+	}
+}
+
+func doublePoint(value float64, attrs ...*commonpb.KeyValue) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		Value:      &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+		Attributes: attrs,
+	}
+}
+
+func intPoint(value float64, attrs ...*commonpb.KeyValue) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		Value:      &metricspb.NumberDataPoint_AsInt{AsInt: int64(value)},
+		Attributes: attrs,
+	}
+}
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func intAttr(key string, value int64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value}}}
+}
+
+func doubleAttr(key string, value float64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value}}}
+}
+
+// asFloat reads a numeric metric value, whether it was collected in this
+// process or decoded from JSON
+func asFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
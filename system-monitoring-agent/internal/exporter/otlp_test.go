@@ -0,0 +1,298 @@
+package exporter
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// stubCollector records what an OTLP/HTTP receiver would be sent
+type stubCollector struct {
+	*httptest.Server
+	mu      sync.Mutex
+	status  int
+	headers http.Header
+	metrics []*metricspb.MetricsData
+	logs    []*logspb.LogsData
+}
+
+func newStubCollector(t *testing.T) *stubCollector {
+	c := &stubCollector{status: http.StatusOK}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.headers = r.Header.Clone()
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			body, err = gunzipBytes(body)
+			require.NoError(t, err)
+		}
+
+		switch r.URL.Path {
+		case "/v1/metrics":
+			data := &metricspb.MetricsData{}
+			require.NoError(t, proto.Unmarshal(body, data))
+			c.metrics = append(c.metrics, data)
+		case "/v1/logs":
+			data := &logspb.LogsData{}
+			require.NoError(t, proto.Unmarshal(body, data))
+			c.logs = append(c.logs, data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(c.status)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func otlpTestPayload() types.MetricPayload {
+	payload := types.MetricPayload{
+		Timestamp: "2025-03-10T12:00:00Z",
+		TenantID:  "tenant-1",
+		TenantMetadata: map[string]string{
+			"agent_version":  "1.0.0",
+			"environment":    "production",
+			"tenant_type":    "enterprise",
+			"config_version": "abc123",
+		},
+		Metrics: map[string]interface{}{
+			"cpu_usage":            42.0,
+			"memory_usage":         uint64(2048),
+			"total_memory":         uint64(8192),
+			"memory_usage_percent": 25.0,
+			"disk": map[string]interface{}{
+				"mounts": map[string]interface{}{
+					"/": map[string]interface{}{
+						"device":        "/dev/sda1",
+						"fstype":        "ext4",
+						"used":          uint64(600),
+						"free":          uint64(400),
+						"usage_percent": 60.0,
+					},
+				},
+				"devices": map[string]interface{}{
+					"sda": map[string]interface{}{"read_bytes": uint64(10), "write_bytes": uint64(20)},
+				},
+			},
+			"network": map[string]interface{}{
+				"interfaces": map[string]interface{}{
+					"eth0": map[string]interface{}{"bytes_sent": uint64(100), "bytes_received": uint64(200)},
+				},
+			},
+		},
+		ThreatIndicators: []types.ThreatIndicator{{
+			Type:        "ssh_brute_force",
+			Description: "20 failed SSH logins from 10.0.0.5",
+			Severity:    "high",
+			Score:       80,
+			Timestamp:   time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC),
+			Tags:        []string{"auth"},
+			Details:     map[string]interface{}{"source_ip": "10.0.0.5", "failures": 20},
+		}},
+	}
+	payload.Host.Hostname = "web-1"
+	payload.Host.OS = "linux"
+	payload.Host.Arch = "amd64"
+	payload.Processes.TotalCount = 150
+	return payload
+}
+
+func attrMap(attrs []*commonpb.KeyValue) map[string]interface{} {
+	values := make(map[string]interface{}, len(attrs))
+	for _, kv := range attrs {
+		switch v := kv.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			values[kv.Key] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			values[kv.Key] = v.IntValue
+		case *commonpb.AnyValue_DoubleValue:
+			values[kv.Key] = v.DoubleValue
+		default:
+			values[kv.Key] = kv.Value
+		}
+	}
+	return values
+}
+
+func TestOTLPExporterExport(t *testing.T) {
+	collector := newStubCollector(t)
+	cfg := &config.Config{}
+	cfg.OTLP.Endpoint = collector.URL + "/"
+	cfg.OTLP.Compression = "gzip"
+	cfg.OTLP.Headers = map[string]string{"Authorization": "Bearer token"}
+
+	exporter, err := NewOTLPExporter(cfg)
+	require.NoError(t, err)
+	require.NoError(t, exporter.Export(otlpTestPayload()))
+	require.NoError(t, exporter.Close())
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.Equal(t, "application/x-protobuf", collector.headers.Get("Content-Type"))
+	assert.Equal(t, "Bearer token", collector.headers.Get("Authorization"))
+
+	require.Len(t, collector.metrics, 1)
+	resourceMetrics := collector.metrics[0].ResourceMetrics[0]
+	resource := attrMap(resourceMetrics.Resource.Attributes)
+	assert.Equal(t, "web-1", resource["host.name"])
+	assert.Equal(t, "tenant-1", resource["tenant.id"])
+	assert.Equal(t, "production", resource["tenant.environment"])
+	assert.Equal(t, "enterprise", resource["tenant.type"])
+	assert.Equal(t, "1.0.0", resource["service.version"])
+	assert.NotContains(t, resource, "tenant.agent_version")
+	assert.NotContains(t, resource, "tenant.config_version")
+
+	metrics := make(map[string]*metricspb.Metric)
+	for _, metric := range resourceMetrics.ScopeMetrics[0].Metrics {
+		metrics[metric.Name] = metric
+	}
+
+	cpu := metrics["system.cpu.utilization"].GetGauge()
+	require.NotNil(t, cpu)
+	assert.InDelta(t, 0.42, cpu.DataPoints[0].GetAsDouble(), 1e-9)
+	assert.Equal(t, uint64(time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC).UnixNano()), cpu.DataPoints[0].TimeUnixNano)
+
+	assert.Equal(t, int64(2048), metrics["system.memory.usage"].GetGauge().DataPoints[0].GetAsInt())
+	assert.Equal(t, int64(150), metrics["system.process.count"].GetGauge().DataPoints[0].GetAsInt())
+
+	filesystem := metrics["system.filesystem.usage"].GetGauge()
+	require.Len(t, filesystem.DataPoints, 2)
+	used := attrMap(filesystem.DataPoints[0].Attributes)
+	assert.Equal(t, "/", used["system.filesystem.mountpoint"])
+	assert.Equal(t, "used", used["system.filesystem.state"])
+	assert.Equal(t, int64(600), filesystem.DataPoints[0].GetAsInt())
+
+	network := metrics["system.network.io"].GetSum()
+	require.NotNil(t, network)
+	assert.True(t, network.IsMonotonic)
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, network.AggregationTemporality)
+	require.Len(t, network.DataPoints, 2)
+	assert.Equal(t, map[string]interface{}{"network.interface.name": "eth0", "network.io.direction": "transmit"}, attrMap(network.DataPoints[0].Attributes))
+	assert.Equal(t, int64(100), network.DataPoints[0].GetAsInt())
+	assert.NotZero(t, network.DataPoints[0].StartTimeUnixNano)
+
+	require.NotNil(t, metrics["system.disk.io"].GetSum())
+
+	require.Len(t, collector.logs, 1)
+	records := collector.logs[0].ResourceLogs[0].ScopeLogs[0].LogRecords
+	require.Len(t, records, 1)
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_ERROR, records[0].SeverityNumber)
+	assert.Equal(t, "20 failed SSH logins from 10.0.0.5", records[0].Body.GetStringValue())
+	attrs := attrMap(records[0].Attributes)
+	assert.Equal(t, "ssh_brute_force", attrs["threat.type"])
+	assert.Equal(t, 80.0, attrs["threat.score"])
+	assert.Equal(t, "10.0.0.5", attrs["threat.details.source_ip"])
+	assert.Equal(t, int64(20), attrs["threat.details.failures"])
+}
+
+func TestOTLPExporterSkipsLogsWithoutIndicators(t *testing.T) {
+	collector := newStubCollector(t)
+	cfg := &config.Config{}
+	cfg.OTLP.Endpoint = collector.URL
+
+	exporter, err := NewOTLPExporter(cfg)
+	require.NoError(t, err)
+	payload := otlpTestPayload()
+	payload.ThreatIndicators = nil
+	require.NoError(t, exporter.Export(payload))
+	require.NoError(t, exporter.Close())
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.Len(t, collector.metrics, 1)
+	assert.Empty(t, collector.logs)
+	assert.Empty(t, collector.headers.Get("Content-Encoding"))
+}
+
+func TestOTLPExporterCollectorError(t *testing.T) {
+	collector := newStubCollector(t)
+	collector.status = http.StatusServiceUnavailable
+	cfg := &config.Config{}
+	cfg.OTLP.Endpoint = collector.URL
+	cfg.HTTP.RetryAttempts = 2
+
+	exporter, err := NewOTLPExporter(cfg)
+	require.NoError(t, err)
+	payload := otlpTestPayload()
+	payload.ThreatIndicators = nil
+	require.NoError(t, exporter.Export(payload))
+	require.NoError(t, exporter.Close())
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.Len(t, collector.metrics, 2, "the failed request should be retried")
+	assert.Equal(t, int64(1), exporter.Dropped())
+	assert.ErrorIs(t, exporter.Export(payload), ErrExporterClosed)
+}
+
+func TestOTLPExporterDropsRejectedRequests(t *testing.T) {
+	collector := newStubCollector(t)
+	collector.status = http.StatusBadRequest
+	cfg := &config.Config{}
+	cfg.OTLP.Endpoint = collector.URL
+	cfg.HTTP.RetryAttempts = 3
+
+	exporter, err := NewOTLPExporter(cfg)
+	require.NoError(t, err)
+	payload := otlpTestPayload()
+	payload.ThreatIndicators = nil
+	require.NoError(t, exporter.Export(payload))
+	require.NoError(t, exporter.Close())
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.Len(t, collector.metrics, 1, "a rejected request should not be retried")
+	assert.Equal(t, int64(1), exporter.Dropped())
+}
+
+func TestOTLPExporterDoesNotWaitForCollector(t *testing.T) {
+	release := make(chan struct{})
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer collector.Close()
+	defer close(release)
+	cfg := &config.Config{}
+	cfg.OTLP.Endpoint = collector.URL
+
+	exporter, err := NewOTLPExporter(cfg)
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, exporter.Export(otlpTestPayload()))
+	assert.Less(t, time.Since(start), time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, exporter.Shutdown(ctx))
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, int64(2), exporter.Dropped())
+}
+
+func TestNewOTLPExporterValidatesConfig(t *testing.T) {
+	_, err := NewOTLPExporter(&config.Config{})
+	assert.Error(t, err)
+
+	cfg := &config.Config{}
+	cfg.OTLP.Endpoint = "http://localhost:4318"
+	cfg.OTLP.Compression = "zstd"
+	_, err = NewOTLPExporter(cfg)
+	assert.Error(t, err)
+}
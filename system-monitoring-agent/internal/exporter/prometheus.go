@@ -0,0 +1,318 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+const promNamespace = "monitoring_agent"
+
+// PrometheusExporter serves the latest collection in the Prometheus text
+// exposition format for hosts that are scraped rather than pushing. Every
+// series carries host, tenant_id and environment labels; threat indicators
+// are counted by type and severity since the agent started.
+type PrometheusExporter struct {
+	path     string
+	listener net.Listener
+	server   *http.Server
+
+	mu      sync.RWMutex
+	latest  *types.MetricPayload
+	threats map[threatKey]int64
+}
+
+type threatKey struct {
+	Type     string
+	Severity string
+}
+
+func NewPrometheusExporter(cfg *config.Config) (*PrometheusExporter, error) {
+	if cfg.Prometheus.ListenAddress == "" {
+		return nil, errors.New("a listen address is required for the Prometheus exporter")
+	}
+	path := cfg.Prometheus.Path
+	if path == "" {
+		path = "/metrics"
+	}
+
+	listener, err := net.Listen("tcp", cfg.Prometheus.ListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", cfg.Prometheus.ListenAddress, err)
+	}
+
+	p := &PrometheusExporter{
+		path:     path,
+		listener: listener,
+		threats:  make(map[threatKey]int64),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, p.handleMetrics)
+	p.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := p.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[ERROR] Prometheus endpoint stopped: %v", err)
+		}
+	}()
+	log.Printf("[INFO] Serving Prometheus metrics on %s%s", listener.Addr(), path)
+	return p, nil
+}
+
+// Addr returns the address the scrape endpoint is listening on
+func (p *PrometheusExporter) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Export replaces the collection served to scrapers and counts its threat
+// indicators
+func (p *PrometheusExporter) Export(data types.MetricPayload) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.latest = &data
+	for _, indicator := range data.ThreatIndicators {
+		p.threats[threatKey{Type: indicator.Type, Severity: indicator.Severity}]++
+	}
+	return nil
+}
+
+func (p *PrometheusExporter) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return p.server.Shutdown(ctx)
+}
+
+func (p *PrometheusExporter) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p.mu.RLock()
+	body := p.render()
+	p.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(body)
+}
+
+// render writes the exposition. Callers hold the read lock.
+func (p *PrometheusExporter) render() []byte {
+	if p.latest == nil {
+		return nil
+	}
+	e := &promExposition{families: make(map[string]*promFamily)}
+	data := p.latest
+	common := []promLabel{
+		{"host", data.Host.Hostname},
+		{"tenant_id", data.TenantID},
+		{"environment", data.TenantMetadata["environment"]},
+	}
+
+	if ts, err := time.Parse(time.RFC3339, data.Timestamp); err == nil {
+		e.add("last_collection_timestamp_seconds", "gauge", "Time of the latest collection", float64(ts.Unix()), common)
+	}
+
+	m := data.Metrics
+	if v, ok := asFloat(m["cpu_usage"]); ok {
+		e.add("cpu_usage_percent", "gauge", "CPU time in use across all cores", v, common)
+	}
+	if v, ok := asFloat(m["memory_usage"]); ok {
+		e.add("memory_used_bytes", "gauge", "Memory in use", v, common)
+	}
+	if v, ok := asFloat(m["total_memory"]); ok {
+		e.add("memory_total_bytes", "gauge", "Total memory", v, common)
+	}
+	if v, ok := asFloat(m["memory_usage_percent"]); ok {
+		e.add("memory_usage_percent", "gauge", "Memory in use as a percentage of total", v, common)
+	}
+	if disk, ok := m["disk"].(map[string]interface{}); ok {
+		e.addDisk(disk, common)
+	}
+	if network, ok := m["network"].(map[string]interface{}); ok {
+		e.addNetwork(network, common)
+	}
+	if data.Processes.TotalCount > 0 {
+		e.add("processes", "gauge", "Number of processes", float64(data.Processes.TotalCount), common)
+	}
+	if queue := data.Metadata.Queue; queue != nil {
+		e.add("offline_queue_depth", "gauge", "Payloads waiting in the offline queue", float64(queue.Depth), common)
+		e.add("offline_queue_bytes", "gauge", "Size of the offline queue", float64(queue.Bytes), common)
+	}
+
+	keys := make([]threatKey, 0, len(p.threats))
+	for key := range p.threats {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Type != keys[j].Type {
+			return keys[i].Type < keys[j].Type
+		}
+		return keys[i].Severity < keys[j].Severity
+	})
+	for _, key := range keys {
+		e.add("threat_indicators_total", "counter", "Threat indicators raised since the agent started",
+			float64(p.threats[key]), common, promLabel{"type", key.Type}, promLabel{"severity", key.Severity})
+	}
+
+	return e.bytes()
+}
+
+func (e *promExposition) addDisk(disk map[string]interface{}, common []promLabel) {
+	gauges := []struct{ key, name, help string }{
+		{"used", "disk_used_bytes", "Filesystem space in use"},
+		{"free", "disk_free_bytes", "Filesystem space available"},
+		{"total", "disk_total_bytes", "Filesystem size"},
+		{"usage_percent", "disk_usage_percent", "Filesystem space in use as a percentage of size"},
+		{"inodes_used", "disk_inodes_used", "Filesystem inodes in use"},
+		{"inodes_free", "disk_inodes_free", "Filesystem inodes available"},
+	}
+	mounts, _ := disk["mounts"].(map[string]interface{})
+	for _, mountpoint := range sortedKeys(mounts) {
+		mount, ok := mounts[mountpoint].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		labels := []promLabel{
+			{"mountpoint", mountpoint},
+			{"device", fmt.Sprint(mount["device"])},
+			{"fstype", fmt.Sprint(mount["fstype"])},
+		}
+		for _, g := range gauges {
+			if v, ok := asFloat(mount[g.key]); ok {
+				e.add(g.name, "gauge", g.help, v, common, labels...)
+			}
+		}
+	}
+	if len(mounts) == 0 {
+		// Hosts whose mounts were all filtered out still report the totals
+		for _, g := range gauges {
+			if v, ok := asFloat(disk[g.key]); ok {
+				e.add(g.name, "gauge", g.help, v, common, promLabel{"mountpoint", "/"})
+			}
+		}
+	}
+
+	counters := []struct{ key, name, help string }{
+		{"read_bytes", "disk_read_bytes_total", "Bytes read from the device"},
+		{"write_bytes", "disk_written_bytes_total", "Bytes written to the device"},
+		{"read_count", "disk_reads_total", "Reads completed by the device"},
+		{"write_count", "disk_writes_total", "Writes completed by the device"},
+	}
+	devices, _ := disk["devices"].(map[string]interface{})
+	for _, name := range sortedKeys(devices) {
+		device, ok := devices[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, c := range counters {
+			if v, ok := asFloat(device[c.key]); ok {
+				e.add(c.name, "counter", c.help, v, common, promLabel{"device", name})
+			}
+		}
+	}
+}
+
+func (e *promExposition) addNetwork(network map[string]interface{}, common []promLabel) {
+	counters := []struct{ key, name, help string }{
+		{"bytes_received", "network_receive_bytes_total", "Bytes received"},
+		{"bytes_sent", "network_transmit_bytes_total", "Bytes sent"},
+		{"packets_received", "network_receive_packets_total", "Packets received"},
+		{"packets_sent", "network_transmit_packets_total", "Packets sent"},
+		{"errors_in", "network_receive_errors_total", "Receive errors"},
+		{"errors_out", "network_transmit_errors_total", "Transmit errors"},
+		{"drops_in", "network_receive_drops_total", "Received packets dropped"},
+		{"drops_out", "network_transmit_drops_total", "Transmitted packets dropped"},
+	}
+	interfaces, _ := network["interfaces"].(map[string]interface{})
+	for _, name := range sortedKeys(interfaces) {
+		iface, ok := interfaces[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, c := range counters {
+			if v, ok := asFloat(iface[c.key]); ok {
+				e.add(c.name, "counter", c.help, v, common, promLabel{"interface", name})
+			}
+		}
+	}
+}
+
+type promLabel struct {
+	name  string
+	value string
+}
+
+type promSample struct {
+	labels []promLabel
+	value  float64
+}
+
+type promFamily struct {
+	kind    string
+	help    string
+	samples []promSample
+}
+
+// promExposition groups samples into metric families so each family's HELP
+// and TYPE lines are written once
+type promExposition struct {
+	families map[string]*promFamily
+}
+
+func (e *promExposition) add(name, kind, help string, value float64, common []promLabel, labels ...promLabel) {
+	name = promNamespace + "_" + name
+	family, ok := e.families[name]
+	if !ok {
+		family = &promFamily{kind: kind, help: help}
+		e.families[name] = family
+	}
+	all := append(append([]promLabel{}, common...), labels...)
+	family.samples = append(family.samples, promSample{labels: all, value: value})
+}
+
+func (e *promExposition) bytes() []byte {
+	var buf bytes.Buffer
+	for _, name := range sortedKeys(e.families) {
+		family := e.families[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, family.help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, family.kind)
+		for _, sample := range family.samples {
+			buf.WriteString(name)
+			buf.WriteByte('{')
+			for i, label := range sample.labels {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				fmt.Fprintf(&buf, "%s=\"%s\"", label.name, promEscape(label.value))
+			}
+			buf.WriteString("} ")
+			buf.WriteString(strconv.FormatFloat(sample.value, 'g', -1, 64))
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promEscape(value string) string {
+	return promEscaper.Replace(value)
+}
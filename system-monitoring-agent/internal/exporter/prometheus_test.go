@@ -0,0 +1,114 @@
+package exporter
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+func newTestPrometheusExporter(t *testing.T) *PrometheusExporter {
+	cfg := &config.Config{}
+	cfg.Prometheus.ListenAddress = "127.0.0.1:0"
+	exporter, err := NewPrometheusExporter(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { exporter.Close() })
+	return exporter
+}
+
+func scrape(t *testing.T, exporter *PrometheusExporter) string {
+	resp, err := http.Get("http://" + exporter.Addr() + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestPrometheusExporterServesLatestCollection(t *testing.T) {
+	exporter := newTestPrometheusExporter(t)
+	assert.Empty(t, scrape(t, exporter))
+
+	payload := otlpTestPayload()
+	payload.Metadata.Queue = &types.QueueStats{Depth: 3, Bytes: 1024}
+	require.NoError(t, exporter.Export(payload))
+	body := scrape(t, exporter)
+
+	common := `host="web-1",tenant_id="tenant-1",environment="production"`
+	for _, line := range []string{
+		"# HELP monitoring_agent_cpu_usage_percent CPU time in use across all cores",
+		"# TYPE monitoring_agent_cpu_usage_percent gauge",
+		"monitoring_agent_cpu_usage_percent{" + common + "} 42",
+		"monitoring_agent_memory_used_bytes{" + common + "} 2048",
+		`monitoring_agent_disk_used_bytes{` + common + `,mountpoint="/",device="/dev/sda1",fstype="ext4"} 600`,
+		"# TYPE monitoring_agent_disk_read_bytes_total counter",
+		`monitoring_agent_disk_read_bytes_total{` + common + `,device="sda"} 10`,
+		`monitoring_agent_network_transmit_bytes_total{` + common + `,interface="eth0"} 100`,
+		"monitoring_agent_processes{" + common + "} 150",
+		"monitoring_agent_offline_queue_depth{" + common + "} 3",
+		"# TYPE monitoring_agent_threat_indicators_total counter",
+		`monitoring_agent_threat_indicators_total{` + common + `,type="ssh_brute_force",severity="high"} 1`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+
+	// Each family is described once
+	assert.Equal(t, 1, strings.Count(body, "# TYPE monitoring_agent_network_transmit_bytes_total"))
+}
+
+func TestPrometheusExporterFallsBackToDiskTotals(t *testing.T) {
+	exporter := newTestPrometheusExporter(t)
+
+	payload := otlpTestPayload()
+	payload.Metrics["disk"] = map[string]interface{}{
+		"total":         uint64(1000),
+		"used":          uint64(250),
+		"usage_percent": 25.0,
+		"mounts":        map[string]interface{}{},
+	}
+	require.NoError(t, exporter.Export(payload))
+	body := scrape(t, exporter)
+
+	common := `host="web-1",tenant_id="tenant-1",environment="production"`
+	assert.Contains(t, body, `monitoring_agent_disk_total_bytes{`+common+`,mountpoint="/"} 1000`+"\n")
+	assert.Contains(t, body, `monitoring_agent_disk_used_bytes{`+common+`,mountpoint="/"} 250`+"\n")
+	assert.Contains(t, body, `monitoring_agent_disk_usage_percent{`+common+`,mountpoint="/"} 25`+"\n")
+}
+
+func TestPrometheusExporterCountsThreatsAcrossCollections(t *testing.T) {
+	exporter := newTestPrometheusExporter(t)
+
+	payload := otlpTestPayload()
+	require.NoError(t, exporter.Export(payload))
+	require.NoError(t, exporter.Export(payload))
+	payload.ThreatIndicators = []types.ThreatIndicator{{Type: "high_cpu_usage", Severity: "low"}}
+	require.NoError(t, exporter.Export(payload))
+
+	body := scrape(t, exporter)
+	assert.Contains(t, body, `type="ssh_brute_force",severity="high"} 2`+"\n")
+	assert.Contains(t, body, `type="high_cpu_usage",severity="low"} 1`+"\n")
+}
+
+func TestPrometheusExporterEscapesLabels(t *testing.T) {
+	exporter := newTestPrometheusExporter(t)
+
+	payload := otlpTestPayload()
+	payload.Host.Hostname = `web "1"` + "\n" + `\prod`
+	require.NoError(t, exporter.Export(payload))
+	assert.Contains(t, scrape(t, exporter), `host="web \"1\"\n\\prod"`)
+}
+
+func TestPrometheusExporterRejectsWrites(t *testing.T) {
+	exporter := newTestPrometheusExporter(t)
+	resp, err := http.Post("http://"+exporter.Addr()+"/metrics", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,138 @@
+package exporter
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+// sendQueueSize bounds the deliveries waiting for a sendQueue's worker
+const sendQueueSize = 100
+
+// sendQueue runs deliveries on their own goroutine for exporters without an
+// offline queue, so a slow or unreachable receiver doesn't hold up
+// collection. Each delivery is tried up to attempts times with backoff; one
+// that still fails, that the receiver rejects permanently, or that doesn't fit
+// in the queue, is dropped and counted.
+type sendQueue struct {
+	name      string
+	attempts  int
+	retryBase time.Duration
+	retryMax  time.Duration
+
+	mu        sync.RWMutex
+	closed    bool
+	pending   chan func(ctx context.Context) error
+	done      chan struct{}
+	stopped   chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	dropped   int64
+}
+
+// newSendQueue starts a worker retrying with the HTTP retry settings
+func newSendQueue(name string, cfg *config.Config) *sendQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &sendQueue{
+		name:      name,
+		attempts:  intOr(cfg.HTTP.RetryAttempts, 3),
+		retryBase: secondsOr(cfg.HTTP.RetryDelay, 1),
+		retryMax:  secondsOr(cfg.HTTP.MaxRetryDelay, 300),
+		pending:   make(chan func(ctx context.Context) error, sendQueueSize),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	go q.run()
+	return q
+}
+
+// add queues a delivery. A full queue drops it rather than blocking the
+// caller.
+func (q *sendQueue) add(deliver func(ctx context.Context) error) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrExporterClosed
+	}
+
+	select {
+	case q.pending <- deliver:
+	default:
+		atomic.AddInt64(&q.dropped, 1)
+		log.Printf("[WARN] %s export queue full, dropping payload", q.name)
+	}
+	return nil
+}
+
+func (q *sendQueue) run() {
+	defer close(q.stopped)
+	for {
+		select {
+		case deliver := <-q.pending:
+			q.deliver(deliver)
+		case <-q.done:
+			for {
+				select {
+				case deliver := <-q.pending:
+					q.deliver(deliver)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (q *sendQueue) deliver(deliver func(ctx context.Context) error) {
+	for attempt := 1; ; attempt++ {
+		err := deliver(q.ctx)
+		if err == nil {
+			return
+		}
+		if q.ctx.Err() != nil || attempt >= q.attempts || permanent(err) {
+			atomic.AddInt64(&q.dropped, 1)
+			log.Printf("[ERROR] Failed to export to %s, dropping payload: %v", q.name, err)
+			return
+		}
+
+		delay := backoff(attempt, q.retryBase, q.retryMax)
+		log.Printf("[WARN] Failed to export to %s, retrying in %s: %v", q.name, delay.Round(time.Millisecond), err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-q.ctx.Done():
+			timer.Stop()
+		}
+	}
+}
+
+// shutdown stops accepting deliveries and gives the queued ones until ctx is
+// done, after which the one in flight is abandoned and the rest dropped
+func (q *sendQueue) shutdown(ctx context.Context) {
+	q.closeOnce.Do(func() {
+		q.mu.Lock()
+		q.closed = true
+		q.mu.Unlock()
+
+		close(q.done)
+		select {
+		case <-q.stopped:
+		case <-ctx.Done():
+			q.cancel()
+			<-q.stopped
+		}
+		q.cancel()
+	})
+}
+
+// Dropped returns how many deliveries were discarded
+func (q *sendQueue) Dropped() int64 {
+	return atomic.LoadInt64(&q.dropped)
+}
@@ -0,0 +1,49 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+func TestSendQueueRetriesOnlyFailedDeliveries(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.HTTP.RetryAttempts = 2
+	queue := newSendQueue("test", cfg)
+
+	calls := make([]int, 3)
+	for i := range calls {
+		require.NoError(t, queue.add(func(ctx context.Context) error {
+			calls[i]++
+			if i == 1 {
+				return errors.New("refused")
+			}
+			return nil
+		}))
+	}
+	queue.shutdown(context.Background())
+
+	assert.Equal(t, []int{1, 2, 1}, calls)
+	assert.Equal(t, int64(1), queue.Dropped())
+	assert.ErrorIs(t, queue.add(func(context.Context) error { return nil }), ErrExporterClosed)
+}
+
+func TestSendQueueDropsPermanentFailures(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.HTTP.RetryAttempts = 3
+	queue := newSendQueue("test", cfg)
+
+	calls := 0
+	require.NoError(t, queue.add(func(ctx context.Context) error {
+		calls++
+		return &sendError{status: 400, permanent: true, err: errors.New("bad request")}
+	}))
+	queue.shutdown(context.Background())
+
+	assert.Equal(t, 1, calls, "a permanent failure should not be retried")
+	assert.Equal(t, int64(1), queue.Dropped())
+}
@@ -1,90 +1,212 @@
 package exporter
 
 import (
+	"bytes"
+	"compress/gzip"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"os"
+	"io"
+	"log"
+	"regexp"
+	"sync"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/agentdb"
+	"github.com/travism26/system-monitoring-agent/internal/config"
 )
 
+// maxSendAttempts is how many refused deliveries a queued batch survives.
+// Failures while the server is unavailable aren't counted.
+const maxSendAttempts = 5
+
+// validTableName guards the queue table name, which is interpolated into SQL
+var validTableName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+const (
+	encodingJSON = "json"
+	encodingGzip = "gzip"
+)
+
+// MetricStorage is the offline queue for payloads that could not be
+// delivered. Batches are addressed by the ID assigned when they are stored.
 type MetricStorage interface {
-	Store(batch MetricBatch) error
-	Remove(batch MetricBatch) error
-	LoadUnsent() ([]MetricBatch, error)
+	Store(batch MetricBatch) (int64, error)
+	Remove(id int64) error
+	// MarkFailed records a delivery the server refused, dropping the batch
+	// once it has used up its attempts
+	MarkFailed(id int64) error
+	// LoadUnsent returns up to limit queued batches, oldest first
+	LoadUnsent(limit int) ([]MetricBatch, error)
+	Stats() (types.QueueStats, error)
 	Close() error
 }
 
-type SQLiteStorage struct {
-	db *sql.DB
+// StorageOptions bound the offline queue. Zero values disable the matching
+// limit.
+type StorageOptions struct {
+	MaxBytes int64         // total size of queued payloads
+	MaxAge   time.Duration // age after which queued payloads are dropped
+	Compress bool          // gzip payloads at rest
+	// Table holds the queue, so exporters that replay their own failures
+	// don't drain each other's. Defaults to HTTPQueueTable.
+	Table string
 }
 
-func NewMetricStorage(storageDir string) (*SQLiteStorage, error) {
-	// Create storage directory if it doesn't exist
-	if err := os.MkdirAll(storageDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+// HTTPQueueTable holds the HTTP exporter's undelivered payloads, and is the
+// default queue
+const HTTPQueueTable = "metric_queue"
+
+// StorageOptionsFromConfig maps the Storage section of the configuration
+func StorageOptionsFromConfig(cfg config.StorageConfig) StorageOptions {
+	return StorageOptions{
+		MaxBytes: int64(cfg.MaxStoragePerTenant) * 1024 * 1024,
+		MaxAge:   time.Duration(cfg.RetentionPeriod) * 24 * time.Hour,
+		Compress: cfg.CompressOldData,
 	}
+}
 
-	dbPath := fmt.Sprintf("%s/metrics.db", storageDir)
-	db, err := sql.Open("sqlite3", dbPath)
+type SQLiteStorage struct {
+	db      *sql.DB
+	table   string
+	options StorageOptions
+	now     func() time.Time
+
+	mu      sync.Mutex
+	dropped types.QueueStats
+}
+
+func NewMetricStorage(storageDir string, options StorageOptions) (*SQLiteStorage, error) {
+	db, err := agentdb.Open(storageDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	table := options.Table
+	if table == "" {
+		table = HTTPQueueTable
+	}
+	if !validTableName.MatchString(table) {
+		db.Close()
+		return nil, fmt.Errorf("invalid queue table name %q", table)
 	}
 
-	// Create metrics table if it doesn't exist
 	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS metrics (
+		CREATE TABLE IF NOT EXISTS ` + table + ` (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			data TEXT NOT NULL,
-			timestamp DATETIME NOT NULL,
-			attempts INTEGER DEFAULT 0,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)
+			payload BLOB NOT NULL,
+			encoding TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at INTEGER NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_attempt_at INTEGER
+		);
+		CREATE INDEX IF NOT EXISTS idx_` + table + `_created_at ON ` + table + `(created_at);
 	`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create metrics table: %w", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to create metric queue table: %w", err)
 	}
 
-	return &SQLiteStorage{db: db}, nil
+	if table == HTTPQueueTable {
+		if err := migrateLegacyMetrics(db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &SQLiteStorage{
+		db:      db,
+		table:   table,
+		options: options,
+		now:     time.Now,
+	}, nil
 }
 
-func (s *SQLiteStorage) Store(batch MetricBatch) error {
-	data, err := json.Marshal(batch.Data)
+// migrateLegacyMetrics moves rows from the original metrics table, which
+// stored uncompressed JSON and was addressed by content, into the queue
+func migrateLegacyMetrics(db *sql.DB) error {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'metrics'").Scan(&count)
 	if err != nil {
-		return fmt.Errorf("failed to marshal metric data: %w", err)
+		return fmt.Errorf("failed to check for legacy metrics table: %w", err)
+	}
+	if count == 0 {
+		return nil
 	}
 
-	_, err = s.db.Exec(
-		"INSERT INTO metrics (data, timestamp, attempts) VALUES (?, ?, ?)",
-		string(data),
-		batch.Timestamp,
-		batch.Attempts,
-	)
+	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to store metric: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	return nil
+	_, err = tx.Exec(`
+		INSERT INTO metric_queue (payload, encoding, size, created_at, attempts)
+		SELECT CAST(data AS BLOB), 'json', LENGTH(CAST(data AS BLOB)),
+			COALESCE(CAST(strftime('%s', substr(timestamp, 1, 19)) AS INTEGER), CAST(strftime('%s', 'now') AS INTEGER)) * 1000000000,
+			attempts
+		FROM metrics
+		ORDER BY id;
+		DROP TABLE metrics;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to migrate legacy metrics table: %w", err)
+	}
+	return tx.Commit()
 }
 
-func (s *SQLiteStorage) Remove(batch MetricBatch) error {
+// Store queues a batch and returns its ID. The size and age budget is
+// enforced afterwards, so the oldest batches make room for the new one. A
+// batch larger than the whole size budget is refused instead, as storing it
+// would evict every other batch and then the new one too.
+func (s *SQLiteStorage) Store(batch MetricBatch) (int64, error) {
 	data, err := json.Marshal(batch.Data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal metric data: %w", err)
+		return 0, fmt.Errorf("failed to marshal metric data: %w", err)
+	}
+
+	encoding := encodingJSON
+	if s.options.Compress {
+		if data, err = gzipBytes(data); err != nil {
+			return 0, fmt.Errorf("failed to compress metric data: %w", err)
+		}
+		encoding = encodingGzip
+	}
+	if s.options.MaxBytes > 0 && int64(len(data)) > s.options.MaxBytes {
+		return 0, fmt.Errorf("metric payload of %d bytes exceeds the %d byte queue budget", len(data), s.options.MaxBytes)
+	}
+
+	createdAt := batch.Timestamp
+	if createdAt.IsZero() {
+		createdAt = s.now()
 	}
 
 	result, err := s.db.Exec(
-		"DELETE FROM metrics WHERE data = ? AND timestamp = ?",
-		string(data),
-		batch.Timestamp,
+		"INSERT INTO "+s.table+" (payload, encoding, size, created_at, attempts) VALUES (?, ?, ?, ?, ?)",
+		data,
+		encoding,
+		len(data),
+		createdAt.UnixNano(),
+		batch.Attempts,
 	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to store metric: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get metric id: %w", err)
+	}
+
+	if err := s.enforceBudget(); err != nil {
+		return id, err
+	}
+	return id, nil
+}
+
+func (s *SQLiteStorage) Remove(id int64) error {
+	result, err := s.db.Exec("DELETE FROM "+s.table+" WHERE id = ?", id)
 	if err != nil {
 		return fmt.Errorf("failed to remove metric: %w", err)
 	}
@@ -95,52 +217,245 @@ func (s *SQLiteStorage) Remove(batch MetricBatch) error {
 	}
 
 	if affected == 0 {
-		return fmt.Errorf("no matching metric found to remove")
+		return fmt.Errorf("no queued metric with id %d", id)
 	}
 
 	return nil
 }
 
-func (s *SQLiteStorage) LoadUnsent() ([]MetricBatch, error) {
+func (s *SQLiteStorage) MarkFailed(id int64) error {
+	_, err := s.db.Exec(
+		"UPDATE "+s.table+" SET attempts = attempts + 1, last_attempt_at = ? WHERE id = ?",
+		s.now().UnixNano(),
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record attempt: %w", err)
+	}
+
+	result, err := s.db.Exec("DELETE FROM "+s.table+" WHERE id = ? AND attempts >= ?", id, maxSendAttempts)
+	if err != nil {
+		return fmt.Errorf("failed to drop metric: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+		s.mu.Lock()
+		s.dropped.DroppedFailed += affected
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) LoadUnsent(limit int) ([]MetricBatch, error) {
+	// Expired batches are dropped here too, so an idle queue still ages out
+	if err := s.enforceBudget(); err != nil {
+		return nil, err
+	}
+	return s.LoadAfter(0, limit)
+}
+
+// LoadAfter returns up to limit queued batches with IDs above afterID,
+// oldest first, so the whole queue can be read a page at a time. Rows that
+// can no longer be decoded are logged and deleted rather than failing the
+// page, which would stall every reader on them.
+func (s *SQLiteStorage) LoadAfter(afterID int64, limit int) ([]MetricBatch, error) {
+	for {
+		batches, corrupt, lastID, err := s.loadPage(afterID, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(corrupt) > 0 {
+			s.dropCorrupt(corrupt)
+		}
+		// A page made up entirely of corrupt rows would look like the end of
+		// the queue, so read on past it
+		if len(batches) > 0 || len(corrupt) == 0 {
+			return batches, nil
+		}
+		afterID = lastID
+	}
+}
+
+// loadPage reads one page of the queue, returning the decoded batches, the
+// IDs of rows that failed to decode and the last ID read
+func (s *SQLiteStorage) loadPage(afterID int64, limit int) ([]MetricBatch, []int64, int64, error) {
 	rows, err := s.db.Query(`
-		SELECT data, timestamp, attempts 
-		FROM metrics 
-		WHERE attempts < 5 
-		ORDER BY timestamp ASC
-		LIMIT 100
-	`)
+		SELECT id, payload, encoding, created_at, attempts
+		FROM `+s.table+`
+		WHERE id > ?
+		ORDER BY id ASC
+		LIMIT ?
+	`, afterID, limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query metrics: %w", err)
+		return nil, nil, 0, fmt.Errorf("failed to query metrics: %w", err)
 	}
 	defer rows.Close()
 
-	var batches []MetricBatch
+	var (
+		batches []MetricBatch
+		corrupt []int64
+		lastID  = afterID
+	)
 	for rows.Next() {
 		var (
-			dataStr   string
-			timestamp time.Time
-			attempts  int
+			batch     MetricBatch
+			payload   []byte
+			encoding  string
+			createdAt int64
 		)
 
-		if err := rows.Scan(&dataStr, &timestamp, &attempts); err != nil {
-			return nil, fmt.Errorf("failed to scan metric row: %w", err)
+		if err := rows.Scan(&batch.ID, &payload, &encoding, &createdAt, &batch.Attempts); err != nil {
+			return nil, nil, 0, fmt.Errorf("failed to scan metric row: %w", err)
 		}
+		lastID = batch.ID
 
-		var data types.MetricPayload
-		if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal metric data: %w", err)
+		if encoding == encodingGzip {
+			if payload, err = gunzipBytes(payload); err != nil {
+				log.Printf("[ERROR] Dropping queued metric %d: failed to decompress: %v", batch.ID, err)
+				corrupt = append(corrupt, batch.ID)
+				continue
+			}
+		}
+		if err := json.Unmarshal(payload, &batch.Data); err != nil {
+			log.Printf("[ERROR] Dropping queued metric %d: failed to unmarshal: %v", batch.ID, err)
+			corrupt = append(corrupt, batch.ID)
+			continue
 		}
+		batch.Timestamp = time.Unix(0, createdAt)
 
-		batches = append(batches, MetricBatch{
-			Data:      data,
-			Timestamp: timestamp,
-			Attempts:  attempts,
-		})
+		batches = append(batches, batch)
 	}
 
-	return batches, nil
+	return batches, corrupt, lastID, rows.Err()
+}
+
+// dropCorrupt deletes undecodable rows, counting them as failed deliveries.
+// A row that cannot be deleted is left in place and skipped on every read.
+func (s *SQLiteStorage) dropCorrupt(ids []int64) {
+	for _, id := range ids {
+		result, err := s.db.Exec("DELETE FROM "+s.table+" WHERE id = ?", id)
+		if err != nil {
+			log.Printf("[WARN] Failed to delete corrupt queued metric %d: %v", id, err)
+			continue
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+			s.mu.Lock()
+			s.dropped.DroppedFailed += affected
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *SQLiteStorage) Stats() (types.QueueStats, error) {
+	s.mu.Lock()
+	stats := s.dropped
+	s.mu.Unlock()
+
+	err := s.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(size), 0) FROM "+s.table).Scan(&stats.Depth, &stats.Bytes)
+	if err != nil {
+		return stats, fmt.Errorf("failed to query queue size: %w", err)
+	}
+	return stats, nil
+}
+
+// Oldest returns when the oldest queued batch was stored, or the zero time
+// for an empty queue
+func (s *SQLiteStorage) Oldest() (time.Time, error) {
+	var createdAt sql.NullInt64
+	if err := s.db.QueryRow("SELECT MIN(created_at) FROM " + s.table).Scan(&createdAt); err != nil {
+		return time.Time{}, fmt.Errorf("failed to query queue age: %w", err)
+	}
+	if !createdAt.Valid {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, createdAt.Int64), nil
+}
+
+// Purge deletes every queued batch and returns how many there were
+func (s *SQLiteStorage) Purge() (int64, error) {
+	result, err := s.db.Exec("DELETE FROM " + s.table)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge queue: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// enforceBudget drops batches past the retention period, then the oldest
+// batches until the queue fits within its size budget
+func (s *SQLiteStorage) enforceBudget() error {
+	if s.options.MaxAge > 0 {
+		cutoff := s.now().Add(-s.options.MaxAge).UnixNano()
+		result, err := s.db.Exec("DELETE FROM "+s.table+" WHERE created_at < ?", cutoff)
+		if err != nil {
+			return fmt.Errorf("failed to drop expired metrics: %w", err)
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+			s.mu.Lock()
+			s.dropped.DroppedExpired += affected
+			s.mu.Unlock()
+		}
+	}
+
+	if s.options.MaxBytes <= 0 {
+		return nil
+	}
+
+	var total int64
+	if err := s.db.QueryRow("SELECT COALESCE(SUM(size), 0) FROM " + s.table).Scan(&total); err != nil {
+		return fmt.Errorf("failed to query queue size: %w", err)
+	}
+	if total <= s.options.MaxBytes {
+		return nil
+	}
+
+	// Find the newest ID that has to go for the rest to fit
+	rows, err := s.db.Query("SELECT id, size FROM " + s.table + " ORDER BY id ASC")
+	if err != nil {
+		return fmt.Errorf("failed to query queue: %w", err)
+	}
+	var lastID int64
+	for total > s.options.MaxBytes && rows.Next() {
+		var size int64
+		if err := rows.Scan(&lastID, &size); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan queue row: %w", err)
+		}
+		total -= size
+	}
+	rows.Close()
+
+	result, err := s.db.Exec("DELETE FROM "+s.table+" WHERE id <= ?", lastID)
+	if err != nil {
+		return fmt.Errorf("failed to evict metrics: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+		s.mu.Lock()
+		s.dropped.DroppedEvicted += affected
+		s.mu.Unlock()
+	}
+	return nil
 }
 
 func (s *SQLiteStorage) Close() error {
 	return s.db.Close()
 }
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipBytes(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -1,8 +1,16 @@
 package exporter
 
 import (
+	"database/sql"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/shared-monitoring-libs/types"
 )
 
 func TestSQLiteStorage(t *testing.T) {
@@ -14,38 +22,42 @@ func TestSQLiteStorage(t *testing.T) {
 	defer os.RemoveAll(tmpDir)
 
 	// Initialize storage
-	storage, err := NewMetricStorage(tmpDir)
+	storage, err := NewMetricStorage(tmpDir, StorageOptions{})
 	if err != nil {
 		t.Fatalf("Failed to create storage: %v", err)
 	}
 	defer storage.Close()
 
-	// Convert to MetricBatch before passing to storage
 	metricBatch := MetricBatch{
-		// convert testData fields to MetricBatch format
+		Data:      types.MetricPayload{TenantID: "tenant-1"},
+		Timestamp: time.Now(),
 	}
 
 	// Test Store
-	if err := storage.Store(metricBatch); err != nil {
+	id, err := storage.Store(metricBatch)
+	if err != nil {
 		t.Errorf("Failed to store metric: %v", err)
 	}
 
 	// Test LoadUnsent
-	batches, err := storage.LoadUnsent()
+	batches, err := storage.LoadUnsent(100)
 	if err != nil {
 		t.Errorf("Failed to load metrics: %v", err)
 	}
 	if len(batches) != 1 {
-		t.Errorf("Expected 1 batch, got %d", len(batches))
+		t.Fatalf("Expected 1 batch, got %d", len(batches))
+	}
+	if batches[0].ID != id || batches[0].Data.TenantID != "tenant-1" {
+		t.Errorf("Unexpected batch loaded: %+v", batches[0])
 	}
 
 	// Test Remove
-	if err := storage.Remove(metricBatch); err != nil {
+	if err := storage.Remove(id); err != nil {
 		t.Errorf("Failed to remove metric: %v", err)
 	}
 
 	// Verify removal
-	batches, err = storage.LoadUnsent()
+	batches, err = storage.LoadUnsent(100)
 	if err != nil {
 		t.Errorf("Failed to load metrics after removal: %v", err)
 	}
@@ -53,3 +65,279 @@ func TestSQLiteStorage(t *testing.T) {
 		t.Errorf("Expected 0 batches after removal, got %d", len(batches))
 	}
 }
+
+func TestSQLiteStorageIdenticalPayloads(t *testing.T) {
+	storage, err := NewMetricStorage(t.TempDir(), StorageOptions{})
+	require.NoError(t, err)
+	defer storage.Close()
+
+	// Identical payloads are separate rows; removing one leaves the other
+	batch := MetricBatch{Data: types.MetricPayload{TenantID: "tenant-1"}, Timestamp: time.Now()}
+	first, err := storage.Store(batch)
+	require.NoError(t, err)
+	second, err := storage.Store(batch)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	require.NoError(t, storage.Remove(first))
+	batches, err := storage.LoadUnsent(100)
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, second, batches[0].ID)
+	assert.Error(t, storage.Remove(first))
+}
+
+func TestSQLiteStorageAttempts(t *testing.T) {
+	storage, err := NewMetricStorage(t.TempDir(), StorageOptions{})
+	require.NoError(t, err)
+	defer storage.Close()
+
+	id, err := storage.Store(MetricBatch{Timestamp: time.Now()})
+	require.NoError(t, err)
+
+	for i := 1; i < maxSendAttempts; i++ {
+		require.NoError(t, storage.MarkFailed(id))
+		batches, err := storage.LoadUnsent(100)
+		require.NoError(t, err)
+		require.Len(t, batches, 1)
+		assert.Equal(t, i, batches[0].Attempts)
+	}
+
+	require.NoError(t, storage.MarkFailed(id))
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Depth)
+	assert.Equal(t, int64(1), stats.DroppedFailed)
+}
+
+func TestSQLiteStorageBudget(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	storage, err := NewMetricStorage(t.TempDir(), StorageOptions{MaxAge: 24 * time.Hour})
+	require.NoError(t, err)
+	defer storage.Close()
+	storage.now = func() time.Time { return now }
+
+	_, err = storage.Store(MetricBatch{Data: types.MetricPayload{TenantID: "old"}, Timestamp: now.Add(-25 * time.Hour)})
+	require.NoError(t, err)
+	_, err = storage.Store(MetricBatch{Data: types.MetricPayload{TenantID: "recent"}, Timestamp: now.Add(-time.Hour)})
+	require.NoError(t, err)
+
+	batches, err := storage.LoadUnsent(100)
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, "recent", batches[0].Data.TenantID)
+
+	// Size budget of roughly three payloads, evicting oldest first
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.DroppedExpired)
+	storage.options.MaxBytes = stats.Bytes*3 + stats.Bytes/2
+
+	for _, tenant := range []string{"one", "two", "three"} {
+		_, err := storage.Store(MetricBatch{Data: types.MetricPayload{TenantID: tenant}, Timestamp: now})
+		require.NoError(t, err)
+	}
+	batches, err = storage.LoadUnsent(100)
+	require.NoError(t, err)
+	var tenants []string
+	for _, batch := range batches {
+		tenants = append(tenants, batch.Data.TenantID)
+	}
+	assert.Equal(t, []string{"one", "two", "three"}, tenants)
+
+	stats, err = storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Depth)
+	assert.LessOrEqual(t, stats.Bytes, storage.options.MaxBytes)
+	assert.Equal(t, int64(1), stats.DroppedEvicted)
+}
+
+func TestSQLiteStorageRefusesOversizedBatch(t *testing.T) {
+	storage, err := NewMetricStorage(t.TempDir(), StorageOptions{})
+	require.NoError(t, err)
+	defer storage.Close()
+
+	now := time.Now()
+	_, err = storage.Store(MetricBatch{Data: types.MetricPayload{TenantID: "small"}, Timestamp: now})
+	require.NoError(t, err)
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	storage.options.MaxBytes = stats.Bytes * 2
+
+	payload := types.MetricPayload{TenantID: "large", Metrics: map[string]interface{}{}}
+	for i := 0; i < 100; i++ {
+		payload.Metrics[fmt.Sprintf("metric_%d", i)] = 42.0
+	}
+	_, err = storage.Store(MetricBatch{Data: payload, Timestamp: now})
+	assert.ErrorContains(t, err, "exceeds")
+
+	// The queued batch is left alone
+	batches, err := storage.LoadUnsent(100)
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, "small", batches[0].Data.TenantID)
+	stats, err = storage.Stats()
+	require.NoError(t, err)
+	assert.Zero(t, stats.DroppedEvicted)
+}
+
+func TestSQLiteStorageCompression(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewMetricStorage(dir, StorageOptions{Compress: true})
+	require.NoError(t, err)
+	defer storage.Close()
+
+	payload := types.MetricPayload{TenantID: "tenant-1", Metrics: map[string]interface{}{}}
+	for i := 0; i < 200; i++ {
+		payload.Metrics[fmt.Sprintf("metric_%d", i)] = 42.0
+	}
+	id, err := storage.Store(MetricBatch{Data: payload, Timestamp: time.Now()})
+	require.NoError(t, err)
+
+	var encoding string
+	var size int
+	require.NoError(t, storage.db.QueryRow("SELECT encoding, size FROM metric_queue WHERE id = ?", id).Scan(&encoding, &size))
+	assert.Equal(t, encodingGzip, encoding)
+
+	uncompressed, err := NewMetricStorage(t.TempDir(), StorageOptions{})
+	require.NoError(t, err)
+	defer uncompressed.Close()
+	_, err = uncompressed.Store(MetricBatch{Data: payload, Timestamp: time.Now()})
+	require.NoError(t, err)
+	stats, err := uncompressed.Stats()
+	require.NoError(t, err)
+	assert.Less(t, int64(size), stats.Bytes)
+
+	batches, err := storage.LoadUnsent(1)
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, payload.Metrics, batches[0].Data.Metrics)
+}
+
+func TestSQLiteStorageMigratesLegacyTable(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "metrics.db"))
+	require.NoError(t, err)
+	_, err = db.Exec(`
+		CREATE TABLE metrics (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			data TEXT NOT NULL,
+			timestamp DATETIME NOT NULL,
+			attempts INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO metrics (data, timestamp, attempts)
+		VALUES ('{"tenant_id":"legacy"}', '2025-03-10 11:00:00.123456+00:00', 2);
+	`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	storage, err := NewMetricStorage(dir, StorageOptions{})
+	require.NoError(t, err)
+	defer storage.Close()
+
+	batches, err := storage.LoadUnsent(100)
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, "legacy", batches[0].Data.TenantID)
+	assert.Equal(t, 2, batches[0].Attempts)
+	assert.Equal(t, time.Date(2025, 3, 10, 11, 0, 0, 0, time.UTC), batches[0].Timestamp.UTC())
+}
+
+func TestSQLiteStorageSeparateTables(t *testing.T) {
+	dir := t.TempDir()
+	httpQueue, err := NewMetricStorage(dir, StorageOptions{})
+	require.NoError(t, err)
+	defer httpQueue.Close()
+	kafkaQueue, err := NewMetricStorage(dir, StorageOptions{Table: KafkaQueueTable})
+	require.NoError(t, err)
+	defer kafkaQueue.Close()
+
+	_, err = kafkaQueue.Store(MetricBatch{Data: types.MetricPayload{TenantID: "tenant-1"}})
+	require.NoError(t, err)
+
+	stats, err := httpQueue.Stats()
+	require.NoError(t, err)
+	assert.Zero(t, stats.Depth)
+	stats, err = kafkaQueue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Depth)
+
+	_, err = NewMetricStorage(dir, StorageOptions{Table: "queue; DROP TABLE metric_queue"})
+	assert.Error(t, err)
+}
+
+func TestSQLiteStorageLoadAfterOldestAndPurge(t *testing.T) {
+	storage, err := NewMetricStorage(t.TempDir(), StorageOptions{})
+	require.NoError(t, err)
+	defer storage.Close()
+
+	oldest, err := storage.Oldest()
+	require.NoError(t, err)
+	assert.True(t, oldest.IsZero())
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		_, err := storage.Store(MetricBatch{
+			Data:      types.MetricPayload{TenantID: fmt.Sprintf("tenant-%d", i)},
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		})
+		require.NoError(t, err)
+	}
+
+	var tenants []string
+	var lastID int64
+	for {
+		page, err := storage.LoadAfter(lastID, 2)
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		for _, batch := range page {
+			tenants = append(tenants, batch.Data.TenantID)
+			lastID = batch.ID
+		}
+	}
+	assert.Equal(t, []string{"tenant-0", "tenant-1", "tenant-2", "tenant-3", "tenant-4"}, tenants)
+
+	oldest, err = storage.Oldest()
+	require.NoError(t, err)
+	assert.True(t, start.Equal(oldest))
+
+	purged, err := storage.Purge()
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, purged)
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Zero(t, stats.Depth)
+}
+
+func TestSQLiteStorageSkipsCorruptRows(t *testing.T) {
+	storage, err := NewMetricStorage(t.TempDir(), StorageOptions{Compress: true})
+	require.NoError(t, err)
+	defer storage.Close()
+
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		id, err := storage.Store(MetricBatch{Data: types.MetricPayload{TenantID: fmt.Sprintf("tenant-%d", i)}})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	// Corrupt a whole page's worth of rows, one undecompressable and one
+	// that decompresses to invalid JSON
+	_, err = storage.db.Exec("UPDATE metric_queue SET payload = ? WHERE id = ?", []byte("not gzip"), ids[0])
+	require.NoError(t, err)
+	_, err = storage.db.Exec("UPDATE metric_queue SET payload = ?, encoding = ? WHERE id = ?", []byte("{"), encodingJSON, ids[1])
+	require.NoError(t, err)
+
+	batches, err := storage.LoadUnsent(2)
+	require.NoError(t, err)
+	require.Len(t, batches, 2)
+	assert.Equal(t, "tenant-2", batches[0].Data.TenantID)
+	assert.Equal(t, "tenant-3", batches[1].Data.TenantID)
+
+	stats, err := storage.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Depth)
+	assert.Equal(t, int64(2), stats.DroppedFailed)
+}
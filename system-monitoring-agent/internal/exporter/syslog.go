@@ -0,0 +1,540 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+const (
+	syslogFormatStructured = "structured"
+	syslogFormatCEF        = "cef"
+
+	// defaultSyslogEnterpriseID qualifies the structured-data IDs when none
+	// is configured. It is a placeholder: 32473 is the example number RFC
+	// 5612 reserves for documentation, not one assigned to this agent.
+	defaultSyslogEnterpriseID = "32473"
+
+	syslogDialTimeout  = 10 * time.Second
+	syslogWriteTimeout = 10 * time.Second
+)
+
+// validEnterpriseID matches a private enterprise number, optionally with
+// sub-identifiers, as allowed in an SD-ID
+var validEnterpriseID = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3,
+	"warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// defaultSyslogSeverity maps indicator severities when SeverityMap doesn't
+var defaultSyslogSeverity = map[string]int{
+	"critical": 2, // crit
+	"high":     3, // err
+	"medium":   4, // warning
+	"low":      5, // notice
+}
+
+// cefSeverity maps indicator severities onto CEF's 0-10 scale
+var cefSeverity = map[string]int{
+	"critical": 10,
+	"high":     8,
+	"medium":   5,
+	"low":      3,
+}
+
+// SyslogExporter sends threat indicators, and optionally a metric summary,
+// to a syslog receiver as RFC 5424 messages. Stream transports use octet
+// counting framing (RFC 6587, RFC 5425). Messages are sent from a
+// sendQueue, each retried on its own so a failure doesn't resend the ones
+// before it.
+type SyslogExporter struct {
+	address   string
+	protocol  string
+	format    string
+	facility  int
+	appName   string
+	sdSuffix  string // "@" and the enterprise number
+	severity  map[string]int
+	summary   bool
+	tlsConfig *tls.Config
+	procID    string
+
+	queue *sendQueue
+	// mu guards the settings above, which Reload replaces, and reconnect
+	mu        sync.Mutex
+	reconnect bool
+	// conn is only used by the queue's worker, and by Close once it has
+	// stopped
+	conn net.Conn
+}
+
+func NewSyslogExporter(cfg *config.Config) (*SyslogExporter, error) {
+	s, err := syslogFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.queue = newSendQueue("syslog receiver", cfg)
+	return s, nil
+}
+
+// syslogFromConfig builds an exporter without starting its queue
+func syslogFromConfig(cfg *config.Config) (*SyslogExporter, error) {
+	sc := cfg.Syslog
+	if sc.Address == "" {
+		return nil, errors.New("a syslog address is required")
+	}
+
+	protocol := strings.ToLower(sc.Protocol)
+	if protocol == "" {
+		protocol = "udp"
+	}
+	if protocol != "udp" && protocol != "tcp" && protocol != "tls" {
+		return nil, fmt.Errorf("unsupported syslog protocol %q", sc.Protocol)
+	}
+
+	format := strings.ToLower(sc.Format)
+	if format == "" {
+		format = syslogFormatStructured
+	}
+	if format != syslogFormatStructured && format != syslogFormatCEF {
+		return nil, fmt.Errorf("unsupported syslog format %q", sc.Format)
+	}
+
+	facilityName := strings.ToLower(sc.Facility)
+	if facilityName == "" {
+		facilityName = "local0"
+	}
+	facility, ok := syslogFacilities[facilityName]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", sc.Facility)
+	}
+
+	severity := make(map[string]int, len(defaultSyslogSeverity))
+	for name, value := range defaultSyslogSeverity {
+		severity[name] = value
+	}
+	for indicatorSeverity, syslogSeverity := range sc.SeverityMap {
+		value, ok := syslogSeverities[strings.ToLower(syslogSeverity)]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog severity %q for %q", syslogSeverity, indicatorSeverity)
+		}
+		severity[strings.ToLower(indicatorSeverity)] = value
+	}
+
+	appName := sc.AppName
+	if appName == "" {
+		appName = "monitoring-agent"
+	}
+
+	enterpriseID := sc.EnterpriseID
+	if enterpriseID == "" {
+		enterpriseID = defaultSyslogEnterpriseID
+	}
+	if !validEnterpriseID.MatchString(enterpriseID) {
+		return nil, fmt.Errorf("invalid syslog enterprise ID %q", sc.EnterpriseID)
+	}
+
+	s := &SyslogExporter{
+		address:  sc.Address,
+		protocol: protocol,
+		format:   format,
+		facility: facility,
+		appName:  headerField(appName, 48),
+		sdSuffix: "@" + enterpriseID,
+		severity: severity,
+		summary:  sc.MetricSummary,
+		procID:   strconv.Itoa(os.Getpid()),
+	}
+	if protocol == "tls" {
+		s.tlsConfig = newTLSConfig(cfg)
+		if host, _, err := net.SplitHostPort(sc.Address); err == nil && s.tlsConfig.ServerName == "" {
+			s.tlsConfig.ServerName = host
+		}
+	}
+	return s, nil
+}
+
+// Export queues one message per threat indicator, followed by the metric
+// summary when enabled
+func (s *SyslogExporter) Export(data types.MetricPayload) error {
+	s.mu.Lock()
+	now := time.Now()
+	var messages []string
+	for _, indicator := range data.ThreatIndicators {
+		messages = append(messages, s.formatIndicator(data, indicator, now))
+	}
+	if s.summary {
+		messages = append(messages, s.formatSummary(data, now))
+	}
+	s.mu.Unlock()
+
+	for _, message := range messages {
+		if err := s.queue.add(func(ctx context.Context) error { return s.write(ctx, message) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reload applies a reloaded Syslog section. The connection is dropped, so
+// the next message sent goes to the new receiver.
+func (s *SyslogExporter) Reload(cfg *config.Config) error {
+	if cfg.Syslog.Address == "" {
+		return errors.New("removing the syslog address requires a restart")
+	}
+	next, err := syslogFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconnect = true
+	s.address, s.protocol, s.format = next.address, next.protocol, next.format
+	s.facility, s.appName, s.severity = next.facility, next.appName, next.severity
+	s.sdSuffix = next.sdSuffix
+	s.summary, s.tlsConfig = next.summary, next.tlsConfig
+	return nil
+}
+
+// write sends a message, reconnecting once if the connection has gone away.
+// It runs on the queue's worker.
+func (s *SyslogExporter) write(ctx context.Context, message string) error {
+	s.mu.Lock()
+	address, protocol, tlsConfig := s.address, s.protocol, s.tlsConfig
+	reconnect := s.reconnect
+	s.reconnect = false
+	s.mu.Unlock()
+
+	if reconnect && s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	frame := []byte(message)
+	if protocol != "udp" {
+		frame = []byte(strconv.Itoa(len(message)) + " " + message)
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if s.conn, err = dialSyslog(ctx, protocol, address, tlsConfig); err != nil {
+				err = fmt.Errorf("failed to connect to syslog receiver: %w", err)
+				var verifyErr *tls.CertificateVerificationError
+				if errors.As(err, &verifyErr) {
+					// The receiver's certificate won't verify until the config changes
+					return &sendError{permanent: true, err: err}
+				}
+				return err
+			}
+		}
+		if err = writeFrame(ctx, s.conn, frame); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+		if errors.Is(err, syscall.EMSGSIZE) {
+			// The message is larger than a datagram can carry
+			return &sendError{permanent: true, err: fmt.Errorf("failed to send syslog message: %w", err)}
+		}
+	}
+	return fmt.Errorf("failed to send syslog message: %w", err)
+}
+
+// writeFrame writes to conn within syslogWriteTimeout, giving up early if
+// ctx is cancelled
+func writeFrame(ctx context.Context, conn net.Conn, frame []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.SetWriteDeadline(time.Now()) })
+	defer stop()
+	_, err := conn.Write(frame)
+	return err
+}
+
+func dialSyslog(ctx context.Context, protocol, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	switch protocol {
+	case "tls":
+		return (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	default:
+		return dialer.DialContext(ctx, protocol, address)
+	}
+}
+
+func (s *SyslogExporter) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// Shutdown gives the queued messages until ctx is done to be sent, then
+// closes the connection. Those still unsent are dropped.
+func (s *SyslogExporter) Shutdown(ctx context.Context) error {
+	s.queue.shutdown(ctx)
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// Dropped returns how many messages were discarded unsent
+func (s *SyslogExporter) Dropped() int64 {
+	return s.queue.Dropped()
+}
+
+func (s *SyslogExporter) formatIndicator(data types.MetricPayload, indicator types.ThreatIndicator, now time.Time) string {
+	level := strings.ToLower(indicator.Severity)
+	severity, ok := s.severity[level]
+	if !ok {
+		severity = syslogSeverities["info"]
+	}
+	timestamp := indicator.Timestamp
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+
+	if s.format == syslogFormatCEF {
+		extension := []cefField{
+			{"rt", strconv.FormatInt(timestamp.UnixMilli(), 10)},
+			{"dvchost", data.Host.Hostname},
+		}
+		extension = append(extension, cefCustom("cs1", "tenant", data.TenantID)...)
+		extension = append(extension, cefCustom("cn1", "score", strconv.FormatFloat(indicator.Score, 'f', -1, 64))...)
+		extension = append(extension, cefCustom("cs2", "tags", strings.Join(indicator.Tags, ","))...)
+		if ip, ok := indicator.Details["source_ip"].(string); ok {
+			extension = append(extension, cefField{"src", ip})
+		}
+		if len(indicator.Details) > 0 {
+			details, _ := json.Marshal(indicator.Details)
+			extension = append(extension, cefCustom("cs3", "details", string(details))...)
+		}
+
+		cefLevel, ok := cefSeverity[level]
+		if !ok {
+			cefLevel = 1
+		}
+		body := formatCEF(data.TenantMetadata["agent_version"], indicator.Type, indicator.Description, cefLevel, extension)
+		return s.header(severity, timestamp, data.Host.Hostname, "THREAT") + " - " + body
+	}
+
+	params := []sdParam{
+		{"type", indicator.Type},
+		{"severity", indicator.Severity},
+		{"score", strconv.FormatFloat(indicator.Score, 'f', -1, 64)},
+		{"tenant", data.TenantID},
+	}
+	if len(indicator.Tags) > 0 {
+		params = append(params, sdParam{"tags", strings.Join(indicator.Tags, ",")})
+	}
+	for _, key := range sortedKeys(indicator.Details) {
+		params = append(params, sdParam{sdName("detail." + key), detailString(indicator.Details[key])})
+	}
+	sd := formatSDElement("threat"+s.sdSuffix, params)
+	return s.header(severity, timestamp, data.Host.Hostname, "THREAT") + " " + sd + " " + indicator.Description
+}
+
+func (s *SyslogExporter) formatSummary(data types.MetricPayload, now time.Time) string {
+	severity := syslogSeverities["info"]
+	values := []struct{ name, cef string }{
+		{"cpu_usage", "cfp1"},
+		{"memory_usage_percent", "cfp2"},
+		{"disk_usage_percent", "cfp3"},
+	}
+	metrics := map[string]float64{}
+	if v, ok := asFloat(data.Metrics["cpu_usage"]); ok {
+		metrics["cpu_usage"] = v
+	}
+	if v, ok := asFloat(data.Metrics["memory_usage_percent"]); ok {
+		metrics["memory_usage_percent"] = v
+	}
+	if disk, ok := data.Metrics["disk"].(map[string]interface{}); ok {
+		if v, ok := asFloat(disk["usage_percent"]); ok {
+			metrics["disk_usage_percent"] = v
+		}
+	}
+	description := fmt.Sprintf("Metric summary: %d processes, %d threat indicators", data.Processes.TotalCount, len(data.ThreatIndicators))
+
+	if s.format == syslogFormatCEF {
+		extension := []cefField{
+			{"rt", strconv.FormatInt(now.UnixMilli(), 10)},
+			{"dvchost", data.Host.Hostname},
+		}
+		extension = append(extension, cefCustom("cs1", "tenant", data.TenantID)...)
+		for _, v := range values {
+			if value, ok := metrics[v.name]; ok {
+				extension = append(extension, cefCustom(v.cef, v.name, strconv.FormatFloat(value, 'f', 2, 64))...)
+			}
+		}
+		extension = append(extension, cefCustom("cn1", "process_count", strconv.Itoa(data.Processes.TotalCount))...)
+		extension = append(extension, cefCustom("cn2", "threat_count", strconv.Itoa(len(data.ThreatIndicators)))...)
+		extension = append(extension, cefField{"msg", description})
+		body := formatCEF(data.TenantMetadata["agent_version"], "metric_summary", "Metric summary", 1, extension)
+		return s.header(severity, now, data.Host.Hostname, "METRICS") + " - " + body
+	}
+
+	params := []sdParam{{"tenant", data.TenantID}}
+	for _, v := range values {
+		if value, ok := metrics[v.name]; ok {
+			params = append(params, sdParam{v.name, strconv.FormatFloat(value, 'f', 2, 64)})
+		}
+	}
+	params = append(params,
+		sdParam{"process_count", strconv.Itoa(data.Processes.TotalCount)},
+		sdParam{"threat_count", strconv.Itoa(len(data.ThreatIndicators))})
+	sd := formatSDElement("metrics"+s.sdSuffix, params)
+	return s.header(severity, now, data.Host.Hostname, "METRICS") + " " + sd + " " + description
+}
+
+// header builds "<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID"
+func (s *SyslogExporter) header(severity int, timestamp time.Time, hostname, msgID string) string {
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s",
+		s.facility*8+severity,
+		timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(hostname, 255),
+		s.appName,
+		s.procID,
+		msgID,
+	)
+}
+
+// headerField makes a value safe for an RFC 5424 header field: printable
+// ASCII without spaces, at most max characters, "-" when empty
+func headerField(value string, max int) string {
+	var b strings.Builder
+	for _, r := range value {
+		if r > 32 && r < 127 {
+			b.WriteRune(r)
+		}
+		if b.Len() == max {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "-"
+	}
+	return b.String()
+}
+
+type sdParam struct {
+	name  string
+	value string
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func formatSDElement(id string, params []sdParam) string {
+	var b strings.Builder
+	b.WriteString("[")
+	b.WriteString(id)
+	for _, p := range params {
+		fmt.Fprintf(&b, ` %s="%s"`, p.name, sdEscaper.Replace(p.value))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// sdName makes a detail key a valid SD-NAME: printable ASCII without '=',
+// ' ', ']' or '"', at most 32 characters
+func sdName(key string) string {
+	var b strings.Builder
+	for _, r := range key {
+		if r <= 32 || r >= 127 || r == '=' || r == ']' || r == '"' {
+			r = '_'
+		}
+		b.WriteRune(r)
+		if b.Len() == 32 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+func detailString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case nil:
+		return ""
+	default:
+		if n, ok := asFloat(value); ok {
+			return strconv.FormatFloat(n, 'f', -1, 64)
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+		return string(encoded)
+	}
+}
+
+type cefField struct {
+	key   string
+	value string
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// cefCustom fills one of CEF's custom fields (cs1, cn1, cfp1, ...) with its
+// label, or nothing if value is empty
+func cefCustom(key, label, value string) []cefField {
+	if value == "" {
+		return nil
+	}
+	return []cefField{{key + "Label", label}, {key, value}}
+}
+
+// formatCEF builds "CEF:0|Vendor|Product|Version|SignatureID|Name|Severity|Extension"
+func formatCEF(version, signatureID, name string, severity int, extension []cefField) string {
+	if version == "" {
+		version = "unknown"
+	}
+	header := []string{
+		"CEF:0",
+		"travism26",
+		"system-monitoring-agent",
+		cefHeaderEscaper.Replace(version),
+		cefHeaderEscaper.Replace(signatureID),
+		cefHeaderEscaper.Replace(name),
+		strconv.Itoa(severity),
+	}
+
+	fields := make([]string, 0, len(extension))
+	for _, field := range extension {
+		if field.value == "" {
+			continue
+		}
+		fields = append(fields, field.key+"="+cefExtensionEscaper.Replace(field.value))
+	}
+	return strings.Join(header, "|") + "|" + strings.Join(fields, " ")
+}
@@ -0,0 +1,280 @@
+package exporter
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+var rfc5424Header = regexp.MustCompile(`^<(\d+)>1 \d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z (\S+) (\S+) \d+ (\S+) `)
+
+func syslogPayload() types.MetricPayload {
+	payload := types.MetricPayload{
+		TenantID:       "acme",
+		TenantMetadata: map[string]string{"agent_version": "1.0.0"},
+		Metrics: map[string]interface{}{
+			"cpu_usage":            12.5,
+			"memory_usage_percent": 40.0,
+			"disk":                 map[string]interface{}{"usage_percent": 70.0},
+		},
+		ThreatIndicators: []types.ThreatIndicator{{
+			Type:        "ssh_brute_force",
+			Description: "20 failed SSH logins from 10.0.0.5",
+			Severity:    "high",
+			Score:       80,
+			Timestamp:   time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC),
+			Tags:        []string{"auth", "ssh"},
+			Details:     map[string]interface{}{"source_ip": "10.0.0.5", "user": `ro"ot]`},
+		}},
+	}
+	payload.Host.Hostname = "web-1"
+	payload.Processes.TotalCount = 150
+	return payload
+}
+
+func newTestSyslogExporter(t *testing.T, mutate func(*config.SyslogConfig)) *SyslogExporter {
+	cfg := &config.Config{}
+	cfg.Syslog.Address = "127.0.0.1:514"
+	if mutate != nil {
+		mutate(&cfg.Syslog)
+	}
+	exporter, err := NewSyslogExporter(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { exporter.Close() })
+	return exporter
+}
+
+func TestSyslogStructuredMessage(t *testing.T) {
+	exporter := newTestSyslogExporter(t, func(sc *config.SyslogConfig) {
+		sc.Facility = "auth"
+		sc.AppName = "sec agent"
+	})
+	payload := syslogPayload()
+	msg := exporter.formatIndicator(payload, payload.ThreatIndicators[0], time.Now())
+
+	match := rfc5424Header.FindStringSubmatch(msg)
+	require.NotNil(t, match, msg)
+	assert.Equal(t, strconv.Itoa(4*8+3), match[1]) // auth.err
+	assert.Equal(t, "web-1", match[2])
+	assert.Equal(t, "secagent", match[3])
+	assert.Equal(t, "THREAT", match[4])
+	assert.True(t, strings.HasPrefix(msg, "<35>1 2025-03-10T12:00:00.000000Z "))
+	assert.Contains(t, msg, `[threat@32473 type="ssh_brute_force" severity="high" score="80" tenant="acme" tags="auth,ssh" detail.source_ip="10.0.0.5" detail.user="ro\"ot\]"]`)
+	assert.True(t, strings.HasSuffix(msg, "] 20 failed SSH logins from 10.0.0.5"))
+}
+
+func TestSyslogCEFMessage(t *testing.T) {
+	exporter := newTestSyslogExporter(t, func(sc *config.SyslogConfig) { sc.Format = "CEF" })
+	payload := syslogPayload()
+	payload.ThreatIndicators[0].Description = "bad | thing"
+	msg := exporter.formatIndicator(payload, payload.ThreatIndicators[0], time.Now())
+
+	require.NotNil(t, rfc5424Header.FindStringSubmatch(msg), msg)
+	body := msg[strings.Index(msg, " - CEF:")+3:]
+	assert.True(t, strings.HasPrefix(body, `CEF:0|travism26|system-monitoring-agent|1.0.0|ssh_brute_force|bad \| thing|8|rt=1741608000000 dvchost=web-1 cs1Label=tenant cs1=acme cn1Label=score cn1=80 cs2Label=tags cs2=auth,ssh src=10.0.0.5 cs3Label=details cs3={"source_ip":"10.0.0.5","user":"ro\\"ot]"}`), body)
+}
+
+func TestSyslogSeverityMapping(t *testing.T) {
+	exporter := newTestSyslogExporter(t, func(sc *config.SyslogConfig) {
+		sc.SeverityMap = map[string]string{"high": "alert"}
+	})
+	payload := syslogPayload()
+	msg := exporter.formatIndicator(payload, payload.ThreatIndicators[0], time.Now())
+	assert.True(t, strings.HasPrefix(msg, "<129>1 "), msg) // local0.alert
+
+	payload.ThreatIndicators[0].Severity = "medium"
+	msg = exporter.formatIndicator(payload, payload.ThreatIndicators[0], time.Now())
+	assert.True(t, strings.HasPrefix(msg, "<132>1 "), msg) // local0.warning
+}
+
+func TestSyslogMetricSummary(t *testing.T) {
+	exporter := newTestSyslogExporter(t, nil)
+	msg := exporter.formatSummary(syslogPayload(), time.Now())
+	assert.True(t, strings.HasPrefix(msg, "<134>1 "), msg) // local0.info
+	assert.Contains(t, msg, ` METRICS [metrics@32473 tenant="acme" cpu_usage="12.50" memory_usage_percent="40.00" disk_usage_percent="70.00" process_count="150" threat_count="1"] `)
+
+	exporter = newTestSyslogExporter(t, func(sc *config.SyslogConfig) { sc.EnterpriseID = "54321.1" })
+	assert.Contains(t, exporter.formatSummary(syslogPayload(), time.Now()), " METRICS [metrics@54321.1 tenant=")
+}
+
+func TestNewSyslogExporterValidatesConfig(t *testing.T) {
+	for name, mutate := range map[string]func(*config.SyslogConfig){
+		"no address":       func(sc *config.SyslogConfig) { sc.Address = "" },
+		"unknown protocol": func(sc *config.SyslogConfig) { sc.Protocol = "relp" },
+		"unknown format":   func(sc *config.SyslogConfig) { sc.Format = "leef" },
+		"unknown facility": func(sc *config.SyslogConfig) { sc.Facility = "local9" },
+		"unknown severity": func(sc *config.SyslogConfig) { sc.SeverityMap = map[string]string{"high": "panic"} },
+		"bad enterprise":   func(sc *config.SyslogConfig) { sc.EnterpriseID = "acme corp" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Syslog.Address = "127.0.0.1:514"
+			mutate(&cfg.Syslog)
+			_, err := NewSyslogExporter(cfg)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSyslogExporterUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	exporter := newTestSyslogExporter(t, func(sc *config.SyslogConfig) {
+		sc.Address = conn.LocalAddr().String()
+		sc.MetricSummary = true
+	})
+	defer exporter.Close()
+	require.NoError(t, exporter.Export(syslogPayload()))
+
+	buf := make([]byte, 4096)
+	var messages []string
+	for i := 0; i < 2; i++ {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		messages = append(messages, string(buf[:n]))
+	}
+	assert.Contains(t, messages[0], " THREAT [threat@32473 ")
+	assert.Contains(t, messages[1], " METRICS [metrics@32473 ")
+}
+
+// readFramed reads count octet-counted messages from a stream listener
+func readFramed(t *testing.T, listener net.Listener, count int) []string {
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	reader := bufio.NewReader(conn)
+	var messages []string
+	for i := 0; i < count; i++ {
+		length, err := reader.ReadString(' ')
+		require.NoError(t, err)
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		require.NoError(t, err)
+		msg := make([]byte, n)
+		_, err = io.ReadFull(reader, msg)
+		require.NoError(t, err)
+		messages = append(messages, string(msg))
+	}
+	return messages
+}
+
+func TestSyslogExporterTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	exporter := newTestSyslogExporter(t, func(sc *config.SyslogConfig) {
+		sc.Address = listener.Addr().String()
+		sc.Protocol = "tcp"
+		sc.Format = "cef"
+		sc.MetricSummary = true
+	})
+	defer exporter.Close()
+
+	received := make(chan []string, 1)
+	go func() { received <- readFramed(t, listener, 2) }()
+	require.NoError(t, exporter.Export(syslogPayload()))
+
+	messages := <-received
+	assert.Contains(t, messages[0], "|ssh_brute_force|")
+	assert.Contains(t, messages[1], "|metric_summary|Metric summary|1|")
+}
+
+func TestSyslogExporterTLS(t *testing.T) {
+	// Borrow httptest's self-signed certificate for the receiver
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.StartTLS()
+	tlsConfig := server.TLS.Clone()
+	server.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	cfg := &config.Config{}
+	cfg.Syslog.Address = listener.Addr().String()
+	cfg.Syslog.Protocol = "tls"
+	exporter, err := NewSyslogExporter(cfg)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	received := make(chan []string, 1)
+	go func() { received <- readFramed(t, listener, 1) }()
+	require.NoError(t, exporter.Export(syslogPayload()))
+	assert.Contains(t, (<-received)[0], "20 failed SSH logins")
+}
+
+func TestSyslogExporterDropsUndeliverableMessages(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	cfg := &config.Config{}
+	cfg.Syslog.Address = address
+	cfg.Syslog.Protocol = "tcp"
+	cfg.Syslog.MetricSummary = true
+	cfg.HTTP.RetryAttempts = 1
+	exporter, err := NewSyslogExporter(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, exporter.Export(syslogPayload()))
+	require.NoError(t, exporter.Close())
+	assert.Equal(t, int64(2), exporter.Dropped())
+	assert.ErrorIs(t, exporter.Export(syslogPayload()), ErrExporterClosed)
+}
+
+func TestSyslogExporterDropsUnverifiedReceiver(t *testing.T) {
+	// httptest's self-signed certificate won't verify
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.StartTLS()
+	tlsConfig := server.TLS.Clone()
+	server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	var accepted int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			tls.Server(conn, tlsConfig).Handshake()
+			conn.Close()
+		}
+	}()
+
+	cfg := &config.Config{}
+	cfg.Syslog.Address = listener.Addr().String()
+	cfg.Syslog.Protocol = "tls"
+	cfg.Security.ValidateSSL = true
+	cfg.HTTP.RetryAttempts = 3
+	exporter, err := NewSyslogExporter(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, exporter.Export(syslogPayload()))
+	require.NoError(t, exporter.Close())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&accepted), "an unverified receiver should not be retried")
+	assert.Equal(t, int64(1), exporter.Dropped())
+}
@@ -0,0 +1,229 @@
+// Package external runs site-specific checks as collectors. A check is an
+// executable that writes one JSON object to stdout:
+//
+//	{
+//	  "metrics": {"queue_depth": 12, "healthy": true},
+//	  "indicators": [
+//	    {"type": "license_expiring", "severity": "low", "description": "..."}
+//	  ]
+//	}
+//
+// The command runs with a minimal environment, a deadline, capped output and,
+// on Linux, resource limits.
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const (
+	defaultTimeout   = 10 * time.Second
+	defaultMaxOutput = 1 << 20
+	defaultMemoryMB  = 512
+	defaultOpenFiles = 64
+	// maxStderr bounds the stderr kept for error messages
+	maxStderr = 4 << 10
+	// defaultPath is the only environment a command gets besides its own
+	defaultPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	// unprivilegedUser runs commands when the agent is root and no user is set
+	unprivilegedUser = "nobody"
+)
+
+var (
+	ErrOutputTooLarge = errors.New("output too large")
+	ErrTimeout        = errors.New("timed out")
+)
+
+var validSeverities = map[string]bool{"low": true, "medium": true, "high": true, "critical": true}
+
+// Limits bounds the resources a command may use. Zero values take the
+// defaults.
+type Limits struct {
+	MaxOutput  int // bytes of stdout
+	MemoryMB   int // address space
+	CPUSeconds int
+	OpenFiles  int
+}
+
+// Config describes a command to run
+type Config struct {
+	Name    string
+	Command string
+	Args    []string
+	Env     []string
+	Dir     string
+	Timeout time.Duration
+	User    string
+	Limits  Limits
+}
+
+// Output is the JSON object a command writes to stdout
+type Output struct {
+	Metrics    map[string]interface{} `json:"metrics"`
+	Indicators []Indicator            `json:"indicators"`
+}
+
+// Indicator is a threat indicator raised by a command
+type Indicator struct {
+	Type        string                 `json:"type"`
+	Description string                 `json:"description"`
+	Severity    string                 `json:"severity"`
+	Score       float64                `json:"score"`
+	Tags        []string               `json:"tags"`
+	Details     map[string]interface{} `json:"details"`
+}
+
+// Command runs one external collector
+type Command struct {
+	cfg      Config
+	env      []string
+	identity *identity
+}
+
+// NewCommand checks the executable and resolves the user to run it as
+func NewCommand(cfg Config) (*Command, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = "/"
+	}
+	if cfg.Limits.MaxOutput <= 0 {
+		cfg.Limits.MaxOutput = defaultMaxOutput
+	}
+	if cfg.Limits.MemoryMB <= 0 {
+		cfg.Limits.MemoryMB = defaultMemoryMB
+	}
+	if cfg.Limits.CPUSeconds <= 0 {
+		cfg.Limits.CPUSeconds = int((cfg.Timeout + time.Second - 1) / time.Second)
+	}
+	if cfg.Limits.OpenFiles <= 0 {
+		cfg.Limits.OpenFiles = defaultOpenFiles
+	}
+	if cfg.User == "" && os.Geteuid() == 0 {
+		cfg.User = unprivilegedUser
+	}
+
+	if err := checkExecutable(cfg.Command); err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.Name, err)
+	}
+	id, err := lookupIdentity(cfg.User)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.Name, err)
+	}
+	return &Command{
+		cfg:      cfg,
+		env:      append([]string{defaultPath, "LANG=C"}, cfg.Env...),
+		identity: id,
+	}, nil
+}
+
+// Name returns the collector name the command was configured with
+func (c *Command) Name() string {
+	return c.cfg.Name
+}
+
+// Run executes the command and parses what it wrote to stdout. The command
+// and anything it started are killed at the deadline.
+func (c *Command) Run(ctx context.Context) (*Output, error) {
+	if err := checkExecutable(c.cfg.Command); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.cfg.Name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	name, args := limitedCommand(c.cfg.Command, c.cfg.Args, c.cfg.Limits)
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = c.env
+	cmd.Dir = c.cfg.Dir
+	stdout := &cappedBuffer{limit: c.cfg.Limits.MaxOutput}
+	stderr := &cappedBuffer{limit: maxStderr}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.WaitDelay = time.Second
+	configure(cmd, c.identity)
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.cfg.Name, err)
+	}
+	err := cmd.Wait()
+	terminate(cmd)
+
+	switch {
+	case ctx.Err() != nil:
+		return nil, fmt.Errorf("%s: %w after %s", c.cfg.Name, ErrTimeout, c.cfg.Timeout)
+	case err != nil:
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("%s: %w: %s", c.cfg.Name, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", c.cfg.Name, err)
+	}
+	if stdout.truncated {
+		return nil, fmt.Errorf("%s: %w: more than %d bytes", c.cfg.Name, ErrOutputTooLarge, c.cfg.Limits.MaxOutput)
+	}
+	return parseOutput(c.cfg.Name, stdout.Bytes())
+}
+
+func parseOutput(name string, data []byte) (*Output, error) {
+	var out Output
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("%s: invalid output: %w", name, err)
+	}
+	if out.Metrics == nil {
+		out.Metrics = map[string]interface{}{}
+	}
+	for i, indicator := range out.Indicators {
+		if indicator.Type == "" {
+			return nil, fmt.Errorf("%s: indicator %d has no type", name, i)
+		}
+		if indicator.Severity == "" {
+			out.Indicators[i].Severity = "medium"
+		} else if !validSeverities[indicator.Severity] {
+			return nil, fmt.Errorf("%s: indicator %s has unknown severity %q", name, indicator.Type, indicator.Severity)
+		}
+	}
+	return &out, nil
+}
+
+// checkExecutable checks the command is a regular file other users can't
+// replace
+func checkExecutable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	return checkPermissions(path, info.Mode())
+}
+
+// cappedBuffer keeps up to limit bytes and discards the rest, noting that
+// it did. The buffer isn't embedded, so io.Copy can't bypass Write through
+// its ReadFrom.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.limit - b.buf.Len(); n > room {
+		b.truncated = true
+		p = p[:max(room, 0)]
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+func (b *cappedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
@@ -0,0 +1,171 @@
+//go:build unix
+
+package external
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain lets the test binary stand in for the agent as the wrapper that
+// applies limits
+func TestMain(m *testing.M) {
+	RunWrapper()
+	os.Exit(m.Run())
+}
+
+// writeScript writes a shell script the test user can run
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "check.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755))
+	return path
+}
+
+// currentUser keeps a root test run as root, since commands would otherwise
+// run as nobody and couldn't reach the test's temp dir
+func currentUser(t *testing.T) string {
+	u, err := user.Current()
+	require.NoError(t, err)
+	return u.Username
+}
+
+func newTestCommand(t *testing.T, body string, configure ...func(*Config)) *Command {
+	t.Helper()
+	cfg := Config{Name: "check", Command: writeScript(t, body), User: currentUser(t)}
+	for _, f := range configure {
+		f(&cfg)
+	}
+	command, err := NewCommand(cfg)
+	require.NoError(t, err)
+	return command
+}
+
+func TestRunParsesOutput(t *testing.T) {
+	command := newTestCommand(t, `cat <<'EOF'
+{
+  "metrics": {"queue_depth": 12, "healthy": true, "nested": {"a": 1}},
+  "indicators": [
+    {"type": "license_expiring", "description": "License expires in 3 days", "severity": "low", "score": 20, "tags": ["vendor"]},
+    {"type": "unknown_severity_defaults"}
+  ]
+}
+EOF`)
+
+	output, err := command.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 12.0, output.Metrics["queue_depth"])
+	assert.Equal(t, true, output.Metrics["healthy"])
+	assert.Equal(t, map[string]interface{}{"a": 1.0}, output.Metrics["nested"])
+	require.Len(t, output.Indicators, 2)
+	assert.Equal(t, "license_expiring", output.Indicators[0].Type)
+	assert.Equal(t, "low", output.Indicators[0].Severity)
+	assert.Equal(t, 20.0, output.Indicators[0].Score)
+	assert.Equal(t, "medium", output.Indicators[1].Severity)
+}
+
+func TestRunRejectsBadOutput(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		error string
+	}{
+		{"not json", `echo not json`, "invalid output"},
+		{"indicator without type", `echo '{"indicators": [{"severity": "high"}]}'`, "has no type"},
+		{"unknown severity", `echo '{"indicators": [{"type": "x", "severity": "urgent"}]}'`, "unknown severity"},
+		{"non-zero exit", `echo "vendor tool missing" >&2; exit 3`, "exit status 3: vendor tool missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestCommand(t, tt.body).Run(context.Background())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.error)
+		})
+	}
+}
+
+func TestRunEnforcesTimeout(t *testing.T) {
+	// The background sleep shares the pipes, so it must be killed too
+	command := newTestCommand(t, "sleep 30 & sleep 30", func(cfg *Config) {
+		cfg.Timeout = 200 * time.Millisecond
+	})
+
+	start := time.Now()
+	_, err := command.Run(context.Background())
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestRunCapsOutput(t *testing.T) {
+	command := newTestCommand(t, `head -c 100000 /dev/zero`, func(cfg *Config) {
+		cfg.Limits.MaxOutput = 1024
+	})
+
+	_, err := command.Run(context.Background())
+	assert.ErrorIs(t, err, ErrOutputTooLarge)
+}
+
+func TestRunUsesMinimalEnvironment(t *testing.T) {
+	t.Setenv("AGENT_SECRET", "hunter2")
+	command := newTestCommand(t, `printf '{"metrics": {"secret": "%s", "custom": "%s", "pwd": "%s"}}' "$AGENT_SECRET" "$CUSTOM" "$(pwd)"`,
+		func(cfg *Config) { cfg.Env = []string{"CUSTOM=set"} })
+
+	output, err := command.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "", output.Metrics["secret"])
+	assert.Equal(t, "set", output.Metrics["custom"])
+	assert.Equal(t, "/", output.Metrics["pwd"])
+}
+
+func TestRunAppliesLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("rlimits are applied on Linux only")
+	}
+	// The limits are in place before the command's first instruction
+	command := newTestCommand(t, `printf '{"metrics": {"files": "%s", "cpu": "%s"}}' "$(ulimit -n)" "$(ulimit -t)"`,
+		func(cfg *Config) { cfg.Limits = Limits{OpenFiles: 32, CPUSeconds: 5} })
+
+	output, err := command.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "32", output.Metrics["files"])
+	assert.Equal(t, "5", output.Metrics["cpu"])
+}
+
+func TestNewCommandChecksExecutable(t *testing.T) {
+	path := writeScript(t, "true")
+
+	require.NoError(t, os.Chmod(path, 0o777))
+	_, err := NewCommand(Config{Name: "check", Command: path, User: currentUser(t)})
+	assert.ErrorContains(t, err, "writable by group or others")
+
+	require.NoError(t, os.Chmod(path, 0o644))
+	_, err = NewCommand(Config{Name: "check", Command: path, User: currentUser(t)})
+	assert.ErrorContains(t, err, "not executable")
+
+	_, err = NewCommand(Config{Name: "check", Command: filepath.Join(t.TempDir(), "missing")})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestNewCommandDropsRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	nobody, err := user.Lookup(unprivilegedUser)
+	if err != nil {
+		t.Skip("no nobody user")
+	}
+
+	command, err := NewCommand(Config{Name: "check", Command: writeScript(t, "true")})
+	require.NoError(t, err)
+	require.NotNil(t, command.identity)
+	assert.Equal(t, nobody.Uid, strconv.FormatUint(uint64(command.identity.uid), 10))
+}
@@ -0,0 +1,84 @@
+//go:build linux
+
+package external
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// wrapperArg, as the first argument, starts the agent binary as the wrapper
+// that sets a command's rlimits and then execs it. Go can't run code between
+// fork and exec, and limits set on a started command leave it a window to
+// run unlimited, so the command is started through the wrapper instead.
+const wrapperArg = "__external-exec"
+
+func sysProcAttr() *syscall.SysProcAttr {
+	// Pdeathsig stops commands outliving an agent that is killed
+	return &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+}
+
+// limitedCommand returns the command line that runs command under the
+// wrapper. /proc/self/exe still names the agent after its binary is
+// replaced on upgrade; the command's user needs execute permission on it.
+func limitedCommand(command string, args []string, limits Limits) (string, []string) {
+	return "/proc/self/exe", append([]string{
+		wrapperArg,
+		strconv.FormatUint(uint64(limits.MemoryMB)<<20, 10),
+		strconv.Itoa(limits.CPUSeconds),
+		strconv.Itoa(limits.OpenFiles),
+		command,
+	}, args...)
+}
+
+// RunWrapper turns the process into the wrapper if it was started as one, in
+// which case it doesn't return. main calls it before anything else.
+func RunWrapper() {
+	if len(os.Args) < 2 || os.Args[1] != wrapperArg {
+		return
+	}
+	if err := runWrapper(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "external: %v\n", err)
+		// The shell's status for a command that couldn't be executed
+		os.Exit(126)
+	}
+}
+
+// runWrapper sets the limits and execs the command, returning only on
+// failure. A limit above the hard limit the agent inherited is lowered to
+// it.
+func runWrapper(args []string) error {
+	if len(args) < 4 {
+		return fmt.Errorf("usage: %s memory-bytes cpu-seconds open-files command [args...]", wrapperArg)
+	}
+	for i, limit := range []struct {
+		name     string
+		resource int
+	}{
+		{"memory", unix.RLIMIT_AS},
+		{"cpu", unix.RLIMIT_CPU},
+		{"open files", unix.RLIMIT_NOFILE},
+	} {
+		value, err := strconv.ParseUint(args[i], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s limit: %w", limit.name, err)
+		}
+		var current unix.Rlimit
+		if err := unix.Getrlimit(limit.resource, &current); err != nil {
+			return fmt.Errorf("read %s limit: %w", limit.name, err)
+		}
+		value = min(value, current.Max)
+		if err := unix.Setrlimit(limit.resource, &unix.Rlimit{Cur: value, Max: value}); err != nil {
+			return fmt.Errorf("set %s limit: %w", limit.name, err)
+		}
+	}
+	command := args[3]
+	if err := syscall.Exec(command, args[3:], os.Environ()); err != nil {
+		return fmt.Errorf("exec %s: %w", command, err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build unix && !linux
+
+package external
+
+import "syscall"
+
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// limitedCommand runs the command as is; this platform has no rlimits for
+// it, but the deadline and output cap still apply
+func limitedCommand(command string, args []string, limits Limits) (string, []string) {
+	return command, args
+}
+
+// RunWrapper has no wrapper to run on this platform
+func RunWrapper() {}
@@ -0,0 +1,36 @@
+//go:build !unix
+
+package external
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+type identity struct{}
+
+// lookupIdentity can't switch users on this platform, so commands run as the
+// agent's own user
+func lookupIdentity(name string) (*identity, error) {
+	if name != "" {
+		return nil, errors.New("running as another user is not supported on this platform")
+	}
+	return nil, nil
+}
+
+// checkPermissions has no executable or group bits to check on this platform
+func checkPermissions(path string, mode os.FileMode) error {
+	return nil
+}
+
+func configure(cmd *exec.Cmd, id *identity) {}
+
+func terminate(cmd *exec.Cmd) {}
+
+func limitedCommand(command string, args []string, limits Limits) (string, []string) {
+	return command, args
+}
+
+// RunWrapper has no wrapper to run on this platform
+func RunWrapper() {}
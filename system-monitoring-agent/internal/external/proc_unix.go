@@ -0,0 +1,70 @@
+//go:build unix
+
+package external
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// identity is the user and group a command runs as
+type identity struct {
+	uid, gid uint32
+}
+
+// lookupIdentity resolves the user to run commands as. No user runs them as
+// the agent's own user; another user needs the agent to be root.
+func lookupIdentity(name string) (*identity, error) {
+	if name == "" {
+		return nil, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %s: %w", name, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %s: %w", name, err)
+	}
+	if euid := os.Geteuid(); euid != 0 && uint64(euid) != uid {
+		return nil, fmt.Errorf("running as %s requires the agent to run as root", name)
+	}
+	return &identity{uid: uint32(uid), gid: uint32(gid)}, nil
+}
+
+// checkPermissions refuses commands that aren't executable or that other
+// users could replace
+func checkPermissions(path string, mode os.FileMode) error {
+	switch {
+	case mode.Perm()&0o111 == 0:
+		return fmt.Errorf("%s is not executable", path)
+	case mode.Perm()&0o022 != 0:
+		return fmt.Errorf("%s is writable by group or others", path)
+	}
+	return nil
+}
+
+// configure runs the command in its own process group, as the configured
+// user, so that the whole group can be killed
+func configure(cmd *exec.Cmd, id *identity) {
+	cmd.SysProcAttr = sysProcAttr()
+	if id != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: id.uid, Gid: id.gid, Groups: []uint32{}}
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
+
+// terminate kills anything the command left running in its process group
+func terminate(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,377 @@
+// Package fim implements file integrity monitoring against a baseline of
+// approved file hashes, modes and owners
+package fim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxFileSize is the largest file that will be hashed. Bigger files are still
+// tracked, with changes detected from their size and mtime.
+const MaxFileSize = 512 << 20
+
+type ChangeType string
+
+const (
+	ChangeCreated            ChangeType = "created"
+	ChangeModified           ChangeType = "modified"
+	ChangeDeleted            ChangeType = "deleted"
+	ChangePermissionsChanged ChangeType = "permissions_changed"
+)
+
+// Entry is the recorded state of a single file
+type Entry struct {
+	Path    string
+	SHA256  string
+	Size    int64
+	Mode    os.FileMode
+	UID     string
+	GID     string
+	ModTime time.Time
+}
+
+// Change is a difference between a file on disk and its baseline. Before is
+// nil for created files and After is nil for deleted ones.
+type Change struct {
+	Type   ChangeType
+	Path   string
+	Root   string
+	Before *Entry
+	After  *Entry
+}
+
+// statInfo holds the platform-specific stat fields
+type statInfo struct {
+	uid   string
+	gid   string
+	inode uint64
+	ctime int64
+}
+
+// observedFile remembers a file's stat identity so unchanged files aren't
+// re-hashed every cycle. The change time is included because, unlike mtime,
+// it can't be reset after editing a file.
+type observedFile struct {
+	entry Entry
+	inode uint64
+	ctime int64
+}
+
+// Monitor compares the configured paths against the stored baseline
+type Monitor struct {
+	store    *Store
+	roots    []string
+	excludes []string
+
+	mu       sync.Mutex
+	observed map[string]observedFile
+	// reported holds the last deviation reported for each path so a change
+	// is reported once, not on every cycle until it is re-baselined
+	reported map[string]string
+}
+
+// NewMonitor creates a Monitor for the given paths. Paths may be files,
+// directories (walked recursively) or glob patterns such as
+// "/home/*/.ssh/authorized_keys". Excludes are glob patterns that also cover
+// everything below a matching directory.
+func NewMonitor(store *Store, paths, excludes []string) *Monitor {
+	return &Monitor{
+		store:    store,
+		roots:    paths,
+		excludes: excludes,
+		observed: make(map[string]observedFile),
+		reported: make(map[string]string),
+	}
+}
+
+// Close closes the baseline store
+func (m *Monitor) Close() error {
+	return m.store.Close()
+}
+
+// Scan compares the files on disk with the baseline and returns the changes
+// not reported before, along with the number of files monitored. A path seen
+// for the first time is baselined as-is rather than reported.
+func (m *Monitor) Scan() ([]Change, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changes []Change
+	monitored := 0
+	reported := make(map[string]string)
+	observed := make(map[string]observedFile)
+
+	for _, root := range m.roots {
+		current, unreadable := m.snapshot(root, observed)
+		monitored += len(current)
+
+		known, err := m.store.HasRoot(root)
+		if err != nil {
+			return nil, monitored, err
+		}
+		if !known {
+			if err := m.store.Replace(root, entryList(current)); err != nil {
+				return nil, monitored, err
+			}
+			continue
+		}
+
+		baseline, err := m.store.Load(root)
+		if err != nil {
+			return nil, monitored, err
+		}
+
+		for _, change := range diff(root, baseline, current, unreadable) {
+			// A file under overlapping roots is reported for the first
+			if _, ok := reported[change.Path]; ok {
+				continue
+			}
+			sig := signature(change)
+			reported[change.Path] = sig
+			if m.reported[change.Path] != sig {
+				changes = append(changes, change)
+			}
+		}
+	}
+
+	m.observed = observed
+	m.reported = reported
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, monitored, nil
+}
+
+// Rebaseline records the current state of the monitored files as approved.
+// With prefixes, only files at or below those paths are re-baselined; without,
+// every root is and roots no longer configured are dropped.
+func (m *Monitor) Rebaseline(prefixes []string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	observed := make(map[string]observedFile)
+	for _, root := range m.roots {
+		current, unreadable := m.snapshot(root, observed)
+		entries := current
+
+		if len(prefixes) > 0 {
+			baseline, err := m.store.Load(root)
+			if err != nil {
+				return count, err
+			}
+			entries = make(map[string]Entry, len(baseline))
+			for path, entry := range baseline {
+				if !underAny(prefixes, path) || underAny(unreadable, path) {
+					entries[path] = entry
+				}
+			}
+			for path, entry := range current {
+				if underAny(prefixes, path) {
+					entries[path] = entry
+					count++
+				}
+			}
+		} else {
+			count += len(current)
+		}
+
+		if err := m.store.Replace(root, entryList(entries)); err != nil {
+			return count, err
+		}
+	}
+
+	if len(prefixes) == 0 {
+		if err := m.store.Forget(m.roots); err != nil {
+			return count, err
+		}
+	}
+
+	m.observed = observed
+	m.reported = make(map[string]string)
+	return count, nil
+}
+
+// snapshot records every file under root. Directories that can't be read are
+// returned so their baseline entries aren't mistaken for deletions.
+func (m *Monitor) snapshot(root string, observed map[string]observedFile) (map[string]Entry, []string) {
+	entries := make(map[string]Entry)
+	var unreadable []string
+
+	matches, err := filepath.Glob(root)
+	if err != nil {
+		matches = []string{root}
+	}
+	for _, match := range matches {
+		filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					unreadable = append(unreadable, path)
+				}
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if matchesAny(m.excludes, path) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || (!d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0) {
+				return nil
+			}
+
+			entry, err := m.entry(path, observed)
+			if err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					unreadable = append(unreadable, path)
+				}
+				return nil
+			}
+			entries[path] = entry
+			return nil
+		})
+	}
+	return entries, unreadable
+}
+
+// entry stats a file and hashes it unless it is unchanged since the last scan
+func (m *Monitor) entry(path string, observed map[string]observedFile) (Entry, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	stat := fileStat(info)
+	entry := Entry{
+		Path:    path,
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		UID:     stat.uid,
+		GID:     stat.gid,
+		ModTime: info.ModTime().UTC(),
+	}
+
+	if prev, ok := m.observed[path]; ok && prev.inode == stat.inode && prev.ctime == stat.ctime &&
+		prev.entry.Size == entry.Size && prev.entry.ModTime.Equal(entry.ModTime) {
+		entry.SHA256 = prev.entry.SHA256
+	} else if entry.SHA256, err = hashFile(path, info); err != nil {
+		return Entry{}, err
+	}
+
+	observed[path] = observedFile{entry: entry, inode: stat.inode, ctime: stat.ctime}
+	return entry, nil
+}
+
+// hashFile hashes a regular file's content, or a symlink's target path
+func hashFile(path string, info fs.FileInfo) (string, error) {
+	h := sha256.New()
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, target)
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	if info.Size() > MaxFileSize {
+		return "", nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, io.LimitReader(f, MaxFileSize)); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diff compares the baseline of a root with its current state
+func diff(root string, baseline, current map[string]Entry, unreadable []string) []Change {
+	var changes []Change
+	for path, after := range current {
+		after := after
+		before, ok := baseline[path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Type: ChangeCreated, Path: path, Root: root, After: &after})
+		case contentChanged(before, after):
+			changes = append(changes, Change{Type: ChangeModified, Path: path, Root: root, Before: &before, After: &after})
+		case before.Mode != after.Mode || before.UID != after.UID || before.GID != after.GID:
+			changes = append(changes, Change{Type: ChangePermissionsChanged, Path: path, Root: root, Before: &before, After: &after})
+		}
+	}
+	for path, before := range baseline {
+		before := before
+		if _, ok := current[path]; !ok && !underAny(unreadable, path) {
+			changes = append(changes, Change{Type: ChangeDeleted, Path: path, Root: root, Before: &before})
+		}
+	}
+	return changes
+}
+
+func contentChanged(before, after Entry) bool {
+	if before.SHA256 != "" && after.SHA256 != "" {
+		return before.SHA256 != after.SHA256
+	}
+	// Too large to hash
+	return before.Size != after.Size || !before.ModTime.Equal(after.ModTime)
+}
+
+// signature identifies the observed state behind a change
+func signature(change Change) string {
+	if change.After == nil {
+		return string(change.Type)
+	}
+	return fmt.Sprintf("%s/%s/%o/%s/%s", change.Type, change.After.SHA256, change.After.Mode, change.After.UID, change.After.GID)
+}
+
+func entryList(entries map[string]Entry) []Entry {
+	list := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	return list
+}
+
+// matchesAny reports whether path, or any directory above it, equals or
+// glob-matches one of the patterns
+func matchesAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		for p := path; ; {
+			if ok, err := filepath.Match(pattern, p); (err == nil && ok) || pattern == p {
+				return true
+			}
+			parent := filepath.Dir(p)
+			if parent == p {
+				break
+			}
+			p = parent
+		}
+	}
+	return false
+}
+
+// underAny reports whether path is one of dirs or below one of them
+func underAny(dirs []string, path string) bool {
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package fim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixture struct {
+	root  string
+	store *Store
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	root := t.TempDir()
+	f := &fixture{root: root, store: store}
+	f.write(t, "etc/hosts", "127.0.0.1 localhost\n", 0644)
+	f.write(t, "etc/passwd", "root:x:0:0::/root:/bin/sh\n", 0644)
+	f.write(t, "etc/cache/noise", "ignored", 0644)
+	f.write(t, "home/alice/.ssh/authorized_keys", "ssh-ed25519 AAAA alice\n", 0600)
+	return f
+}
+
+func (f *fixture) path(name string) string {
+	return filepath.Join(f.root, name)
+}
+
+func (f *fixture) write(t *testing.T, name, content string, mode os.FileMode) {
+	t.Helper()
+	path := f.path(name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), mode))
+	require.NoError(t, os.Chmod(path, mode))
+}
+
+func (f *fixture) monitor() *Monitor {
+	return NewMonitor(f.store,
+		[]string{f.path("etc"), f.path("home/*/.ssh/authorized_keys")},
+		[]string{f.path("etc/cache")},
+	)
+}
+
+func changesByPath(changes []Change) map[string]Change {
+	byPath := make(map[string]Change, len(changes))
+	for _, change := range changes {
+		byPath[change.Path] = change
+	}
+	return byPath
+}
+
+func TestScanDetectsChanges(t *testing.T) {
+	f := newFixture(t)
+	m := f.monitor()
+
+	// The first scan records the baseline
+	changes, monitored, err := m.Scan()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Equal(t, 3, monitored)
+
+	f.write(t, "etc/hosts", "127.0.0.1 localhost evil.example\n", 0644)
+	require.NoError(t, os.Chmod(f.path("etc/passwd"), 0666))
+	require.NoError(t, os.Remove(f.path("home/alice/.ssh/authorized_keys")))
+	f.write(t, "etc/cron.d/backdoor", "* * * * * root sh\n", 0644)
+	f.write(t, "home/bob/.ssh/authorized_keys", "ssh-rsa AAAA mallory\n", 0600)
+	f.write(t, "etc/cache/noise", "changed", 0644)
+
+	changes, _, err = m.Scan()
+	require.NoError(t, err)
+	byPath := changesByPath(changes)
+	require.Len(t, byPath, 5)
+
+	hosts := byPath[f.path("etc/hosts")]
+	assert.Equal(t, ChangeModified, hosts.Type)
+	assert.NotEqual(t, hosts.Before.SHA256, hosts.After.SHA256)
+	assert.Len(t, hosts.After.SHA256, 64)
+
+	passwd := byPath[f.path("etc/passwd")]
+	assert.Equal(t, ChangePermissionsChanged, passwd.Type)
+	assert.Equal(t, os.FileMode(0644), passwd.Before.Mode)
+	assert.Equal(t, os.FileMode(0666), passwd.After.Mode)
+	assert.Equal(t, passwd.Before.UID, passwd.After.UID)
+
+	assert.Equal(t, ChangeDeleted, byPath[f.path("home/alice/.ssh/authorized_keys")].Type)
+	assert.Nil(t, byPath[f.path("home/alice/.ssh/authorized_keys")].After)
+	assert.Equal(t, ChangeCreated, byPath[f.path("etc/cron.d/backdoor")].Type)
+	assert.Equal(t, ChangeCreated, byPath[f.path("home/bob/.ssh/authorized_keys")].Type)
+
+	// Outstanding changes are not reported again
+	changes, _, err = m.Scan()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// A further edit to an already modified file is
+	f.write(t, "etc/hosts", "127.0.0.1 localhost other.example\n", 0644)
+	changes, _, err = m.Scan()
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, f.path("etc/hosts"), changes[0].Path)
+}
+
+func TestScanDetectsEditWithRestoredMtime(t *testing.T) {
+	f := newFixture(t)
+	m := f.monitor()
+	_, _, err := m.Scan()
+	require.NoError(t, err)
+
+	info, err := os.Stat(f.path("etc/hosts"))
+	require.NoError(t, err)
+	f.write(t, "etc/hosts", "127.0.0.1 localhosX\n", 0644)
+	require.NoError(t, os.Chtimes(f.path("etc/hosts"), info.ModTime(), info.ModTime()))
+
+	changes, _, err := m.Scan()
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, ChangeModified, changes[0].Type)
+}
+
+func TestRebaseline(t *testing.T) {
+	f := newFixture(t)
+	m := f.monitor()
+	_, _, err := m.Scan()
+	require.NoError(t, err)
+
+	f.write(t, "etc/hosts", "changed\n", 0644)
+	f.write(t, "etc/passwd", "changed\n", 0644)
+
+	// Approve only /etc/hosts
+	count, err := m.Rebaseline([]string{f.path("etc/hosts")})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	changes, _, err := m.Scan()
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, f.path("etc/passwd"), changes[0].Path)
+
+	// A separate monitor, as used by the rebaseline command, approves the rest
+	count, err = f.monitor().Rebaseline(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	changes, _, err = m.Scan()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestRebaselineDropsRemovedRoots(t *testing.T) {
+	f := newFixture(t)
+	_, _, err := f.monitor().Scan()
+	require.NoError(t, err)
+
+	m := NewMonitor(f.store, []string{f.path("etc")}, nil)
+	_, err = m.Rebaseline(nil)
+	require.NoError(t, err)
+
+	known, err := f.store.HasRoot(f.path("home/*/.ssh/authorized_keys"))
+	require.NoError(t, err)
+	assert.False(t, known)
+	entries, err := f.store.Load(f.path("etc"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestOverlappingRoots(t *testing.T) {
+	f := newFixture(t)
+	m := NewMonitor(f.store, []string{f.path("etc"), f.path("etc/hosts")}, nil)
+	_, _, err := m.Scan()
+	require.NoError(t, err)
+
+	// Each root keeps its own entry for the shared file
+	for _, root := range []string{f.path("etc"), f.path("etc/hosts")} {
+		entries, err := f.store.Load(root)
+		require.NoError(t, err)
+		assert.Contains(t, entries, f.path("etc/hosts"), root)
+	}
+	changes, _, err := m.Scan()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	_, err = m.Rebaseline(nil)
+	require.NoError(t, err)
+	changes, _, err = m.Scan()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// A change is reported once, under the first root
+	f.write(t, "etc/hosts", "10.0.0.1 evil\n", 0644)
+	changes, _, err = m.Scan()
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, f.path("etc/hosts"), changes[0].Path)
+	assert.Equal(t, ChangeModified, changes[0].Type)
+	assert.Equal(t, f.path("etc"), changes[0].Root)
+
+	changes, _, err = m.Scan()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	entry := Entry{
+		Path:    "/etc/hosts",
+		SHA256:  "abc",
+		Size:    12,
+		Mode:    os.ModeSetuid | 0755,
+		UID:     "0",
+		GID:     "0",
+		ModTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	require.NoError(t, store.Replace("/etc", []Entry{entry}))
+
+	entries, err := store.Load("/etc")
+	require.NoError(t, err)
+	assert.Equal(t, entry, entries["/etc/hosts"])
+}
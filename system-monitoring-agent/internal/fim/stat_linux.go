@@ -0,0 +1,22 @@
+//go:build linux
+
+package fim
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+func fileStat(info fs.FileInfo) statInfo {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return statInfo{}
+	}
+	return statInfo{
+		uid:   strconv.FormatUint(uint64(stat.Uid), 10),
+		gid:   strconv.FormatUint(uint64(stat.Gid), 10),
+		inode: uint64(stat.Ino),
+		ctime: stat.Ctim.Nano(),
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package fim
+
+import "io/fs"
+
+// fileStat has no ownership information on this platform; content and mode
+// changes are still detected
+func fileStat(info fs.FileInfo) statInfo {
+	return statInfo{}
+}
@@ -0,0 +1,23 @@
+//go:build unix && !linux
+
+package fim
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+// fileStat omits the change time here, as Stat_t names it differently on
+// each BSD; inode, size and mtime still catch ordinary edits
+func fileStat(info fs.FileInfo) statInfo {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return statInfo{}
+	}
+	return statInfo{
+		uid:   strconv.FormatUint(uint64(stat.Uid), 10),
+		gid:   strconv.FormatUint(uint64(stat.Gid), 10),
+		inode: uint64(stat.Ino),
+	}
+}
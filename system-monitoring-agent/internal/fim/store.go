@@ -0,0 +1,141 @@
+package fim
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/travism26/system-monitoring-agent/internal/agentdb"
+)
+
+// Store persists the approved baseline in the agent's SQLite database
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore opens the baseline tables in metrics.db under storageDir
+func NewStore(storageDir string) (*Store, error) {
+	db, err := agentdb.Open(storageDir)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS fim_roots (
+			root TEXT PRIMARY KEY,
+			baselined_at DATETIME NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS fim_baseline (
+			root TEXT NOT NULL,
+			path TEXT NOT NULL,
+			sha256 TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			mode INTEGER NOT NULL,
+			uid TEXT NOT NULL,
+			gid TEXT NOT NULL,
+			mod_time DATETIME NOT NULL,
+			PRIMARY KEY (root, path)
+		);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create fim tables: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// HasRoot reports whether a configured path pattern has been baselined
+func (s *Store) HasRoot(root string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM fim_roots WHERE root = ?", root).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to query fim root: %w", err)
+	}
+	return count > 0, nil
+}
+
+// Load returns the baseline entries recorded for a root, keyed by path
+func (s *Store) Load(root string) (map[string]Entry, error) {
+	rows, err := s.db.Query(`
+		SELECT path, sha256, size, mode, uid, gid, mod_time
+		FROM fim_baseline
+		WHERE root = ?
+	`, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fim baseline: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make(map[string]Entry)
+	for rows.Next() {
+		var entry Entry
+		var mode uint32
+		if err := rows.Scan(&entry.Path, &entry.SHA256, &entry.Size, &mode, &entry.UID, &entry.GID, &entry.ModTime); err != nil {
+			return nil, fmt.Errorf("failed to scan fim baseline row: %w", err)
+		}
+		entry.Mode = os.FileMode(mode)
+		entries[entry.Path] = entry
+	}
+	return entries, rows.Err()
+}
+
+// Replace records entries as the new baseline for a root, discarding any
+// previous baseline for it
+func (s *Store) Replace(root string, entries []Entry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin fim baseline update: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM fim_baseline WHERE root = ?", root); err != nil {
+		return fmt.Errorf("failed to clear fim baseline: %w", err)
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO fim_baseline (path, root, sha256, size, mode, uid, gid, mod_time)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare fim baseline insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		if _, err := stmt.Exec(entry.Path, root, entry.SHA256, entry.Size, uint32(entry.Mode), entry.UID, entry.GID, entry.ModTime); err != nil {
+			return fmt.Errorf("failed to store fim baseline for %s: %w", entry.Path, err)
+		}
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO fim_roots (root, baselined_at) VALUES (?, ?)", root, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to record fim root: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Forget removes the baseline of every root not in keep, e.g. paths removed
+// from the configuration
+func (s *Store) Forget(keep []string) error {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(keep)), ",")
+	args := make([]interface{}, len(keep))
+	for i, root := range keep {
+		args[i] = root
+	}
+	where := ""
+	if len(keep) > 0 {
+		where = " WHERE root NOT IN (" + placeholders + ")"
+	}
+
+	if _, err := s.db.Exec("DELETE FROM fim_baseline"+where, args...); err != nil {
+		return fmt.Errorf("failed to prune fim baseline: %w", err)
+	}
+	if _, err := s.db.Exec("DELETE FROM fim_roots"+where, args...); err != nil {
+		return fmt.Errorf("failed to prune fim roots: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
@@ -2,26 +2,38 @@
 package metrics
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"reflect"
 	"runtime"
 	"time"
 
 	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/authlog"
+	"github.com/travism26/system-monitoring-agent/internal/baseline"
 	"github.com/travism26/system-monitoring-agent/internal/config"
 	"github.com/travism26/system-monitoring-agent/internal/core"
+	"github.com/travism26/system-monitoring-agent/internal/external"
+	"github.com/travism26/system-monitoring-agent/internal/fim"
 	"github.com/travism26/system-monitoring-agent/internal/metrics/collectors"
+	"github.com/travism26/system-monitoring-agent/internal/persistence"
 	"github.com/travism26/system-monitoring-agent/internal/threat"
 )
 
 type MetricsCollector struct {
-	collectors  []MetricCollector
-	config      *config.Config
-	lastNetwork map[string]core.NetworkStats
-	lastCheck   time.Time
-	analyzer    *threat.Analyzer
-	tenantID    string
-	tenantMeta  map[string]string
+	monitor    core.SystemMonitor
+	collectors []MetricCollector
+	config     *config.Config
+	analyzer   *threat.Analyzer
+	baselines  *baseline.Tracker
+	queue      QueueStatsSource
+	runner     *collectorRunner
+	tenantID   string
+	tenantMeta map[string]string
 }
 
 func NewMetricsCollector(monitor core.SystemMonitor, cfg *config.Config) *MetricsCollector {
@@ -33,53 +45,234 @@ func NewMetricsCollector(monitor core.SystemMonitor, cfg *config.Config) *Metric
 	collectors := []MetricCollector{
 		collectors.NewCPUCollector(monitor),
 		collectors.NewMemoryCollector(monitor),
-		collectors.NewDiskCollector(monitor),
+		collectors.NewDiskCollector(monitor, cfg.DiskFilters),
 		collectors.NewNetworkCollector(monitor),
 		collectors.NewProcessCollector(monitor),
+		collectors.NewConnectionCollector(monitor),
 	}
+	if persistenceCollector := newPersistenceCollector(cfg); persistenceCollector != nil {
+		collectors = append(collectors, persistenceCollector)
+	}
+	if fimCollector := newFIMCollector(cfg); fimCollector != nil {
+		collectors = append(collectors, fimCollector)
+	}
+	if authCollector := newAuthCollector(cfg); authCollector != nil {
+		collectors = append(collectors, authCollector)
+	}
+	collectors = append(collectors, newExternalCollectors(cfg)...)
 
 	return &MetricsCollector{
-		collectors:  collectors,
-		config:      cfg,
-		lastNetwork: make(map[string]core.NetworkStats),
-		lastCheck:   time.Now(),
-		analyzer:    threat.NewAnalyzer(),
-		tenantID:    cfg.Tenant.ID,
-		tenantMeta:  tenantMeta,
+		monitor:    monitor,
+		collectors: collectors,
+		config:     cfg,
+		analyzer:   threat.NewAnalyzerFromConfig(cfg),
+		baselines:  newBaselineTracker(cfg),
+		runner:     newCollectorRunner(),
+		tenantID:   cfg.Tenant.ID,
+		tenantMeta: tenantMeta,
+	}
+}
+
+// SetQueueStats reports the offline queue's depth and drop counts in the
+// metadata of each payload
+func (mc *MetricsCollector) SetQueueStats(source QueueStatsSource) {
+	mc.queue = source
+}
+
+// Reload brings the collectors in line with a reloaded configuration.
+// Enabled metrics and tenant metadata are read on every collection; changed
+// thresholds or threat rules need a new analyzer, which carries over the
+// processes already reported, changed disk filters a new disk collector, and
+// newly enabled persistence, FIM or auth log monitoring opens its state.
+// Changed FIM, auth log and baseline settings and external collectors are
+// replaced, and the replaced ones closed once any run of theirs that missed
+// its deadline returns. Reload runs between collections, so it doesn't
+// otherwise lock.
+func (mc *MetricsCollector) Reload(previous *config.Config) {
+	cfg := mc.config
+	if !reflect.DeepEqual(previous.Thresholds, cfg.Thresholds) ||
+		!reflect.DeepEqual(previous.ThreatDetection, cfg.ThreatDetection) {
+		analyzer := threat.NewAnalyzerFromConfig(cfg)
+		analyzer.KeepReported(mc.analyzer)
+		mc.analyzer = analyzer
+	}
+
+	if !reflect.DeepEqual(previous.DiskFilters, cfg.DiskFilters) {
+		for i, collector := range mc.collectors {
+			if collector.Name() == "disk" {
+				mc.collectors[i] = collectors.NewDiskCollector(mc.monitor, cfg.DiskFilters)
+			}
+		}
+	}
+
+	// FIM baselines and auth log offsets are stored, so the new collectors
+	// carry on from them
+	if !reflect.DeepEqual(previous.FIM, cfg.FIM) {
+		mc.removeCollector("fim")
+	}
+	if !reflect.DeepEqual(previous.AuthLog, cfg.AuthLog) {
+		mc.removeCollector("auth")
+	}
+	if !mc.hasCollector("persistence") {
+		if persistenceCollector := newPersistenceCollector(cfg); persistenceCollector != nil {
+			mc.collectors = append(mc.collectors, persistenceCollector)
+		}
+	}
+	if !mc.hasCollector("fim") {
+		if fimCollector := newFIMCollector(cfg); fimCollector != nil {
+			mc.collectors = append(mc.collectors, fimCollector)
+		}
+	}
+	if !mc.hasCollector("auth") {
+		if authCollector := newAuthCollector(cfg); authCollector != nil {
+			mc.collectors = append(mc.collectors, authCollector)
+		}
+	}
+	// Baselines are saved as they are observed, so a new tracker picks up
+	// where the old one left off
+	if mc.baselines == nil || !reflect.DeepEqual(previous.Baseline, cfg.Baseline) {
+		if mc.baselines != nil {
+			mc.baselines.Close()
+		}
+		mc.baselines = newBaselineTracker(cfg)
+	}
+
+	if !reflect.DeepEqual(previous.Collectors.External, cfg.Collectors.External) {
+		kept := mc.collectors[:0]
+		for _, collector := range mc.collectors {
+			if external, ok := collector.(*collectors.ExternalCollector); ok {
+				mc.closeCollector(external)
+			} else {
+				kept = append(kept, collector)
+			}
+		}
+		mc.collectors = append(kept, newExternalCollectors(cfg)...)
+	}
+}
+
+// mergeMetric adds a collector's top-level metric. Collectors that share a
+// key, as external collectors share "external", have their maps combined.
+func mergeMetric(metrics map[string]interface{}, key string, value interface{}) {
+	existing, ok := metrics[key].(map[string]interface{})
+	incoming, ok2 := value.(map[string]interface{})
+	if !ok || !ok2 {
+		metrics[key] = value
+		return
+	}
+	merged := make(map[string]interface{}, len(existing)+len(incoming))
+	for k, v := range existing {
+		merged[k] = v
 	}
+	for k, v := range incoming {
+		merged[k] = v
+	}
+	metrics[key] = merged
+}
+
+// removeCollector drops the named collector, closing it if it holds state
+func (mc *MetricsCollector) removeCollector(name string) {
+	kept := mc.collectors[:0]
+	for _, collector := range mc.collectors {
+		if collector.Name() != name {
+			kept = append(kept, collector)
+		} else {
+			mc.closeCollector(collector)
+		}
+	}
+	mc.collectors = kept
+}
+
+// closeCollector closes a replaced collector that holds state. A run of it
+// still going may be using its database, so it is closed when that returns.
+func (mc *MetricsCollector) closeCollector(collector MetricCollector) {
+	closer, ok := collector.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := mc.runner.closeWhenIdle(collector.Name(), closer); err != nil {
+		log.Printf("[ERROR] Closing replaced %s collector: %v", collector.Name(), err)
+	}
+}
+
+// Wait waits until ctx is done for collector runs that outlived their cycle,
+// such as those abandoned at their deadline or cut short by cancellation.
+// Collection must have stopped.
+func (mc *MetricsCollector) Wait(ctx context.Context) error {
+	return mc.runner.wait(ctx)
+}
+
+// Close closes the collectors that hold state, such as the FIM and auth log
+// databases and external collectors' commands, and the baseline tracker. A
+// collector whose run is still going is closed when that returns. Collection
+// must have stopped.
+func (mc *MetricsCollector) Close() error {
+	var errs []error
+	for _, collector := range mc.collectors {
+		if closer, ok := collector.(io.Closer); ok {
+			errs = append(errs, mc.runner.closeWhenIdle(collector.Name(), closer))
+		}
+	}
+	mc.collectors = nil
+	if mc.baselines != nil {
+		errs = append(errs, mc.baselines.Close())
+		mc.baselines = nil
+	}
+	return errors.Join(errs...)
+}
+
+func (mc *MetricsCollector) hasCollector(name string) bool {
+	for _, collector := range mc.collectors {
+		if collector.Name() == name {
+			return true
+		}
+	}
+	return false
 }
 
 func (mc *MetricsCollector) Collect() types.MetricPayload {
+	return mc.CollectContext(context.Background())
+}
+
+// CollectContext collects a payload, stopping the collectors still running
+// when ctx is cancelled
+func (mc *MetricsCollector) CollectContext(ctx context.Context) types.MetricPayload {
 	now := time.Now()
 	metrics := make(map[string]interface{})
 	var collectionErrors []string
 	var processData interface{}
+	var collectorIndicators []types.ThreatIndicator
 
 	// Get enabled metrics from tenant configuration
 	enabledMetrics := make(map[string]bool)
 	for _, metric := range mc.config.Tenant.CollectionRules.EnabledMetrics {
 		enabledMetrics[metric] = true
 	}
+	// External collectors are enabled by being configured
+	for _, c := range mc.config.Collectors.External {
+		enabledMetrics["external."+c.Name] = true
+	}
 
-	// Collect from each collector
-	for _, collector := range mc.collectors {
-		// Skip if metric type is not enabled for this tenant
-		if !enabledMetrics[collector.Name()] {
+	// Collect from every enabled collector at once, each under its own
+	// deadline, then merge the results in collector order
+	results, health := mc.runner.run(ctx, mc.collectors, enabledMetrics, mc.config.Collectors)
+	for i, result := range results {
+		collectorIndicators = append(collectorIndicators, result.late...)
+		if !result.ran {
 			continue
 		}
-
-		if data, err := collector.Collect(); err == nil {
-			// Special handling for process collector
-			if collector.Name() == "processes" {
-				processData = data["processes"]
-				continue
-			}
-			// Add other metrics to the metrics map
-			for k, v := range data {
-				metrics[k] = v
-			}
-		} else {
-			collectionErrors = append(collectionErrors, err.Error())
+		if result.err != nil {
+			collectionErrors = append(collectionErrors, result.err.Error())
+			continue
+		}
+		collectorIndicators = append(collectorIndicators, result.indicators...)
+		// Special handling for process collector
+		if mc.collectors[i].Name() == "processes" {
+			processData = result.data["processes"]
+			continue
+		}
+		// Add other metrics to the metrics map
+		for k, v := range result.data {
+			mergeMetric(metrics, k, v)
 		}
 	}
 
@@ -94,6 +287,7 @@ func (mc *MetricsCollector) Collect() types.MetricPayload {
 		"tenant_name":    mc.config.Tenant.Name,
 		"sample_rate":    fmt.Sprintf("%d", mc.config.Tenant.CollectionRules.SampleRate),
 		"retention_days": fmt.Sprintf("%d", mc.config.Tenant.CollectionRules.RetentionDays),
+		"config_version": mc.config.Revision,
 	}
 
 	// Initialize all required structs
@@ -135,15 +329,40 @@ func (mc *MetricsCollector) Collect() types.MetricPayload {
 			processes.List = procList
 		}
 	}
+	threatIndicators = append(threatIndicators, mc.analyzer.AnalyzeProcesses(processes.List)...)
+	threatIndicators = append(threatIndicators, collectorIndicators...)
+
+	// Compare this cycle against the host's own history
+	if mc.baselines != nil && mc.config.Baseline.Enabled {
+		values := baselineValues(metrics, processData != nil, processes.TotalCount)
+		anomalies, err := mc.baselines.Observe(values, now)
+		if err != nil {
+			collectionErrors = append(collectionErrors, err.Error())
+		}
+		threatIndicators = append(threatIndicators, mc.analyzer.AnalyzeAnomalies(anomalies)...)
+	}
+
+	var queue *types.QueueStats
+	if mc.queue != nil {
+		if stats, err := mc.queue.Stats(); err == nil {
+			queue = &stats
+		} else {
+			collectionErrors = append(collectionErrors, err.Error())
+		}
+	}
 
 	metadata := struct {
-		CollectionDuration string   `json:"collection_duration"`
-		CollectorCount     int      `json:"collector_count"`
-		Errors             []string `json:"errors,omitempty"`
+		CollectionDuration string                           `json:"collection_duration"`
+		CollectorCount     int                              `json:"collector_count"`
+		Errors             []string                         `json:"errors,omitempty"`
+		Queue              *types.QueueStats                `json:"queue,omitempty"`
+		Collectors         map[string]types.CollectorHealth `json:"collectors,omitempty"`
 	}{
 		CollectionDuration: time.Since(now).String(),
 		CollectorCount:     len(mc.collectors),
 		Errors:             collectionErrors,
+		Queue:              queue,
+		Collectors:         health,
 	}
 
 	// Create the final payload with all initialized structs
@@ -161,6 +380,166 @@ func (mc *MetricsCollector) Collect() types.MetricPayload {
 	return payload
 }
 
+// newPersistenceCollector opens the stored persistence inventory when the
+// "persistence" metric is enabled
+func newPersistenceCollector(cfg *config.Config) MetricCollector {
+	if !metricEnabled(cfg, "persistence") {
+		return nil
+	}
+	store, err := persistence.NewStore(cfg.HTTP.StorageDir)
+	if err != nil {
+		log.Printf("Persistence monitoring disabled: %v", err)
+		return nil
+	}
+	return collectors.NewPersistenceCollector(persistence.NewInventory("/", store))
+}
+
+// newFIMCollector opens the FIM baseline when the "fim" metric is enabled. It
+// lives in SQLite, so it isn't opened otherwise.
+func newFIMCollector(cfg *config.Config) MetricCollector {
+	if !metricEnabled(cfg, "fim") {
+		return nil
+	}
+	store, err := fim.NewStore(cfg.HTTP.StorageDir)
+	if err != nil {
+		log.Printf("File integrity monitoring disabled: %v", err)
+		return nil
+	}
+	return collectors.NewFIMCollector(fim.NewMonitor(store, cfg.FIM.Paths, cfg.FIM.ExcludePaths))
+}
+
+// newAuthCollector opens the auth log offsets when the "auth" metric is
+// enabled
+func newAuthCollector(cfg *config.Config) MetricCollector {
+	if !metricEnabled(cfg, "auth") {
+		return nil
+	}
+	offsets, err := authlog.NewOffsetStore(cfg.HTTP.StorageDir)
+	if err != nil {
+		log.Printf("Auth log monitoring disabled: %v", err)
+		return nil
+	}
+	detector := authlog.NewDetector(
+		time.Duration(cfg.AuthLog.Window)*time.Second,
+		cfg.AuthLog.BruteForceThreshold,
+		cfg.AuthLog.SprayThreshold,
+	)
+	return collectors.NewAuthCollector(authlog.NewMonitor(cfg.AuthLog.Paths, offsets, detector))
+}
+
+// newExternalCollectors prepares the configured external collectors. One
+// whose command can't be run is left out, as the other collectors can still
+// report.
+func newExternalCollectors(cfg *config.Config) []MetricCollector {
+	var externals []MetricCollector
+	for _, c := range cfg.Collectors.External {
+		timeout := time.Duration(c.Timeout) * time.Second
+		command, err := external.NewCommand(external.Config{
+			Name:    c.Name,
+			Command: c.Command,
+			Args:    c.Args,
+			Env:     c.Env,
+			Dir:     c.Dir,
+			Timeout: timeout,
+			User:    c.User,
+			Limits: external.Limits{
+				MaxOutput:  c.Limits.MaxOutput,
+				MemoryMB:   c.Limits.MemoryMB,
+				CPUSeconds: c.Limits.CPUSeconds,
+				OpenFiles:  c.Limits.OpenFiles,
+			},
+		})
+		if err != nil {
+			log.Printf("External collector disabled: %v", err)
+			continue
+		}
+		externals = append(externals, collectors.NewExternalCollector(command, time.Duration(c.Interval)*time.Second, timeout))
+	}
+	return externals
+}
+
+// newBaselineTracker loads the per-host baselines when baselining is enabled
+func newBaselineTracker(cfg *config.Config) *baseline.Tracker {
+	if !cfg.Baseline.Enabled {
+		return nil
+	}
+	metrics, err := baselineMetrics(cfg.Baseline.Directions)
+	if err != nil {
+		log.Printf("Baseline anomaly detection disabled: %v", err)
+		return nil
+	}
+	store, err := baseline.NewStore(cfg.HTTP.StorageDir)
+	if err != nil {
+		log.Printf("Baseline anomaly detection disabled: %v", err)
+		return nil
+	}
+	tracker, err := baseline.NewTracker(store, baseline.Config{
+		Alpha:         cfg.Baseline.Alpha,
+		Sigma:         cfg.Baseline.Sigma,
+		WarmupSamples: cfg.Baseline.WarmupSamples,
+		Seasonal:      cfg.Baseline.Seasonal,
+	}, metrics)
+	if err != nil {
+		store.Close()
+		log.Printf("Baseline anomaly detection disabled: %v", err)
+		return nil
+	}
+	return tracker
+}
+
+// baselineMetrics applies the configured directions to the default baselined
+// metrics
+func baselineMetrics(directions []config.BaselineDirection) ([]baseline.Metric, error) {
+	metrics := baseline.DefaultMetrics()
+	for _, d := range directions {
+		found := false
+		for i := range metrics {
+			if metrics[i].Name == d.Metric {
+				metrics[i].Direction = baseline.Direction(d.Direction)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown baseline metric %q", d.Metric)
+		}
+	}
+	return metrics, nil
+}
+
+// baselineValues picks the values tracked against per-host baselines out of
+// the collected metrics
+func baselineValues(metrics map[string]interface{}, haveProcesses bool, processCount int) map[string]float64 {
+	values := make(map[string]float64)
+	if cpu, ok := metrics["cpu_usage"].(float64); ok {
+		values["cpu_usage"] = cpu
+	}
+	if memory, ok := metrics["memory_usage_percent"].(float64); ok {
+		values["memory_usage_percent"] = memory
+	}
+	if network, ok := metrics["network"].(map[string]interface{}); ok {
+		if rates, ok := network["rates"].(map[string]float64); ok {
+			for _, name := range []string{"bytes_received_per_sec", "bytes_sent_per_sec"} {
+				if rate, ok := rates[name]; ok {
+					values["network."+name] = rate
+				}
+			}
+		}
+	}
+	if haveProcesses {
+		values["process_count"] = float64(processCount)
+	}
+	return values
+}
+
+func metricEnabled(cfg *config.Config, name string) bool {
+	for _, metric := range cfg.Tenant.CollectionRules.EnabledMetrics {
+		if metric == name {
+			return true
+		}
+	}
+	return false
+}
+
 func hostname() string {
 	name, err := os.Hostname()
 	if err != nil {
@@ -1,7 +1,42 @@
 // internal/metrics/collector_interface.go
 package metrics
 
+import (
+	"context"
+	"time"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+)
+
 type MetricCollector interface {
 	Collect() (map[string]interface{}, error)
 	Name() string
 }
+
+// ContextCollector is implemented by collectors that can stop early when
+// their deadline passes. Other collectors are abandoned at the deadline and
+// not run again until they return.
+type ContextCollector interface {
+	MetricCollector
+	CollectContext(ctx context.Context) (map[string]interface{}, error)
+}
+
+// TimeoutCollector is implemented by collectors configured with their own
+// deadline, which applies unless Collectors.Timeouts names the collector
+type TimeoutCollector interface {
+	MetricCollector
+	Timeout() time.Duration
+}
+
+// IndicatorCollector is implemented by collectors that also raise threat
+// indicators. Indicators returns those found by the latest Collect call.
+type IndicatorCollector interface {
+	MetricCollector
+	Indicators() []types.ThreatIndicator
+}
+
+// QueueStatsSource reports the state of the offline export queue so it can be
+// carried in the payload metadata
+type QueueStatsSource interface {
+	Stats() (types.QueueStats, error)
+}
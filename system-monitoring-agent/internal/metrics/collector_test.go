@@ -3,6 +3,8 @@ package metrics
 import (
 	"testing"
 
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/baseline"
 	"github.com/travism26/system-monitoring-agent/internal/config"
 	"github.com/travism26/system-monitoring-agent/internal/core"
 )
@@ -34,6 +36,10 @@ func (m *MockSystemMonitor) GetNetworkStats() (core.NetworkStats, error) {
 	return core.NetworkStats{}, nil
 }
 
+func (m *MockSystemMonitor) GetConnections() ([]core.ConnectionInfo, error) {
+	return []core.ConnectionInfo{}, nil
+}
+
 func (m *MockSystemMonitor) GetDiskUsage() (core.DiskStats, error) {
 	return core.DiskStats{
 		Total: 1024 * 1024 * 1024 * 100, // 100GB
@@ -60,8 +66,10 @@ func TestNewMetricsCollector(t *testing.T) {
 	if collector == nil {
 		t.Error("Expected non-nil collector, got nil")
 	}
-	if len(collector.collectors) != 5 {
-		t.Errorf("Expected 5 collectors, got %d", len(collector.collectors))
+	// Persistence, FIM and auth log collectors hold state and are only
+	// created when enabled
+	if len(collector.collectors) != 6 {
+		t.Errorf("Expected 6 collectors, got %d", len(collector.collectors))
 	}
 	if collector.config != cfg {
 		t.Error("Config not set correctly")
@@ -74,21 +82,22 @@ func TestNewMetricsCollector(t *testing.T) {
 func TestCollect(t *testing.T) {
 	mockMonitor := &MockSystemMonitor{}
 	cfg := &config.Config{Interval: 5}
+	cfg.Tenant.CollectionRules.EnabledMetrics = []string{"cpu", "memory", "disk", "network", "processes"}
 	collector := NewMetricsCollector(mockMonitor, cfg)
 
 	// Test successful collection
 	metrics := collector.Collect()
 
 	// Verify expected metrics are present
-	expectedMetrics := []string{"cpu", "memory", "disk", "network"}
+	expectedMetrics := []string{"cpu_usage", "memory_usage", "disk", "network"}
 	for _, metric := range expectedMetrics {
-		if _, exists := metrics.Data.Metrics[metric]; !exists {
+		if _, exists := metrics.Metrics[metric]; !exists {
 			t.Errorf("Expected %s metrics", metric)
 		}
 	}
 
 	// Verify processes data
-	if len(metrics.Data.Processes.ProcessList) == 0 {
+	if len(metrics.Processes.List) == 0 {
 		t.Error("Expected process metrics")
 	}
 
@@ -96,6 +105,30 @@ func TestCollect(t *testing.T) {
 	// TODO: Add error scenarios once we have error injection in the mock
 }
 
+type mockQueue struct{}
+
+func (mockQueue) Stats() (types.QueueStats, error) {
+	return types.QueueStats{Depth: 3, Bytes: 2048, DroppedEvicted: 1}, nil
+}
+
+func TestCollectReportsQueueStats(t *testing.T) {
+	cfg := &config.Config{Interval: 5}
+	collector := NewMetricsCollector(&MockSystemMonitor{}, cfg)
+
+	if payload := collector.Collect(); payload.Metadata.Queue != nil {
+		t.Error("Expected no queue stats without a queue")
+	}
+
+	collector.SetQueueStats(mockQueue{})
+	queue := collector.Collect().Metadata.Queue
+	if queue == nil {
+		t.Fatal("Expected queue stats in metadata")
+	}
+	if queue.Depth != 3 || queue.Bytes != 2048 || queue.DroppedEvicted != 1 {
+		t.Errorf("Unexpected queue stats: %+v", *queue)
+	}
+}
+
 func TestHostname(t *testing.T) {
 	host := hostname()
 	if host == "" {
@@ -107,3 +140,135 @@ func TestErrorHandling(t *testing.T) {
 	// TODO: Implement error handling tests
 	// This will require modifying the mock to support error injection
 }
+
+type externalStub struct{ name string }
+
+func (c externalStub) Name() string { return "external." + c.name }
+
+func (c externalStub) Collect() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"external": map[string]interface{}{c.name: map[string]interface{}{"up": 1.0}},
+	}, nil
+}
+
+func TestCollectMergesExternalMetrics(t *testing.T) {
+	cfg := &config.Config{Interval: 5}
+	cfg.Tenant.CollectionRules.EnabledMetrics = []string{"external.vendor", "external.backup"}
+	collector := NewMetricsCollector(&MockSystemMonitor{}, cfg)
+	collector.collectors = []MetricCollector{externalStub{"vendor"}, externalStub{"backup"}}
+
+	external, ok := collector.Collect().Metrics["external"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected an external metrics map")
+	}
+	for _, name := range []string{"vendor", "backup"} {
+		if _, exists := external[name]; !exists {
+			t.Errorf("Expected metrics from external collector %s, got %v", name, external)
+		}
+	}
+}
+
+func TestBaselineMetricsAppliesDirections(t *testing.T) {
+	metrics, err := baselineMetrics([]config.BaselineDirection{{Metric: "process_count", Direction: "both"}})
+	if err != nil {
+		t.Fatalf("baselineMetrics: %v", err)
+	}
+	for _, m := range metrics {
+		want := baseline.Direction("")
+		if m.Name == "process_count" {
+			want = baseline.Both
+		}
+		if m.Direction != want {
+			t.Errorf("%s direction = %q, want %q", m.Name, m.Direction, want)
+		}
+	}
+
+	if _, err := baselineMetrics([]config.BaselineDirection{{Metric: "load_average", Direction: "below"}}); err == nil {
+		t.Error("expected an error for an unknown metric")
+	}
+}
+
+func TestReloadReplacesChangedFIMAndAuthCollectors(t *testing.T) {
+	storageDir := t.TempDir()
+	newConfig := func(fimPaths []string, bruteForce int) *config.Config {
+		cfg := &config.Config{Interval: 5}
+		cfg.HTTP.StorageDir = storageDir
+		cfg.Tenant.CollectionRules.EnabledMetrics = []string{"fim", "auth"}
+		cfg.FIM.Paths = fimPaths
+		cfg.AuthLog.BruteForceThreshold = bruteForce
+		return cfg
+	}
+	find := func(mc *MetricsCollector, name string) MetricCollector {
+		for _, collector := range mc.collectors {
+			if collector.Name() == name {
+				return collector
+			}
+		}
+		t.Fatalf("no %s collector", name)
+		return nil
+	}
+
+	previous := newConfig([]string{storageDir}, 5)
+	mc := NewMetricsCollector(&MockSystemMonitor{}, previous)
+	defer mc.Close()
+	oldFIM, oldAuth := find(mc, "fim"), find(mc, "auth")
+
+	// Unchanged sections keep their collectors
+	mc.config = newConfig([]string{storageDir}, 5)
+	mc.Reload(previous)
+	if find(mc, "fim") != oldFIM || find(mc, "auth") != oldAuth {
+		t.Error("collectors replaced without a config change")
+	}
+
+	previous = mc.config
+	mc.config = newConfig([]string{t.TempDir()}, 10)
+	mc.Reload(previous)
+	if len(mc.collectors) != 8 {
+		t.Errorf("Expected 8 collectors, got %d", len(mc.collectors))
+	}
+	if find(mc, "fim") == oldFIM || find(mc, "auth") == oldAuth {
+		t.Error("collectors not replaced after their sections changed")
+	}
+	if _, err := oldFIM.Collect(); err == nil {
+		t.Error("replaced FIM collector was not closed")
+	}
+	if _, err := find(mc, "fim").Collect(); err != nil {
+		t.Errorf("new FIM collector: %v", err)
+	}
+}
+
+func TestCloseClosesCollectors(t *testing.T) {
+	cfg := &config.Config{Interval: 5}
+	cfg.HTTP.StorageDir = t.TempDir()
+	cfg.Tenant.CollectionRules.EnabledMetrics = []string{"fim", "persistence"}
+	cfg.FIM.Paths = []string{cfg.HTTP.StorageDir}
+	cfg.Baseline.Enabled = true
+	cfg.Baseline.Alpha, cfg.Baseline.Sigma = 0.02, 3
+	mc := NewMetricsCollector(&MockSystemMonitor{}, cfg)
+
+	var fimCollector, persistenceCollector MetricCollector
+	for _, collector := range mc.collectors {
+		switch collector.Name() {
+		case "fim":
+			fimCollector = collector
+		case "persistence":
+			persistenceCollector = collector
+		}
+	}
+	if fimCollector == nil || persistenceCollector == nil || mc.baselines == nil {
+		t.Fatal("expected FIM and persistence collectors and baseline tracker")
+	}
+
+	if err := mc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := fimCollector.Collect(); err == nil {
+		t.Error("FIM collector was not closed")
+	}
+	if _, err := persistenceCollector.Collect(); err == nil {
+		t.Error("persistence collector was not closed")
+	}
+	if mc.baselines != nil || len(mc.collectors) != 0 {
+		t.Error("collectors kept after Close")
+	}
+}
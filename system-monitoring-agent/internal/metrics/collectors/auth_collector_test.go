@@ -0,0 +1,51 @@
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/system-monitoring-agent/internal/authlog"
+)
+
+func TestAuthCollectorEventsAndIndicators(t *testing.T) {
+	offsets, err := authlog.NewOffsetStore(t.TempDir())
+	require.NoError(t, err)
+	defer offsets.Close()
+
+	logPath := filepath.Join(t.TempDir(), "auth.log")
+	require.NoError(t, os.WriteFile(logPath, nil, 0640))
+
+	monitor := authlog.NewMonitor([]string{logPath}, offsets, authlog.NewDetector(5*time.Minute, 3, 10))
+	collector := NewAuthCollector(monitor)
+	_, err = collector.Collect()
+	require.NoError(t, err)
+
+	stamp := time.Now().Format(time.Stamp)
+	var lines []string
+	for i := 0; i < 3; i++ {
+		lines = append(lines, stamp+" web1 sshd[10]: Failed password for root from 203.0.113.9 port 4000 ssh2")
+	}
+	lines = append(lines, stamp+" web1 sshd[11]: Accepted password for root from 203.0.113.9 port 4001 ssh2")
+	require.NoError(t, os.WriteFile(logPath, []byte(strings.Join(lines, "\n")+"\n"), 0640))
+
+	data, err := collector.Collect()
+	require.NoError(t, err)
+	auth := data["auth"].(map[string]interface{})
+	assert.Equal(t, 4, auth["event_count"])
+	assert.Equal(t, map[string]int{authlog.EventLoginFailure: 3, authlog.EventLoginSuccess: 1}, auth["event_counts"])
+	assert.Len(t, auth["events"], 4)
+
+	indicators := collector.Indicators()
+	require.Len(t, indicators, 2)
+	assert.Equal(t, "ssh_brute_force", indicators[0].Type)
+	assert.Equal(t, "medium", indicators[0].Severity)
+	assert.Equal(t, "ssh_brute_force_success", indicators[1].Type)
+	assert.Equal(t, "high", indicators[1].Severity)
+	assert.Equal(t, "root", indicators[1].Details["user"])
+	assert.Equal(t, "203.0.113.9", indicators[1].Details["source_ip"])
+}
@@ -0,0 +1,174 @@
+// internal/metrics/collectors/connection_collector.go
+package collectors
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/travism26/system-monitoring-agent/internal/core"
+)
+
+// IANA dynamic port range, used when the monitor can't read the host's
+const (
+	defaultEphemeralLow  = 49152
+	defaultEphemeralHigh = 65535
+)
+
+type ConnectionCollector struct {
+	monitor          core.ConnectionMonitor
+	initialized      bool
+	lastListeners    map[string]bool
+	lastDestinations map[string]bool
+	lastUDPBinds     map[string]bool
+}
+
+func NewConnectionCollector(monitor core.ConnectionMonitor) *ConnectionCollector {
+	return &ConnectionCollector{
+		monitor:          monitor,
+		lastListeners:    make(map[string]bool),
+		lastDestinations: make(map[string]bool),
+		lastUDPBinds:     make(map[string]bool),
+	}
+}
+
+func (c *ConnectionCollector) Name() string {
+	return "connections"
+}
+
+// Collect reports every TCP/UDP socket, the listening ports, and the listeners
+// and outbound destinations that appeared since the previous cycle. The first
+// cycle only records the baseline, so nothing is reported as new. A UDP socket
+// without a peer is only a listener when it is bound outside the ephemeral
+// port range or was already bound in the previous cycle, so the short-lived
+// sockets of DNS lookups and the like aren't reported.
+func (c *ConnectionCollector) Collect() (map[string]interface{}, error) {
+	connections, err := c.monitor.GetConnections()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(connections, func(i, j int) bool {
+		a, b := connections[i], connections[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.LocalPort < b.LocalPort
+	})
+
+	// Local ports with a listener, used to tell accepted inbound connections
+	// apart from outbound ones
+	listeningPorts := make(map[string]bool)
+	for _, conn := range connections {
+		if conn.IsListener() || conn.IsUDPBind() {
+			listeningPorts[fmt.Sprintf("%s/%d", conn.Protocol, conn.LocalPort)] = true
+		}
+	}
+	ephemeralLow, ephemeralHigh := c.ephemeralPorts()
+
+	sockets := make([]map[string]interface{}, 0, len(connections))
+	listeners := []map[string]interface{}{}
+	newListeners := []map[string]interface{}{}
+	newDestinations := []map[string]interface{}{}
+	currentListeners := make(map[string]bool)
+	currentDestinations := make(map[string]bool)
+	currentUDPBinds := make(map[string]bool)
+
+	for _, conn := range connections {
+		sockets = append(sockets, map[string]interface{}{
+			"protocol":       conn.Protocol,
+			"local_address":  conn.LocalAddr,
+			"local_port":     conn.LocalPort,
+			"remote_address": conn.RemoteAddr,
+			"remote_port":    conn.RemotePort,
+			"state":          conn.State,
+			"pid":            conn.PID,
+		})
+
+		// Keyed without the PID so a restarted daemon isn't a new listener
+		key := fmt.Sprintf("%s/%s/%d", conn.Protocol, conn.LocalAddr, conn.LocalPort)
+		listening := conn.IsListener()
+		if conn.IsUDPBind() {
+			currentUDPBinds[key] = true
+			ephemeral := conn.LocalPort >= ephemeralLow && conn.LocalPort <= ephemeralHigh
+			listening = !ephemeral || c.lastUDPBinds[key]
+		}
+		if listening {
+			if currentListeners[key] {
+				continue
+			}
+			currentListeners[key] = true
+			listener := map[string]interface{}{
+				"protocol": conn.Protocol,
+				"address":  conn.LocalAddr,
+				"port":     conn.LocalPort,
+				"pid":      conn.PID,
+			}
+			listeners = append(listeners, listener)
+			if c.initialized && !c.lastListeners[key] {
+				newListeners = append(newListeners, listener)
+			}
+			continue
+		}
+
+		if !isOutbound(conn, listeningPorts) {
+			continue
+		}
+		key = fmt.Sprintf("%s/%s/%d", conn.Protocol, conn.RemoteAddr, conn.RemotePort)
+		if currentDestinations[key] {
+			continue
+		}
+		currentDestinations[key] = true
+		if c.initialized && !c.lastDestinations[key] {
+			newDestinations = append(newDestinations, map[string]interface{}{
+				"protocol":       conn.Protocol,
+				"remote_address": conn.RemoteAddr,
+				"remote_port":    conn.RemotePort,
+				"pid":            conn.PID,
+			})
+		}
+	}
+
+	c.lastListeners = currentListeners
+	c.lastDestinations = currentDestinations
+	c.lastUDPBinds = currentUDPBinds
+	c.initialized = true
+
+	return map[string]interface{}{
+		"connections": map[string]interface{}{
+			"total_count":      len(sockets),
+			"sockets":          sockets,
+			"listeners":        listeners,
+			"new_listeners":    newListeners,
+			"new_destinations": newDestinations,
+		},
+	}, nil
+}
+
+// ephemeralPorts returns the local port range the kernel assigns to client
+// sockets
+func (c *ConnectionCollector) ephemeralPorts() (uint16, uint16) {
+	if m, ok := c.monitor.(core.EphemeralPortMonitor); ok {
+		if low, high, err := m.GetEphemeralPortRange(); err == nil {
+			return low, high
+		}
+	}
+	return defaultEphemeralLow, defaultEphemeralHigh
+}
+
+// isOutbound reports whether a socket is a live connection this host opened to
+// a non-loopback peer
+func isOutbound(conn core.ConnectionInfo, listeningPorts map[string]bool) bool {
+	if conn.RemotePort == 0 {
+		return false
+	}
+	if strings.HasPrefix(conn.Protocol, "tcp") && conn.State != "ESTABLISHED" && conn.State != "SYN_SENT" {
+		return false
+	}
+	if listeningPorts[fmt.Sprintf("%s/%d", conn.Protocol, conn.LocalPort)] {
+		return false
+	}
+	ip := net.ParseIP(conn.RemoteAddr)
+	return ip != nil && !ip.IsLoopback() && !ip.IsUnspecified()
+}
@@ -0,0 +1,150 @@
+package collectors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/system-monitoring-agent/internal/core"
+)
+
+// MockConnectionMonitor returns a scripted sequence of socket snapshots
+type MockConnectionMonitor struct {
+	samples [][]core.ConnectionInfo
+}
+
+func (m *MockConnectionMonitor) GetConnections() ([]core.ConnectionInfo, error) {
+	sample := m.samples[0]
+	m.samples = m.samples[1:]
+	return sample, nil
+}
+
+func connectionsData(t *testing.T, data map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	connections, ok := data["connections"].(map[string]interface{})
+	require.True(t, ok)
+	return connections
+}
+
+func TestConnectionCollectorChanges(t *testing.T) {
+	sshd := core.ConnectionInfo{Protocol: "tcp", LocalAddr: "0.0.0.0", LocalPort: 22, State: "LISTEN", PID: 1}
+	// Accepted connection on a listening port is inbound, not egress
+	inbound := core.ConnectionInfo{Protocol: "tcp", LocalAddr: "10.0.0.5", LocalPort: 22, RemoteAddr: "203.0.113.9", RemotePort: 51000, State: "ESTABLISHED", PID: 900}
+	web := core.ConnectionInfo{Protocol: "tcp", LocalAddr: "10.0.0.5", LocalPort: 40000, RemoteAddr: "93.184.216.34", RemotePort: 443, State: "ESTABLISHED", PID: 4242}
+
+	monitor := &MockConnectionMonitor{samples: [][]core.ConnectionInfo{
+		{sshd, inbound, web},
+		{
+			sshd, inbound, web,
+			{Protocol: "tcp", LocalAddr: "0.0.0.0", LocalPort: 4444, State: "LISTEN", PID: 31337},
+			{Protocol: "udp", LocalAddr: "0.0.0.0", LocalPort: 53, State: "CLOSE", PID: 101},
+			{Protocol: "tcp", LocalAddr: "10.0.0.5", LocalPort: 40001, RemoteAddr: "198.51.100.7", RemotePort: 8443, State: "SYN_SENT", PID: 31337},
+			{Protocol: "tcp", LocalAddr: "127.0.0.1", LocalPort: 40002, RemoteAddr: "127.0.0.1", RemotePort: 5432, State: "ESTABLISHED", PID: 4242},
+			{Protocol: "tcp", LocalAddr: "10.0.0.5", LocalPort: 40003, RemoteAddr: "192.0.2.1", RemotePort: 80, State: "TIME_WAIT"},
+		},
+	}}
+	collector := NewConnectionCollector(monitor)
+
+	// The first cycle is the baseline
+	data, err := collector.Collect()
+	require.NoError(t, err)
+	connections := connectionsData(t, data)
+	assert.Equal(t, 3, connections["total_count"])
+	assert.Len(t, connections["listeners"], 1)
+	assert.Empty(t, connections["new_listeners"])
+	assert.Empty(t, connections["new_destinations"])
+
+	data, err = collector.Collect()
+	require.NoError(t, err)
+	connections = connectionsData(t, data)
+	assert.Equal(t, 8, connections["total_count"])
+	assert.Len(t, connections["listeners"], 3)
+
+	newListeners := connections["new_listeners"].([]map[string]interface{})
+	require.Len(t, newListeners, 2)
+	assert.Equal(t, uint16(4444), newListeners[0]["port"])
+	assert.Equal(t, 31337, newListeners[0]["pid"])
+	assert.Equal(t, "udp", newListeners[1]["protocol"])
+
+	newDestinations := connections["new_destinations"].([]map[string]interface{})
+	require.Len(t, newDestinations, 1)
+	assert.Equal(t, "198.51.100.7", newDestinations[0]["remote_address"])
+	assert.Equal(t, uint16(8443), newDestinations[0]["remote_port"])
+}
+
+func TestConnectionCollectorListenerReturns(t *testing.T) {
+	listener := core.ConnectionInfo{Protocol: "tcp6", LocalAddr: "::", LocalPort: 8080, State: "LISTEN", PID: 10}
+	restarted := listener
+	restarted.PID = 11
+
+	monitor := &MockConnectionMonitor{samples: [][]core.ConnectionInfo{
+		{listener},
+		{restarted},
+		{},
+		{listener},
+	}}
+	collector := NewConnectionCollector(monitor)
+
+	_, err := collector.Collect()
+	require.NoError(t, err)
+
+	// A restarted daemon on the same port is not a new listener
+	data, err := collector.Collect()
+	require.NoError(t, err)
+	assert.Empty(t, connectionsData(t, data)["new_listeners"])
+
+	_, err = collector.Collect()
+	require.NoError(t, err)
+
+	// Reopened after a cycle without it
+	data, err = collector.Collect()
+	require.NoError(t, err)
+	assert.Len(t, connectionsData(t, data)["new_listeners"], 1)
+}
+
+// rangedConnectionMonitor also reports the host's ephemeral port range
+type rangedConnectionMonitor struct {
+	*MockConnectionMonitor
+	low, high uint16
+}
+
+func (m rangedConnectionMonitor) GetEphemeralPortRange() (uint16, uint16, error) {
+	return m.low, m.high, nil
+}
+
+func TestConnectionCollectorEphemeralUDPBinds(t *testing.T) {
+	dns := core.ConnectionInfo{Protocol: "udp", LocalAddr: "0.0.0.0", LocalPort: 53, PID: 101}
+	resolver := core.ConnectionInfo{Protocol: "udp", LocalAddr: "0.0.0.0", LocalPort: 40001, PID: 500}
+	lookup := core.ConnectionInfo{Protocol: "udp", LocalAddr: "0.0.0.0", LocalPort: 40002, PID: 500}
+
+	monitor := rangedConnectionMonitor{
+		MockConnectionMonitor: &MockConnectionMonitor{samples: [][]core.ConnectionInfo{
+			{},
+			{dns, resolver},
+			{dns, resolver, lookup},
+		}},
+		low:  32768,
+		high: 60999,
+	}
+	collector := NewConnectionCollector(monitor)
+
+	_, err := collector.Collect()
+	require.NoError(t, err)
+
+	// A fixed port is a listener straight away, a client socket's is not
+	data, err := collector.Collect()
+	require.NoError(t, err)
+	connections := connectionsData(t, data)
+	newListeners := connections["new_listeners"].([]map[string]interface{})
+	require.Len(t, newListeners, 1)
+	assert.Equal(t, uint16(53), newListeners[0]["port"])
+
+	// An ephemeral port still bound a cycle later is
+	data, err = collector.Collect()
+	require.NoError(t, err)
+	connections = connectionsData(t, data)
+	assert.Len(t, connections["listeners"], 2)
+	newListeners = connections["new_listeners"].([]map[string]interface{})
+	require.Len(t, newListeners, 1)
+	assert.Equal(t, uint16(40001), newListeners[0]["port"])
+}
@@ -1,32 +1,183 @@
 // internal/metrics/collectors/disk_collector.go
 package collectors
 
-import "github.com/travism26/system-monitoring-agent/internal/core"
+import (
+	"path"
+	"time"
+
+	"github.com/travism26/system-monitoring-agent/internal/config"
+	"github.com/travism26/system-monitoring-agent/internal/core"
+)
 
 type DiskCollector struct {
-	monitor core.DiskMonitor
+	monitor   core.DiskMonitor
+	filter    config.DiskFilterConfig
+	now       func() time.Time
+	last      map[string]core.DiskIOStats
+	lastCheck time.Time
 }
 
-func NewDiskCollector(monitor core.DiskMonitor) *DiskCollector {
-	return &DiskCollector{monitor: monitor}
+func NewDiskCollector(monitor core.DiskMonitor, filter config.DiskFilterConfig) *DiskCollector {
+	return &DiskCollector{
+		monitor: monitor,
+		filter:  filter,
+		now:     time.Now,
+		last:    make(map[string]core.DiskIOStats),
+	}
 }
 
 func (c *DiskCollector) Name() string {
 	return "disk"
 }
 
+// Collect reports root filesystem usage, usage of every mount that passes the
+// configured filters, and per-device I/O counters with rates since the
+// previous cycle. A monitor that can filter mounts never queries the
+// excluded ones, so a hung network mount can be excluded to keep it from
+// blocking collection.
 func (c *DiskCollector) Collect() (map[string]interface{}, error) {
-	diskStats, err := c.monitor.GetDiskUsage()
+	var diskStats core.DiskStats
+	var err error
+	if monitor, ok := c.monitor.(core.FilteredDiskMonitor); ok {
+		diskStats, err = monitor.GetDiskUsageFiltered(c.includeMount)
+	} else {
+		diskStats, err = c.monitor.GetDiskUsage()
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	mounts := make(map[string]interface{}, len(diskStats.Mounts))
+	for _, mount := range diskStats.Mounts {
+		if !c.includeMount(mount) {
+			continue
+		}
+		mounts[mount.Mountpoint] = map[string]interface{}{
+			"device":               mount.Device,
+			"fstype":               mount.FSType,
+			"total":                mount.Total,
+			"used":                 mount.Used,
+			"free":                 mount.Free,
+			"usage_percent":        percent(mount.Used, mount.Total),
+			"inodes_total":         mount.InodesTotal,
+			"inodes_used":          mount.InodesUsed,
+			"inodes_free":          mount.InodesFree,
+			"inodes_usage_percent": percent(mount.InodesUsed, mount.InodesTotal),
+		}
+	}
+
 	return map[string]interface{}{
 		"disk": map[string]interface{}{
 			"total":         diskStats.Total,
 			"used":          diskStats.Used,
 			"free":          diskStats.Free,
-			"usage_percent": float64(diskStats.Used) / float64(diskStats.Total) * 100,
+			"usage_percent": percent(diskStats.Used, diskStats.Total),
+			"mounts":        mounts,
+			"devices":       c.deviceIO(diskStats.Devices),
 		},
 	}, nil
 }
+
+// deviceIO builds the per-device entries and computes rates against the
+// previous sample. Devices seen for the first time have no rates.
+func (c *DiskCollector) deviceIO(devices []core.DiskIOStats) map[string]interface{} {
+	now := c.now()
+	elapsed := now.Sub(c.lastCheck).Seconds()
+	if c.lastCheck.IsZero() {
+		elapsed = 0
+	}
+
+	result := make(map[string]interface{}, len(devices))
+	current := make(map[string]core.DiskIOStats, len(devices))
+	for _, device := range devices {
+		current[device.Name] = device
+		entry := map[string]interface{}{
+			"read_bytes":  device.ReadBytes,
+			"write_bytes": device.WriteBytes,
+			"read_count":  device.ReadCount,
+			"write_count": device.WriteCount,
+			"io_time_ms":  device.IOTimeMs,
+		}
+
+		if prev, ok := c.last[device.Name]; ok && elapsed > 0 {
+			rates := make(map[string]float64)
+			if delta, ok := counterDelta(prev.ReadBytes, device.ReadBytes); ok {
+				rates["read_bytes_per_sec"] = float64(delta) / elapsed
+			}
+			if delta, ok := counterDelta(prev.WriteBytes, device.WriteBytes); ok {
+				rates["write_bytes_per_sec"] = float64(delta) / elapsed
+			}
+			if delta, ok := counterDelta(prev.ReadCount, device.ReadCount); ok {
+				rates["read_iops"] = float64(delta) / elapsed
+			}
+			if delta, ok := counterDelta(prev.WriteCount, device.WriteCount); ok {
+				rates["write_iops"] = float64(delta) / elapsed
+			}
+			if delta, ok := counterDelta(prev.IOTimeMs, device.IOTimeMs); ok {
+				rates["io_time_ms_per_sec"] = float64(delta) / elapsed
+				// Share of wall time the device had I/O in flight
+				rates["utilization_percent"] = float64(delta) / (elapsed * 1000) * 100
+			}
+			entry["rates"] = rates
+		}
+
+		result[device.Name] = entry
+	}
+
+	c.last = current
+	c.lastCheck = now
+	return result
+}
+
+// includeMount applies the configured fstype and mountpoint filters
+func (c *DiskCollector) includeMount(mount core.MountStats) bool {
+	if len(c.filter.IncludeFSTypes) > 0 && !containsString(c.filter.IncludeFSTypes, mount.FSType) {
+		return false
+	}
+	if len(c.filter.IncludeMountpoints) > 0 && !matchesAny(c.filter.IncludeMountpoints, mount.Mountpoint) {
+		return false
+	}
+	if containsString(c.filter.ExcludeFSTypes, mount.FSType) {
+		return false
+	}
+	return !matchesAny(c.filter.ExcludeMountpoints, mount.Mountpoint)
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether the mountpoint, or any directory above it other
+// than the root, equals or glob-matches one of the patterns, so
+// "/var/lib/docker/*" also covers the overlay mounts nested deeper under it
+func matchesAny(patterns []string, mountpoint string) bool {
+	for _, pattern := range patterns {
+		p := mountpoint
+		for {
+			if pattern == p {
+				return true
+			}
+			if ok, err := path.Match(pattern, p); err == nil && ok {
+				return true
+			}
+			parent := path.Dir(p)
+			if parent == p || parent == "/" || parent == "." {
+				break
+			}
+			p = parent
+		}
+	}
+	return false
+}
+
+func percent(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
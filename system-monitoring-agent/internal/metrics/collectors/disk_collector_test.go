@@ -0,0 +1,130 @@
+package collectors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+	"github.com/travism26/system-monitoring-agent/internal/core"
+)
+
+// MockDiskMonitor returns a scripted sequence of disk samples
+type MockDiskMonitor struct {
+	samples []core.DiskStats
+}
+
+func (m *MockDiskMonitor) GetDiskUsage() (core.DiskStats, error) {
+	sample := m.samples[0]
+	if len(m.samples) > 1 {
+		m.samples = m.samples[1:]
+	}
+	return sample, nil
+}
+
+var testMounts = []core.MountStats{
+	{Device: "/dev/sda1", Mountpoint: "/", FSType: "ext4", Total: 100, Used: 25, Free: 75, InodesTotal: 10, InodesUsed: 5},
+	{Device: "tmpfs", Mountpoint: "/run", FSType: "tmpfs", Total: 10, Used: 1, Free: 9},
+	{Device: "overlay", Mountpoint: "/var/lib/docker/overlay2/abc/merged", FSType: "overlay", Total: 100, Used: 25},
+	{Device: "/dev/loop0", Mountpoint: "/snap/core", FSType: "squashfs", Total: 5, Used: 5},
+	{Device: "/dev/sdb1", Mountpoint: "/data", FSType: "xfs", Total: 1000, Used: 900, Free: 100},
+}
+
+func collectMounts(t *testing.T, filter config.DiskFilterConfig) map[string]interface{} {
+	t.Helper()
+	collector := NewDiskCollector(&MockDiskMonitor{samples: []core.DiskStats{{Mounts: testMounts}}}, filter)
+	data, err := collector.Collect()
+	require.NoError(t, err)
+	return data["disk"].(map[string]interface{})["mounts"].(map[string]interface{})
+}
+
+func TestDiskCollectorMountFilters(t *testing.T) {
+	t.Run("no filters reports every mount", func(t *testing.T) {
+		assert.Len(t, collectMounts(t, config.DiskFilterConfig{}), len(testMounts))
+	})
+
+	t.Run("exclude fstypes and mountpoint globs", func(t *testing.T) {
+		mounts := collectMounts(t, config.DiskFilterConfig{
+			ExcludeFSTypes:     []string{"tmpfs", "squashfs"},
+			ExcludeMountpoints: []string{"/var/lib/docker/*"},
+		})
+		assert.Len(t, mounts, 2)
+		assert.Contains(t, mounts, "/")
+		assert.Contains(t, mounts, "/data")
+	})
+
+	t.Run("include lists restrict the result", func(t *testing.T) {
+		mounts := collectMounts(t, config.DiskFilterConfig{
+			IncludeFSTypes:     []string{"ext4", "xfs"},
+			ExcludeMountpoints: []string{"/"},
+		})
+		assert.Len(t, mounts, 1)
+		data := mounts["/data"].(map[string]interface{})
+		assert.Equal(t, "xfs", data["fstype"])
+		assert.InDelta(t, 90.0, data["usage_percent"], 0.001)
+	})
+
+	t.Run("include mountpoints", func(t *testing.T) {
+		mounts := collectMounts(t, config.DiskFilterConfig{IncludeMountpoints: []string{"/", "/d*"}})
+		assert.Len(t, mounts, 2)
+		root := mounts["/"].(map[string]interface{})
+		assert.InDelta(t, 50.0, root["inodes_usage_percent"], 0.001)
+	})
+}
+
+func TestDiskCollectorIORates(t *testing.T) {
+	monitor := &MockDiskMonitor{samples: []core.DiskStats{
+		{Total: 0, Devices: []core.DiskIOStats{{Name: "sda", ReadBytes: 1000, WriteBytes: 0, ReadCount: 10, WriteCount: 0, IOTimeMs: 100}}},
+		{Total: 0, Devices: []core.DiskIOStats{{Name: "sda", ReadBytes: 5000, WriteBytes: 8000, ReadCount: 30, WriteCount: 4, IOTimeMs: 600}}},
+	}}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	collector := NewDiskCollector(monitor, config.DiskFilterConfig{})
+	collector.now = func() time.Time { return start }
+
+	data, err := collector.Collect()
+	require.NoError(t, err)
+	disk := data["disk"].(map[string]interface{})
+	// A zero-sized root must not produce NaN, which JSON can't encode
+	assert.Equal(t, 0.0, disk["usage_percent"])
+	sda := disk["devices"].(map[string]interface{})["sda"].(map[string]interface{})
+	assert.NotContains(t, sda, "rates")
+
+	collector.now = func() time.Time { return start.Add(2 * time.Second) }
+	data, err = collector.Collect()
+	require.NoError(t, err)
+	sda = data["disk"].(map[string]interface{})["devices"].(map[string]interface{})["sda"].(map[string]interface{})
+	rates := sda["rates"].(map[string]float64)
+	assert.InDelta(t, 2000.0, rates["read_bytes_per_sec"], 0.001)
+	assert.InDelta(t, 4000.0, rates["write_bytes_per_sec"], 0.001)
+	assert.InDelta(t, 10.0, rates["read_iops"], 0.001)
+	assert.InDelta(t, 2.0, rates["write_iops"], 0.001)
+	assert.InDelta(t, 250.0, rates["io_time_ms_per_sec"], 0.001)
+	assert.InDelta(t, 25.0, rates["utilization_percent"], 0.001)
+}
+
+// filteringDiskMonitor records which mounts it was asked to query
+type filteringDiskMonitor struct {
+	MockDiskMonitor
+	queried []string
+}
+
+func (m *filteringDiskMonitor) GetDiskUsageFiltered(include core.MountFilter) (core.DiskStats, error) {
+	stats := core.DiskStats{}
+	for _, mount := range testMounts {
+		if include(core.MountStats{Device: mount.Device, Mountpoint: mount.Mountpoint, FSType: mount.FSType}) {
+			m.queried = append(m.queried, mount.Mountpoint)
+			stats.Mounts = append(stats.Mounts, mount)
+		}
+	}
+	return stats, nil
+}
+
+func TestDiskCollectorFiltersBeforeQuerying(t *testing.T) {
+	monitor := &filteringDiskMonitor{}
+	collector := NewDiskCollector(monitor, config.DiskFilterConfig{ExcludeFSTypes: []string{"tmpfs", "overlay", "squashfs"}})
+	_, err := collector.Collect()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/", "/data"}, monitor.queried)
+}
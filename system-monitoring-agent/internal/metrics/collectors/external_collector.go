@@ -0,0 +1,108 @@
+// internal/metrics/collectors/external_collector.go
+package collectors
+
+import (
+	"context"
+	"time"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/external"
+)
+
+// ExternalCollector reports the output of a site-specific check under
+// metrics["external"][name], so rules reach it as "external.<name>.<metric>".
+// The check runs on its own interval; in between, the latest metrics are
+// reported again.
+type ExternalCollector struct {
+	command    *external.Command
+	interval   time.Duration
+	timeout    time.Duration
+	now        func() time.Time
+	lastRun    time.Time
+	metrics    map[string]interface{}
+	indicators []types.ThreatIndicator
+	// closed is cancelled by Close
+	closed context.Context
+	cancel context.CancelFunc
+}
+
+func NewExternalCollector(command *external.Command, interval, timeout time.Duration) *ExternalCollector {
+	closed, cancel := context.WithCancel(context.Background())
+	return &ExternalCollector{
+		command:  command,
+		interval: interval,
+		timeout:  timeout,
+		now:      time.Now,
+		closed:   closed,
+		cancel:   cancel,
+	}
+}
+
+func (c *ExternalCollector) Name() string {
+	return "external." + c.command.Name()
+}
+
+// Timeout implements metrics.TimeoutCollector, so the check gets the
+// deadline it was configured with
+func (c *ExternalCollector) Timeout() time.Duration {
+	return c.timeout
+}
+
+func (c *ExternalCollector) Collect() (map[string]interface{}, error) {
+	return c.CollectContext(context.Background())
+}
+
+// CollectContext runs the check when it is due. Indicators are raised only
+// by the run that found them.
+func (c *ExternalCollector) CollectContext(ctx context.Context) (map[string]interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(c.closed, cancel)()
+
+	c.indicators = nil
+	now := c.now()
+	if c.metrics == nil || now.Sub(c.lastRun) >= c.interval {
+		output, err := c.command.Run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.lastRun = now
+		c.metrics = output.Metrics
+		for _, indicator := range output.Indicators {
+			c.indicators = append(c.indicators, externalIndicator(c.command.Name(), indicator, now))
+		}
+	}
+	return map[string]interface{}{
+		"external": map[string]interface{}{c.command.Name(): c.metrics},
+	}, nil
+}
+
+// Close kills a run still in progress, as when the collector is replaced on
+// reload after the runner stopped waiting for it. Later runs fail at once.
+func (c *ExternalCollector) Close() error {
+	c.cancel()
+	return nil
+}
+
+// Indicators implements metrics.IndicatorCollector
+func (c *ExternalCollector) Indicators() []types.ThreatIndicator {
+	return c.indicators
+}
+
+func externalIndicator(name string, indicator external.Indicator, now time.Time) types.ThreatIndicator {
+	details := make(map[string]interface{}, len(indicator.Details)+1)
+	for k, v := range indicator.Details {
+		details[k] = v
+	}
+	details["collector"] = name
+
+	return types.ThreatIndicator{
+		Type:        indicator.Type,
+		Description: indicator.Description,
+		Severity:    indicator.Severity,
+		Score:       indicator.Score,
+		Timestamp:   now,
+		Tags:        append([]string{"external"}, indicator.Tags...),
+		Details:     details,
+	}
+}
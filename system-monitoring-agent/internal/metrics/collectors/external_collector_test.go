@@ -0,0 +1,101 @@
+//go:build unix
+
+package collectors
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/system-monitoring-agent/internal/external"
+)
+
+// TestMain lets the test binary stand in for the agent as the wrapper that
+// applies an external command's limits
+func TestMain(m *testing.M) {
+	external.RunWrapper()
+	os.Exit(m.Run())
+}
+
+func TestExternalCollector(t *testing.T) {
+	dir := t.TempDir()
+	counter := filepath.Join(dir, "runs")
+	script := filepath.Join(dir, "check.sh")
+	require.NoError(t, os.WriteFile(script, []byte(`#!/bin/sh
+echo x >> "$COUNTER"
+runs=$(wc -l < "$COUNTER")
+printf '{"metrics": {"runs": %d}, "indicators": [{"type": "vendor_alert", "severity": "high", "tags": ["vendor"], "details": {"code": 7}}]}' $runs
+`), 0o755))
+
+	current, err := user.Current()
+	require.NoError(t, err)
+	command, err := external.NewCommand(external.Config{
+		Name:    "vendor",
+		Command: script,
+		Env:     []string{"COUNTER=" + counter},
+		User:    current.Username,
+	})
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	collector := NewExternalCollector(command, time.Minute, 5*time.Second)
+	collector.now = func() time.Time { return now }
+	assert.Equal(t, "external.vendor", collector.Name())
+	assert.Equal(t, 5*time.Second, collector.Timeout())
+
+	data, err := collector.Collect()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"runs": 1.0}, data["external"].(map[string]interface{})["vendor"])
+
+	indicators := collector.Indicators()
+	require.Len(t, indicators, 1)
+	assert.Equal(t, "vendor_alert", indicators[0].Type)
+	assert.Equal(t, "high", indicators[0].Severity)
+	assert.Equal(t, []string{"external", "vendor"}, indicators[0].Tags)
+	assert.Equal(t, map[string]interface{}{"code": 7.0, "collector": "vendor"}, indicators[0].Details)
+	assert.Equal(t, now, indicators[0].Timestamp)
+
+	// Not due yet: the latest metrics are reported again without indicators
+	now = now.Add(30 * time.Second)
+	data, err = collector.Collect()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"runs": 1.0}, data["external"].(map[string]interface{})["vendor"])
+	assert.Empty(t, collector.Indicators())
+
+	now = now.Add(30 * time.Second)
+	data, err = collector.Collect()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"runs": 2.0}, data["external"].(map[string]interface{})["vendor"])
+	assert.Len(t, collector.Indicators(), 1)
+}
+
+func TestExternalCollectorClose(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "check.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nsleep 10\n"), 0o755))
+	current, err := user.Current()
+	require.NoError(t, err)
+	command, err := external.NewCommand(external.Config{Name: "slow", Command: script, User: current.Username, Timeout: time.Minute})
+	require.NoError(t, err)
+	collector := NewExternalCollector(command, 0, time.Minute)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := collector.Collect()
+		errs <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, collector.Close())
+
+	select {
+	case err := <-errs:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't stop the running command")
+	}
+	_, err = collector.Collect()
+	assert.Error(t, err)
+}
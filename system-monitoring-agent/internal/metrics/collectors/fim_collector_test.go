@@ -0,0 +1,71 @@
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/system-monitoring-agent/internal/fim"
+)
+
+func TestFIMCollectorIndicators(t *testing.T) {
+	store, err := fim.NewStore(t.TempDir())
+	require.NoError(t, err)
+	defer store.Close()
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "tool")
+	require.NoError(t, os.WriteFile(binary, []byte("v1"), 0755))
+
+	collector := NewFIMCollector(fim.NewMonitor(store, []string{dir}, nil))
+	data, err := collector.Collect()
+	require.NoError(t, err)
+	assert.Equal(t, 1, data["fim"].(map[string]interface{})["files_monitored"])
+	assert.Empty(t, collector.Indicators())
+
+	require.NoError(t, os.Chmod(binary, 0755|os.ModeSetuid))
+	data, err = collector.Collect()
+	require.NoError(t, err)
+	assert.Equal(t, 1, data["fim"].(map[string]interface{})["changes"])
+
+	indicators := collector.Indicators()
+	require.Len(t, indicators, 1)
+	assert.Equal(t, "file_permissions_changed", indicators[0].Type)
+	assert.Equal(t, "high", indicators[0].Severity)
+	assert.Contains(t, indicators[0].Tags, "fim")
+	assert.Equal(t, binary, indicators[0].Details["path"])
+	before := indicators[0].Details["before"].(map[string]interface{})
+	after := indicators[0].Details["after"].(map[string]interface{})
+	assert.Equal(t, "-rwxr-xr-x", before["mode"])
+	assert.Equal(t, "urwxr-xr-x", after["mode"])
+	assert.Equal(t, before["sha256"], after["sha256"])
+
+	// Indicators only cover the latest cycle
+	_, err = collector.Collect()
+	require.NoError(t, err)
+	assert.Empty(t, collector.Indicators())
+}
+
+func TestFIMSeverity(t *testing.T) {
+	plain := &fim.Entry{Mode: 0644}
+	tests := []struct {
+		name   string
+		change fim.Change
+		want   string
+	}{
+		{"modified config", fim.Change{Path: "/etc/hosts", Before: plain, After: plain}, "medium"},
+		{"authorized_keys", fim.Change{Path: "/root/.ssh/authorized_keys", After: plain}, "high"},
+		{"deleted sudoers", fim.Change{Path: "/etc/sudoers", Before: plain}, "high"},
+		{"now world-writable", fim.Change{Path: "/etc/hosts", Before: plain, After: &fim.Entry{Mode: 0666}}, "high"},
+		{"already setuid", fim.Change{Path: "/usr/bin/su", Before: &fim.Entry{Mode: os.ModeSetuid | 0755}, After: &fim.Entry{Mode: os.ModeSetuid | 0755}}, "medium"},
+		{"created setgid", fim.Change{Path: "/usr/bin/new", After: &fim.Entry{Mode: os.ModeSetgid | 0755}}, "high"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, fimSeverity(tt.change))
+		})
+	}
+}
@@ -1,27 +1,123 @@
 // internal/metrics/collectors/network_collector.go
 package collectors
 
-import "github.com/travism26/system-monitoring-agent/internal/core"
+import (
+	"math"
+	"time"
+
+	"github.com/travism26/system-monitoring-agent/internal/core"
+)
 
 type NetworkCollector struct {
-	monitor core.NetworkMonitor
+	monitor   core.NetworkMonitor
+	now       func() time.Time
+	last      map[string]core.InterfaceStats
+	lastCheck time.Time
 }
 
 func NewNetworkCollector(monitor core.NetworkMonitor) *NetworkCollector {
-	return &NetworkCollector{monitor: monitor}
+	return &NetworkCollector{
+		monitor: monitor,
+		now:     time.Now,
+		last:    make(map[string]core.InterfaceStats),
+	}
 }
 
 func (c *NetworkCollector) Name() string {
 	return "network"
 }
 
+// Collect reports cumulative counters per interface along with per-second
+// rates since the previous cycle. Rates are omitted for interfaces seen for the
+// first time and for counters that were reset.
 func (c *NetworkCollector) Collect() (map[string]interface{}, error) {
 	networkStats, err := c.monitor.GetNetworkStats()
 	if err != nil {
 		return nil, err
 	}
 
+	now := c.now()
+	elapsed := now.Sub(c.lastCheck).Seconds()
+	if c.lastCheck.IsZero() {
+		elapsed = 0
+	}
+
+	totalRates := map[string]float64{}
+	interfaces := make(map[string]interface{}, len(networkStats.Interfaces))
+	current := make(map[string]core.InterfaceStats, len(networkStats.Interfaces))
+
+	for _, iface := range networkStats.Interfaces {
+		current[iface.Name] = iface
+		counters := interfaceCounters(iface)
+
+		entry := make(map[string]interface{}, len(counters)+1)
+		for name, value := range counters {
+			entry[name] = value
+		}
+
+		// Interfaces that disappeared are simply not carried over, so a
+		// re-created interface starts from a fresh baseline
+		if prev, ok := c.last[iface.Name]; ok && elapsed > 0 {
+			rates := make(map[string]float64, len(counters))
+			prevCounters := interfaceCounters(prev)
+			for name, value := range counters {
+				delta, ok := counterDelta(prevCounters[name], value)
+				if !ok {
+					continue
+				}
+				rate := float64(delta) / elapsed
+				rates[name+"_per_sec"] = rate
+				if !iface.Loopback {
+					totalRates[name+"_per_sec"] += rate
+				}
+			}
+			entry["rates"] = rates
+		}
+
+		interfaces[iface.Name] = entry
+	}
+
+	c.last = current
+	c.lastCheck = now
+
+	network := map[string]interface{}{
+		"bytes_sent":     networkStats.BytesSent,
+		"bytes_received": networkStats.BytesReceived,
+		"interfaces":     interfaces,
+	}
+	if len(totalRates) > 0 {
+		network["rates"] = totalRates
+	}
+
 	return map[string]interface{}{
-		"network": networkStats,
+		"network": network,
 	}, nil
 }
+
+// interfaceCounters flattens the counters of an interface into named values
+func interfaceCounters(s core.InterfaceStats) map[string]uint64 {
+	return map[string]uint64{
+		"bytes_sent":       s.BytesSent,
+		"bytes_received":   s.BytesReceived,
+		"packets_sent":     s.PacketsSent,
+		"packets_received": s.PacketsReceived,
+		"errors_in":        s.ErrorsIn,
+		"errors_out":       s.ErrorsOut,
+		"drops_in":         s.DropsIn,
+		"drops_out":        s.DropsOut,
+	}
+}
+
+// counterDelta returns how much a cumulative counter grew between two
+// samples. A decrease from the upper half of the 32-bit range is treated as a
+// 32-bit wraparound; any other decrease is a reset (driver reload, interface
+// re-created) and reports ok=false so it can't show up as a traffic spike.
+func counterDelta(prev, cur uint64) (uint64, bool) {
+	if cur >= prev {
+		return cur - prev, true
+	}
+	if prev <= math.MaxUint32 && prev > math.MaxUint32/2 && cur <= math.MaxUint32/2 {
+		return (math.MaxUint32 - prev) + cur + 1, true
+	}
+	return 0, false
+}
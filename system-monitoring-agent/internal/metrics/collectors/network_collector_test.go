@@ -0,0 +1,160 @@
+package collectors
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/system-monitoring-agent/internal/core"
+)
+
+// MockNetworkMonitor returns a scripted sequence of network samples
+type MockNetworkMonitor struct {
+	samples []core.NetworkStats
+}
+
+func (m *MockNetworkMonitor) GetNetworkStats() (core.NetworkStats, error) {
+	sample := m.samples[0]
+	m.samples = m.samples[1:]
+	return sample, nil
+}
+
+func interfaceEntry(t *testing.T, data map[string]interface{}, name string) map[string]interface{} {
+	t.Helper()
+	network := data["network"].(map[string]interface{})
+	interfaces := network["interfaces"].(map[string]interface{})
+	entry, ok := interfaces[name].(map[string]interface{})
+	require.True(t, ok, "interface %s missing", name)
+	return entry
+}
+
+func TestNetworkCollectorRates(t *testing.T) {
+	monitor := &MockNetworkMonitor{samples: []core.NetworkStats{
+		{
+			BytesSent: 1000, BytesReceived: 5000,
+			Interfaces: []core.InterfaceStats{
+				{Name: "eth0", BytesSent: 1000, BytesReceived: 5000, PacketsSent: 10, DropsIn: 1},
+				{Name: "lo", BytesSent: 300, BytesReceived: 300, Loopback: true},
+			},
+		},
+		{
+			BytesSent: 21000, BytesReceived: 6000,
+			Interfaces: []core.InterfaceStats{
+				{Name: "eth0", BytesSent: 21000, BytesReceived: 6000, PacketsSent: 30, DropsIn: 1},
+				{Name: "lo", BytesSent: 900, BytesReceived: 900, Loopback: true},
+			},
+		},
+	}}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	collector := NewNetworkCollector(monitor)
+	collector.now = func() time.Time { return start }
+
+	// First cycle has no baseline, so no rates
+	data, err := collector.Collect()
+	require.NoError(t, err)
+	eth0 := interfaceEntry(t, data, "eth0")
+	assert.Equal(t, uint64(1000), eth0["bytes_sent"])
+	assert.NotContains(t, eth0, "rates")
+	assert.NotContains(t, data["network"], "rates")
+
+	collector.now = func() time.Time { return start.Add(10 * time.Second) }
+	data, err = collector.Collect()
+	require.NoError(t, err)
+
+	rates := interfaceEntry(t, data, "eth0")["rates"].(map[string]float64)
+	assert.InDelta(t, 2000.0, rates["bytes_sent_per_sec"], 0.001)
+	assert.InDelta(t, 100.0, rates["bytes_received_per_sec"], 0.001)
+	assert.InDelta(t, 2.0, rates["packets_sent_per_sec"], 0.001)
+	assert.InDelta(t, 0.0, rates["drops_in_per_sec"], 0.001)
+
+	// Host totals exclude loopback
+	network := data["network"].(map[string]interface{})
+	totals := network["rates"].(map[string]float64)
+	assert.InDelta(t, 2000.0, totals["bytes_sent_per_sec"], 0.001)
+	assert.Equal(t, uint64(21000), network["bytes_sent"])
+}
+
+func TestNetworkCollectorExcludesLoopbackByFlag(t *testing.T) {
+	monitor := &MockNetworkMonitor{samples: []core.NetworkStats{
+		{Interfaces: []core.InterfaceStats{
+			{Name: "Ethernet", BytesSent: 1000},
+			{Name: "Loopback Pseudo-Interface 1", BytesSent: 1000, Loopback: true},
+		}},
+		{Interfaces: []core.InterfaceStats{
+			{Name: "Ethernet", BytesSent: 2000},
+			{Name: "Loopback Pseudo-Interface 1", BytesSent: 51000, Loopback: true},
+		}},
+	}}
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	collector := NewNetworkCollector(monitor)
+	collector.now = func() time.Time { return start }
+	_, err := collector.Collect()
+	require.NoError(t, err)
+
+	collector.now = func() time.Time { return start.Add(10 * time.Second) }
+	data, err := collector.Collect()
+	require.NoError(t, err)
+
+	totals := data["network"].(map[string]interface{})["rates"].(map[string]float64)
+	assert.InDelta(t, 100.0, totals["bytes_sent_per_sec"], 0.001)
+	// Still reported per interface
+	loopback := interfaceEntry(t, data, "Loopback Pseudo-Interface 1")["rates"].(map[string]float64)
+	assert.InDelta(t, 5000.0, loopback["bytes_sent_per_sec"], 0.001)
+}
+
+func TestNetworkCollectorInterfaceChurn(t *testing.T) {
+	monitor := &MockNetworkMonitor{samples: []core.NetworkStats{
+		{Interfaces: []core.InterfaceStats{{Name: "eth0", BytesSent: 100}, {Name: "veth1", BytesSent: 5000}}},
+		{Interfaces: []core.InterfaceStats{{Name: "eth0", BytesSent: 200}}},
+		{Interfaces: []core.InterfaceStats{{Name: "eth0", BytesSent: 300}, {Name: "veth1", BytesSent: 10}}},
+	}}
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	collector := NewNetworkCollector(monitor)
+	collector.now = func() time.Time { return now }
+
+	_, err := collector.Collect()
+	require.NoError(t, err)
+
+	// veth1 disappears
+	now = now.Add(time.Second)
+	data, err := collector.Collect()
+	require.NoError(t, err)
+	interfaces := data["network"].(map[string]interface{})["interfaces"].(map[string]interface{})
+	assert.NotContains(t, interfaces, "veth1")
+	assert.NotContains(t, collector.last, "veth1")
+
+	// veth1 comes back with fresh counters; it must not produce a rate
+	now = now.Add(time.Second)
+	data, err = collector.Collect()
+	require.NoError(t, err)
+	assert.NotContains(t, interfaceEntry(t, data, "veth1"), "rates")
+	assert.Contains(t, interfaceEntry(t, data, "eth0"), "rates")
+}
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur uint64
+		want      uint64
+		wantOK    bool
+	}{
+		{"increase", 100, 250, 150, true},
+		{"unchanged", 100, 100, 0, true},
+		{"32-bit wraparound", math.MaxUint32 - 9, 20, 30, true},
+		{"reset of small counter", 5000, 10, 0, false},
+		{"reset of 64-bit counter", 1 << 40, 10, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := counterDelta(tt.prev, tt.cur)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
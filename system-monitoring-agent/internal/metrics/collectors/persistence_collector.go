@@ -0,0 +1,111 @@
+// internal/metrics/collectors/persistence_collector.go
+package collectors
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/persistence"
+)
+
+type PersistenceCollector struct {
+	inventory  *persistence.Inventory
+	now        func() time.Time
+	indicators []types.ThreatIndicator
+}
+
+func NewPersistenceCollector(inventory *persistence.Inventory) *PersistenceCollector {
+	return &PersistenceCollector{
+		inventory: inventory,
+		now:       time.Now,
+	}
+}
+
+func (c *PersistenceCollector) Name() string {
+	return "persistence"
+}
+
+// Collect reports how many persistence entries exist per category and the
+// entries added or changed since the previous cycle. Each change is raised
+// once as a threat indicator.
+func (c *PersistenceCollector) Collect() (map[string]interface{}, error) {
+	c.indicators = nil
+	entries, changes, err := c.inventory.Scan()
+	if err != nil {
+		return nil, err
+	}
+
+	categories := make(map[string]int)
+	for _, entry := range entries {
+		categories[string(entry.Category)]++
+	}
+	now := c.now()
+	for _, change := range changes {
+		c.indicators = append(c.indicators, persistenceIndicator(change, now))
+	}
+	if changes == nil {
+		changes = []persistence.Change{}
+	}
+
+	return map[string]interface{}{
+		"persistence": map[string]interface{}{
+			"entry_count": len(entries),
+			"categories":  categories,
+			"changes":     changes,
+		},
+	}, nil
+}
+
+// Close closes the inventory store, as when the agent stops
+func (c *PersistenceCollector) Close() error {
+	return c.inventory.Close()
+}
+
+// Indicators implements metrics.IndicatorCollector
+func (c *PersistenceCollector) Indicators() []types.ThreatIndicator {
+	return c.indicators
+}
+
+func persistenceIndicator(change persistence.Change, now time.Time) types.ThreatIndicator {
+	details := map[string]interface{}{
+		"category":   string(change.Category),
+		"path":       change.Path,
+		"change":     string(change.Type),
+		"hash_after": change.HashAfter,
+	}
+	if change.HashBefore != "" {
+		details["hash_before"] = change.HashBefore
+	}
+	if len(change.AddedLines) > 0 {
+		details["added_lines"] = change.AddedLines
+	}
+	if len(change.RemovedLines) > 0 {
+		details["removed_lines"] = change.RemovedLines
+	}
+
+	severity := persistenceSeverity(change)
+	return types.ThreatIndicator{
+		Type:        "persistence_" + string(change.Type),
+		Description: fmt.Sprintf("Persistence entry %s: %s", change.Type, change.Path),
+		Severity:    severity,
+		Score:       map[string]float64{"high": 90, "medium": 60, "low": 30}[severity],
+		Timestamp:   now,
+		Tags:        []string{"persistence", string(change.Category)},
+		Details:     details,
+	}
+}
+
+// persistenceSeverity is high for mechanisms that are rarely changed
+// legitimately and give immediate code execution or access
+func persistenceSeverity(change persistence.Change) string {
+	switch change.Category {
+	case persistence.CategoryLDPreload, persistence.CategoryAuthorizedKeys, persistence.CategoryRCLocal:
+		return "high"
+	}
+	// Edits that only remove lines don't add a way back in
+	if change.Type == persistence.ChangeChanged && len(change.AddedLines) == 0 {
+		return "low"
+	}
+	return "medium"
+}
@@ -0,0 +1,61 @@
+package collectors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/system-monitoring-agent/internal/persistence"
+)
+
+func TestPersistenceCollectorIndicators(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	write("etc/crontab", "17 * * * * root run-parts /etc/cron.hourly\n* * * * * root /opt/cleanup\n")
+	write("root/.bashrc", "alias ll='ls -l'\nexport EDITOR=vi\n")
+
+	collector := NewPersistenceCollector(persistence.NewInventory(root, nil))
+	_, err := collector.Collect()
+	require.NoError(t, err)
+	assert.Empty(t, collector.Indicators())
+
+	write("root/.ssh/authorized_keys", "ssh-rsa BBBB attacker\n")
+	write("etc/crontab", "17 * * * * root run-parts /etc/cron.hourly\n* * * * * root /opt/cleanup\n* * * * * root sh\n")
+	write("root/.bashrc", "alias ll='ls -l'\n")
+	data, err := collector.Collect()
+	require.NoError(t, err)
+	assert.Equal(t, 3, data["persistence"].(map[string]interface{})["entry_count"])
+
+	indicators := collector.Indicators()
+	require.Len(t, indicators, 3)
+	byPath := make(map[string]int)
+	for i, indicator := range indicators {
+		byPath[indicator.Details["path"].(string)] = i
+	}
+
+	crontab := indicators[byPath["/etc/crontab"]]
+	assert.Equal(t, "persistence_changed", crontab.Type)
+	assert.Equal(t, "medium", crontab.Severity)
+	assert.Equal(t, []string{"* * * * * root sh"}, crontab.Details["added_lines"])
+	assert.Contains(t, crontab.Details, "hash_before")
+
+	keys := indicators[byPath["/root/.ssh/authorized_keys"]]
+	assert.Equal(t, "persistence_added", keys.Type)
+	assert.Equal(t, "high", keys.Severity)
+	assert.Equal(t, []string{"persistence", "authorized_keys"}, keys.Tags)
+	assert.NotContains(t, keys.Details, "hash_before")
+
+	// Edits that only remove lines are low
+	assert.Equal(t, "low", indicators[byPath["/root/.bashrc"]].Severity)
+
+	// Each change is raised once
+	_, err = collector.Collect()
+	require.NoError(t, err)
+	assert.Empty(t, collector.Indicators())
+}
@@ -2,6 +2,8 @@
 package collectors
 
 import (
+	"context"
+
 	"github.com/travism26/shared-monitoring-libs/types"
 	"github.com/travism26/system-monitoring-agent/internal/core"
 )
@@ -19,7 +21,19 @@ func (c *ProcessCollector) Name() string {
 }
 
 func (c *ProcessCollector) Collect() (map[string]interface{}, error) {
-	processes, err := c.monitor.GetProcesses()
+	return c.CollectContext(context.Background())
+}
+
+// CollectContext stops enumerating processes once ctx is done, if the
+// monitor supports it
+func (c *ProcessCollector) CollectContext(ctx context.Context) (map[string]interface{}, error) {
+	var processes []core.ProcessInfo
+	var err error
+	if monitor, ok := c.monitor.(core.ProcessContextMonitor); ok {
+		processes, err = monitor.GetProcessesContext(ctx)
+	} else {
+		processes, err = c.monitor.GetProcesses()
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -40,9 +54,16 @@ func (c *ProcessCollector) Collect() (map[string]interface{}, error) {
 		processMetric := types.ProcessInfo{
 			Name:        proc.Name,
 			PID:         proc.PID,
+			PPID:        proc.PPID,
 			CPUPercent:  proc.CPUPercent,
 			MemoryUsage: proc.MemoryUsage,
 			Status:      proc.Status,
+			Cmdline:     proc.Cmdline,
+			Username:    proc.Username,
+			UID:         proc.UID,
+			StartTime:   proc.StartTime,
+			ExePath:     proc.ExePath,
+			ExeSHA256:   proc.ExeSHA256,
 		}
 		processList = append(processList, processMetric)
 	}
@@ -0,0 +1,317 @@
+// internal/metrics/runner.go
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+const (
+	defaultCollectorTimeout = 10 * time.Second
+	defaultFailureThreshold = 3
+	defaultMaxBackoff       = 10 * time.Minute
+	// initialBackoff is how long a collector is first paused for once it
+	// reaches the failure threshold
+	initialBackoff = 30 * time.Second
+)
+
+// collectorState is one collector's health across cycles
+type collectorState struct {
+	running       bool // a run abandoned at its deadline hasn't returned yet
+	abandoned     bool // no cycle is waiting for the current run's result
+	duration      time.Duration
+	lastSuccess   time.Time
+	failures      int
+	lastError     string
+	disabledUntil time.Time
+	// late holds the indicators of runs that returned after their deadline,
+	// for the next cycle. Collectors such as FIM record what they have
+	// reported during the run, so they wouldn't raise them again.
+	late []types.ThreatIndicator
+	// closers are replaced collectors to close once the run returns
+	closers []io.Closer
+}
+
+// collectorResult is the outcome of one collector's run in a cycle
+type collectorResult struct {
+	ran        bool
+	data       map[string]interface{}
+	indicators []types.ThreatIndicator
+	late       []types.ThreatIndicator // from earlier runs that missed their deadline
+	duration   time.Duration
+	err        error
+}
+
+// collectorRunner runs the enabled collectors concurrently, each under its
+// own deadline, and pauses those that keep failing
+type collectorRunner struct {
+	mu     sync.Mutex
+	states map[string]*collectorState
+	now    func() time.Time
+	// background counts the collectors' runs, including those abandoned at
+	// their deadline
+	background sync.WaitGroup
+}
+
+func newCollectorRunner() *collectorRunner {
+	return &collectorRunner{
+		states: make(map[string]*collectorState),
+		now:    time.Now,
+	}
+}
+
+// run collects from each enabled collector and returns the results in
+// collector order, with the health of every enabled collector. Runs cut
+// short by cancelling ctx don't count against a collector's health.
+func (r *collectorRunner) run(ctx context.Context, collectors []MetricCollector, enabled map[string]bool, cfg config.CollectorsConfig) ([]collectorResult, map[string]types.CollectorHealth) {
+	results := make([]collectorResult, len(collectors))
+	start := r.now()
+
+	var wg sync.WaitGroup
+	for i, collector := range collectors {
+		if !enabled[collector.Name()] || !r.admit(collector.Name(), start, &results[i]) {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, collector MetricCollector) {
+			defer wg.Done()
+			results[i] = r.runOne(ctx, collector, collectorTimeout(cfg, collector))
+		}(i, collector)
+	}
+	wg.Wait()
+
+	health := make(map[string]types.CollectorHealth)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, collector := range collectors {
+		name := collector.Name()
+		if !enabled[name] {
+			continue
+		}
+		state := r.states[name]
+		results[i].late, state.late = state.late, nil
+		if results[i].ran && ctx.Err() == nil {
+			r.record(name, state, results[i], start, cfg)
+		}
+		health[name] = state.health()
+	}
+	return results, health
+}
+
+// admit reports whether a collector should run this cycle. One still paused
+// is skipped quietly; one whose previous run is still going counts as failed.
+func (r *collectorRunner) admit(name string, now time.Time, result *collectorResult) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, ok := r.states[name]
+	if !ok {
+		state = &collectorState{}
+		r.states[name] = state
+	}
+	if now.Before(state.disabledUntil) {
+		return false
+	}
+	if state.running {
+		*result = collectorResult{ran: true, err: fmt.Errorf("%s collector is still running from an earlier cycle", name)}
+		return false
+	}
+	state.running = true
+	return true
+}
+
+// runOne collects under a deadline within parent. A collector that doesn't
+// honour its context is left to finish in the background, and the indicators
+// it returns are kept for the next cycle.
+func (r *collectorRunner) runOne(parent context.Context, collector MetricCollector, timeout time.Duration) collectorResult {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	r.mu.Lock()
+	state := r.states[collector.Name()]
+	r.mu.Unlock()
+
+	start := time.Now()
+	done := make(chan collectorResult, 1)
+	r.background.Add(1)
+	go func() {
+		defer r.background.Done()
+		var result collectorResult
+		if c, ok := collector.(ContextCollector); ok {
+			result.data, result.err = c.CollectContext(ctx)
+		} else {
+			result.data, result.err = collector.Collect()
+		}
+		if source, ok := collector.(IndicatorCollector); ok && result.err == nil {
+			result.indicators = source.Indicators()
+		}
+
+		r.mu.Lock()
+		state.running = false
+		closers := state.closers
+		state.closers = nil
+		if state.abandoned {
+			state.abandoned = false
+			state.late = append(state.late, result.indicators...)
+		} else {
+			done <- result
+		}
+		r.mu.Unlock()
+		closeAll(closers)
+	}()
+
+	var result collectorResult
+	select {
+	case result = <-done:
+		if ctx.Err() != nil && result.err != nil {
+			result.err = stoppedError(parent, collector, timeout)
+		}
+	case <-ctx.Done():
+		r.mu.Lock()
+		if state.running {
+			state.abandoned = true
+			result.err = stoppedError(parent, collector, timeout)
+		} else {
+			// Returned just as the deadline passed
+			result = <-done
+			if result.err != nil {
+				result.err = stoppedError(parent, collector, timeout)
+			}
+		}
+		r.mu.Unlock()
+	}
+	result.ran = true
+	result.duration = time.Since(start)
+	return result
+}
+
+// closeWhenIdle closes a collector that has been replaced or removed. One
+// whose run is still going is closed when the run returns, as it may be
+// using the collector's storage.
+func (r *collectorRunner) closeWhenIdle(name string, closer io.Closer) error {
+	r.mu.Lock()
+	state, ok := r.states[name]
+	if ok && state.running {
+		state.closers = append(state.closers, closer)
+		r.mu.Unlock()
+		return nil
+	}
+	r.mu.Unlock()
+	return closer.Close()
+}
+
+// wait waits for the collectors' runs, including those abandoned at their
+// deadline, until ctx is done. No new cycle may start while it waits.
+func (r *collectorRunner) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("collectors still running: %w", ctx.Err())
+	}
+}
+
+func closeAll(closers []io.Closer) {
+	var errs []error
+	for _, closer := range closers {
+		errs = append(errs, closer.Close())
+	}
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("[ERROR] Closing replaced collector: %v", err)
+	}
+}
+
+// stoppedError explains why a collector's context ended: its own deadline,
+// or the agent stopping
+func stoppedError(parent context.Context, collector MetricCollector, timeout time.Duration) error {
+	if parent.Err() != nil {
+		return fmt.Errorf("%s collector stopped: %w", collector.Name(), parent.Err())
+	}
+	return fmt.Errorf("%s collector timed out after %s", collector.Name(), timeout)
+}
+
+// record updates a collector's health with the result of its run. Callers
+// hold the lock.
+func (r *collectorRunner) record(name string, state *collectorState, result collectorResult, now time.Time, cfg config.CollectorsConfig) {
+	state.duration = result.duration
+	if result.err == nil {
+		if state.failures >= failureThreshold(cfg) {
+			log.Printf("[INFO] %s collector recovered after %d failures", name, state.failures)
+		}
+		state.lastSuccess = now
+		state.failures = 0
+		state.lastError = ""
+		state.disabledUntil = time.Time{}
+		return
+	}
+
+	state.failures++
+	state.lastError = result.err.Error()
+	threshold := failureThreshold(cfg)
+	if state.failures < threshold {
+		return
+	}
+	backoff := initialBackoff << min(state.failures-threshold, 16)
+	if limit := maxBackoff(cfg); backoff > limit {
+		backoff = limit
+	}
+	state.disabledUntil = now.Add(backoff)
+	log.Printf("[WARN] %s collector failed %d times in a row, pausing it for %s: %v", name, state.failures, backoff, result.err)
+}
+
+func (s *collectorState) health() types.CollectorHealth {
+	health := types.CollectorHealth{
+		Duration:            s.duration.String(),
+		ConsecutiveFailures: s.failures,
+		LastError:           s.lastError,
+	}
+	if !s.lastSuccess.IsZero() {
+		lastSuccess := s.lastSuccess.UTC()
+		health.LastSuccess = &lastSuccess
+	}
+	if !s.disabledUntil.IsZero() {
+		disabledUntil := s.disabledUntil.UTC()
+		health.DisabledUntil = &disabledUntil
+	}
+	return health
+}
+
+func collectorTimeout(cfg config.CollectorsConfig, collector MetricCollector) time.Duration {
+	if seconds := cfg.Timeouts[collector.Name()]; seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if c, ok := collector.(TimeoutCollector); ok && c.Timeout() > 0 {
+		return c.Timeout()
+	}
+	if cfg.Timeout > 0 {
+		return time.Duration(cfg.Timeout) * time.Second
+	}
+	return defaultCollectorTimeout
+}
+
+func failureThreshold(cfg config.CollectorsConfig) int {
+	if cfg.FailureThreshold > 0 {
+		return cfg.FailureThreshold
+	}
+	return defaultFailureThreshold
+}
+
+func maxBackoff(cfg config.CollectorsConfig) time.Duration {
+	if cfg.MaxBackoff > 0 {
+		return time.Duration(cfg.MaxBackoff) * time.Second
+	}
+	return defaultMaxBackoff
+}
@@ -0,0 +1,339 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+// stubCollector returns its configured result, optionally after a delay
+type stubCollector struct {
+	name  string
+	delay time.Duration
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+func (c *stubCollector) Name() string { return c.name }
+
+func (c *stubCollector) Collect() (map[string]interface{}, error) {
+	c.mu.Lock()
+	delay := c.delay
+	c.mu.Unlock()
+	time.Sleep(delay)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return map[string]interface{}{c.name: c.calls}, nil
+}
+
+func (c *stubCollector) setDelay(delay time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delay = delay
+}
+
+func (c *stubCollector) setErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+func (c *stubCollector) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+// contextCollector blocks until its context is done
+type contextCollector struct{ stubCollector }
+
+func (c *contextCollector) CollectContext(ctx context.Context) (map[string]interface{}, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// reportingCollector raises an indicator for a change once, recording it as
+// reported within the run as the FIM collector does
+type reportingCollector struct {
+	stubCollector
+	reported   bool
+	indicators []types.ThreatIndicator
+}
+
+func (c *reportingCollector) Collect() (map[string]interface{}, error) {
+	data, err := c.stubCollector.Collect()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.indicators = nil
+	if !c.reported {
+		c.indicators = []types.ThreatIndicator{{Type: "file_modified", Description: "/etc/passwd changed"}}
+		c.reported = true
+	}
+	return data, err
+}
+
+func (c *reportingCollector) Indicators() []types.ThreatIndicator {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.indicators
+}
+
+func enableAll(collectors ...MetricCollector) map[string]bool {
+	enabled := make(map[string]bool)
+	for _, c := range collectors {
+		enabled[c.Name()] = true
+	}
+	return enabled
+}
+
+func TestRunnerRunsCollectorsConcurrently(t *testing.T) {
+	collectors := []MetricCollector{
+		&stubCollector{name: "a", delay: 200 * time.Millisecond},
+		&stubCollector{name: "b", delay: 200 * time.Millisecond},
+		&stubCollector{name: "c", delay: 200 * time.Millisecond},
+	}
+	runner := newCollectorRunner()
+
+	start := time.Now()
+	results, health := runner.run(context.Background(), collectors, enableAll(collectors...), config.CollectorsConfig{})
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected collectors to run concurrently, took %s", elapsed)
+	}
+
+	for i, result := range results {
+		name := collectors[i].Name()
+		if !result.ran || result.err != nil {
+			t.Errorf("Expected %s to succeed, got %+v", name, result)
+		}
+		if result.data[name] != 1 {
+			t.Errorf("Expected %s results in collector order, got %v", name, result.data)
+		}
+		if health[name].LastSuccess == nil || health[name].ConsecutiveFailures != 0 {
+			t.Errorf("Unexpected health for %s: %+v", name, health[name])
+		}
+	}
+}
+
+func TestRunnerSkipsDisabledMetrics(t *testing.T) {
+	enabled := &stubCollector{name: "cpu"}
+	disabled := &stubCollector{name: "disk"}
+	runner := newCollectorRunner()
+
+	results, health := runner.run(context.Background(), []MetricCollector{enabled, disabled}, enableAll(enabled), config.CollectorsConfig{})
+	if results[1].ran || disabled.callCount() != 0 {
+		t.Error("Expected disabled collector not to run")
+	}
+	if _, ok := health["disk"]; ok {
+		t.Error("Expected no health for a disabled collector")
+	}
+}
+
+func TestRunnerTimesOutCollectors(t *testing.T) {
+	cfg := config.CollectorsConfig{Timeout: 1, Timeouts: map[string]int{"slow": 1}}
+
+	t.Run("context collector stops at its deadline", func(t *testing.T) {
+		collector := &contextCollector{stubCollector{name: "slow"}}
+		runner := newCollectorRunner()
+
+		start := time.Now()
+		results, health := runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg)
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the run to end at the deadline, took %s", elapsed)
+		}
+		if results[0].err == nil || health["slow"].ConsecutiveFailures != 1 {
+			t.Errorf("Expected a timeout failure, got %+v", health["slow"])
+		}
+	})
+
+	t.Run("collector ignoring its context is abandoned", func(t *testing.T) {
+		collector := &stubCollector{name: "slow", delay: 1500 * time.Millisecond}
+		runner := newCollectorRunner()
+
+		results, _ := runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg)
+		if results[0].err == nil {
+			t.Fatal("Expected a timeout error")
+		}
+
+		// Still running from the first cycle, so it isn't started again
+		results, health := runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg)
+		if results[0].err == nil || health["slow"].ConsecutiveFailures != 2 {
+			t.Errorf("Expected an overrunning collector to count as failed, got %+v", health["slow"])
+		}
+
+		collector.setDelay(0)
+		time.Sleep(time.Second)
+		if results, _ := runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg); results[0].err != nil {
+			t.Errorf("Expected the collector to run once it returned, got %v", results[0].err)
+		}
+	})
+}
+
+func TestRunnerKeepsIndicatorsOfLateRuns(t *testing.T) {
+	cfg := config.CollectorsConfig{Timeout: 1}
+	collector := &reportingCollector{stubCollector: stubCollector{name: "fim", delay: 1500 * time.Millisecond}}
+	runner := newCollectorRunner()
+
+	results, _ := runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg)
+	if results[0].err == nil || len(results[0].indicators) != 0 {
+		t.Fatalf("Expected the run to time out without indicators, got %+v", results[0])
+	}
+
+	// The change is recorded as reported once the run returns, so the
+	// indicator has to come from that run
+	collector.setDelay(0)
+	time.Sleep(time.Second)
+	results, _ = runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg)
+	if len(results[0].late) != 1 || results[0].late[0].Type != "file_modified" {
+		t.Fatalf("Expected the late run's indicator in the next cycle, got %+v", results[0])
+	}
+	if len(results[0].indicators) != 0 {
+		t.Errorf("Expected the change not to be raised again, got %+v", results[0].indicators)
+	}
+
+	// Delivered once
+	results, _ = runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg)
+	if len(results[0].late) != 0 {
+		t.Errorf("Expected late indicators to be delivered once, got %+v", results[0].late)
+	}
+}
+
+// closingCollector records when it is closed
+type closingCollector struct {
+	stubCollector
+	closed chan struct{}
+}
+
+func (c *closingCollector) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestRunnerClosesReplacedCollectorsWhenIdle(t *testing.T) {
+	collector := &closingCollector{stubCollector: stubCollector{name: "fim", delay: 1500 * time.Millisecond}, closed: make(chan struct{})}
+	runner := newCollectorRunner()
+
+	runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), config.CollectorsConfig{Timeout: 1})
+	if err := runner.closeWhenIdle("fim", collector); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-collector.closed:
+		t.Fatal("Expected the collector to stay open while its run is going")
+	default:
+	}
+
+	select {
+	case <-collector.closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the collector to be closed once its run returned")
+	}
+}
+
+func TestRunnerWaitsForBackgroundRuns(t *testing.T) {
+	collector := &stubCollector{name: "slow", delay: 1500 * time.Millisecond}
+	runner := newCollectorRunner()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	runner.run(ctx, []MetricCollector{collector}, enableAll(collector), config.CollectorsConfig{Timeout: 30})
+
+	short, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShort()
+	if err := runner.wait(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to end at the deadline, got %v", err)
+	}
+
+	long, cancelLong := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelLong()
+	if err := runner.wait(long); err != nil {
+		t.Fatalf("Expected the run to return, got %v", err)
+	}
+	if collector.callCount() != 1 {
+		t.Error("Expected the wait to last until the run returned")
+	}
+}
+
+func TestRunnerStopsOnCancellation(t *testing.T) {
+	collector := &contextCollector{stubCollector{name: "slow"}}
+	runner := newCollectorRunner()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	results, health := runner.run(ctx, []MetricCollector{collector}, enableAll(collector), config.CollectorsConfig{Timeout: 30})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the run to end on cancellation, took %s", elapsed)
+	}
+	if !errors.Is(results[0].err, context.Canceled) {
+		t.Errorf("Expected a cancellation error, got %v", results[0].err)
+	}
+	// Stopping the agent isn't the collector's failure
+	if health["slow"].ConsecutiveFailures != 0 {
+		t.Errorf("Expected no failure recorded, got %+v", health["slow"])
+	}
+}
+
+func TestRunnerBacksOffFailingCollectors(t *testing.T) {
+	collector := &stubCollector{name: "flaky", err: errors.New("device busy")}
+	runner := newCollectorRunner()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	runner.now = func() time.Time { return now }
+	cfg := config.CollectorsConfig{FailureThreshold: 2, MaxBackoff: 45}
+
+	runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg)
+	_, health := runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg)
+	if until := health["flaky"].DisabledUntil; until == nil || !until.Equal(now.Add(initialBackoff)) {
+		t.Fatalf("Expected the collector paused for %s, got %+v", initialBackoff, health["flaky"])
+	}
+
+	// Paused: skipped without another attempt or error
+	now = now.Add(10 * time.Second)
+	results, health := runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg)
+	if results[0].ran || collector.callCount() != 2 {
+		t.Error("Expected a paused collector to be skipped")
+	}
+	if health["flaky"].ConsecutiveFailures != 2 || health["flaky"].LastError != "device busy" {
+		t.Errorf("Unexpected health while paused: %+v", health["flaky"])
+	}
+
+	// Failing again after the pause doubles it, up to MaxBackoff
+	now = now.Add(initialBackoff)
+	_, health = runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg)
+	if until := health["flaky"].DisabledUntil; until == nil || !until.Equal(now.Add(45*time.Second)) {
+		t.Errorf("Expected the pause capped at MaxBackoff, got %v", until)
+	}
+
+	// Recovering clears the failures and the pause
+	collector.setErr(nil)
+	now = now.Add(time.Minute)
+	_, health = runner.run(context.Background(), []MetricCollector{collector}, enableAll(collector), cfg)
+	if h := health["flaky"]; h.ConsecutiveFailures != 0 || h.DisabledUntil != nil || h.LastError != "" || h.LastSuccess == nil {
+		t.Errorf("Expected the collector to recover, got %+v", h)
+	}
+}
+
+func TestCollectReportsCollectorHealth(t *testing.T) {
+	cfg := &config.Config{Interval: 5}
+	cfg.Tenant.CollectionRules.EnabledMetrics = []string{"cpu", "memory"}
+	collector := NewMetricsCollector(&MockSystemMonitor{}, cfg)
+
+	health := collector.Collect().Metadata.Collectors
+	if len(health) != 2 {
+		t.Fatalf("Expected health for the 2 enabled collectors, got %v", health)
+	}
+	for _, name := range []string{"cpu", "memory"} {
+		if health[name].LastSuccess == nil || health[name].Duration == "" {
+			t.Errorf("Unexpected health for %s: %+v", name, health[name])
+		}
+	}
+}
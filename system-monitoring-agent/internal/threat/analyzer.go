@@ -1,78 +1,118 @@
 package threat
 
 import (
-	"math"
+	"fmt"
 	"time"
 
 	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
 )
 
 type Analyzer struct {
-	thresholds map[string]float64
+	metricRules   *RuleFile
+	ancestryRules []AncestryRule
+	// breaches counts the consecutive cycles each rule has matched, keyed by
+	// rule name and metric path
+	breaches map[string]int
+	// reported holds the ancestry matches already flagged, so a long-lived
+	// process is reported once rather than on every cycle
+	reported map[string]bool
 }
 
 func NewAnalyzer() *Analyzer {
+	return NewAnalyzerWithRules(DefaultAncestryRules())
+}
+
+func NewAnalyzerWithRules(ancestryRules []AncestryRule) *Analyzer {
 	return &Analyzer{
-		thresholds: map[string]float64{
-			"cpu_usage":    8.0,
-			"memory_usage": 85.0,
-			"disk_usage":   90.0,
-		},
+		metricRules:   NewRuleFile("", DefaultMetricRules(0, 0, 0)),
+		ancestryRules: ancestryRules,
+		breaches:      make(map[string]int),
+		reported:      make(map[string]bool),
 	}
 }
 
-func (a *Analyzer) AnalyzeMetrics(metrics map[string]interface{}) []types.ThreatIndicator {
-	var indicators []types.ThreatIndicator
-	now := time.Now()
+// NewAnalyzerFromConfig builds an analyzer from the configured ancestry rules
+// and rule file. Without a rule file, the resource usage rules use the
+// configured thresholds.
+func NewAnalyzerFromConfig(cfg *config.Config) *Analyzer {
+	analyzer := NewAnalyzerWithRules(AncestryRulesFromConfig(cfg.ThreatDetection.AncestryRules))
+	defaults := DefaultMetricRules(
+		float64(cfg.Thresholds.CPU),
+		float64(cfg.Thresholds.Memory),
+		float64(cfg.Thresholds.Disk),
+	)
+	analyzer.metricRules = NewRuleFile(cfg.ThreatDetection.RulesFile, defaults)
+	return analyzer
+}
 
-	// Analyze CPU usage
-	if cpuUsage, ok := metrics["cpu_usage"].(float64); ok {
-		if cpuUsage > a.thresholds["cpu_usage"] {
-			indicators = append(indicators, types.ThreatIndicator{
-				Type:        "high_cpu_usage",
-				Description: "CPU usage exceeds threshold",
-				Severity:    a.getSeverity(cpuUsage, a.thresholds["cpu_usage"]),
-				Score:       a.calculateScore(cpuUsage, a.thresholds["cpu_usage"]),
-				Timestamp:   now,
-				Tags:        []string{"performance", "resource_usage"},
-			})
-		}
+// KeepReported carries over the ancestry matches previous has already
+// flagged, so replacing the analyzer on a config reload doesn't report
+// running processes again
+func (a *Analyzer) KeepReported(previous *Analyzer) {
+	for key := range previous.reported {
+		a.reported[key] = true
 	}
+}
+
+func (a *Analyzer) AnalyzeMetrics(metrics map[string]interface{}) []types.ThreatIndicator {
+	return a.analyzeMetricRules(metrics, time.Now())
+}
 
-	// Add memory analysis
-	if memUsage, ok := metrics["memory_usage_percent"].(float64); ok {
-		if memUsage > a.thresholds["memory_usage"] {
-			indicators = append(indicators, types.ThreatIndicator{
-				Type:        "high_memory_usage",
-				Description: "Memory usage exceeds threshold",
-				Severity:    a.getSeverity(memUsage, a.thresholds["memory_usage"]),
-				Score:       a.calculateScore(memUsage, a.thresholds["memory_usage"]),
-				Timestamp:   now,
-				Tags:        []string{"performance", "resource_usage"},
-			})
+// analyzeMetricRules evaluates the metric rules, raising an indicator for
+// each metric path that has matched its rule for the required cycles
+func (a *Analyzer) analyzeMetricRules(metrics map[string]interface{}, now time.Time) []types.ThreatIndicator {
+	var indicators []types.ThreatIndicator
+	breaches := make(map[string]int)
+
+	for _, rule := range a.metricRules.Rules() {
+		for _, metric := range lookupMetric(metrics, rule.Metric) {
+			if !rule.breached(metric.value) {
+				continue
+			}
+			key := rule.Name + "|" + metric.path
+			cycles := a.breaches[key] + 1
+			breaches[key] = cycles
+			if cycles < rule.For {
+				continue
+			}
+			indicators = append(indicators, rule.indicator(metric.path, metric.value, cycles, now))
 		}
 	}
 
+	// Paths that stopped matching start counting from zero again
+	a.breaches = breaches
 	return indicators
 }
 
-func (a *Analyzer) getSeverity(value, threshold float64) string {
-	excess := value - threshold
-	switch {
-	case excess > 20:
-		return "high"
-	case excess > 10:
-		return "medium"
-	default:
-		return "low"
-	}
-}
+// AnalyzeProcesses builds the process tree for this cycle and flags processes
+// whose lineage matches an ancestry rule
+func (a *Analyzer) AnalyzeProcesses(processes []types.ProcessInfo) []types.ThreatIndicator {
+	var indicators []types.ThreatIndicator
+	now := time.Now()
+	tree := BuildProcessTree(processes)
+	reported := make(map[string]bool)
 
-func (a *Analyzer) calculateScore(value, threshold float64) float64 {
-	// Normalize to 0-100 scale and apply logarithmic scaling
-	excess := value - threshold
-	if excess <= 0 {
-		return 0
+	for _, proc := range processes {
+		ancestors := tree.Ancestors(proc.PID)
+		if len(ancestors) == 0 {
+			continue
+		}
+		for _, rule := range a.ancestryRules {
+			matched := rule.matchAncestor(proc, ancestors)
+			if matched < 0 {
+				continue
+			}
+			key := fmt.Sprintf("%s/%d/%d", rule.Name, proc.PID, proc.StartTime.UnixNano())
+			reported[key] = true
+			if a.reported[key] {
+				continue
+			}
+			indicators = append(indicators, ancestryIndicator(rule, proc, ancestors, matched, now))
+		}
 	}
-	return math.Min(100, math.Log10(1+excess)*30)
+
+	// Forget processes that have exited so a reused PID is reported again
+	a.reported = reported
+	return indicators
 }
@@ -0,0 +1,205 @@
+package threat
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+// AncestryRule flags a process matching Children whose parent, or any ancestor
+// up to Depth levels above it, matches Parents. Patterns are case-insensitive
+// globs compared against the process name and executable base name, with any
+// ".exe" suffix ignored.
+type AncestryRule struct {
+	Name        string
+	Description string
+	Severity    string
+	Parents     []string
+	Children    []string
+	Depth       int
+	Tags        []string
+}
+
+var shells = []string{
+	"sh", "bash", "dash", "zsh", "ksh", "csh", "tcsh", "fish", "ash", "busybox",
+	"cmd", "powershell", "pwsh",
+}
+
+var networkTools = []string{
+	"curl", "wget", "nc", "ncat", "netcat", "socat", "nmap", "telnet", "ssh", "scp",
+	"sftp", "ftp", "tftp", "rsync", "certutil", "bitsadmin",
+}
+
+// DefaultAncestryRules returns the built-in rules used when none are configured
+func DefaultAncestryRules() []AncestryRule {
+	return []AncestryRule{
+		{
+			Name:        "web_server_shell",
+			Description: "Web server spawned a shell",
+			Severity:    "high",
+			Parents: []string{
+				"nginx", "apache", "apache2", "httpd", "lighttpd", "caddy", "php-fpm*",
+				"php-cgi*", "tomcat*", "w3wp", "iisexpress", "uwsgi", "gunicorn",
+			},
+			Children: shells,
+			Tags:     []string{"execution", "webshell"},
+		},
+		{
+			Name:        "database_shell",
+			Description: "Database server spawned a shell",
+			Severity:    "high",
+			Parents: []string{
+				"mysqld", "mariadbd", "postgres", "postmaster", "mongod", "redis-server",
+				"sqlservr", "oracle*", "clickhouse-server",
+			},
+			Children: shells,
+			Tags:     []string{"execution"},
+		},
+		{
+			Name:        "office_network_tool",
+			Description: "Office application or script interpreter spawned a network tool",
+			Severity:    "medium",
+			Parents: []string{
+				"winword", "excel", "powerpnt", "outlook", "soffice*", "libreoffice*",
+				"python*", "perl", "ruby", "node", "php", "wscript", "cscript", "mshta",
+			},
+			Children: networkTools,
+			Tags:     []string{"execution", "command_and_control"},
+		},
+	}
+}
+
+// AncestryRulesFromConfig converts configured rules, falling back to the
+// built-in set when none are configured
+func AncestryRulesFromConfig(cfg []config.AncestryRuleConfig) []AncestryRule {
+	if len(cfg) == 0 {
+		return DefaultAncestryRules()
+	}
+	rules := make([]AncestryRule, 0, len(cfg))
+	for _, rule := range cfg {
+		rules = append(rules, AncestryRule{
+			Name:        rule.Name,
+			Description: rule.Description,
+			Severity:    strings.ToLower(rule.Severity),
+			Parents:     rule.Parents,
+			Children:    rule.Children,
+			Depth:       rule.Depth,
+			Tags:        rule.Tags,
+		})
+	}
+	return rules
+}
+
+// matchAncestor returns the first ancestor within the rule's depth that
+// matches Parents, or -1
+func (r AncestryRule) matchAncestor(proc types.ProcessInfo, ancestors []types.ProcessInfo) int {
+	if !matchesProcess(r.Children, proc) {
+		return -1
+	}
+	depth := r.Depth
+	if depth < 1 {
+		depth = 1
+	}
+	for i, ancestor := range ancestors {
+		if i >= depth {
+			break
+		}
+		if matchesProcess(r.Parents, ancestor) {
+			return i
+		}
+	}
+	return -1
+}
+
+func matchesProcess(patterns []string, proc types.ProcessInfo) bool {
+	names := []string{normalizeProcessName(proc.Name)}
+	if proc.ExePath != "" {
+		// Windows paths use backslashes, which path.Base doesn't split on
+		names = append(names, normalizeProcessName(filepath.Base(strings.ReplaceAll(proc.ExePath, `\`, "/"))))
+	}
+	for _, pattern := range patterns {
+		pattern = normalizeProcessName(pattern)
+		for _, name := range names {
+			if ok, err := path.Match(pattern, name); err == nil && ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func normalizeProcessName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".exe")
+}
+
+// ancestryIndicator describes a rule match with the full chain, from the
+// flagged process up to the oldest known ancestor
+func ancestryIndicator(rule AncestryRule, proc types.ProcessInfo, ancestors []types.ProcessInfo, matched int, now time.Time) types.ThreatIndicator {
+	chain := make([]map[string]interface{}, 0, len(ancestors)+1)
+	for _, p := range append([]types.ProcessInfo{proc}, ancestors...) {
+		entry := map[string]interface{}{
+			"pid":      p.PID,
+			"ppid":     p.PPID,
+			"name":     p.Name,
+			"cmdline":  p.Cmdline,
+			"username": p.Username,
+			"exe_path": p.ExePath,
+		}
+		if p.ExeSHA256 != "" {
+			entry["exe_sha256"] = p.ExeSHA256
+		}
+		chain = append(chain, entry)
+	}
+
+	names := make([]string, 0, len(chain))
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		names = append(names, ancestors[i].Name)
+	}
+	names = append(names, proc.Name)
+
+	severity := rule.Severity
+	if severity == "" {
+		severity = "medium"
+	}
+	description := rule.Description
+	if description == "" {
+		description = fmt.Sprintf("Suspicious process ancestry: %s", rule.Name)
+	}
+
+	return types.ThreatIndicator{
+		Type:        "suspicious_process_ancestry",
+		Description: description,
+		Severity:    severity,
+		Score:       severityScore(severity),
+		Timestamp:   now,
+		Tags:        append([]string{"process", "ancestry"}, rule.Tags...),
+		Details: map[string]interface{}{
+			"rule":             rule.Name,
+			"pid":              proc.PID,
+			"process_name":     proc.Name,
+			"matched_ancestor": ancestors[matched].Name,
+			"lineage":          strings.Join(names, " > "),
+			"ancestry":         chain,
+		},
+	}
+}
+
+// severityScore scores the severities a rule may have: low, medium, high or
+// critical
+func severityScore(severity string) float64 {
+	switch severity {
+	case "critical":
+		return 100
+	case "high":
+		return 90
+	case "medium":
+		return 60
+	default:
+		return 30
+	}
+}
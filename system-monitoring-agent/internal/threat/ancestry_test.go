@@ -0,0 +1,136 @@
+package threat
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+var boot = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func proc(pid, ppid int, name string, started time.Duration) types.ProcessInfo {
+	return types.ProcessInfo{PID: pid, PPID: ppid, Name: name, StartTime: boot.Add(started)}
+}
+
+func webShellSnapshot() []types.ProcessInfo {
+	return []types.ProcessInfo{
+		proc(1, 0, "systemd", 0),
+		proc(100, 1, "nginx", time.Minute),
+		proc(101, 100, "nginx", time.Minute),
+		proc(200, 101, "sh", time.Hour),
+		proc(201, 200, "curl", time.Hour),
+		proc(300, 1, "sshd", time.Minute),
+		proc(301, 300, "bash", time.Hour),
+	}
+}
+
+func TestProcessTreeAncestors(t *testing.T) {
+	tree := BuildProcessTree(webShellSnapshot())
+
+	ancestors := tree.Ancestors(201)
+	require.Len(t, ancestors, 4)
+	assert.Equal(t, []int{200, 101, 100, 1}, []int{ancestors[0].PID, ancestors[1].PID, ancestors[2].PID, ancestors[3].PID})
+	assert.ElementsMatch(t, []int{100, 300}, tree.Children(1))
+	assert.Empty(t, tree.Ancestors(1))
+	assert.Nil(t, tree.Ancestors(999))
+}
+
+func TestProcessTreeStopsAtReusedPID(t *testing.T) {
+	tree := BuildProcessTree([]types.ProcessInfo{
+		proc(1, 0, "systemd", 0),
+		// pid 50 exited and was reused by a process that started later
+		proc(50, 1, "nginx", 2*time.Hour),
+		proc(60, 50, "bash", time.Hour),
+		// PPID cycles are cut rather than looping forever
+		proc(70, 71, "a", 0),
+		proc(71, 70, "b", 0),
+	})
+
+	assert.Empty(t, tree.Ancestors(60))
+	assert.Len(t, tree.Ancestors(70), 1)
+}
+
+func TestAnalyzeProcessesFlagsSuspiciousLineage(t *testing.T) {
+	analyzer := NewAnalyzer()
+
+	indicators := analyzer.AnalyzeProcesses(webShellSnapshot())
+	require.Len(t, indicators, 1)
+
+	indicator := indicators[0]
+	assert.Equal(t, "suspicious_process_ancestry", indicator.Type)
+	assert.Equal(t, "high", indicator.Severity)
+	assert.Contains(t, indicator.Tags, "webshell")
+	assert.Equal(t, "web_server_shell", indicator.Details["rule"])
+	assert.Equal(t, 200, indicator.Details["pid"])
+	assert.Equal(t, "systemd > nginx > nginx > sh", indicator.Details["lineage"])
+
+	chain := indicator.Details["ancestry"].([]map[string]interface{})
+	require.Len(t, chain, 4)
+	assert.Equal(t, "sh", chain[0]["name"])
+	assert.Equal(t, 1, chain[3]["pid"])
+
+	// The same process is not reported again on the next cycle
+	assert.Empty(t, analyzer.AnalyzeProcesses(webShellSnapshot()))
+}
+
+func TestAnalyzerKeepsReportedMatches(t *testing.T) {
+	previous := NewAnalyzer()
+	require.Len(t, previous.AnalyzeProcesses(webShellSnapshot()), 1)
+
+	// A replacement analyzer, as built on reload, doesn't report the
+	// running shell again
+	analyzer := NewAnalyzer()
+	analyzer.KeepReported(previous)
+	assert.Empty(t, analyzer.AnalyzeProcesses(webShellSnapshot()))
+}
+
+func TestAnalyzeProcessesMatchesExecutableName(t *testing.T) {
+	analyzer := NewAnalyzer()
+	processes := []types.ProcessInfo{
+		proc(10, 1, "WINWORD.EXE", 0),
+		{PID: 11, PPID: 10, Name: "renamed", ExePath: `C:\Windows\System32\certutil.exe`, StartTime: boot},
+	}
+
+	indicators := analyzer.AnalyzeProcesses(processes)
+	require.Len(t, indicators, 1)
+	assert.Equal(t, "office_network_tool", indicators[0].Details["rule"])
+	assert.Equal(t, "medium", indicators[0].Severity)
+}
+
+func TestAncestryRuleSeverityScores(t *testing.T) {
+	processes := []types.ProcessInfo{proc(1, 0, "java", 0), proc(2, 1, "bash", 0)}
+	for severity, score := range map[string]float64{"Critical": 100, "high": 90, "medium": 60, "low": 30} {
+		analyzer := NewAnalyzerWithRules(AncestryRulesFromConfig([]config.AncestryRuleConfig{
+			{Name: "java_shell", Severity: severity, Parents: []string{"java"}, Children: []string{"bash"}},
+		}))
+		indicators := analyzer.AnalyzeProcesses(processes)
+		require.Len(t, indicators, 1)
+		assert.Equal(t, strings.ToLower(severity), indicators[0].Severity)
+		assert.Equal(t, score, indicators[0].Score, severity)
+	}
+}
+
+func TestAnalyzeProcessesConfiguredRules(t *testing.T) {
+	analyzer := NewAnalyzerWithRules(AncestryRulesFromConfig([]config.AncestryRuleConfig{
+		{Name: "java_grandchild_shell", Parents: []string{"java"}, Children: []string{"bash"}, Depth: 2},
+	}))
+	processes := []types.ProcessInfo{
+		proc(1, 0, "java", 0),
+		proc(2, 1, "sh", 0),
+		proc(3, 2, "bash", 0),
+		// Built-in rules are replaced
+		proc(4, 0, "nginx", 0),
+		proc(5, 4, "sh", 0),
+	}
+
+	indicators := analyzer.AnalyzeProcesses(processes)
+	require.Len(t, indicators, 1)
+	assert.Equal(t, 3, indicators[0].Details["pid"])
+	assert.Equal(t, "java", indicators[0].Details["matched_ancestor"])
+	assert.Equal(t, "medium", indicators[0].Severity)
+}
@@ -0,0 +1,54 @@
+package threat
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+	"github.com/travism26/system-monitoring-agent/internal/baseline"
+)
+
+// AnalyzeAnomalies turns deviations from the host's learned baselines into
+// indicators that carry the baseline they were measured against
+func (a *Analyzer) AnalyzeAnomalies(anomalies []baseline.Anomaly) []types.ThreatIndicator {
+	var indicators []types.ThreatIndicator
+	now := time.Now()
+	for _, anomaly := range anomalies {
+		indicators = append(indicators, anomalyIndicator(anomaly, now))
+	}
+	return indicators
+}
+
+func anomalyIndicator(anomaly baseline.Anomaly, now time.Time) types.ThreatIndicator {
+	direction := "above"
+	if anomaly.Deviation < 0 {
+		direction = "below"
+	}
+	deviation := math.Abs(anomaly.Deviation)
+
+	// Twice the configured deviation is treated as clearly abnormal
+	severity := "medium"
+	if deviation > 2*anomaly.Sigma {
+		severity = "high"
+	}
+
+	return types.ThreatIndicator{
+		Type:        "metric_anomaly",
+		Description: fmt.Sprintf("%s is %.1f standard deviations %s its baseline", anomaly.Metric, deviation, direction),
+		Severity:    severity,
+		Score:       math.Min(100, deviation/anomaly.Sigma*50),
+		Timestamp:   now,
+		Tags:        []string{"anomaly", "baseline"},
+		Details: map[string]interface{}{
+			"metric":           anomaly.Metric,
+			"value":            anomaly.Value,
+			"baseline_mean":    anomaly.Mean,
+			"baseline_stddev":  anomaly.StdDev,
+			"baseline_bucket":  anomaly.Bucket,
+			"baseline_samples": anomaly.Samples,
+			"deviation_sigma":  anomaly.Deviation,
+			"threshold_sigma":  anomaly.Sigma,
+		},
+	}
+}
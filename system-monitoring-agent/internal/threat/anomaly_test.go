@@ -0,0 +1,30 @@
+package threat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/system-monitoring-agent/internal/baseline"
+)
+
+func TestAnalyzeAnomalies(t *testing.T) {
+	indicators := NewAnalyzer().AnalyzeAnomalies([]baseline.Anomaly{
+		{Metric: "cpu_usage", Bucket: "all", Value: 60, Mean: 2, StdDev: 0.5, Deviation: 29, Sigma: 3, Samples: 500},
+		{Metric: "process_count", Bucket: "hour_03", Value: 80, Mean: 120, StdDev: 10, Deviation: -4, Sigma: 3, Samples: 90},
+	})
+	require.Len(t, indicators, 2)
+
+	assert.Equal(t, "metric_anomaly", indicators[0].Type)
+	assert.Equal(t, "cpu_usage is 29.0 standard deviations above its baseline", indicators[0].Description)
+	assert.Equal(t, "high", indicators[0].Severity)
+	assert.Equal(t, 100.0, indicators[0].Score)
+	assert.Equal(t, 2.0, indicators[0].Details["baseline_mean"])
+	assert.Equal(t, 0.5, indicators[0].Details["baseline_stddev"])
+	assert.Equal(t, 500, indicators[0].Details["baseline_samples"])
+
+	assert.Equal(t, "process_count is 4.0 standard deviations below its baseline", indicators[1].Description)
+	assert.Equal(t, "medium", indicators[1].Severity)
+	assert.InDelta(t, 66.7, indicators[1].Score, 0.1)
+	assert.Equal(t, "hour_03", indicators[1].Details["baseline_bucket"])
+}
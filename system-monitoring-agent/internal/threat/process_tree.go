@@ -0,0 +1,62 @@
+package threat
+
+import (
+	"github.com/travism26/shared-monitoring-libs/types"
+)
+
+// ProcessTree indexes a process snapshot by PID and parent PID
+type ProcessTree struct {
+	nodes    map[int]types.ProcessInfo
+	children map[int][]int
+}
+
+// BuildProcessTree builds the tree for a single collection cycle
+func BuildProcessTree(processes []types.ProcessInfo) *ProcessTree {
+	tree := &ProcessTree{
+		nodes:    make(map[int]types.ProcessInfo, len(processes)),
+		children: make(map[int][]int),
+	}
+	for _, proc := range processes {
+		tree.nodes[proc.PID] = proc
+		if proc.PPID != proc.PID {
+			tree.children[proc.PPID] = append(tree.children[proc.PPID], proc.PID)
+		}
+	}
+	return tree
+}
+
+// Process returns the process with the given PID
+func (t *ProcessTree) Process(pid int) (types.ProcessInfo, bool) {
+	proc, ok := t.nodes[pid]
+	return proc, ok
+}
+
+// Children returns the PIDs of the direct children of pid
+func (t *ProcessTree) Children(pid int) []int {
+	return t.children[pid]
+}
+
+// Ancestors returns the parent chain of pid, nearest first. The walk stops at
+// a parent missing from the snapshot, at a cycle, or at a parent that started
+// after its child, which means the parent's PID has been reused.
+func (t *ProcessTree) Ancestors(pid int) []types.ProcessInfo {
+	var chain []types.ProcessInfo
+	child, ok := t.nodes[pid]
+	if !ok {
+		return nil
+	}
+
+	visited := map[int]bool{pid: true}
+	for {
+		parent, ok := t.nodes[child.PPID]
+		if !ok || visited[parent.PID] {
+			return chain
+		}
+		if !parent.StartTime.IsZero() && !child.StartTime.IsZero() && parent.StartTime.After(child.StartTime) {
+			return chain
+		}
+		visited[parent.PID] = true
+		chain = append(chain, parent)
+		child = parent
+	}
+}
@@ -0,0 +1,352 @@
+package threat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/travism26/shared-monitoring-libs/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Built-in thresholds used when the configuration leaves them unset
+const (
+	defaultCPUThreshold    = 80.0
+	defaultMemoryThreshold = 85.0
+	defaultDiskThreshold   = 90.0
+)
+
+// MetricRule raises an indicator of type Name when the value at Metric
+// compares against Threshold with Operator for For consecutive cycles. Metric
+// is a dotted path into the collected metrics; a "*" segment matches every key
+// at that level, e.g. "disk.mounts.*.usage_percent". The indicator starts at
+// Severity and takes the severity of each Escalate step whose Margin the value
+// exceeds the threshold by.
+type MetricRule struct {
+	Name        string         `yaml:"Name"`
+	Description string         `yaml:"Description"`
+	Metric      string         `yaml:"Metric"`
+	Operator    string         `yaml:"Operator"`
+	Threshold   float64        `yaml:"Threshold"`
+	For         int            `yaml:"For"`
+	Severity    string         `yaml:"Severity"`
+	Escalate    []SeverityStep `yaml:"Escalate"`
+	Tags        []string       `yaml:"Tags"`
+}
+
+// SeverityStep raises a rule's severity once the value is past the threshold
+// by more than Margin
+type SeverityStep struct {
+	Margin   float64 `yaml:"Margin"`
+	Severity string  `yaml:"Severity"`
+}
+
+type metricRuleFile struct {
+	Rules []MetricRule `yaml:"Rules"`
+}
+
+var operators = map[string]func(value, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+var severities = map[string]bool{"low": true, "medium": true, "high": true, "critical": true}
+
+// DefaultMetricRules returns the resource usage rules used when no rule file
+// is configured. A zero threshold takes the built-in value.
+func DefaultMetricRules(cpu, memory, disk float64) []MetricRule {
+	return []MetricRule{
+		resourceRule("high_cpu_usage", "CPU usage exceeds threshold", "cpu_usage", cpu, defaultCPUThreshold),
+		resourceRule("high_memory_usage", "Memory usage exceeds threshold", "memory_usage_percent", memory, defaultMemoryThreshold),
+		resourceRule("high_disk_usage", "Disk usage exceeds threshold", "disk.usage_percent", disk, defaultDiskThreshold),
+	}
+}
+
+func resourceRule(name, description, metric string, threshold, fallback float64) MetricRule {
+	if threshold == 0 {
+		threshold = fallback
+	}
+	return MetricRule{
+		Name:        name,
+		Description: description,
+		Metric:      metric,
+		Operator:    ">",
+		Threshold:   threshold,
+		For:         1,
+		Severity:    "low",
+		Escalate: []SeverityStep{
+			{Margin: 10, Severity: "medium"},
+			{Margin: 20, Severity: "high"},
+		},
+		Tags: []string{"performance", "resource_usage"},
+	}
+}
+
+// ParseMetricRules decodes and validates a YAML rule file. Unset operators
+// default to ">", durations to one cycle and severities to "low".
+func ParseMetricRules(data []byte) ([]MetricRule, error) {
+	var file metricRuleFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(file.Rules))
+	rules := make([]MetricRule, 0, len(file.Rules))
+	for i, rule := range file.Rules {
+		if rule.Operator == "" {
+			rule.Operator = ">"
+		}
+		if rule.For < 1 {
+			rule.For = 1
+		}
+		if rule.Severity == "" {
+			rule.Severity = "low"
+		}
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("rule %d: duplicate name %q", i+1, rule.Name)
+		}
+		seen[rule.Name] = true
+		sort.SliceStable(rule.Escalate, func(a, b int) bool {
+			return rule.Escalate[a].Margin < rule.Escalate[b].Margin
+		})
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// LoadMetricRules reads and parses a rule file
+func LoadMetricRules(path string) ([]MetricRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := ParseMetricRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+func (r MetricRule) validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.Metric == "" {
+		return fmt.Errorf("%s: metric is required", r.Name)
+	}
+	if operators[r.Operator] == nil {
+		return fmt.Errorf("%s: unknown operator %q", r.Name, r.Operator)
+	}
+	if !severities[r.Severity] {
+		return fmt.Errorf("%s: unknown severity %q", r.Name, r.Severity)
+	}
+	for _, step := range r.Escalate {
+		if !severities[step.Severity] {
+			return fmt.Errorf("%s: unknown severity %q", r.Name, step.Severity)
+		}
+	}
+	return nil
+}
+
+func (r MetricRule) breached(value float64) bool {
+	return operators[r.Operator](value, r.Threshold)
+}
+
+// margin is how far past the threshold the value is, in the direction the
+// operator checks
+func (r MetricRule) margin(value float64) float64 {
+	switch r.Operator {
+	case ">", ">=":
+		return value - r.Threshold
+	case "<", "<=":
+		return r.Threshold - value
+	default:
+		return math.Abs(value - r.Threshold)
+	}
+}
+
+func (r MetricRule) severity(margin float64) string {
+	severity := r.Severity
+	for _, step := range r.Escalate {
+		if margin > step.Margin {
+			severity = step.Severity
+		}
+	}
+	return severity
+}
+
+func (r MetricRule) indicator(path string, value float64, cycles int, now time.Time) types.ThreatIndicator {
+	description := r.Description
+	if description == "" {
+		description = fmt.Sprintf("%s %s %g", r.Metric, r.Operator, r.Threshold)
+	}
+	margin := r.margin(value)
+	return types.ThreatIndicator{
+		Type:        r.Name,
+		Description: description,
+		Severity:    r.severity(margin),
+		Score:       calculateScore(margin),
+		Timestamp:   now,
+		Tags:        r.Tags,
+		Details: map[string]interface{}{
+			"metric":    path,
+			"value":     value,
+			"operator":  r.Operator,
+			"threshold": r.Threshold,
+			"cycles":    cycles,
+		},
+	}
+}
+
+// metricValue is a numeric value found at a concrete metric path
+type metricValue struct {
+	path  string
+	value float64
+}
+
+// lookupMetric resolves a dotted path, expanding "*" segments, and returns
+// the numeric values found ordered by path
+func lookupMetric(metrics map[string]interface{}, path string) []metricValue {
+	var values []metricValue
+	var walk func(node interface{}, segments []string, prefix string)
+	walk = func(node interface{}, segments []string, prefix string) {
+		if len(segments) == 0 {
+			if value, ok := toFloat(node); ok {
+				values = append(values, metricValue{path: prefix, value: value})
+			}
+			return
+		}
+		children, ok := node.(map[string]interface{})
+		if !ok {
+			return
+		}
+		join := func(key string) string {
+			if prefix == "" {
+				return key
+			}
+			return prefix + "." + key
+		}
+		if segments[0] != "*" {
+			if child, ok := children[segments[0]]; ok {
+				walk(child, segments[1:], join(segments[0]))
+			}
+			return
+		}
+		for key, child := range children {
+			walk(child, segments[1:], join(key))
+		}
+	}
+	walk(metrics, strings.Split(path, "."), "")
+
+	sort.Slice(values, func(i, j int) bool { return values[i].path < values[j].path })
+	return values
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// RuleFile serves metric rules from a YAML file and reloads it whenever its
+// modification time or size changes. The fallback rules are served when no
+// path is set and until the file first loads; a file that fails to load after
+// a change leaves the current rules in place.
+type RuleFile struct {
+	mu       sync.Mutex
+	path     string
+	rules    []MetricRule
+	modTime  time.Time
+	size     int64
+	lastErr  string
+	fallback []MetricRule
+}
+
+func NewRuleFile(path string, fallback []MetricRule) *RuleFile {
+	return &RuleFile{
+		path:     path,
+		rules:    fallback,
+		fallback: fallback,
+	}
+}
+
+// Rules returns the current rules, reloading the file first if it changed
+func (f *RuleFile) Rules() []MetricRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.path == "" {
+		return f.rules
+	}
+	info, err := os.Stat(f.path)
+	if err != nil {
+		f.logError(err)
+		return f.rules
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.rules
+	}
+	f.modTime, f.size = info.ModTime(), info.Size()
+
+	rules, err := LoadMetricRules(f.path)
+	if err != nil {
+		f.logError(err)
+		return f.rules
+	}
+	f.rules = rules
+	f.lastErr = ""
+	log.Printf("Loaded %d threat rules from %s", len(rules), f.path)
+	return f.rules
+}
+
+// logError reports a rule file problem once rather than on every cycle
+func (f *RuleFile) logError(err error) {
+	if err.Error() == f.lastErr {
+		return
+	}
+	f.lastErr = err.Error()
+	log.Printf("Keeping current threat rules: %v", err)
+}
+
+// calculateScore maps how far past its threshold a value is onto a 0-100
+// scale, logarithmically
+func calculateScore(margin float64) float64 {
+	if margin <= 0 {
+		return 0
+	}
+	return math.Min(100, math.Log10(1+margin)*30)
+}
@@ -0,0 +1,223 @@
+package threat
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travism26/system-monitoring-agent/internal/config"
+)
+
+func TestDefaultMetricRules(t *testing.T) {
+	analyzer := NewAnalyzer()
+
+	indicators := analyzer.AnalyzeMetrics(map[string]interface{}{
+		"cpu_usage":            95.0,
+		"memory_usage_percent": 90.0,
+		"disk": map[string]interface{}{
+			"usage_percent": 91.0,
+		},
+	})
+	require.Len(t, indicators, 3)
+
+	assert.Equal(t, "high_cpu_usage", indicators[0].Type)
+	assert.Equal(t, "CPU usage exceeds threshold", indicators[0].Description)
+	assert.Equal(t, "medium", indicators[0].Severity)
+	assert.InDelta(t, 36.1, indicators[0].Score, 0.1)
+	assert.Equal(t, []string{"performance", "resource_usage"}, indicators[0].Tags)
+
+	assert.Equal(t, "high_memory_usage", indicators[1].Type)
+	assert.Equal(t, "low", indicators[1].Severity)
+
+	// Disk usage was collected but never evaluated before
+	assert.Equal(t, "high_disk_usage", indicators[2].Type)
+	assert.Equal(t, "disk.usage_percent", indicators[2].Details["metric"])
+
+	assert.Empty(t, analyzer.AnalyzeMetrics(map[string]interface{}{"cpu_usage": 25.0}))
+}
+
+func TestAnalyzerUsesConfiguredThresholds(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Thresholds.CPU = 50
+
+	indicators := NewAnalyzerFromConfig(cfg).AnalyzeMetrics(map[string]interface{}{
+		"cpu_usage":            75.0,
+		"memory_usage_percent": 80.0,
+	})
+	require.Len(t, indicators, 1)
+	assert.Equal(t, "high_cpu_usage", indicators[0].Type)
+	assert.Equal(t, "high", indicators[0].Severity)
+	assert.Equal(t, 50.0, indicators[0].Details["threshold"])
+}
+
+func TestParseMetricRules(t *testing.T) {
+	rules, err := ParseMetricRules([]byte(`
+Rules:
+  - Name: low_free_inodes
+    Metric: disk.mounts.*.inodes_free
+    Operator: "<"
+    Threshold: 1000
+    For: 3
+    Escalate:
+      - Margin: 900
+        Severity: high
+      - Margin: 500
+        Severity: medium
+    Tags: [capacity]
+`))
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, 3, rules[0].For)
+	assert.Equal(t, "low", rules[0].Severity)
+	assert.Equal(t, []SeverityStep{{Margin: 500, Severity: "medium"}, {Margin: 900, Severity: "high"}}, rules[0].Escalate)
+
+	rules, err = ParseMetricRules(nil)
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	for name, data := range map[string]string{
+		"missing metric":   "Rules:\n  - Name: a\n",
+		"unknown operator": "Rules:\n  - Name: a\n    Metric: cpu_usage\n    Operator: '=>'\n",
+		"unknown severity": "Rules:\n  - Name: a\n    Metric: cpu_usage\n    Severity: severe\n",
+		"unknown step":     "Rules:\n  - Name: a\n    Metric: cpu_usage\n    Escalate:\n      - Margin: 5\n        Severity: urgent\n",
+		"duplicate name":   "Rules:\n  - Name: a\n    Metric: cpu_usage\n  - Name: a\n    Metric: disk.used\n",
+		"misspelled field": "Rules:\n  - Name: a\n    Metric: cpu_usage\n    Treshold: 5\n",
+		"not a rule list":  "Rules: cpu_usage > 5\n",
+	} {
+		_, err := ParseMetricRules([]byte(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseMetricRulesCritical(t *testing.T) {
+	rules, err := ParseMetricRules([]byte(`
+Rules:
+  - Name: root_disk_full
+    Metric: disk.mounts./.usage_percent
+    Threshold: 95
+    Severity: high
+    Escalate:
+      - Margin: 4
+        Severity: critical
+  - Name: ransomware_canary
+    Metric: external.canary.modified
+    Threshold: 0
+    Severity: critical
+`))
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "critical", rules[0].Escalate[0].Severity)
+	assert.Equal(t, "critical", rules[1].Severity)
+
+	indicator := rules[0].indicator("disk.mounts./.usage_percent", 99.5, 1, time.Now())
+	assert.Equal(t, "critical", indicator.Severity)
+}
+
+func TestMetricRuleDurationAndWildcard(t *testing.T) {
+	rules, err := ParseMetricRules([]byte(`
+Rules:
+  - Name: low_free_inodes
+    Description: Filesystem is running out of inodes
+    Metric: disk.mounts.*.inodes_free
+    Operator: "<"
+    Threshold: 1000
+    For: 2
+    Escalate:
+      - Margin: 500
+        Severity: medium
+`))
+	require.NoError(t, err)
+	analyzer := NewAnalyzer()
+	analyzer.metricRules = NewRuleFile("", rules)
+
+	metrics := func(root, data uint64) map[string]interface{} {
+		return map[string]interface{}{
+			"disk": map[string]interface{}{
+				"mounts": map[string]interface{}{
+					"/":     map[string]interface{}{"inodes_free": root},
+					"/data": map[string]interface{}{"inodes_free": data},
+				},
+			},
+		}
+	}
+
+	assert.Empty(t, analyzer.AnalyzeMetrics(metrics(100, 5000)))
+
+	indicators := analyzer.AnalyzeMetrics(metrics(100, 900))
+	require.Len(t, indicators, 1)
+	assert.Equal(t, "low_free_inodes", indicators[0].Type)
+	assert.Equal(t, "Filesystem is running out of inodes", indicators[0].Description)
+	assert.Equal(t, "medium", indicators[0].Severity)
+	assert.Equal(t, "disk.mounts./.inodes_free", indicators[0].Details["metric"])
+	assert.Equal(t, 2, indicators[0].Details["cycles"])
+
+	indicators = analyzer.AnalyzeMetrics(metrics(100, 900))
+	require.Len(t, indicators, 2)
+	assert.Equal(t, "disk.mounts./data.inodes_free", indicators[1].Details["metric"])
+	assert.Equal(t, "low", indicators[1].Severity)
+
+	// A recovered cycle resets the count
+	assert.Empty(t, analyzer.AnalyzeMetrics(metrics(5000, 5000)))
+	assert.Empty(t, analyzer.AnalyzeMetrics(metrics(100, 5000)))
+}
+
+func TestMetricRuleOnExternalMetric(t *testing.T) {
+	rules, err := ParseMetricRules([]byte(`
+Rules:
+  - Name: license_expiring
+    Metric: external.vendor.days_left
+    Operator: "<"
+    Threshold: 7
+`))
+	require.NoError(t, err)
+	analyzer := NewAnalyzer()
+	analyzer.metricRules = NewRuleFile("", rules)
+
+	metrics := func(daysLeft float64) map[string]interface{} {
+		return map[string]interface{}{
+			"external": map[string]interface{}{
+				"vendor": map[string]interface{}{"days_left": daysLeft},
+				"backup": map[string]interface{}{"days_left": 1.0},
+			},
+		}
+	}
+
+	assert.Empty(t, analyzer.AnalyzeMetrics(metrics(30)))
+	indicators := analyzer.AnalyzeMetrics(metrics(3))
+	require.Len(t, indicators, 1)
+	assert.Equal(t, "license_expiring", indicators[0].Type)
+	assert.Equal(t, "external.vendor.days_left", indicators[0].Details["metric"])
+}
+
+func TestRuleFileReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	fallback := DefaultMetricRules(0, 0, 0)
+
+	// Missing file serves the fallback rules
+	file := NewRuleFile(path, fallback)
+	assert.Equal(t, fallback, file.Rules())
+
+	require.NoError(t, os.WriteFile(path, []byte("Rules:\n  - Name: busy\n    Metric: cpu_usage\n    Threshold: 50\n"), 0644))
+	rules := file.Rules()
+	require.Len(t, rules, 1)
+	assert.Equal(t, "busy", rules[0].Name)
+
+	// Broken edits keep the last good rules
+	require.NoError(t, os.WriteFile(path, []byte("Rules:\n  - Name: busy\n    Operator: '~'\n"), 0644))
+	touch(t, path, time.Now().Add(time.Second))
+	assert.Equal(t, rules, file.Rules())
+
+	require.NoError(t, os.WriteFile(path, []byte("Rules:\n  - Name: idle\n    Metric: cpu_usage\n    Operator: '<'\n    Threshold: 1\n"), 0644))
+	touch(t, path, time.Now().Add(2*time.Second))
+	rules = file.Rules()
+	require.Len(t, rules, 1)
+	assert.Equal(t, "idle", rules[0].Name)
+}
+
+func touch(t *testing.T, path string, at time.Time) {
+	t.Helper()
+	require.NoError(t, os.Chtimes(path, at, at))
+}
@@ -6,6 +6,10 @@ export const kafkaWrapper = {
         console.log("Mocked Producer publish:", data);
         return Promise.resolve();
       }),
+      publishBatch: jest.fn().mockImplementation((data) => {
+        console.log("Mocked Producer publishBatch:", data);
+        return Promise.resolve();
+      }),
     };
   }),
   getConsumer: jest.fn().mockImplementation(() => {
@@ -25,6 +25,17 @@ export abstract class Publisher<T extends Event> {
     console.log(`Event published to topic ${this.topic}`);
   }
 
+  // publishBatch sends each item as its own message in a single request.
+  // Sharing one key, they land on one partition as one record batch, which
+  // the broker appends whole or not at all.
+  async publishBatch(data: T['data'][], key: string): Promise<void> {
+    await this.producer.send({
+      topic: this.topic,
+      messages: data.map((item) => ({ key, value: JSON.stringify(item) })),
+    });
+    console.log(`${data.length} events published to topic ${this.topic}`);
+  }
+
   async disconnect(): Promise<void> {
     await this.producer.disconnect();
   }
@@ -25,44 +25,52 @@ export const validateTenantConsistency = async (
   }
 
   // For POST requests, validate tenant ID and environment in body matches headers
-  // Only validate POST request tenant consistency if tenant information is provided
-  if (req.method === "POST" && req.body?.data?.metadata) {
-    console.log("Checking tenant consistency in POST request");
-    const payloadTenantId = req.body.data.metadata.tenant_id;
-    const payloadEnvironment = req.body.data.metadata.environment;
+  // Only validate POST request tenant consistency if tenant information is provided.
+  // Metrics requests may carry a list of payloads under data.
+  const data = req.body?.data;
+  const payloads = Array.isArray(data) ? data : [data];
+  if (req.method === "POST") {
+    for (const payload of payloads) {
+      if (!payload?.metadata) {
+        continue;
+      }
+      console.log("Checking tenant consistency in POST request");
+      const payloadTenantId = payload.metadata.tenant_id;
+      const payloadEnvironment = payload.metadata.environment;
 
-    // Skip validation if payload doesn't include tenant information
-    if (!payloadTenantId || !payloadEnvironment) {
-      console.log("No tenant information in payload - proceeding");
-      return next();
-    }
+      // Skip validation if payload doesn't include tenant information
+      if (!payloadTenantId || !payloadEnvironment) {
+        console.log("No tenant information in payload - proceeding");
+        continue;
+      }
 
-    // Only validate if both header and payload tenant IDs exist
-    if (
-      headerTenantId &&
-      payloadTenantId &&
-      headerTenantId !== payloadTenantId
-    ) {
-      console.log("Tenant ID mismatch", headerTenantId, payloadTenantId);
-      throw new BadRequestError(
-        "Tenant ID mismatch between headers and payload"
-      );
-    }
+      // Only validate if both header and payload tenant IDs exist
+      if (
+        headerTenantId &&
+        payloadTenantId &&
+        headerTenantId !== payloadTenantId
+      ) {
+        console.log("Tenant ID mismatch", headerTenantId, payloadTenantId);
+        throw new BadRequestError(
+          "Tenant ID mismatch between headers and payload"
+        );
+      }
 
-    // Only validate if both header and payload environments exist
-    if (
-      headerEnvironment &&
-      payloadEnvironment &&
-      headerEnvironment !== payloadEnvironment
-    ) {
-      console.log(
-        `Environment mismatch`,
-        headerEnvironment,
-        payloadEnvironment
-      );
-      throw new BadRequestError(
-        "Environment mismatch between headers and payload"
-      );
+      // Only validate if both header and payload environments exist
+      if (
+        headerEnvironment &&
+        payloadEnvironment &&
+        headerEnvironment !== payloadEnvironment
+      ) {
+        console.log(
+          `Environment mismatch`,
+          headerEnvironment,
+          payloadEnvironment
+        );
+        throw new BadRequestError(
+          "Environment mismatch between headers and payload"
+        );
+      }
     }
   }
 
@@ -56,6 +56,12 @@ export interface SystemMetricsData {
   data: MetricsPayload;
 }
 
+// SystemMetricsBatch is an ingest request once a single payload has been
+// wrapped in a list
+export interface SystemMetricsBatch {
+  data: MetricsPayload[];
+}
+
 export interface SystemMetrics extends Event<SystemMetricsData> {
   timestamp: string;
 }
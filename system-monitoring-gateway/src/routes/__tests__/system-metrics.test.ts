@@ -0,0 +1,103 @@
+import request from "supertest";
+import { app } from "../../app";
+import { kafkaWrapper } from "../../kafka/kafka-wrapper";
+
+jest.mock("../../kafka/kafka-wrapper");
+
+const createPayload = (hostname: string) => ({
+  timestamp: new Date().toISOString(),
+  tenant_id: "test-tenant",
+  host: {
+    os: "linux",
+    arch: "amd64",
+    hostname,
+    cpu_cores: 4,
+  },
+  metrics: { cpu_usage: 12.5 },
+  processes: {
+    total_count: 0,
+    list: [],
+  },
+  metadata: {
+    collection_duration: "1.2s",
+    collector_count: 6,
+  },
+});
+
+describe("POST /gateway/api/v1/system-metrics/ingest", () => {
+  let producers: Record<string, { publish: jest.Mock; publishBatch: jest.Mock }>;
+
+  beforeEach(() => {
+    producers = {};
+    (kafkaWrapper.isInitialized as jest.Mock).mockReturnValue(true);
+    (kafkaWrapper.getProducer as jest.Mock).mockImplementation(
+      (name: string) => {
+        if (!producers[name]) {
+          producers[name] = {
+            publish: jest.fn().mockResolvedValue(undefined),
+            publishBatch: jest.fn().mockResolvedValue(undefined),
+          };
+        }
+        return producers[name];
+      }
+    );
+  });
+
+  const ingest = (body: object) =>
+    request(app)
+      .post("/gateway/api/v1/system-metrics/ingest")
+      .set("X-API-Key", "test-api-key")
+      .send(body);
+
+  it("publishes a batched body in one producer call", async () => {
+    const response = await ingest({
+      data: [createPayload("web-1"), createPayload("web-1")],
+    });
+
+    expect(response.status).toBe(202);
+    const metrics = producers["system-metrics"];
+    expect(metrics.publishBatch).toHaveBeenCalledTimes(1);
+    const [published, key] = metrics.publishBatch.mock.calls[0];
+    expect(published).toHaveLength(2);
+    expect(key).toBe("web-1");
+    for (const payload of published) {
+      expect(payload.api_key).toBe("test-api-key");
+    }
+    expect(metrics.publish).not.toHaveBeenCalled();
+  });
+
+  it("still accepts a single payload", async () => {
+    const response = await ingest({ data: createPayload("web-1") });
+
+    expect(response.status).toBe(202);
+    const [published] = producers["system-metrics"].publishBatch.mock.calls[0];
+    expect(published).toHaveLength(1);
+  });
+
+  it("sends a batch that fails to publish to the DLQ once", async () => {
+    kafkaWrapper.getProducer("system-metrics");
+    producers["system-metrics"].publishBatch.mockRejectedValue(
+      new Error("broker unavailable")
+    );
+
+    const body = { data: [createPayload("web-1"), createPayload("web-1")] };
+    const response = await ingest(body);
+
+    expect(response.status).toBe(500);
+    const dlq = producers["system-metrics-dlq"];
+    expect(dlq.publish).toHaveBeenCalledTimes(1);
+    expect(dlq.publish.mock.calls[0][0].original_message.data).toHaveLength(2);
+  });
+
+  it("rejects a batch with a payload missing its metrics", async () => {
+    const response = await ingest({
+      data: [
+        createPayload("web-1"),
+        { ...createPayload("web-1"), metrics: undefined },
+      ],
+    });
+
+    expect(response.status).toBe(400);
+    expect(producers["system-metrics"]?.publishBatch).toBeUndefined();
+  });
+});
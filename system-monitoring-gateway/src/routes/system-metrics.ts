@@ -1,4 +1,4 @@
-import express, { Request, Response } from "express";
+import express, { NextFunction, Request, Response } from "express";
 import { body } from "express-validator";
 import { validateRequest } from "../middleware/validate-request";
 import { validateTenantConsistency } from "../middleware/validate-tenant";
@@ -7,50 +7,61 @@ import { Counter } from "prom-client";
 import {
   SystemMetrics,
   SystemMetricsPayload,
-  SystemMetricsData,
+  SystemMetricsBatch,
 } from "../payload/system-metrics";
 import { kafkaWrapper } from "../kafka/kafka-wrapper";
 
 const router = express.Router();
 
+// Agents batching their sends post {"data": [payload, ...]} instead of
+// {"data": payload}; both are handled as a list
+const normalizeBatch = (req: Request, res: Response, next: NextFunction) => {
+  const data = req.body?.data;
+  if (data && typeof data === "object" && !Array.isArray(data)) {
+    req.body.data = [data];
+  }
+  next();
+};
+
 // Validation middleware
 const validateMetrics = [
   body().isObject().withMessage("Request body must be an object"),
-  body("data").isObject().withMessage("Data field is required"),
-  body("data.metrics").notEmpty().withMessage("Metrics data is required"),
-  body("data.timestamp").isISO8601().withMessage("Invalid timestamp format"),
-  body("data.tenant_id").optional(),
-  body("data.user_id")
+  body("data").isArray({ min: 1 }).withMessage("Data field is required"),
+  body("data.*").isObject().withMessage("Each payload must be an object"),
+  body("data.*.metrics").notEmpty().withMessage("Metrics data is required"),
+  body("data.*.timestamp").isISO8601().withMessage("Invalid timestamp format"),
+  body("data.*.tenant_id").optional(),
+  body("data.*.user_id")
     .optional()
     .isString()
     .withMessage("User ID must be a string"),
-  body("data.tenant_metadata").optional(),
-  body("data.api_key")
+  body("data.*.tenant_metadata").optional(),
+  body("data.*.api_key")
     .optional()
     .isString()
     .withMessage("API key must be a string"),
-  body("data.host").isObject().withMessage("Host information is required"),
-  body("data.host.os").isString().withMessage("Host OS is required"),
-  body("data.host.hostname")
+  body("data.*.host").isObject().withMessage("Host information is required"),
+  body("data.*.host.os").isString().withMessage("Host OS is required"),
+  body("data.*.host.hostname")
     .isString()
     .withMessage("Host hostname is required"),
-  body("data.host.cpu_cores")
+  body("data.*.host.cpu_cores")
     .isInt()
     .withMessage("Host CPU cores must be a number"),
-  body("data.processes")
+  body("data.*.processes")
     .isObject()
     .withMessage("Process information is required"),
-  body("data.processes.total_count")
+  body("data.*.processes.total_count")
     .isInt()
     .withMessage("Process total count must be a number"),
-  body("data.processes.list")
+  body("data.*.processes.list")
     .isArray()
     .withMessage("Process list must be an array"),
-  body("data.metadata").isObject().withMessage("Metadata is required"),
-  body("data.metadata.collection_duration")
+  body("data.*.metadata").isObject().withMessage("Metadata is required"),
+  body("data.*.metadata.collection_duration")
     .isString()
     .withMessage("Collection duration is required"),
-  body("data.metadata.collector_count")
+  body("data.*.metadata.collector_count")
     .isInt()
     .withMessage("Collector count must be a number"),
 ];
@@ -58,10 +69,11 @@ const validateMetrics = [
 // "/api/v1/system",
 router.post(
   "/system-metrics/ingest",
+  normalizeBatch,
   validateMetrics,
   validateRequest,
   validateTenantConsistency,
-  async (req: Request<{}, {}, SystemMetricsData>, res: Response) => {
+  async (req: Request<{}, {}, SystemMetricsBatch>, res: Response) => {
     const tenantFeatureFlag = false; // turned off for now
     console.log("[DEBUG] Received metrics request", {
       headers: req.headers,
@@ -75,38 +87,49 @@ router.post(
     try {
       console.log("[DEBUG] Starting metrics processing");
 
-      // Extract data from wrapper and add API key
-      const { data } = req.body;
-      if (!data) {
+      // Extract payloads from wrapper and add API key
+      const { data: payloads } = req.body;
+      if (!payloads || payloads.length === 0) {
         return res.status(400).json({
           errors: [{ message: "Missing data field in payload" }],
         });
       }
 
-      // Add API key and user data to the payload from middleware
+      // Add API key and user data to the payloads from middleware
       if (!req.apiKey) {
         throw new Error("API key not found in request");
       }
-      data.api_key = req.apiKey;
+      for (const data of payloads) {
+        data.api_key = req.apiKey;
+        if (req.userId) {
+          data.user_id = req.userId;
+        }
+        if (req.tenantId && tenantFeatureFlag) {
+          data.tenant_id = req.tenantId;
+        }
+      }
       if (req.userId) {
         console.log("[DEBUG] User ID found in request", req.userId);
-        data.user_id = req.userId;
       }
       if (req.tenantId && tenantFeatureFlag) {
         console.log("[DEBUG] Tenant ID found in request", req.tenantId);
-        data.tenant_id = req.tenantId;
       }
 
       // Log only process summary instead of detailed list
-      const processCount = data.processes?.list?.length || 0;
-      console.log(`Received ${processCount} processes in metrics payload`);
+      const processCount = payloads.reduce(
+        (total, data) => total + (data.processes?.list?.length || 0),
+        0
+      );
+      console.log(
+        `Received ${processCount} processes in ${payloads.length} metrics payloads`
+      );
 
       // Update Prometheus counter for incoming metrics
       const counter = metricsRegistry.getSingleMetric(
         "system_metrics_received_total"
       ) as Counter<string>;
       if (counter) {
-        counter.inc();
+        counter.inc(payloads.length);
       }
 
       // Attempt to publish to Kafka
@@ -125,32 +148,35 @@ router.post(
 
       // Validate tenant ID consistency if header is present
       const headerTenantId = req.get("x-tenant-id");
-      if (headerTenantId && data.tenant_id && tenantFeatureFlag) {
-        if (headerTenantId !== data.tenant_id) {
-          const errorProducer = kafkaWrapper.getProducer(
-            "system-metrics-errors"
-          );
-          await errorProducer.publish({
-            error: "Tenant ID mismatch",
-            header_tenant_id: headerTenantId,
-            payload_tenant_id: data.tenant_id,
-            timestamp: new Date().toISOString(),
-          });
-          return res.status(400).json({
-            errors: [
-              { message: "Tenant ID mismatch between headers and payload" },
-            ],
-          });
+      for (const data of payloads) {
+        if (headerTenantId && data.tenant_id && tenantFeatureFlag) {
+          if (headerTenantId !== data.tenant_id) {
+            const errorProducer = kafkaWrapper.getProducer(
+              "system-metrics-errors"
+            );
+            await errorProducer.publish({
+              error: "Tenant ID mismatch",
+              header_tenant_id: headerTenantId,
+              payload_tenant_id: data.tenant_id,
+              timestamp: new Date().toISOString(),
+            });
+            return res.status(400).json({
+              errors: [
+                { message: "Tenant ID mismatch between headers and payload" },
+              ],
+            });
+          }
         }
       }
 
       // Validate required fields
-      if (!data.metrics) {
+      const incomplete = payloads.find((data) => !data.metrics);
+      if (incomplete) {
         const errorProducer = kafkaWrapper.getProducer("system-metrics-errors");
         await errorProducer.publish({
           error: "Validation failed",
           original_payload: req.body,
-          tenant_id: data.tenant_id || "no-tenant",
+          tenant_id: incomplete.tenant_id || "no-tenant",
           timestamp: new Date().toISOString(),
         });
         return res.status(400).json({
@@ -162,8 +188,13 @@ router.post(
         console.log("[DEBUG] Attempting to publish metrics to Kafka");
         const kafkaProducer = kafkaWrapper.getProducer("system-metrics");
 
-        await kafkaProducer.publish(data);
-        console.log("[DEBUG] Successfully published metrics to Kafka");
+        // Each payload is its own message, whether or not it was batched.
+        // A batch is published all or nothing, so on failure it goes to the
+        // DLQ and can be retried whole without duplicating any of it.
+        await kafkaProducer.publishBatch(payloads, payloads[0].host.hostname);
+        console.log(
+          `[DEBUG] Successfully published ${payloads.length} metrics payloads to Kafka`
+        );
 
         return res.status(202).json({
           status: "accepted",
@@ -177,7 +208,7 @@ router.post(
         await dlqProducer.publish({
           error: "Message processing failed",
           original_message: req.body,
-          tenant_id: data.tenant_id || "no-tenant",
+          tenant_id: payloads[0].tenant_id || "no-tenant",
           timestamp: new Date().toISOString(),
         });
 